@@ -0,0 +1,144 @@
+// Command dlq inspects the dead-letter queues, and replays the events on them
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ses"
+	"github.com/briggysmalls/detectordag/admin/dlq/replay"
+	consumer "github.com/briggysmalls/detectordag/consumer/app"
+	"github.com/briggysmalls/detectordag/shared"
+	"github.com/briggysmalls/detectordag/shared/database"
+	"github.com/briggysmalls/detectordag/shared/email"
+	"github.com/briggysmalls/detectordag/shared/iot"
+	"github.com/briggysmalls/detectordag/shared/scheduler"
+	"github.com/briggysmalls/detectordag/shared/shadow"
+	"github.com/briggysmalls/detectordag/shared/sqs"
+)
+
+const (
+	senderEnvVar           = "SENDER_EMAIL"
+	templateLocationEnvVar = "TEMPLATE_LOCATION"
+)
+
+func main() {
+	// Parse the command line
+	dlqURL := flag.String("dlq", "", "URL of the dead-letter queue to inspect")
+	sourceURL := flag.String("source", "", "URL of the queue to replay connection events to")
+	advisoryURL := flag.String("advisory", "", "URL of the queue to schedule food-safety advisories and maintenance checks on")
+	replay := flag.Bool("replay", false, "offer to replay each event")
+	dryRun := flag.Bool("dry-run", false, "print the notifications a replay would send, without sending them")
+	yes := flag.Bool("yes", false, "replay every event without asking")
+	flag.Parse()
+	if *dlqURL == "" {
+		log.Fatal("A dead-letter queue must be provided")
+	}
+	// Create the application
+	a, err := createApp(*dlqURL, *sourceURL, *advisoryURL, *dryRun)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	// List the dead-lettered events
+	entries, err := a.List()
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	fmt.Printf("%d message(s) on the dead-letter queue\n", len(entries))
+	for _, entry := range entries {
+		fmt.Println(entry)
+	}
+	if !*replay && !*dryRun {
+		return
+	}
+	// Offer to replay each event
+	stdin := bufio.NewReader(os.Stdin)
+	for _, entry := range entries {
+		if entry.Err != nil {
+			continue
+		}
+		if !*yes && !*dryRun && !confirm(stdin, fmt.Sprintf("Replay %s?", entry)) {
+			continue
+		}
+		if err := a.Replay(entry); err != nil {
+			log.Printf("Failed to replay %s: %v", entry.Message.ID, err)
+		}
+	}
+}
+
+func createApp(dlqURL, sourceURL, advisoryURL string, dryRun bool) (replay.App, error) {
+	// Create an AWS session
+	sesh := shared.CreateSession(aws.Config{})
+	// Create the queue clients
+	dlq, err := sqs.New(sesh, dlqURL)
+	if err != nil {
+		return nil, err
+	}
+	source, err := sqs.New(sesh, sourceURL)
+	if err != nil {
+		return nil, err
+	}
+	// Create the clients the handlers need
+	db, err := database.New(sesh)
+	if err != nil {
+		return nil, err
+	}
+	iotClient, err := iot.New(sesh)
+	if err != nil {
+		return nil, err
+	}
+	shadowClient, err := shadow.New(sesh)
+	if err != nil {
+		return nil, err
+	}
+	// Only send emails, record outages and schedule advisories for real
+	emailer := replay.NewDryRunEmailer(os.Stdout)
+	outages := replay.NewDryRunShadow(shadowClient, os.Stdout)
+	advisories := replay.NewDryRunScheduler(os.Stdout)
+	if !dryRun {
+		if emailer, err = createEmailer(sesh); err != nil {
+			return nil, err
+		}
+		advisoryQueue, err := sqs.New(sesh, advisoryURL)
+		if err != nil {
+			return nil, err
+		}
+		outages = shadowClient
+		advisories = scheduler.NewSQS(advisoryQueue, scheduler.NewClock(), scheduler.NewDispatcher())
+	}
+	// Replay power status updates through the consumer
+	c := consumer.New(db, iotClient, outages, emailer, advisories)
+	return replay.New(dlq, source, c, shadowClient, iotClient, db, os.Stdout, dryRun), nil
+}
+
+func createEmailer(sesh *session.Session) (email.Emailer, error) {
+	// Get the email sender
+	sender := os.Getenv(senderEnvVar)
+	if sender == "" {
+		return nil, fmt.Errorf("Env var '%s' unset", senderEnvVar)
+	}
+	// Create a source for the email templates
+	templates, err := email.NewTemplateSource(sesh, os.Getenv(templateLocationEnvVar))
+	if err != nil {
+		return nil, err
+	}
+	// Create a new session just for emailing (there is no emailing service in eu-west-2)
+	emailSesh := shared.CreateSession(aws.Config{Region: aws.String("eu-west-1")})
+	return email.NewEmailer(ses.New(emailSesh), sender, templates)
+}
+
+// confirm asks the user a yes/no question
+func confirm(in *bufio.Reader, question string) bool {
+	fmt.Printf("%s [y/N] ", question)
+	answer, err := in.ReadString('\n')
+	if err != nil {
+		return false
+	}
+	return strings.ToLower(strings.TrimSpace(answer)) == "y"
+}
@@ -0,0 +1,141 @@
+package replay
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/briggysmalls/detectordag/connection"
+	consumer "github.com/briggysmalls/detectordag/consumer/app"
+	"github.com/briggysmalls/detectordag/shared"
+	"github.com/briggysmalls/detectordag/shared/scheduler"
+	"github.com/briggysmalls/detectordag/shared/sqs"
+)
+
+// Entry is a dead-lettered message, decoded into the event it carries
+type Entry struct {
+	Message sqs.Message
+	// Connection is set for disconnections that failed to be confirmed
+	Connection *sqs.ConnectionEventPayload
+	// Status is set for power status updates that failed to be notified
+	Status *consumer.StatusUpdatedEvent
+	// Err is set if the message couldn't be decoded
+	Err error
+}
+
+// destinationRecord is sent by lambda when an asynchronous invocation fails
+type destinationRecord struct {
+	RequestContext struct {
+		FunctionArn string `json:"functionArn"`
+		Condition   string `json:"condition"`
+	} `json:"requestContext"`
+	RequestPayload json.RawMessage `json:"requestPayload"`
+}
+
+// ruleError is sent by an IoT rule's error action when it fails to invoke its action
+type ruleError struct {
+	RuleName              string `json:"ruleName"`
+	Topic                 string `json:"topic"`
+	Base64OriginalPayload string `json:"base64OriginalPayload"`
+}
+
+// shadowDocuments is published to a shadow's 'update/documents' topic
+type shadowDocuments struct {
+	Timestamp int `json:"timestamp"`
+	Current   struct {
+		State struct {
+			Reported json.RawMessage `json:"reported"`
+		} `json:"state"`
+		Metadata struct {
+			Reported json.RawMessage `json:"reported"`
+		} `json:"metadata"`
+	} `json:"current"`
+}
+
+// Decode works out which event a dead-lettered message carries
+func Decode(message sqs.Message) Entry {
+	entry := Entry{Message: message}
+	// Find out which kind of message this is
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(message.Body), &fields); err != nil {
+		entry.Err = fmt.Errorf("Failed to parse message: %w", err)
+		return entry
+	}
+	switch {
+	case fields["runAt"] != nil:
+		entry.Connection, entry.Err = decodeJob(message.Body)
+	case fields["requestPayload"] != nil:
+		entry.Status, entry.Err = decodeDestinationRecord(message.Body)
+	case fields["base64OriginalPayload"] != nil:
+		entry.Status, entry.Err = decodeRuleError(message.Body)
+	default:
+		entry.Err = errors.New("Unrecognised message")
+	}
+	return entry
+}
+
+func decodeJob(body string) (*sqs.ConnectionEventPayload, error) {
+	// Unpack the scheduled job
+	var job scheduler.Job
+	if err := json.Unmarshal([]byte(body), &job); err != nil {
+		return nil, err
+	}
+	if job.Type != connection.JobTypeConfirmDisconnection {
+		return nil, fmt.Errorf("Unexpected job type: %s", job.Type)
+	}
+	// Unpack the connection event
+	var payload sqs.ConnectionEventPayload
+	if err := json.Unmarshal(job.Payload, &payload); err != nil {
+		return nil, err
+	}
+	return &payload, payload.Validate()
+}
+
+func decodeDestinationRecord(body string) (*consumer.StatusUpdatedEvent, error) {
+	var record destinationRecord
+	if err := json.Unmarshal([]byte(body), &record); err != nil {
+		return nil, err
+	}
+	return decodeStatusUpdatedEvent(record.RequestPayload)
+}
+
+func decodeRuleError(body string) (*consumer.StatusUpdatedEvent, error) {
+	var ruleErr ruleError
+	if err := json.Unmarshal([]byte(body), &ruleErr); err != nil {
+		return nil, err
+	}
+	// Get the shadow documents the rule was triggered by
+	original, err := base64.StdEncoding.DecodeString(ruleErr.Base64OriginalPayload)
+	if err != nil {
+		return nil, err
+	}
+	var documents shadowDocuments
+	if err := json.Unmarshal(original, &documents); err != nil {
+		return nil, err
+	}
+	// Select the fields the same as the rule does (the device ID is the third topic level)
+	levels := strings.Split(ruleErr.Topic, "/")
+	if len(levels) < 3 {
+		return nil, fmt.Errorf("Unexpected topic: %s", ruleErr.Topic)
+	}
+	selected, err := json.Marshal(map[string]interface{}{
+		"deviceId":  levels[2],
+		"timestamp": documents.Timestamp,
+		"state":     documents.Current.State.Reported,
+		"updated":   documents.Current.Metadata.Reported,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return decodeStatusUpdatedEvent(selected)
+}
+
+func decodeStatusUpdatedEvent(payload []byte) (*consumer.StatusUpdatedEvent, error) {
+	var event consumer.StatusUpdatedEvent
+	if err := json.Unmarshal(payload, &event); err != nil {
+		return nil, err
+	}
+	return &event, shared.Validate.Struct(event)
+}
@@ -0,0 +1,65 @@
+package replay
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/briggysmalls/detectordag/shared/email"
+	"github.com/briggysmalls/detectordag/shared/scheduler"
+	"github.com/briggysmalls/detectordag/shared/shadow"
+	"github.com/briggysmalls/detectordag/shared/state"
+)
+
+type dryRunEmailer struct {
+	out io.Writer
+}
+
+// NewDryRunEmailer gets an Emailer that prints the emails it would send
+func NewDryRunEmailer(out io.Writer) email.Emailer {
+	return &dryRunEmailer{out: out}
+}
+
+func (e *dryRunEmailer) SendUpdate(toAddresses []string, event state.Event, context email.ContextData) error {
+	_, err := fmt.Fprintf(e.out, "Would email %v: '%s' %s at %s\n", toAddresses, context.DeviceName, event.Transition, context.Time)
+	return err
+}
+
+func (e *dryRunEmailer) SendAlert(toAddresses []string, alert email.Alert, current state.State, context email.ContextData) error {
+	_, err := fmt.Fprintf(e.out, "Would email %v: '%s' %s at %s\n", toAddresses, context.DeviceName, alert, context.Time)
+	return err
+}
+
+type dryRunScheduler struct {
+	out io.Writer
+}
+
+// NewDryRunScheduler gets a Scheduler that prints the jobs it would schedule
+func NewDryRunScheduler(out io.Writer) scheduler.Scheduler {
+	return &dryRunScheduler{out: out}
+}
+
+func (s *dryRunScheduler) Schedule(jobType string, payload interface{}, at time.Time) error {
+	_, err := fmt.Fprintf(s.out, "Would schedule '%s' job at %s: %+v\n", jobType, at, payload)
+	return err
+}
+
+func (s *dryRunScheduler) ScheduleAfter(jobType string, payload interface{}, delay time.Duration) error {
+	_, err := fmt.Fprintf(s.out, "Would schedule '%s' job in %s: %+v\n", jobType, delay, payload)
+	return err
+}
+
+type dryRunShadow struct {
+	shadow.Client
+	out io.Writer
+}
+
+// NewDryRunShadow gets a shadow Client that reads from the shadow, but prints the outages it would record
+func NewDryRunShadow(client shadow.Client, out io.Writer) shadow.Client {
+	return &dryRunShadow{Client: client, out: out}
+}
+
+func (s *dryRunShadow) UpdateOutage(deviceID string, outage shadow.OutageShadow) error {
+	_, err := fmt.Fprintf(s.out, "Would record outage for '%s': %+v\n", deviceID, outage)
+	return err
+}
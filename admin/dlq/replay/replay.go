@@ -0,0 +1,147 @@
+package replay
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	consumer "github.com/briggysmalls/detectordag/consumer/app"
+	"github.com/briggysmalls/detectordag/shared/database"
+	"github.com/briggysmalls/detectordag/shared/iot"
+	"github.com/briggysmalls/detectordag/shared/shadow"
+	"github.com/briggysmalls/detectordag/shared/sqs"
+)
+
+const (
+	// The most messages SQS will return at once
+	maxReceive = 10
+	// How long received messages are hidden, giving time to decide whether to replay them
+	visibilityTimeout = 5 * time.Minute
+	// How long to wait for messages (long polling asks every SQS server, so we don't stop early)
+	receiveWait = 2 * time.Second
+)
+
+type replayer struct {
+	dlq      sqs.Client
+	source   sqs.Client
+	consumer consumer.App
+	shadow   shadow.Client
+	iot      iot.Client
+	db       database.Client
+	out      io.Writer
+	dryRun   bool
+}
+
+// App inspects and replays dead-lettered events
+type App interface {
+	List() ([]Entry, error)
+	Replay(entry Entry) error
+}
+
+// New gets an App for the given dead-letter queue
+// Connection events are replayed by sending them back to the source queue
+// Power status updates are replayed by the consumer, which should print rather than email for a dry run
+func New(
+	dlq sqs.Client,
+	source sqs.Client,
+	consumer consumer.App,
+	shadow shadow.Client,
+	iot iot.Client,
+	db database.Client,
+	out io.Writer,
+	dryRun bool,
+) App {
+	return &replayer{
+		dlq:      dlq,
+		source:   source,
+		consumer: consumer,
+		shadow:   shadow,
+		iot:      iot,
+		db:       db,
+		out:      out,
+		dryRun:   dryRun,
+	}
+}
+
+// List receives all the messages on the dead-letter queue
+func (a *replayer) List() ([]Entry, error) {
+	entries := []Entry{}
+	for {
+		messages, err := a.dlq.Receive(maxReceive, visibilityTimeout, receiveWait)
+		if err != nil {
+			return nil, err
+		}
+		// Stop once the queue is empty (short polls can come back empty when it isn't)
+		if len(messages) == 0 {
+			return entries, nil
+		}
+		for _, message := range messages {
+			entries = append(entries, Decode(message))
+		}
+	}
+}
+
+// Replay sends an event through its handler again
+// Replayed messages are removed from the dead-letter queue, unless this is a dry run
+func (a *replayer) Replay(entry Entry) error {
+	// We can't replay messages we don't understand
+	if entry.Err != nil {
+		return entry.Err
+	}
+	var err error
+	switch {
+	case entry.Connection != nil:
+		err = a.replayConnection(entry)
+	case entry.Status != nil:
+		err = a.consumer.HandleRequest(context.Background(), *entry.Status)
+	default:
+		err = errors.New("Entry has no event")
+	}
+	if err != nil || a.dryRun {
+		return err
+	}
+	// The event has been handled, so it is no longer dead
+	return a.dlq.Delete(entry.Message.ReceiptHandle)
+}
+
+func (a *replayer) replayConnection(entry Entry) error {
+	if !a.dryRun {
+		// Send the event to be handled again straight away
+		return a.source.Send([]byte(entry.Message.Body), 0)
+	}
+	// Check the event hasn't been superseded (the handler would ignore it)
+	payload := entry.Connection
+	shdw, err := a.shadow.Get(payload.DeviceID)
+	if err != nil {
+		return err
+	}
+	if shdw.Connection.TransientID != payload.ID {
+		fmt.Fprintf(a.out, "Would ignore '%s' event for '%s': superseded by a newer event\n", payload.Status, shdw.Name)
+		return nil
+	}
+	// Find out who would be notified
+	device, err := a.iot.GetThing(payload.DeviceID)
+	if err != nil {
+		return err
+	}
+	account, err := a.db.GetAccountById(device.AccountId)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(a.out, "Would email %v: '%s' %s at %s\n", account.Emails, shdw.Name, payload.Status, payload.Time)
+	return nil
+}
+
+// String describes the entry
+func (e Entry) String() string {
+	switch {
+	case e.Err != nil:
+		return fmt.Sprintf("%s: undecodable (%v)", e.Message.ID, e.Err)
+	case e.Connection != nil:
+		return fmt.Sprintf("%s: device '%s' %s at %s", e.Message.ID, e.Connection.DeviceID, e.Connection.Status, e.Connection.Time)
+	default:
+		return fmt.Sprintf("%s: device '%s' power %s at %s", e.Message.ID, e.Status.DeviceId, e.Status.State.Status, time.Unix(e.Status.Updated.Status.Timestamp, 0).UTC())
+	}
+}
@@ -0,0 +1,166 @@
+package replay
+
+//go:generate go run github.com/golang/mock/mockgen -destination mock_sqs.go -package replay -mock_names Client=MockSQSClient github.com/briggysmalls/detectordag/shared/sqs Client
+//go:generate go run github.com/golang/mock/mockgen -destination mock_shadow.go -package replay -mock_names Client=MockShadowClient github.com/briggysmalls/detectordag/shared/shadow Client
+//go:generate go run github.com/golang/mock/mockgen -destination mock_iot.go -package replay -mock_names Client=MockIoTClient github.com/briggysmalls/detectordag/shared/iot Client
+//go:generate go run github.com/golang/mock/mockgen -destination mock_db.go -package replay -mock_names Client=MockDBClient github.com/briggysmalls/detectordag/shared/database Client
+//go:generate go run github.com/golang/mock/mockgen -destination mock_consumer.go -package replay -mock_names App=MockConsumer github.com/briggysmalls/detectordag/consumer/app App
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/briggysmalls/detectordag/shared/database"
+	"github.com/briggysmalls/detectordag/shared/iot"
+	"github.com/briggysmalls/detectordag/shared/shadow"
+	"github.com/briggysmalls/detectordag/shared/sqs"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	deviceID    = "b6d62b30-00ac-49c4-9268-88559a46889f"
+	accountID   = "c6d62b30-00ac-49c4-9268-88559a46889f"
+	transientID = "52068a06-f89d-4256-9b64-48fa990088d9"
+)
+
+// A disconnection that failed to be confirmed
+var jobBody = fmt.Sprintf(`{
+	"type":"confirm-disconnection",
+	"runAt":"2020-12-12T20:00:00Z",
+	"payload":{"deviceId":"%s","id":"%s","type":"disconnected","time":"2020-12-12T19:58:16Z"}
+}`, deviceID, transientID)
+
+type mocks struct {
+	dlq      *MockSQSClient
+	source   *MockSQSClient
+	consumer *MockConsumer
+	shadow   *MockShadowClient
+	iot      *MockIoTClient
+	db       *MockDBClient
+}
+
+func TestDecode(t *testing.T) {
+	// Shadow documents, as sent to the IoT rule
+	documents := `{"timestamp":1584803414,"current":{
+		"state":{"reported":{"status":"off"}},
+		"metadata":{"reported":{"status":{"timestamp":1584803410}}}
+	}}`
+	testParams := []struct {
+		body       string
+		connection bool
+		status     bool
+	}{
+		{body: jobBody, connection: true},
+		{ // A failed asynchronous invocation of the consumer
+			body: fmt.Sprintf(`{
+				"requestContext":{"functionArn":"arn:aws:lambda:eu-west-2:123456789012:function:consumer","condition":"RetriesExhausted"},
+				"requestPayload":{"deviceId":"%s","timestamp":1584803414,"state":{"status":"off"},"updated":{"status":{"timestamp":1584803410}}}
+			}`, deviceID),
+			status: true,
+		},
+		{ // A failure of the IoT rule
+			body: fmt.Sprintf(`{"ruleName":"PowerStatusChanged","topic":"$aws/things/%s/shadow/update/documents","base64OriginalPayload":"%s"}`,
+				deviceID, base64.StdEncoding.EncodeToString([]byte(documents))),
+			status: true,
+		},
+		{body: `not json`},
+		{body: `{"something":"else"}`},
+	}
+	for _, params := range testParams {
+		entry := Decode(sqs.Message{ID: "id", Body: params.body})
+		if !params.connection && !params.status {
+			assert.Error(t, entry.Err)
+			continue
+		}
+		assert.NoError(t, entry.Err)
+		if params.connection {
+			assert.Equal(t, deviceID, entry.Connection.DeviceID)
+			assert.Equal(t, transientID, entry.Connection.ID)
+		}
+		if params.status {
+			assert.Equal(t, deviceID, entry.Status.DeviceId)
+			assert.Equal(t, shadow.POWER_STATUS_OFF, entry.Status.State.Status)
+			assert.Equal(t, int64(1584803410), entry.Status.Updated.Status.Timestamp)
+		}
+	}
+}
+
+func TestList(t *testing.T) {
+	// Create the unit under test
+	a, m, _ := getStubbedApp(t, false)
+	// Return a page of messages, then nothing
+	gomock.InOrder(
+		m.dlq.EXPECT().Receive(maxReceive, visibilityTimeout, receiveWait).Return([]sqs.Message{{ID: "one", Body: jobBody}, {ID: "two", Body: "{}"}}, nil),
+		m.dlq.EXPECT().Receive(maxReceive, visibilityTimeout, receiveWait).Return([]sqs.Message{}, nil),
+	)
+	// Run the test
+	entries, err := a.List()
+	assert.NoError(t, err)
+	assert.Len(t, entries, 2)
+	assert.NotNil(t, entries[0].Connection)
+	assert.Error(t, entries[1].Err)
+}
+
+func TestReplayConnection(t *testing.T) {
+	// Create the unit under test
+	a, m, _ := getStubbedApp(t, false)
+	entry := Decode(sqs.Message{ID: "one", Body: jobBody, ReceiptHandle: "handle"})
+	// Expect the event to be sent back to its queue, then removed
+	gomock.InOrder(
+		m.source.EXPECT().Send([]byte(jobBody), time.Duration(0)),
+		m.dlq.EXPECT().Delete("handle"),
+	)
+	assert.NoError(t, a.Replay(entry))
+}
+
+func TestReplayConnectionDryRun(t *testing.T) {
+	// Create the unit under test
+	a, m, out := getStubbedApp(t, true)
+	entry := Decode(sqs.Message{ID: "one", Body: jobBody, ReceiptHandle: "handle"})
+	// Expect the notification to be looked up, but nothing sent or deleted
+	m.shadow.EXPECT().Get(deviceID).Return(&shadow.Shadow{Name: "Annex", Connection: shadow.ConnectionShadow{TransientID: transientID}}, nil)
+	m.iot.EXPECT().GetThing(deviceID).Return(&iot.Device{DeviceId: deviceID, AccountId: accountID}, nil)
+	m.db.EXPECT().GetAccountById(accountID).Return(&database.Account{Emails: []string{"me@example.com"}}, nil)
+	assert.NoError(t, a.Replay(entry))
+	assert.Contains(t, out.String(), "Would email [me@example.com]: 'Annex' disconnected")
+}
+
+func TestReplayStatus(t *testing.T) {
+	testParams := []bool{false, true}
+	for _, dryRun := range testParams {
+		// Create the unit under test
+		a, m, _ := getStubbedApp(t, dryRun)
+		entry := Decode(sqs.Message{ID: "one", ReceiptHandle: "handle", Body: fmt.Sprintf(`{
+			"requestContext":{"condition":"RetriesExhausted"},
+			"requestPayload":{"deviceId":"%s","state":{"status":"on"},"updated":{"status":{"timestamp":1584803410}}}
+		}`, deviceID)})
+		// Expect the event to be handled by the consumer
+		m.consumer.EXPECT().HandleRequest(gomock.Any(), *entry.Status)
+		// Expect the message to be removed, unless this is a dry run
+		if !dryRun {
+			m.dlq.EXPECT().Delete("handle")
+		}
+		assert.NoError(t, a.Replay(entry))
+	}
+}
+
+func getStubbedApp(t *testing.T, dryRun bool) (App, mocks, *bytes.Buffer) {
+	// Create mock controller
+	ctrl := gomock.NewController(t)
+	// Create the mocks
+	m := mocks{
+		dlq:      NewMockSQSClient(ctrl),
+		source:   NewMockSQSClient(ctrl),
+		consumer: NewMockConsumer(ctrl),
+		shadow:   NewMockShadowClient(ctrl),
+		iot:      NewMockIoTClient(ctrl),
+		db:       NewMockDBClient(ctrl),
+	}
+	// Capture the output
+	out := &bytes.Buffer{}
+	return New(m.dlq, m.source, m.consumer, m.shadow, m.iot, m.db, out, dryRun), m, out
+}
@@ -0,0 +1,180 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/briggysmalls/detectordag/shared"
+	"github.com/briggysmalls/detectordag/shared/email"
+	"github.com/briggysmalls/detectordag/shared/food"
+	"github.com/briggysmalls/detectordag/shared/maintenance"
+	"github.com/briggysmalls/detectordag/shared/scheduler"
+	"github.com/briggysmalls/detectordag/shared/shadow"
+	"github.com/briggysmalls/detectordag/shared/sqs"
+	"github.com/briggysmalls/detectordag/shared/state"
+	"github.com/briggysmalls/detectordag/shared/telemetry"
+)
+
+// claimLifetime is how long after a maintenance window ends we remember that it was notified
+const claimLifetime = 7 * 24 * time.Hour
+
+type app struct {
+	*telemetry.Clients
+	queue scheduler.Queue
+	clock scheduler.Clock
+}
+
+type App interface {
+	Handler(ctx context.Context, sqsEvent events.SQSEvent) (sqs.BatchResponse, error)
+}
+
+// New gets an App that advises accounts when a power cut has lasted longer than their food stays safe
+// It also notifies accounts when a maintenance window ends with the power still off
+func New(clients *telemetry.Clients, queue scheduler.Queue, dispatcher *scheduler.Dispatcher, clock scheduler.Clock) App {
+	a := &app{
+		Clients: clients,
+		queue:   queue,
+		clock:   clock,
+	}
+	// Handle the advisories scheduled when the power went off
+	dispatcher.Register(food.JobTypeAdvisory, a.advise)
+	// Handle the checks scheduled when the power went off during maintenance
+	dispatcher.Register(maintenance.JobTypeEnded, a.maintenanceEnded)
+	return a
+}
+
+// Handler handles SQS events
+// Failed messages are reported individually, so only they are retried
+func (a *app) Handler(ctx context.Context, sqsEvent events.SQSEvent) (sqs.BatchResponse, error) {
+	response := sqs.BatchResponse{}
+	// Handle SQS events
+	for _, message := range sqsEvent.Records {
+		if err := a.queue.Handle(message.Body); err != nil {
+			log.Printf("Failed to handle message '%s': %v", message.MessageId, err)
+			response.BatchItemFailures = append(response.BatchItemFailures, sqs.BatchItemFailure{
+				ItemIdentifier: message.MessageId,
+			})
+		}
+	}
+	return response, nil
+}
+
+func (a *app) advise(body json.RawMessage) error {
+	// Deserialise the advisory
+	var payload food.AdvisoryPayload
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return err
+	}
+	// Validate the parsed struct
+	if err := shared.Validate.Struct(payload); err != nil {
+		return err
+	}
+	// Get the current device shadow
+	shdw, err := a.Shadow.Get(payload.DeviceID)
+	if err != nil {
+		return err
+	}
+	// Check the power cut is still going, and we haven't already advised on it
+	if !shdw.Outage.Started.Equal(payload.OutageStarted) || shdw.Outage.Advised {
+		log.Printf("Device '%s' no longer needs advising on the power cut from %s", payload.DeviceID, payload.OutageStarted)
+		return nil
+	}
+	// Check the account still wants advising
+	limit, ok := food.Limit(shdw.Appliance)
+	if !ok {
+		return nil
+	}
+	// Get the account
+	account, err := a.Account(payload.DeviceID)
+	if err != nil {
+		return err
+	}
+	// Hold the advice back until the end of any maintenance window
+	now := a.clock.Now()
+	if window, ok := maintenance.Find(account.MaintenanceWindowsFor(shdw.Maintenance), now); ok {
+		log.Printf("Device '%s' is in maintenance window '%s', advising later", payload.DeviceID, window.ID)
+		return a.queue.Schedule(food.JobTypeAdvisory, payload, window.End(now))
+	}
+	// Determine the state of the device
+	current, err := state.New(shdw.Connection.Status, shdw.Power.Value)
+	if err != nil {
+		return err
+	}
+	// Send 'food safety' emails
+	update := telemetry.NewContext(shdw, account, now)
+	update.Food = &email.FoodData{
+		Appliance: shdw.Appliance.Type,
+		Limit:     limit,
+		Outage:    now.Sub(payload.OutageStarted),
+	}
+	log.Printf("Send emails to: %s", account.Emails)
+	if err := a.Emailer.SendAlert(account.Emails, email.FoodSafety, current, update); err != nil {
+		return shared.LogErrorAndReturn(err)
+	}
+	// Remember we've advised on this power cut
+	return a.Shadow.UpdateOutage(payload.DeviceID, shadow.OutageShadow{Started: shdw.Outage.Started, Advised: true})
+}
+
+func (a *app) maintenanceEnded(body json.RawMessage) error {
+	// Deserialise the check
+	var payload maintenance.EndedPayload
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return err
+	}
+	// Validate the parsed struct
+	if err := shared.Validate.Struct(payload); err != nil {
+		return err
+	}
+	// Get the current device shadow
+	shdw, err := a.Shadow.Get(payload.DeviceID)
+	if err != nil {
+		return err
+	}
+	// Check the power is still off
+	if shdw.Power.Value != shadow.POWER_STATUS_OFF {
+		log.Printf("Device '%s' power came back during maintenance", payload.DeviceID)
+		return nil
+	}
+	// Get the account
+	account, err := a.Account(payload.DeviceID)
+	if err != nil {
+		return err
+	}
+	// Check again later if another window has started (or this one was extended)
+	windows := account.MaintenanceWindowsFor(shdw.Maintenance)
+	if window, ok := maintenance.Find(windows, payload.Ended); ok {
+		log.Printf("Device '%s' is still in maintenance window '%s'", payload.DeviceID, window.ID)
+		next := maintenance.EndedPayload{DeviceID: payload.DeviceID, Ended: window.End(payload.Ended)}
+		return a.queue.Schedule(maintenance.JobTypeEnded, next, next.Ended)
+	}
+	// Determine the state of the device
+	current, err := state.New(shdw.Connection.Status, shdw.Power.Value)
+	if err != nil {
+		return err
+	}
+	// Only notify once, however many times the power went off during the window
+	id := fmt.Sprintf("%s/%s/%d", payload.DeviceID, maintenance.JobTypeEnded, payload.Ended.Unix())
+	claimed, err := a.DB.ClaimEvent(id, payload.Ended.Add(claimLifetime))
+	if err != nil {
+		return err
+	}
+	if !claimed {
+		log.Printf("Event '%s' has already been notified", id)
+		return nil
+	}
+	// Send 'maintenance ended' emails
+	update := telemetry.NewContext(shdw, account, payload.Ended)
+	log.Printf("Send emails to: %s", account.Emails)
+	if err := a.Emailer.SendAlert(account.Emails, email.MaintenanceEnded, current, update); err != nil {
+		// Give up our claim, so that a retry can notify
+		if releaseErr := a.DB.ReleaseEvent(id); releaseErr != nil {
+			log.Printf("Failed to release event '%s': %v", id, releaseErr)
+		}
+		return shared.LogErrorAndReturn(err)
+	}
+	return nil
+}
@@ -0,0 +1,266 @@
+package app
+
+//go:generate go run github.com/golang/mock/mockgen -destination mock_db.go -package app -mock_names Client=MockDBClient github.com/briggysmalls/detectordag/shared/database Client
+//go:generate go run github.com/golang/mock/mockgen -destination mock_iot.go -package app -mock_names Client=MockIoTClient github.com/briggysmalls/detectordag/shared/iot Client
+//go:generate go run github.com/golang/mock/mockgen -destination mock_shadow.go -package app -mock_names Client=MockShadowClient github.com/briggysmalls/detectordag/shared/shadow Client
+//go:generate go run github.com/golang/mock/mockgen -destination mock_email.go -package app github.com/briggysmalls/detectordag/shared/email Emailer
+//go:generate go run github.com/golang/mock/mockgen -destination mock_sqs.go -package app -mock_names Client=MockSQSClient github.com/briggysmalls/detectordag/shared/sqs Client
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/briggysmalls/detectordag/shared/database"
+	"github.com/briggysmalls/detectordag/shared/email"
+	"github.com/briggysmalls/detectordag/shared/food"
+	"github.com/briggysmalls/detectordag/shared/iot"
+	"github.com/briggysmalls/detectordag/shared/maintenance"
+	"github.com/briggysmalls/detectordag/shared/scheduler"
+	"github.com/briggysmalls/detectordag/shared/shadow"
+	"github.com/briggysmalls/detectordag/shared/sqs"
+	"github.com/briggysmalls/detectordag/shared/state"
+	"github.com/briggysmalls/detectordag/shared/telemetry"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	deviceID  = "792ac520-0733-4ffe-8137-8aba3ca446d7"
+	accountID = "c6d62b30-00ac-49c4-9268-88559a46889f"
+	// The power went off at 2020/04/15 08:00:00
+	outageStarted = 1586937600
+)
+
+// The time at which the tests are run (5 hours into the power cut)
+var now = time.Unix(outageStarted, 0).Add(5 * time.Hour)
+
+// The time at which the maintenance window ended
+var maintenanceEnded = now.Add(-time.Minute)
+
+type mocks struct {
+	db      *MockDBClient
+	iot     *MockIoTClient
+	shadow  *MockShadowClient
+	emailer *MockEmailer
+	sqs     *MockSQSClient
+}
+
+func TestAdvised(t *testing.T) {
+	testParams := []struct {
+		connection string
+		current    state.State
+	}{
+		{connection: shadow.CONNECTION_STATUS_CONNECTED, current: state.Off},
+		// The device's battery has run out
+		{connection: shadow.CONNECTION_STATUS_DISCONNECTED, current: state.WasOff},
+	}
+	for _, params := range testParams {
+		// Create app under test
+		app, m := getStubbedApp(t)
+		shdw := createShadow(shadow.OutageShadow{Started: time.Unix(outageStarted, 0)})
+		shdw.Connection.Status = params.connection
+		m.shadow.EXPECT().Get(deviceID).Return(shdw, nil)
+		// Expect the account to be advised
+		m.iot.EXPECT().GetThing(deviceID).Return(&iot.Device{DeviceId: deviceID, AccountId: accountID}, nil)
+		m.db.EXPECT().GetAccountById(accountID).Return(&database.Account{AccountId: accountID, Emails: []string{"owner@example.com"}}, nil)
+		m.emailer.EXPECT().SendAlert(
+			[]string{"owner@example.com"},
+			email.FoodSafety,
+			params.current,
+			email.ContextData{
+				DeviceName: "Kitchen",
+				Time:       now,
+				Food:       &email.FoodData{Appliance: "fridge", Limit: 4 * time.Hour, Outage: 5 * time.Hour},
+			},
+		)
+		// Expect the advice to be remembered
+		m.shadow.EXPECT().UpdateOutage(deviceID, shadow.OutageShadow{Started: time.Unix(outageStarted, 0), Advised: true})
+		// Run the test
+		assertFailures(t, app, createEvent(), 0)
+	}
+}
+
+func TestNotAdvised(t *testing.T) {
+	testParams := []struct {
+		outage    shadow.OutageShadow
+		appliance shadow.ApplianceShadow
+	}{
+		// The power came back
+		{appliance: shadow.ApplianceShadow{Type: shadow.APPLIANCE_FRIDGE}},
+		// The power came back, and then went again
+		{outage: shadow.OutageShadow{Started: time.Unix(outageStarted, 0).Add(time.Hour)}, appliance: shadow.ApplianceShadow{Type: shadow.APPLIANCE_FRIDGE}},
+		// We've already advised
+		{outage: shadow.OutageShadow{Started: time.Unix(outageStarted, 0), Advised: true}, appliance: shadow.ApplianceShadow{Type: shadow.APPLIANCE_FRIDGE}},
+		// The account no longer wants advising
+		{outage: shadow.OutageShadow{Started: time.Unix(outageStarted, 0)}},
+	}
+	for _, params := range testParams {
+		// Create app under test
+		app, m := getStubbedApp(t)
+		shdw := createShadow(params.outage)
+		shdw.Appliance = params.appliance
+		m.shadow.EXPECT().Get(deviceID).Return(shdw, nil)
+		// Run the test (expecting nothing else to happen)
+		assertFailures(t, app, createEvent(), 0)
+	}
+}
+
+func TestAdviceFailed(t *testing.T) {
+	// Create app under test
+	app, m := getStubbedApp(t)
+	m.shadow.EXPECT().Get(deviceID).Return(createShadow(shadow.OutageShadow{Started: time.Unix(outageStarted, 0)}), nil)
+	// Fail to get the account
+	m.iot.EXPECT().GetThing(deviceID).Return(nil, errors.New("Oops"))
+	// Assert the message is retried
+	assertFailures(t, app, createEvent(), 1)
+}
+
+func TestAdviceHeldBackDuringMaintenance(t *testing.T) {
+	// Create app under test
+	app, m := getStubbedApp(t)
+	m.shadow.EXPECT().Get(deviceID).Return(createShadow(shadow.OutageShadow{Started: time.Unix(outageStarted, 0)}), nil)
+	// The account is in a maintenance window
+	m.iot.EXPECT().GetThing(deviceID).Return(&iot.Device{DeviceId: deviceID, AccountId: accountID}, nil)
+	m.db.EXPECT().GetAccountById(accountID).Return(&database.Account{
+		AccountId:   accountID,
+		Emails:      []string{"owner@example.com"},
+		Maintenance: []database.MaintenanceWindow{{ID: "works", Start: now.Add(-time.Hour).Unix(), Duration: 7200, Repeat: "once"}},
+	}, nil)
+	// Expect the advice to be rescheduled for when the window ends, rather than sent
+	m.sqs.EXPECT().Send(gomock.Any(), sqs.MaxDelay).Do(func(body []byte, delay time.Duration) {
+		assert.Contains(t, string(body), food.JobTypeAdvisory)
+	})
+	// Run the test
+	assertFailures(t, app, createEvent(), 0)
+}
+
+func TestInvalidPayload(t *testing.T) {
+	testParams := []string{
+		"other",
+		jobMessage(food.JobTypeAdvisory, `{"dummy":"text"}`),
+		jobMessage(food.JobTypeAdvisory, fmt.Sprintf(`{"outageStarted":"%s"}`, time.Unix(outageStarted, 0).Format(time.RFC3339))),
+		jobMessage(food.JobTypeAdvisory, fmt.Sprintf(`{"deviceId":"%s","outageStarted":"some-bad-time"}`, deviceID)),
+	}
+	for _, body := range testParams {
+		// Create app under test
+		app, _ := getStubbedApp(t)
+		// Run the test
+		assertFailures(t, app, events.SQSEvent{Records: []events.SQSMessage{{Body: body}}}, 1)
+	}
+}
+
+func TestMaintenanceEnded(t *testing.T) {
+	testParams := []struct {
+		connection string
+		current    state.State
+	}{
+		{connection: shadow.CONNECTION_STATUS_CONNECTED, current: state.Off},
+		{connection: shadow.CONNECTION_STATUS_DISCONNECTED, current: state.WasOff},
+	}
+	for _, params := range testParams {
+		// Create app under test
+		app, m := getStubbedApp(t)
+		shdw := createShadow(shadow.OutageShadow{})
+		shdw.Connection.Status = params.connection
+		m.shadow.EXPECT().Get(deviceID).Return(shdw, nil)
+		// Expect the account to be notified, once
+		id := fmt.Sprintf("%s/maintenance-ended/%d", deviceID, maintenanceEnded.Unix())
+		m.iot.EXPECT().GetThing(deviceID).Return(&iot.Device{DeviceId: deviceID, AccountId: accountID}, nil)
+		m.db.EXPECT().GetAccountById(accountID).Return(&database.Account{AccountId: accountID, Emails: []string{"owner@example.com"}}, nil)
+		m.db.EXPECT().ClaimEvent(id, maintenanceEnded.Add(claimLifetime).UTC()).Return(true, nil)
+		m.emailer.EXPECT().SendAlert(
+			[]string{"owner@example.com"},
+			email.MaintenanceEnded,
+			params.current,
+			gomock.Any(),
+		)
+		// Run the test
+		assertFailures(t, app, endedEvent(), 0)
+	}
+}
+
+func TestMaintenanceEndedNotNotified(t *testing.T) {
+	// The power came back during the window
+	app, m := getStubbedApp(t)
+	shdw := createShadow(shadow.OutageShadow{})
+	shdw.Power.Value = shadow.POWER_STATUS_ON
+	m.shadow.EXPECT().Get(deviceID).Return(shdw, nil)
+	assertFailures(t, app, endedEvent(), 0)
+	// The power went off more than once during the window, and we've already notified
+	app, m = getStubbedApp(t)
+	m.shadow.EXPECT().Get(deviceID).Return(createShadow(shadow.OutageShadow{}), nil)
+	m.iot.EXPECT().GetThing(deviceID).Return(&iot.Device{DeviceId: deviceID, AccountId: accountID}, nil)
+	m.db.EXPECT().GetAccountById(accountID).Return(&database.Account{AccountId: accountID, Emails: []string{"owner@example.com"}}, nil)
+	m.db.EXPECT().ClaimEvent(gomock.Any(), gomock.Any()).Return(false, nil)
+	assertFailures(t, app, endedEvent(), 0)
+}
+
+func TestMaintenanceExtended(t *testing.T) {
+	// Create app under test
+	app, m := getStubbedApp(t)
+	shdw := createShadow(shadow.OutageShadow{})
+	shdw.Maintenance = []maintenance.Window{{ID: "works", Start: maintenanceEnded.Add(-time.Hour), Duration: 2 * time.Hour, Repeat: maintenance.Once}}
+	m.shadow.EXPECT().Get(deviceID).Return(shdw, nil)
+	m.iot.EXPECT().GetThing(deviceID).Return(&iot.Device{DeviceId: deviceID, AccountId: accountID}, nil)
+	m.db.EXPECT().GetAccountById(accountID).Return(&database.Account{AccountId: accountID, Emails: []string{"owner@example.com"}}, nil)
+	// Expect another check when the extended window ends
+	m.sqs.EXPECT().Send(gomock.Any(), sqs.MaxDelay)
+	// Run the test
+	assertFailures(t, app, endedEvent(), 0)
+}
+
+func createShadow(outage shadow.OutageShadow) *shadow.Shadow {
+	return &shadow.Shadow{
+		Name:       "Kitchen",
+		Connection: shadow.ConnectionShadow{Status: shadow.CONNECTION_STATUS_CONNECTED},
+		Power:      shadow.PowerShadow{Value: shadow.POWER_STATUS_OFF, Updated: time.Unix(outageStarted, 0)},
+		Appliance:  shadow.ApplianceShadow{Type: shadow.APPLIANCE_FRIDGE},
+		Outage:     outage,
+	}
+}
+
+// createEvent gets an event holding an advisory for the power cut
+func createEvent() events.SQSEvent {
+	payload := fmt.Sprintf(`{"deviceId":"%s","outageStarted":"%s"}`, deviceID, time.Unix(outageStarted, 0).Format(time.RFC3339))
+	return events.SQSEvent{Records: []events.SQSMessage{{MessageId: "1", Body: jobMessage(food.JobTypeAdvisory, payload)}}}
+}
+
+// endedEvent gets an event holding a check on the device when the maintenance window ended
+func endedEvent() events.SQSEvent {
+	payload := fmt.Sprintf(`{"deviceId":"%s","ended":"%s"}`, deviceID, maintenanceEnded.Format(time.RFC3339))
+	return events.SQSEvent{Records: []events.SQSMessage{{MessageId: "1", Body: jobMessage(maintenance.JobTypeEnded, payload)}}}
+}
+
+// jobMessage wraps the payload in a job that is due to be run
+func jobMessage(jobType, payload string) string {
+	return fmt.Sprintf(`{"type":"%s","runAt":"%s","payload":%s}`, jobType, now.Format(time.RFC3339), payload)
+}
+
+// assertFailures runs the handler, checking how many messages were reported as failed
+func assertFailures(t *testing.T, app App, event events.SQSEvent, failures int) {
+	response, err := app.Handler(nil, event)
+	assert.NoError(t, err)
+	assert.Len(t, response.BatchItemFailures, failures)
+}
+
+func getStubbedApp(t *testing.T) (App, mocks) {
+	// Create mock controller
+	ctrl := gomock.NewController(t)
+	// Create the mocks
+	m := mocks{
+		db:      NewMockDBClient(ctrl),
+		iot:     NewMockIoTClient(ctrl),
+		shadow:  NewMockShadowClient(ctrl),
+		emailer: NewMockEmailer(ctrl),
+		sqs:     NewMockSQSClient(ctrl),
+	}
+	// Create a queue that runs on a fake clock
+	clock := scheduler.NewFakeClock(now)
+	dispatcher := scheduler.NewDispatcher()
+	queue := scheduler.NewSQS(m.sqs, clock, dispatcher)
+	// Create the app
+	return New(&telemetry.Clients{DB: m.db, IoT: m.iot, Shadow: m.shadow, Emailer: m.emailer}, queue, dispatcher, clock), m
+}
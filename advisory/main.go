@@ -0,0 +1,49 @@
+package main
+
+import (
+	"log"
+	"os"
+
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/briggysmalls/detectordag/advisory/app"
+	"github.com/briggysmalls/detectordag/shared"
+	"github.com/briggysmalls/detectordag/shared/scheduler"
+	"github.com/briggysmalls/detectordag/shared/sqs"
+	"github.com/briggysmalls/detectordag/shared/telemetry"
+)
+
+const (
+	advisoryQueueEnvVar = "ADVISORY_QUEUE_URL"
+)
+
+// Prepare an application to reuse across lambda runs
+var advisory app.App
+
+func init() {
+	// Add file/line number to the default logger
+	log.SetFlags(log.LstdFlags | log.Lshortfile)
+	// Create an AWS session
+	// Good practice will share this session for all services
+	sesh := shared.CreateSession(aws.Config{})
+	// Create the clients
+	clients, err := telemetry.NewClients(sesh)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	// Create a new SQS queue client
+	sqsQueue, err := sqs.New(sesh, os.Getenv(advisoryQueueEnvVar))
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	// Create the application
+	clock := scheduler.NewClock()
+	dispatcher := scheduler.NewDispatcher()
+	queue := scheduler.NewSQS(sqsQueue, clock, dispatcher)
+	advisory = app.New(clients, queue, dispatcher, clock)
+}
+
+// main is the entrypoint to the lambda function
+func main() {
+	lambda.Start(advisory.Handler)
+}
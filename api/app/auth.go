@@ -5,19 +5,15 @@ import (
 	"errors"
 	"github.com/briggysmalls/detectordag/api/app/server"
 	"github.com/briggysmalls/detectordag/api/app/tokens"
+	"github.com/briggysmalls/detectordag/shared/database"
 	"github.com/briggysmalls/detectordag/shared/iot"
 	"github.com/gorilla/mux"
 	"net/http"
 	"strings"
 )
 
-const (
-	AuthenticationHeaderPrefix = "Bearer "
-)
-
 var (
-	ErrNoAuthHeader           = errors.New("Authorization header not set")
-	ErrMalformattedAuthHeader = errors.New("Authorization header badly formed")
+	ErrTokenRevoked = errors.New("Token has been revoked")
 	// Internal because gorilla should catch this
 	errPathParameterMissing = errors.New("Path parameter missing")
 )
@@ -25,6 +21,7 @@ var (
 type auth struct {
 	tokens tokens.Tokens
 	iot    iot.Client
+	db     database.Client
 }
 
 type accountFetcher func(r *http.Request) (string, error)
@@ -33,12 +30,13 @@ type accountFetcher func(r *http.Request) (string, error)
 func (a *auth) middleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Ensure there is a token
-		token, err := getToken(&r.Header)
+		token, err := server.GetToken(&r.Header)
 		if err != nil {
 			server.SetError(w, err, http.StatusInternalServerError)
+			return
 		}
 		// Check that the token is valid
-		tokenAccountID, err := a.tokens.Validate(token)
+		claims, err := a.tokens.Validate(token)
 		switch err {
 		case tokens.ErrBadToken:
 			server.SetError(w, err, http.StatusForbidden)
@@ -49,6 +47,16 @@ func (a *auth) middleware(next http.Handler) http.Handler {
 		default:
 			break
 		}
+		// Check that the token hasn't been revoked
+		revoked, err := a.db.IsTokenRevoked(claims.Id)
+		if err != nil {
+			server.SetError(w, err, http.StatusInternalServerError)
+			return
+		}
+		if revoked {
+			server.SetError(w, ErrTokenRevoked, http.StatusForbidden)
+			return
+		}
 		// Fetch the account associated with the resource request
 		accountID, err := a.getAccount(r)
 		// Ensure we were able to get the account
@@ -65,7 +73,7 @@ func (a *auth) middleware(next http.Handler) http.Handler {
 			return
 		}
 		// Ensure we are authorised to access the account's resources
-		if accountID != tokenAccountID {
+		if accountID != claims.AccountId {
 			// Don't reveal that the account exists by returning 403
 			server.SetError(w, errors.New("Not found"), http.StatusNotFound)
 			return
@@ -117,17 +125,3 @@ func (a *auth) getAccountFromDevice(r *http.Request) (string, error) {
 	}
 	return d.AccountId, nil
 }
-
-func getToken(header *http.Header) (string, error) {
-	// Check the auth header is set
-	authHeader := header.Get("Authorization")
-	if authHeader == "" {
-		return "", ErrNoAuthHeader
-	}
-	// Ensure we've been given a JWT how we expect
-	if !strings.HasPrefix(authHeader, AuthenticationHeaderPrefix) {
-		return "", ErrMalformattedAuthHeader
-	}
-	// Return the token
-	return strings.TrimPrefix(authHeader, AuthenticationHeaderPrefix), nil
-}
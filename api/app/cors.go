@@ -10,11 +10,13 @@ func corsMiddleware(next http.Handler) http.Handler {
 		// Add CORS header
 		w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 		w.Header().Set("Access-Control-Allow-Origin", "*")
+		// Let the frontend see entity tags, so it can make conditional requests, and when it can retry
+		w.Header().Set("Access-Control-Expose-Headers", "ETag,Retry-After")
 		// Add options headers if necessary
 		if r.Method == http.MethodOptions {
-			w.Header().Set("Access-Control-Allow-Headers", "Content-Type,Authorization")
+			w.Header().Set("Access-Control-Allow-Headers", "Content-Type,Authorization,If-None-Match")
 			// Quick hack for allowing all our methods across all endpoings
-			w.Header().Set("Access-Control-Allow-Methods", "GET,PATCH,POST")
+			w.Header().Set("Access-Control-Allow-Methods", "GET,PATCH,POST,PUT,DELETE")
 			w.WriteHeader(http.StatusOK)
 			// Our work here is done
 			return
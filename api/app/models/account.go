@@ -7,12 +7,65 @@ type Emails struct {
 	Emails []string `json:"emails"`
 }
 
+// Branding overrides the look of the account's notification emails
+// Fields that are empty use the default look
+type Branding struct {
+	// Logo shown at the top of emails, which must be served over https
+	// example: https://example.com/logo.png
+	LogoURL string `json:"logoUrl" validate:"omitempty,url,startswith=https://"`
+	// Colour of the header (hex)
+	// example: #17a2b8
+	HeaderColour string `json:"headerColour" validate:"omitempty,hexcolor"`
+	// Colour of buttons (hex)
+	// example: #17a2b8
+	ButtonColour string `json:"buttonColour" validate:"omitempty,hexcolor"`
+	// Name that emails are sent from
+	// example: Acme Catering
+	SenderName string `json:"senderName" validate:"omitempty,max=64"`
+}
+
 type Account struct {
 	// The username of the account
 	// required: true
 	// example: user@example.com
 	Username string `json:"username"`
 	Emails
+	// Whether a code is needed to log in, as well as the password
+	// required: true
+	// example: false
+	MFAEnabled bool `json:"mfaEnabled"`
+	// Monthly data allowance of each device's plan (bytes), or zero for no allowance
+	// required: true
+	// example: 524288000
+	DataCapBytes int64 `json:"dataCapBytes"`
+	// IANA name of the time zone that recurring maintenance windows follow (empty for UTC)
+	// required: true
+	// example: Europe/London
+	TimeZone string `json:"timeZone"`
+	// Seconds to wait before notifying that a device has disconnected, in case it reconnects
+	// required: true
+	// example: 300
+	GracePeriodSeconds int `json:"gracePeriodSeconds"`
+	// Overrides for the look of notification emails
+	// required: true
+	Branding Branding `json:"branding"`
+}
+
+type MutableAccount struct {
+	// The emails associated with the account
+	// example: ["jane@example.com", "john@example.com"]
+	Emails *[]string `json:"emails"`
+	// Monthly data allowance of each device's plan (bytes, 0 to remove the allowance)
+	// example: 524288000
+	DataCapBytes *int64 `json:"dataCapBytes" validate:"omitempty,min=0"`
+	// IANA name of the time zone that recurring maintenance windows follow (empty for UTC)
+	// example: Europe/London
+	TimeZone *string `json:"timeZone"`
+	// Seconds to wait before notifying that a device has disconnected (at most a day, 0 to notify straight away)
+	// example: 300
+	GracePeriodSeconds *int `json:"gracePeriodSeconds" validate:"omitempty,min=0,max=86400"`
+	// Overrides for the look of notification emails, replacing any already set
+	Branding *Branding `json:"branding"`
 }
 
 // Successful account retrieval
@@ -29,7 +82,7 @@ type AccountNotFoundResponse struct {
 	Body ModelError
 }
 
-// swagger:parameters getAccount getDevices updateAccount
+// swagger:parameters getAccount getDevices updateAccount getLogins
 type AccountParameter struct {
 	// ID of account that is to be queried
 	//
@@ -39,10 +92,11 @@ type AccountParameter struct {
 }
 
 // swagger:parameters updateAccount
-type EmailsParameter struct {
+type MutableAccountParameter struct {
 	// Properties to update about the account
+	// Fields that are left out are unchanged
 	//
 	// required: true
 	// in: body
-	Body Emails
+	Body MutableAccount
 }
@@ -0,0 +1,33 @@
+package models
+
+type Appliance struct {
+	// Appliance the device is watching over
+	// required: true
+	// example: freezer
+	Type string `json:"type" validate:"required,eq=fridge|eq=freezer"`
+	// How long food stays safe without power (seconds)
+	// When setting, leave out to use the usual limit for the appliance (4 hours for a fridge, 24 hours for a freezer)
+	// example: 172800
+	SafeFor int `json:"safeFor,omitempty" validate:"min=0,max=604800"`
+}
+
+// swagger:parameters updateDeviceAppliance
+type ApplianceParameter struct {
+	// ID of device
+	//
+	// required: true
+	// in: path
+	DeviceID string `json:"deviceId"`
+	// Appliance to advise on food safety for, when the power has been off too long
+	//
+	// required: true
+	// in: body
+	Appliance Appliance
+}
+
+// Successful appliance update
+// swagger:response getDeviceApplianceResponse
+type GetDeviceApplianceResponse struct {
+	// in: body
+	Body Appliance
+}
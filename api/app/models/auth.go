@@ -20,9 +20,20 @@ type Token struct {
 	// required: true
 	// example: 7ea472c0-bb92-4989-9471-6a4560ac7a31
 	AccountId string `json:"accountId"`
+	// Token that can be exchanged once for new tokens, when the access token expires
+	// required: true
+	// example: 3q2-7wEjRWeJq83vASNFZ4mrze8BI0VniavN7wEjRWc
+	RefreshToken string `json:"refreshToken"`
 }
 
-// swagger:parameters getAccount updateAccount getDevices updateDevice
+type Refresh struct {
+	// Refresh token obtained through authentication
+	// required: true
+	// example: 3q2-7wEjRWeJq83vASNFZ4mrze8BI0VniavN7wEjRWc
+	RefreshToken string `json:"refreshToken" validate:"required"`
+}
+
+// swagger:parameters getAccount updateAccount getDevices updateDevice logout getLogins enrolMFA confirmMFA
 type TokenParameter struct {
 	// A token obtained through authentication
 	//
@@ -41,6 +52,30 @@ type AuthParameters struct {
 	Body Credentials
 }
 
+// Refresh token to exchange
+// swagger:parameters refresh
+type RefreshParameters struct {
+	// Refresh token to exchange for new tokens
+	//
+	// required: true
+	// in:body
+	Body Refresh
+}
+
+// Refresh token to forget when logging out
+// swagger:parameters logout
+type LogoutParameters struct {
+	// Refresh token to forget, so it can't be used again
+	//
+	// in:body
+	Body Refresh
+}
+
+// Successfully logged out
+// swagger:response loggedOutResponse
+type LoggedOutResponse struct {
+}
+
 // Successful authentication
 // swagger:response tokenResponse
 type TokenResponse struct {
@@ -68,3 +103,10 @@ type UnauthorisedResponse struct {
 	// in:body
 	Body ModelError
 }
+
+// Too many failed attempts to log in (see the Retry-After header)
+// swagger:response tooManyAttemptsResponse
+type TooManyAttemptsResponse struct {
+	// in:body
+	Body ModelError
+}
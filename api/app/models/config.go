@@ -0,0 +1,67 @@
+package models
+
+type DeviceConfig struct {
+	// How often the device reports its status (seconds)
+	// example: 300
+	ReportInterval int `json:"reportInterval,omitempty"`
+	// How long the device has to respond to a status request (seconds)
+	// example: 10
+	StatusRequestTimeout int `json:"statusRequestTimeout,omitempty"`
+	// UPS charge below which the battery is considered low (percent)
+	// example: 20
+	LowBatteryThreshold int `json:"lowBatteryThreshold,omitempty"`
+}
+
+type DeviceConfigStatus struct {
+	// Configuration the device has been asked to apply
+	// required: true
+	Desired DeviceConfig `json:"desired"`
+	// Configuration the device reports it is using
+	// required: true
+	Reported DeviceConfig `json:"reported"`
+	// Desired configuration the device is yet to apply
+	// required: true
+	Delta DeviceConfig `json:"delta"`
+	// Whether the device has applied all the desired configuration
+	// required: true
+	// example: true
+	Applied bool `json:"applied"`
+}
+
+type MutableDeviceConfig struct {
+	// How often the device should report its status (seconds, 0 to go back to the default)
+	// example: 300
+	ReportInterval *int `json:"reportInterval" validate:"omitempty,eq=0|min=10,max=86400"`
+	// How long the device should have to respond to a status request (seconds, 0 to go back to the default)
+	// example: 10
+	StatusRequestTimeout *int `json:"statusRequestTimeout" validate:"omitempty,eq=0|min=1,max=3600"`
+	// UPS charge below which the battery should be considered low (percent, 0 to go back to the default)
+	// example: 20
+	LowBatteryThreshold *int `json:"lowBatteryThreshold" validate:"omitempty,eq=0|min=1,max=100"`
+}
+
+// swagger:parameters getDeviceConfig updateDeviceConfig
+type DeviceConfigParameter struct {
+	// ID of device
+	//
+	// required: true
+	// in: path
+	DeviceID string `json:"deviceId"`
+}
+
+// swagger:parameters updateDeviceConfig
+type MutableDeviceConfigParameter struct {
+	// Configuration to ask the device to apply
+	// Fields that are left out are unchanged
+	//
+	// required: true
+	// in: body
+	Config MutableDeviceConfig
+}
+
+// Successful device configuration retrieval
+// swagger:response getDeviceConfigResponse
+type GetDeviceConfigResponse struct {
+	// in: body
+	Body DeviceConfigStatus
+}
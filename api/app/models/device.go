@@ -19,6 +19,21 @@ type Device struct {
 	// Connection status of the device
 	// required: true
 	Connection *DeviceConnection `json:"connection"`
+	// Whether the device has been disconnecting frequently
+	// (usually a sign of a bad SIM or antenna)
+	// required: true
+	// example: false
+	Unstable bool `json:"unstable"`
+	// Latest readings of the device's temperature probes, if it has any
+	Temperatures []DeviceTemperature `json:"temperatures,omitempty"`
+	// Latest generic readings the device reports, by metric name
+	Metrics map[string]DeviceMetric `json:"metrics,omitempty"`
+	// Appliance to advise on food safety for, if the account has set one
+	Appliance *Appliance `json:"appliance,omitempty"`
+	// Why the device couldn't be fetched, when listing devices
+	// Only the device ID is set alongside it
+	// example: Timed out fetching device
+	Error string `json:"error,omitempty"`
 }
 
 type DeviceState struct {
@@ -31,6 +46,61 @@ type DeviceState struct {
 	// required: true
 	// example: 2020-12-18T15:56:53Z
 	Updated time.Time `json:"updated"`
+	// State of the UPS battery, if the device reports it
+	Battery *DeviceBattery `json:"battery,omitempty"`
+}
+
+type DeviceBattery struct {
+	// Remaining charge (percent)
+	// required: true
+	// example: 85
+	Percent int `json:"percent"`
+	// Battery voltage
+	// required: true
+	// example: 4.05
+	Voltage float64 `json:"voltage"`
+	// Whether the battery is charging
+	// required: true
+	// example: true
+	Charging bool `json:"charging"`
+	// Estimate of how much longer the device can report on battery during a power cut (seconds)
+	// Left out if the power is on, or the discharge rate isn't known yet
+	// example: 7800
+	Runtime int `json:"runtime,omitempty"`
+}
+
+type DeviceTemperature struct {
+	// Name of the probe
+	// required: true
+	// example: freezer
+	Probe string `json:"probe"`
+	// Temperature (°C)
+	// required: true
+	// example: -18.5
+	Celsius float64 `json:"celsius"`
+}
+
+type DeviceMetric struct {
+	// Kind of reading
+	// required: true
+	// example: humidity
+	Kind string `json:"kind"`
+	// How to describe the kind of reading
+	// required: true
+	// example: Humidity
+	DisplayName string `json:"displayName"`
+	// The reading
+	// required: true
+	// example: 85
+	Value float64 `json:"value"`
+	// Unit of the reading
+	// required: true
+	// example: percent
+	Unit string `json:"unit"`
+	// How to show the unit of the reading
+	// required: true
+	// example: %
+	Symbol string `json:"symbol"`
 }
 
 type DeviceConnection struct {
@@ -63,7 +133,7 @@ type DeviceRegistered struct {
 	Certificate *DeviceRegisteredCertificate `json:"certificate"`
 }
 
-// swagger:parameters updateDevice
+// swagger:parameters getDevice updateDevice pingDevice
 type DeviceParameter struct {
 	// ID of device
 	//
@@ -81,9 +151,19 @@ type MutableDeviceParameter struct {
 	Device MutableDevice
 }
 
+// swagger:parameters getDevice getDevices
+type ConditionalParameter struct {
+	// ETag of a previous response
+	//
+	// in: header
+	IfNoneMatch string `json:"If-None-Match"`
+}
+
 // Successful devices retrieval
 // swagger:response getDevicesResponse
 type GetDevicesResponse struct {
+	// Changes whenever any of the devices do
+	ETag string
 	// in: body
 	Body []Device
 }
@@ -91,10 +171,17 @@ type GetDevicesResponse struct {
 // Successful device retrieval
 // swagger:response getDeviceResponse
 type GetDeviceResponse struct {
+	// Changes whenever the device does
+	ETag string
 	// in: body
 	Body Device
 }
 
+// Nothing has changed since the response with the given ETag
+// swagger:response notModifiedResponse
+type NotModifiedResponse struct {
+}
+
 // Device with that ID not found
 // swagger:response deviceNotFoundResponse
 type DeviceNotFoundResponse struct {
@@ -108,3 +195,10 @@ type DeviceRegisteredResponse struct {
 	// in:body
 	Body DeviceRegistered
 }
+
+// Device didn't respond in time
+// swagger:response deviceUnresponsiveResponse
+type DeviceUnresponsiveResponse struct {
+	// in: body
+	Body ModelError
+}
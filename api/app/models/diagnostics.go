@@ -0,0 +1,66 @@
+package models
+
+import (
+	"time"
+)
+
+type DeviceSignal struct {
+	// Received signal strength indicator (dBm)
+	// required: true
+	// example: -71
+	RSSI int `json:"rssi"`
+	// Reference signal received power (dBm)
+	// required: true
+	// example: -98
+	RSRP int `json:"rsrp"`
+}
+
+type DeviceCellularSample struct {
+	// When the device reported the reading
+	// required: true
+	// example: 2020-12-18T15:56:53Z
+	Time time.Time `json:"time"`
+	// Signal quality at the time
+	// required: true
+	Signal DeviceSignal `json:"signal"`
+	// Data used so far this month (bytes)
+	// required: true
+	// example: 10485760
+	DataUsedBytes int64 `json:"dataUsedBytes"`
+}
+
+type DeviceDiagnostics struct {
+	// Latest signal quality, if the device reports it
+	Signal *DeviceSignal `json:"signal,omitempty"`
+	// Data used so far this month (bytes)
+	// required: true
+	// example: 10485760
+	DataUsedBytes int64 `json:"dataUsedBytes"`
+	// Data the device is on course to use this month (bytes)
+	// Left out early in the month, before there is enough usage to go on
+	// example: 20971520
+	ProjectedDataBytes int64 `json:"projectedDataBytes,omitempty"`
+	// Monthly data allowance of the device's plan (bytes)
+	// Left out if the account has no allowance
+	// example: 52428800
+	DataCapBytes int64 `json:"dataCapBytes,omitempty"`
+	// Recent readings, oldest first
+	// required: true
+	History []DeviceCellularSample `json:"history"`
+}
+
+// swagger:parameters getDeviceDiagnostics
+type DeviceDiagnosticsParameter struct {
+	// ID of device
+	//
+	// required: true
+	// in: path
+	DeviceID string `json:"deviceId"`
+}
+
+// Successful device diagnostics retrieval
+// swagger:response getDeviceDiagnosticsResponse
+type GetDeviceDiagnosticsResponse struct {
+	// in: body
+	Body DeviceDiagnostics
+}
@@ -0,0 +1,38 @@
+package models
+
+// JSONWebKey is the public part of a key that tokens are signed with (RFC 7517)
+type JSONWebKey struct {
+	// Type of key
+	// required: true
+	// example: OKP
+	Kty string `json:"kty"`
+	// ID of the key, given in the header of the tokens it signed
+	// required: true
+	// example: 2020-04
+	Kid string `json:"kid"`
+	// What the key is used for
+	// required: true
+	// example: sig
+	Use string `json:"use"`
+	// Algorithm the key signs tokens with
+	// required: true
+	// example: EdDSA
+	Alg string `json:"alg"`
+	// RSA modulus (base64url)
+	N string `json:"n,omitempty"`
+	// RSA exponent (base64url)
+	// example: AQAB
+	E string `json:"e,omitempty"`
+	// Curve of an octet key pair
+	// example: Ed25519
+	Crv string `json:"crv,omitempty"`
+	// Public key of an octet key pair (base64url)
+	// example: 11qYAYKxCrfVS_7TyWQHOg7hcvPapiMlrwIaaPcHURo
+	X string `json:"x,omitempty"`
+}
+
+// JSONWebKeySet is the set of keys that tokens are currently accepted from
+type JSONWebKeySet struct {
+	// required: true
+	Keys []JSONWebKey `json:"keys"`
+}
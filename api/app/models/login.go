@@ -0,0 +1,32 @@
+package models
+
+import "time"
+
+type Login struct {
+	// When the attempt was made
+	// required: true
+	// example: 2020-04-18T09:00:00Z
+	Time time.Time `json:"time"`
+	// Address the attempt came from
+	// required: true
+	// example: 203.0.113.7
+	IP string `json:"ip"`
+	// Browser (or other client) the attempt was made with
+	// required: true
+	// example: Mozilla/5.0 (X11; Linux x86_64; rv:75.0) Gecko/20100101 Firefox/75.0
+	UserAgent string `json:"userAgent"`
+	// Whether the attempt logged in
+	// required: true
+	// example: false
+	Success bool `json:"success"`
+	// Why the attempt failed
+	// example: Incorrect password
+	Reason string `json:"reason,omitempty"`
+}
+
+// Successful login history retrieval
+// swagger:response getLoginsResponse
+type GetLoginsResponse struct {
+	// in: body
+	Body []Login
+}
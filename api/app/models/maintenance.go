@@ -0,0 +1,76 @@
+package models
+
+import "time"
+
+type MaintenanceWindow struct {
+	// ID of the window
+	// required: true
+	// example: 5e3f1c2a-8b1d-4c55-9d8e-3f0b4a2c6d71
+	ID string `json:"id"`
+	// When the window (first) starts
+	// required: true
+	// example: 2020-04-18T09:00:00Z
+	Start time.Time `json:"start"`
+	// How long the window lasts (seconds)
+	// required: true
+	// example: 7200
+	Duration int `json:"duration"`
+	// How often the window recurs
+	// required: true
+	// example: once
+	Repeat string `json:"repeat"`
+	// Whether the window covers all the account's devices
+	// required: true
+	// example: false
+	AllDevices bool `json:"allDevices"`
+}
+
+type MutableMaintenanceWindow struct {
+	// When the window (first) starts
+	// example: 2020-04-18T09:00:00Z
+	Start time.Time `json:"start" validate:"required"`
+	// How long the window lasts (seconds)
+	// example: 7200
+	Duration int `json:"duration" validate:"min=60,max=604800"`
+	// How often the window recurs
+	// Leave out for a one-off window
+	// example: weekly
+	Repeat string `json:"repeat" validate:"omitempty,eq=once|eq=daily|eq=weekly"`
+	// Set to hold back notifications for all the account's devices, rather than just this one
+	// example: false
+	AllDevices bool `json:"allDevices"`
+}
+
+// swagger:parameters getMaintenanceWindows createMaintenanceWindow deleteMaintenanceWindow
+type MaintenanceWindowsParameter struct {
+	// ID of device
+	//
+	// required: true
+	// in: path
+	DeviceID string `json:"deviceId"`
+}
+
+// swagger:parameters createMaintenanceWindow
+type MutableMaintenanceWindowParameter struct {
+	// Window during which notifications are held back
+	//
+	// required: true
+	// in: body
+	Window MutableMaintenanceWindow
+}
+
+// swagger:parameters deleteMaintenanceWindow
+type MaintenanceWindowIDParameter struct {
+	// ID of the window
+	//
+	// required: true
+	// in: path
+	WindowID string `json:"windowId"`
+}
+
+// Successful maintenance windows retrieval
+// swagger:response getMaintenanceWindowsResponse
+type GetMaintenanceWindowsResponse struct {
+	// in: body
+	Body []MaintenanceWindow
+}
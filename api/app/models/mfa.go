@@ -0,0 +1,142 @@
+package models
+
+type MFAEnrolment struct {
+	// Secret to add to an authenticator app (base32)
+	// required: true
+	// example: JBSWY3DPEHPK3PXPJBSWY3DPEHPK3PXP
+	Secret string `json:"secret"`
+	// Provisioning URI, to show as a QR code for an authenticator app to scan
+	// required: true
+	// example: otpauth://totp/detectordag:user@example.com?algorithm=SHA1&digits=6&issuer=detectordag&period=30&secret=JBSWY3DPEHPK3PXPJBSWY3DPEHPK3PXP
+	URI string `json:"uri"`
+	// Codes that can each be used once instead of a code from the app, if it is lost
+	// required: true
+	// example: ["k3p7x-q2m9d", "7hw4c-zn5ta"]
+	RecoveryCodes []string `json:"recoveryCodes"`
+}
+
+type MFARecoveryCodes struct {
+	// Codes that can each be used once instead of a code from the app, replacing any left over
+	// required: true
+	// example: ["k3p7x-q2m9d", "7hw4c-zn5ta"]
+	RecoveryCodes []string `json:"recoveryCodes"`
+}
+
+type MFAEnrolRequest struct {
+	// Password for the account, so a stolen access token can't be used to enrol
+	// required: true
+	// example: my-secret-password
+	Password string `json:"password" validate:"required"`
+}
+
+type MFACode struct {
+	// Code from the authenticator app
+	// required: true
+	// example: 287082
+	Code string `json:"code" validate:"required"`
+}
+
+type MFAChallenge struct {
+	// Challenge to complete with a code, to obtain tokens
+	// required: true
+	// example: 3q2-7wEjRWeJq83vASNFZ4mrze8BI0VniavN7wEjRWc
+	Challenge string `json:"challenge"`
+	// Identifier for user's account
+	// required: true
+	// example: 7ea472c0-bb92-4989-9471-6a4560ac7a31
+	AccountId string `json:"accountId"`
+}
+
+type MFAResponse struct {
+	// Challenge obtained through authentication
+	// required: true
+	// example: 3q2-7wEjRWeJq83vASNFZ4mrze8BI0VniavN7wEjRWc
+	Challenge string `json:"challenge" validate:"required"`
+	// Code from the authenticator app, or an unused recovery code
+	// required: true
+	// example: 287082
+	Code string `json:"code" validate:"required"`
+}
+
+// swagger:parameters enrolMFA confirmMFA disableMFA createRecoveryCodes
+type MFAParameter struct {
+	// ID of account
+	//
+	// required: true
+	// in: path
+	AccountID string `json:"accountId"`
+}
+
+// swagger:parameters enrolMFA
+type MFAEnrolParameter struct {
+	// Password for the account
+	//
+	// required: true
+	// in: body
+	Request MFAEnrolRequest
+}
+
+// swagger:parameters confirmMFA
+type MFACodeParameter struct {
+	// Code showing the authenticator app has been set up
+	//
+	// required: true
+	// in: body
+	Code MFACode
+}
+
+// swagger:parameters disableMFA createRecoveryCodes
+type MFACurrentCodeParameter struct {
+	// Code from the authenticator app, or an unused recovery code
+	//
+	// required: true
+	// in: body
+	Code MFACode
+}
+
+// swagger:parameters completeMFA
+type MFAResponseParameter struct {
+	// Code for the challenge
+	//
+	// required: true
+	// in: body
+	Response MFAResponse
+}
+
+// Successful start of enrolment
+// swagger:response mfaEnrolmentResponse
+type MFAEnrolmentResponse struct {
+	// in: body
+	Body MFAEnrolment
+}
+
+// Successful enrolment
+// swagger:response mfaEnabledResponse
+type MFAEnabledResponse struct {
+}
+
+// Two-factor authentication successfully disabled
+// swagger:response mfaDisabledResponse
+type MFADisabledResponse struct {
+}
+
+// New recovery codes
+// swagger:response mfaRecoveryCodesResponse
+type MFARecoveryCodesResponse struct {
+	// in: body
+	Body MFARecoveryCodes
+}
+
+// The password was correct, but a code is needed too
+// swagger:response mfaChallengeResponse
+type MFAChallengeResponse struct {
+	// in: body
+	Body MFAChallenge
+}
+
+// Two-factor authentication has already been enabled (or isn't enabled, when changing it)
+// swagger:response mfaConflictResponse
+type MFAConflictResponse struct {
+	// in: body
+	Body ModelError
+}
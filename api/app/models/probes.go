@@ -0,0 +1,85 @@
+package models
+
+import (
+	"time"
+)
+
+type ProbeThresholds struct {
+	// Temperature the probe should stay above (°C)
+	// Left out if unbounded
+	// example: 0
+	Min *float64 `json:"min,omitempty"`
+	// Temperature the probe should stay below (°C)
+	// Left out if unbounded
+	// example: 5
+	Max *float64 `json:"max,omitempty"`
+}
+
+type DeviceTemperatureSample struct {
+	// When the device reported the reading
+	// required: true
+	// example: 2020-12-18T15:56:53Z
+	Time time.Time `json:"time"`
+	// Temperature (°C)
+	// required: true
+	// example: -18.5
+	Celsius float64 `json:"celsius"`
+}
+
+type DeviceProbe struct {
+	// Name of the probe
+	// required: true
+	// example: freezer
+	Probe string `json:"probe"`
+	// Latest temperature (°C), if the device is reporting the probe
+	// example: -18.5
+	Celsius *float64 `json:"celsius,omitempty"`
+	// Temperatures the probe should stay between
+	// required: true
+	Thresholds ProbeThresholds `json:"thresholds"`
+	// Whether the account has been warned the probe is out of range
+	// required: true
+	// example: false
+	Alerting bool `json:"alerting"`
+	// Recent readings, oldest first
+	// required: true
+	History []DeviceTemperatureSample `json:"history"`
+}
+
+// swagger:parameters getDeviceProbes updateProbeThresholds
+type DeviceProbesParameter struct {
+	// ID of device
+	//
+	// required: true
+	// in: path
+	DeviceID string `json:"deviceId"`
+}
+
+// swagger:parameters updateProbeThresholds
+type ProbeThresholdsParameter struct {
+	// Name of the probe
+	//
+	// required: true
+	// in: path
+	Probe string `json:"probe"`
+	// Temperatures the probe should stay between
+	// Thresholds that are left out are unbounded
+	//
+	// required: true
+	// in: body
+	Thresholds ProbeThresholds
+}
+
+// Successful device probes retrieval
+// swagger:response getDeviceProbesResponse
+type GetDeviceProbesResponse struct {
+	// in: body
+	Body []DeviceProbe
+}
+
+// Successful probe thresholds update
+// swagger:response getDeviceProbeResponse
+type GetDeviceProbeResponse struct {
+	// in: body
+	Body DeviceProbe
+}
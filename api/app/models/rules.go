@@ -0,0 +1,72 @@
+package models
+
+type MetricRule struct {
+	// ID of the rule
+	// required: true
+	// example: damp
+	ID string `json:"id"`
+	// Name of the metric the rule is about
+	// required: true
+	// example: cellar
+	Metric string `json:"metric"`
+	// How the reading is compared with the value
+	// required: true
+	// example: above
+	Condition string `json:"condition"`
+	// Value the reading is compared with
+	// required: true
+	// example: 80
+	Value float64 `json:"value"`
+	// How long the condition must hold before warning (seconds)
+	// required: true
+	// example: 1800
+	For int `json:"for"`
+	// Whether the account has been warned the rule is broken
+	// required: true
+	// example: false
+	Alerting bool `json:"alerting"`
+}
+
+type MutableMetricRule struct {
+	// ID of the rule
+	// One is created if left out
+	// example: damp
+	ID string `json:"id" validate:"omitempty,max=64"`
+	// Name of the metric the rule is about
+	// example: cellar
+	Metric string `json:"metric" validate:"required,max=64"`
+	// How the reading should be compared with the value
+	// example: above
+	Condition string `json:"condition" validate:"required,eq=above|eq=below"`
+	// Value the reading should be compared with
+	// example: 80
+	Value float64 `json:"value"`
+	// How long the condition must hold before warning (seconds)
+	// example: 1800
+	For int `json:"for" validate:"min=0,max=604800"`
+}
+
+// swagger:parameters getMetricRules updateMetricRules
+type MetricRulesParameter struct {
+	// ID of device
+	//
+	// required: true
+	// in: path
+	DeviceID string `json:"deviceId"`
+}
+
+// swagger:parameters updateMetricRules
+type MutableMetricRulesParameter struct {
+	// Rules to check the device's metrics against, replacing any already set up
+	//
+	// required: true
+	// in: body
+	Rules []MutableMetricRule
+}
+
+// Successful metric rules retrieval
+// swagger:response getMetricRulesResponse
+type GetMetricRulesResponse struct {
+	// in: body
+	Body []MetricRule
+}
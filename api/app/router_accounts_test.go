@@ -1,13 +1,18 @@
 package app
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"testing"
+	"time"
 
 	"github.com/briggysmalls/detectordag/api/app/models"
+	"github.com/briggysmalls/detectordag/shared/database"
 	"github.com/briggysmalls/detectordag/shared/iot"
+	"github.com/briggysmalls/detectordag/shared/metrics"
 	"github.com/briggysmalls/detectordag/shared/shadow"
 	"github.com/golang/mock/gomock"
 	"github.com/stretchr/testify/assert"
@@ -39,51 +44,66 @@ func TestGetDevicesSuccess(t *testing.T) {
 			State: &models.DeviceState{
 				Power:   "off",
 				Updated: createTime(t, "2020/03/22 01:20:00"),
+				Battery: &models.DeviceBattery{Percent: 40, Voltage: 3.8, Runtime: 18000},
 			},
 			Connection: &models.DeviceConnection{
 				Status:  "connected",
 				Updated: createTime(t, "2020/03/22 01:20:01"),
 			},
+			Temperatures: []models.DeviceTemperature{{Probe: "freezer", Celsius: -18.5}},
+			Metrics: map[string]models.DeviceMetric{
+				"cellar": {Kind: "humidity", DisplayName: "Humidity", Value: 85, Unit: "percent", Symbol: "%"},
+			},
+			Appliance: &models.Appliance{Type: "freezer", SafeFor: 86400},
 		},
 	}
 	// Create a client
 	_, shdw, _, iotClient, tokens, router := createRealRouter(t)
 	gomock.InOrder(
 		// Configure the tokens to expect a call to validate a token
-		tokens.EXPECT().Validate(token).Return(accountID, nil),
+		tokens.EXPECT().Validate(token).Return(testClaims(accountID), nil),
 		// Configure the IoT client to expect a request for devices
 		iotClient.EXPECT().GetThingsByAccount(accountID).Return([]*iot.Device{
 			{AccountId: accountID, DeviceId: devices[0].DeviceId},
 			{AccountId: accountID, DeviceId: devices[1].DeviceId},
 		}, nil),
-		// Configure the mock shadow client to expect calls for each device
-		shdw.EXPECT().Get(devices[0].DeviceId).Return(&shadow.Shadow{
-			Name: devices[0].Name,
-			Time: createTime(t, "2020/03/22 00:27:00"),
-			Power: shadow.PowerShadow{
-				Value:   devices[0].State.Power,
-				Updated: devices[0].State.Updated,
-			},
-			Connection: shadow.ConnectionShadow{
-				Status:      devices[0].Connection.Status,
-				TransientID: "0a72fb5e-6489-49d5-aadb-782d54d1ed1f",
-				Updated:     devices[0].Connection.Updated,
-			},
-		}, nil),
-		shdw.EXPECT().Get(devices[1].DeviceId).Return(&shadow.Shadow{
-			Name: devices[1].Name,
-			Time: createTime(t, "2020/03/22 00:27:00"),
-			Power: shadow.PowerShadow{
-				Value:   devices[1].State.Power,
-				Updated: devices[1].State.Updated,
-			},
-			Connection: shadow.ConnectionShadow{
-				Status:      devices[1].Connection.Status,
-				TransientID: "63c7d830-724a-4715-aa1e-dc8934cd32fb",
-				Updated:     devices[1].Connection.Updated,
-			},
-		}, nil),
 	)
+	// Configure the mock shadow client to expect calls for each device (in any order)
+	shdw.EXPECT().GetWithContext(gomock.Any(), devices[0].DeviceId).Return(&shadow.Shadow{
+		Name: devices[0].Name,
+		Time: createTime(t, "2020/03/22 00:27:00"),
+		Power: shadow.PowerShadow{
+			Value:   devices[0].State.Power,
+			Updated: devices[0].State.Updated,
+		},
+		Connection: shadow.ConnectionShadow{
+			Status:      devices[0].Connection.Status,
+			TransientID: "0a72fb5e-6489-49d5-aadb-782d54d1ed1f",
+			Updated:     devices[0].Connection.Updated,
+		},
+	}, nil)
+	shdw.EXPECT().GetWithContext(gomock.Any(), devices[1].DeviceId).Return(&shadow.Shadow{
+		Name: devices[1].Name,
+		Time: createTime(t, "2020/03/22 00:27:00"),
+		Power: shadow.PowerShadow{
+			Value:   devices[1].State.Power,
+			Updated: devices[1].State.Updated,
+		},
+		Battery:   &shadow.BatteryShadow{Percent: 40, Voltage: 3.8},
+		Discharge: shadow.DischargeShadow{Rate: 8},
+		Temperature: shadow.TemperatureShadow{
+			Readings: []shadow.ProbeReading{{Probe: "freezer", Celsius: -18.5}},
+		},
+		Metrics: shadow.MetricsShadow{
+			Readings: map[string]metrics.Reading{"cellar": {Kind: "humidity", Value: 85, Unit: "percent"}},
+		},
+		Appliance: shadow.ApplianceShadow{Type: shadow.APPLIANCE_FREEZER},
+		Connection: shadow.ConnectionShadow{
+			Status:      devices[1].Connection.Status,
+			TransientID: "63c7d830-724a-4715-aa1e-dc8934cd32fb",
+			Updated:     devices[1].Connection.Updated,
+		},
+	}, nil)
 	// Create a request for devices
 	req := createRequest(t, "GET", fmt.Sprintf("/v1/accounts/%s/devices", accountID), nil)
 	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
@@ -96,4 +116,178 @@ func TestGetDevicesSuccess(t *testing.T) {
 	err := json.Unmarshal(rr.Body.Bytes(), &resp)
 	assert.NoError(t, err)
 	assert.Equal(t, devices, resp)
+	assert.NotEmpty(t, rr.Header().Get("ETag"))
+}
+
+func TestGetDevicesNotModified(t *testing.T) {
+	const (
+		accountID = "35581BF4-32C8-4908-8377-2E6A021D3D2B"
+		deviceID  = "63eda5eb-7f56-417f-88ed-44a9eb9e5f67"
+	)
+	device := func(version int) *shadow.Shadow {
+		return &shadow.Shadow{
+			Version:    version,
+			Connection: shadow.ConnectionShadow{Status: shadow.CONNECTION_STATUS_CONNECTED},
+			Power:      shadow.PowerShadow{Value: shadow.POWER_STATUS_ON},
+		}
+	}
+	// Get the devices, and then again with the same, and a changed, shadow
+	var etag string
+	for _, params := range []struct {
+		version int
+		status  int
+	}{
+		{version: 50, status: http.StatusOK},
+		{version: 50, status: http.StatusNotModified},
+		{version: 51, status: http.StatusOK},
+	} {
+		// Create a client
+		_, shdw, _, iotClient, tokens, router := createRealRouter(t)
+		gomock.InOrder(
+			tokens.EXPECT().Validate(testToken).Return(testClaims(accountID), nil),
+			iotClient.EXPECT().GetThingsByAccount(accountID).Return([]*iot.Device{{AccountId: accountID, DeviceId: deviceID}}, nil),
+			shdw.EXPECT().GetWithContext(gomock.Any(), deviceID).Return(device(params.version), nil),
+		)
+		// Create a request for devices, with the tag of the last response
+		req := createRequest(t, http.MethodGet, fmt.Sprintf("/v1/accounts/%s/devices", accountID), nil)
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", testToken))
+		req.Header.Set("If-None-Match", etag)
+		// Execute the handler
+		rr := runHandler(router, req)
+		assert.Equal(t, params.status, rr.Code)
+		if params.status == http.StatusNotModified {
+			assert.Empty(t, rr.Body.Bytes())
+		} else {
+			assert.NotEqual(t, etag, rr.Header().Get("ETag"))
+		}
+		etag = rr.Header().Get("ETag")
+	}
+}
+
+func TestGetDevicesPartial(t *testing.T) {
+	const accountID = "35581BF4-32C8-4908-8377-2E6A021D3D2B"
+	ids := []string{
+		"63eda5eb-7f56-417f-88ed-44a9eb9e5f67",
+		"4e9a7d26-d4de-4ea9-a0be-ec1b8264e35b",
+		"c0e94a1b-a835-4cc2-9574-642bea13805a",
+	}
+	// Create a client
+	_, shdw, _, iotClient, tokens, router := createRealRouter(t)
+	gomock.InOrder(
+		tokens.EXPECT().Validate(testToken).Return(testClaims(accountID), nil),
+		iotClient.EXPECT().GetThingsByAccount(accountID).Return([]*iot.Device{
+			{AccountId: accountID, DeviceId: ids[0]},
+			{AccountId: accountID, DeviceId: ids[1]},
+			{AccountId: accountID, DeviceId: ids[2]},
+		}, nil),
+	)
+	// One device is fine, one fails, and one takes too long
+	shdw.EXPECT().GetWithContext(gomock.Any(), ids[0]).Return(&shadow.Shadow{
+		Name:       "one",
+		Connection: shadow.ConnectionShadow{Status: shadow.CONNECTION_STATUS_CONNECTED},
+		Power:      shadow.PowerShadow{Value: shadow.POWER_STATUS_ON},
+	}, nil)
+	shdw.EXPECT().GetWithContext(gomock.Any(), ids[1]).Return(nil, errors.New("AccessDeniedException: not authorized to perform iot:GetThingShadow"))
+	shdw.EXPECT().GetWithContext(gomock.Any(), ids[2]).DoAndReturn(func(ctx context.Context, id string) (*shadow.Shadow, error) {
+		// Expect the request to be given up on with the context
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+	// Create a request for devices, that gives up quickly
+	req := createRequest(t, http.MethodGet, fmt.Sprintf("/v1/accounts/%s/devices", accountID), nil)
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", testToken))
+	ctx, cancel := context.WithTimeout(req.Context(), 100*time.Millisecond)
+	defer cancel()
+	// Execute the handler
+	rr := runHandler(router, req.WithContext(ctx))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	// Inspect the body of the response
+	var resp []models.Device
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+	assert.Len(t, resp, 3)
+	assert.Equal(t, "one", resp[0].Name)
+	assert.Empty(t, resp[0].Error)
+	// The details of what went wrong aren't passed on
+	assert.Equal(t, models.Device{DeviceId: ids[1], Error: "Failed to fetch device"}, resp[1])
+	assert.Equal(t, models.Device{DeviceId: ids[2], Error: "Timed out fetching device"}, resp[2])
+	// Partial results aren't tagged
+	assert.Empty(t, rr.Header().Get("ETag"))
+}
+
+func TestUpdateAccount(t *testing.T) {
+	const accountID = "35581BF4-32C8-4908-8377-2E6A021D3D2B"
+	emails := []string{"jane@example.com"}
+	dataCap := int64(500 * 1024 * 1024)
+	noCap := int64(0)
+	london := "Europe/London"
+	gracePeriod := 300
+	noGracePeriod := 0
+	branding := database.Branding{LogoURL: "https://example.com/logo.png", HeaderColour: "#17a2b8", SenderName: "Acme Catering"}
+	testParams := []struct {
+		body   string
+		update *database.AccountUpdate
+		status int
+	}{
+		{body: `{"emails":["jane@example.com"]}`, update: &database.AccountUpdate{Emails: &emails}, status: http.StatusOK},
+		{body: `{"dataCapBytes":524288000}`, update: &database.AccountUpdate{DataCap: &dataCap}, status: http.StatusOK},
+		// The allowance can be removed
+		{body: `{"dataCapBytes":0}`, update: &database.AccountUpdate{DataCap: &noCap}, status: http.StatusOK},
+		{body: `{"emails":["jane@example.com"],"dataCapBytes":524288000}`, update: &database.AccountUpdate{Emails: &emails, DataCap: &dataCap}, status: http.StatusOK},
+		{body: `{"timeZone":"Europe/London"}`, update: &database.AccountUpdate{TimeZone: &london}, status: http.StatusOK},
+		{
+			body:   `{"branding":{"logoUrl":"https://example.com/logo.png","headerColour":"#17a2b8","senderName":"Acme Catering"}}`,
+			update: &database.AccountUpdate{Branding: &branding},
+			status: http.StatusOK,
+		},
+		{body: `{"gracePeriodSeconds":300}`, update: &database.AccountUpdate{GracePeriod: &gracePeriod}, status: http.StatusOK},
+		// Notifications can be sent straight away
+		{body: `{"gracePeriodSeconds":0}`, update: &database.AccountUpdate{GracePeriod: &noGracePeriod}, status: http.StatusOK},
+		// The grace period must be sensible
+		{body: `{"gracePeriodSeconds":-1}`, status: http.StatusBadRequest},
+		{body: `{"gracePeriodSeconds":86401}`, status: http.StatusBadRequest},
+		// The overrides can be removed
+		{body: `{"branding":{}}`, update: &database.AccountUpdate{Branding: &database.Branding{}}, status: http.StatusOK},
+		// The logo must be served securely, and the colours must be colours
+		{body: `{"branding":{"logoUrl":"http://example.com/logo.png"}}`, status: http.StatusBadRequest},
+		{body: `{"branding":{"logoUrl":"not a url"}}`, status: http.StatusBadRequest},
+		{body: `{"branding":{"buttonColour":"blue"}}`, status: http.StatusBadRequest},
+		// The allowance can't be negative
+		{body: `{"dataCapBytes":-1}`, status: http.StatusBadRequest},
+		// The time zone must be known
+		{body: `{"timeZone":"Europe/Nowhere"}`, status: http.StatusBadRequest},
+	}
+	for _, params := range testParams {
+		// Create a client
+		db, _, verifier, _, tokens, router := createRealRouter(t)
+		tokens.EXPECT().Validate(testToken).Return(testClaims(accountID), nil)
+		if params.update != nil {
+			// Expect new emails to be verified
+			if params.update.Emails != nil {
+				verifier.EXPECT().VerifyEmailsIfNecessary(*params.update.Emails).Return(nil)
+			}
+			// Expect only the given fields to be updated
+			account := &database.Account{AccountId: accountID, Username: "user@example.com", Emails: emails, DataCap: dataCap, TimeZone: london, GracePeriod: gracePeriod, Branding: branding}
+			db.EXPECT().UpdateAccount(accountID, *params.update).Return(account, nil)
+		}
+		// Create a request to update the account
+		req := createRequest(t, http.MethodPatch, fmt.Sprintf("/v1/accounts/%s", accountID), []byte(params.body))
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", testToken))
+		// Execute the handler
+		rr := runHandler(router, req)
+		assert.Equal(t, params.status, rr.Code)
+		if params.status != http.StatusOK {
+			continue
+		}
+		// Check the account is returned
+		var resp models.Account
+		assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+		assert.Equal(t, models.Account{
+			Username:           "user@example.com",
+			Emails:             models.Emails{Emails: emails},
+			DataCapBytes:       dataCap,
+			TimeZone:           london,
+			GracePeriodSeconds: gracePeriod,
+			Branding:           models.Branding{LogoURL: "https://example.com/logo.png", HeaderColour: "#17a2b8", SenderName: "Acme Catering"},
+		}, resp)
+	}
 }
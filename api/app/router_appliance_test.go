@@ -0,0 +1,72 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/briggysmalls/detectordag/api/app/models"
+	"github.com/briggysmalls/detectordag/shared/iot"
+	"github.com/briggysmalls/detectordag/shared/shadow"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUpdateDeviceAppliance(t *testing.T) {
+	const (
+		accountID = "35581BF4-32C8-4908-8377-2E6A021D3D2B"
+		deviceID  = "63eda5eb-7f56-417f-88ed-44a9eb9e5f67"
+	)
+	testParams := []struct {
+		body      string
+		appliance *shadow.ApplianceShadow
+		status    int
+		response  models.Appliance
+	}{
+		// The usual limit is filled in
+		{
+			body:      `{"type":"fridge"}`,
+			appliance: &shadow.ApplianceShadow{Type: shadow.APPLIANCE_FRIDGE},
+			status:    http.StatusOK,
+			response:  models.Appliance{Type: "fridge", SafeFor: 14400},
+		},
+		{
+			body:      `{"type":"freezer","safeFor":172800}`,
+			appliance: &shadow.ApplianceShadow{Type: shadow.APPLIANCE_FREEZER, SafeFor: 48 * time.Hour},
+			status:    http.StatusOK,
+			response:  models.Appliance{Type: "freezer", SafeFor: 172800},
+		},
+		{body: `{"type":"oven"}`, status: http.StatusBadRequest},
+		{body: `{"type":"fridge","safeFor":-1}`, status: http.StatusBadRequest},
+		{body: `{}`, status: http.StatusBadRequest},
+		{body: `not json`, status: http.StatusBadRequest},
+	}
+	for _, params := range testParams {
+		// Create a client
+		_, shdw, _, iotClient, tokens, router := createRealRouter(t)
+		gomock.InOrder(
+			// Expect the auth middleware to check the device belongs to the account
+			tokens.EXPECT().Validate(testToken).Return(testClaims(accountID), nil),
+			iotClient.EXPECT().GetThing(deviceID).Return(&iot.Device{AccountId: accountID}, nil),
+		)
+		// Expect the appliance to be set
+		if params.appliance != nil {
+			shdw.EXPECT().UpdateAppliance(deviceID, *params.appliance).Return(&shadow.Shadow{Appliance: *params.appliance}, nil)
+		}
+		// Create a request to set the appliance
+		req := createRequest(t, http.MethodPut, fmt.Sprintf("/v1/devices/%s/appliance", deviceID), []byte(params.body))
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", testToken))
+		// Execute the handler
+		rr := runHandler(router, req)
+		assert.Equal(t, params.status, rr.Code, params.body)
+		if params.status != http.StatusOK {
+			continue
+		}
+		// Check the appliance is returned
+		var resp models.Appliance
+		assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+		assert.Equal(t, params.response, resp)
+	}
+}
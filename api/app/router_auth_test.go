@@ -4,11 +4,13 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/briggysmalls/detectordag/api/app/models"
+	tkns "github.com/briggysmalls/detectordag/api/app/tokens"
 	"github.com/briggysmalls/detectordag/shared/database"
 	"github.com/golang/mock/gomock"
 	"github.com/stretchr/testify/assert"
 	"net/http"
 	"testing"
+	"time"
 )
 
 const (
@@ -29,15 +31,32 @@ func TestAuthSuccess(t *testing.T) {
 	// Configure the mock db client to expect a call to fetch the account
 	account := database.Account{AccountId: accountID, Username: username, Password: hashedPassword}
 	db.EXPECT().GetAccountByUsername(gomock.Eq(username)).Return(&account, nil)
+	// Expect previous failures to be checked, the attempt counted, then those for the username forgotten
+	usernameAttempts := database.LoginAttempts{Key: "username:" + username}
+	ipAttempts := database.LoginAttempts{Key: "ip:203.0.113.7"}
+	db.EXPECT().GetLoginAttempts("username:"+username, gomock.Any()).Return(&usernameAttempts, nil)
+	db.EXPECT().GetLoginAttempts("ip:203.0.113.7", gomock.Any()).Return(&ipAttempts, nil)
+	db.EXPECT().RecordLoginAttempt(usernameAttempts, gomock.Any(), gomock.Any()).Return(&database.LoginAttempts{}, nil)
+	db.EXPECT().RecordLoginAttempt(ipAttempts, gomock.Any(), gomock.Any()).Return(&database.LoginAttempts{}, nil)
+	db.EXPECT().ClearLoginAttempts("username:" + username)
+	// Expect the attempt to be audited
+	var login database.Login
+	db.EXPECT().RecordLogin(gomock.Any()).Do(func(l database.Login) { login = l })
 	// Configure the mock tokens to create a token
 	expectedToken := "dummy-token"
 	tokens.EXPECT().Create(gomock.Eq(accountID)).Return(expectedToken, nil)
+	// Expect a refresh token to be stored
+	var stored database.RefreshToken
+	db.EXPECT().CreateRefreshToken(gomock.Any()).Do(func(token database.RefreshToken) { stored = token })
 	// Create a request to authenticate
 	req := createRequest(t, "POST", "/v1/auth", []byte(fmt.Sprintf(`{"username": "email@example.com", "password": "%s"}`, password)))
+	req.RemoteAddr = "203.0.113.7:51234"
+	req.Header.Set("User-Agent", "test-agent")
 	// Execute the handler
 	rr := runHandler(router, req)
 	// Assert the HTTP status
 	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, database.Login{AccountId: accountID, Timestamp: login.Timestamp, IP: "203.0.113.7", UserAgent: "test-agent", Success: true}, login)
 	// Check the response body is what we expect.
 	var resp models.Token
 	var err error
@@ -46,4 +65,163 @@ func TestAuthSuccess(t *testing.T) {
 	assert.Equal(t, accountID, resp.AccountId)
 	// Parse the token contents
 	assert.Equal(t, expectedToken, resp.Token)
+	// Check only the hash of the refresh token is stored
+	assert.NotEmpty(t, resp.RefreshToken)
+	assert.Equal(t, database.RefreshToken{
+		Hash:      tkns.HashRefreshToken(resp.RefreshToken),
+		AccountId: accountID,
+		Family:    stored.Family,
+		Expires:   stored.Expires,
+	}, stored)
+	// Check a new family was started
+	assert.NotEmpty(t, stored.Family)
+	assert.True(t, time.Unix(stored.Expires, 0).After(time.Now().Add(24*time.Hour)))
+}
+
+func TestRefresh(t *testing.T) {
+	const (
+		accountID = "35581BF4-32C8-4908-8377-2E6A021D3D2B"
+		family    = "0E5C2BA9-2D86-4C04-8A2B-4E52C4C5A2A4"
+	)
+	testParams := []struct {
+		body    string
+		stored  *database.RefreshToken
+		useErr  error
+		revoked bool
+		status  int
+	}{
+		{
+			body:   `{"refreshToken":"old-refresh-token"}`,
+			stored: &database.RefreshToken{AccountId: accountID, Family: family, Expires: time.Now().Add(time.Hour).Unix()},
+			status: http.StatusOK,
+		},
+		// The token doesn't exist
+		{body: `{"refreshToken":"old-refresh-token"}`, useErr: database.ErrRefreshTokenNotFound, status: http.StatusForbidden},
+		// The token has already been used, so its family is revoked
+		{
+			body:    `{"refreshToken":"old-refresh-token"}`,
+			stored:  &database.RefreshToken{AccountId: accountID, Family: family, Used: true, Expires: time.Now().Add(time.Hour).Unix()},
+			useErr:  database.ErrRefreshTokenReused,
+			revoked: true,
+			status:  http.StatusForbidden,
+		},
+		// The token has expired, but hasn't been deleted yet
+		{
+			body:   `{"refreshToken":"old-refresh-token"}`,
+			stored: &database.RefreshToken{AccountId: accountID, Family: family, Expires: time.Now().Add(-time.Hour).Unix()},
+			status: http.StatusForbidden,
+		},
+		{body: `{}`, status: http.StatusBadRequest},
+		{body: `not json`, status: http.StatusBadRequest},
+	}
+	for _, params := range testParams {
+		// Create a mock client
+		db, _, _, _, tokens, router := createRealRouter(t)
+		if params.stored != nil || params.useErr != nil {
+			// Expect the token to be used up
+			db.EXPECT().UseRefreshToken(tkns.HashRefreshToken("old-refresh-token")).Return(params.stored, params.useErr)
+		}
+		if params.revoked {
+			// Expect the whole family to be revoked
+			db.EXPECT().RevokeRefreshTokens(*params.stored)
+		}
+		var created database.RefreshToken
+		if params.status == http.StatusOK {
+			// Expect new tokens to be issued
+			tokens.EXPECT().Create(accountID).Return("new-token", nil)
+			db.EXPECT().CreateRefreshToken(gomock.Any()).Do(func(token database.RefreshToken) { created = token })
+		}
+		// Create a request to refresh
+		req := createRequest(t, http.MethodPost, "/v1/auth/refresh", []byte(params.body))
+		// Execute the handler
+		rr := runHandler(router, req)
+		assert.Equal(t, params.status, rr.Code, params.body)
+		if params.status != http.StatusOK {
+			continue
+		}
+		// Check the refresh token is replaced
+		var resp models.Token
+		assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+		assert.Equal(t, "new-token", resp.Token)
+		assert.NotEmpty(t, resp.RefreshToken)
+		assert.NotEqual(t, "old-refresh-token", resp.RefreshToken)
+		// Check the new token stays in the family
+		assert.Equal(t, family, created.Family)
+	}
+}
+
+func TestLogout(t *testing.T) {
+	const (
+		accountID      = "35581BF4-32C8-4908-8377-2E6A021D3D2B"
+		otherAccountID = "9A3C1E0B-6B1F-4E0D-9E0B-2B5F3C8E7D11"
+	)
+	expires := time.Unix(9223372036, 0)
+	testParams := []struct {
+		body    string
+		stored  *database.RefreshToken
+		getErr  error
+		revoked bool
+		status  int
+	}{
+		{
+			body:    `{"refreshToken":"my-refresh-token"}`,
+			stored:  &database.RefreshToken{AccountId: accountID, Family: "family"},
+			revoked: true,
+			status:  http.StatusNoContent,
+		},
+		// The refresh token has already gone
+		{body: `{"refreshToken":"my-refresh-token"}`, getErr: database.ErrRefreshTokenNotFound, revoked: true, status: http.StatusNoContent},
+		// The refresh token belongs to someone else
+		{
+			body:   `{"refreshToken":"my-refresh-token"}`,
+			stored: &database.RefreshToken{AccountId: otherAccountID, Family: "family"},
+			status: http.StatusForbidden,
+		},
+		// The refresh token is optional
+		{revoked: true, status: http.StatusNoContent},
+	}
+	for _, params := range testParams {
+		// Create a mock client
+		db, _, _, _, tokens, router := createRealRouter(t)
+		claims := testClaims(accountID)
+		claims.ExpiresAt = expires.Unix()
+		tokens.EXPECT().Validate(testToken).Return(claims, nil)
+		if params.stored != nil || params.getErr != nil {
+			db.EXPECT().GetRefreshToken(tkns.HashRefreshToken("my-refresh-token")).Return(params.stored, params.getErr)
+		}
+		if params.revoked {
+			// Expect the access token to be revoked, and the refresh token forgotten
+			db.EXPECT().RevokeToken(testTokenID, expires)
+			if params.stored != nil {
+				db.EXPECT().RevokeRefreshTokens(*params.stored)
+			}
+		}
+		// Create a request to log out
+		req := createRequest(t, http.MethodPost, "/v1/auth/logout", []byte(params.body))
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", testToken))
+		// Execute the handler
+		rr := runHandler(router, req)
+		assert.Equal(t, params.status, rr.Code, params.body)
+	}
+}
+
+func TestRevokedToken(t *testing.T) {
+	const (
+		accountID = "35581BF4-32C8-4908-8377-2E6A021D3D2B"
+		deviceID  = "63eda5eb-7f56-417f-88ed-44a9eb9e5f67"
+	)
+	// Create a router with a database that has revoked the test token
+	ctrl := gomock.NewController(t)
+	db := NewMockDBClient(ctrl)
+	iotClient := NewMockIoTClient(ctrl)
+	tokens := NewMockTokens(ctrl)
+	router := NewRouter(iotClient, db, NewMockServer(ctrl), tokens)
+	tokens.EXPECT().Validate(testToken).Return(testClaims(accountID), nil)
+	db.EXPECT().IsTokenRevoked(testTokenID).Return(true, nil)
+	// Create a request for a device
+	req := createRequest(t, http.MethodGet, fmt.Sprintf("/v1/devices/%s", deviceID), nil)
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", testToken))
+	// Execute the handler (which isn't called)
+	rr := runHandler(router, req)
+	assert.Equal(t, http.StatusForbidden, rr.Code)
 }
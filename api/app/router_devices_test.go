@@ -0,0 +1,350 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/briggysmalls/detectordag/api/app/models"
+	"github.com/briggysmalls/detectordag/shared/database"
+	"github.com/briggysmalls/detectordag/shared/iot"
+	"github.com/briggysmalls/detectordag/shared/shadow"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetDevice(t *testing.T) {
+	const (
+		accountID = "35581BF4-32C8-4908-8377-2E6A021D3D2B"
+		deviceID  = "63eda5eb-7f56-417f-88ed-44a9eb9e5f67"
+	)
+	testParams := []struct {
+		ifNoneMatch string
+		battery     bool
+		etag        string
+		status      int
+	}{
+		{etag: `"50"`, status: http.StatusOK},
+		{ifNoneMatch: `"49"`, etag: `"50"`, status: http.StatusOK},
+		{ifNoneMatch: `"50"`, etag: `"50"`, status: http.StatusNotModified},
+		{ifNoneMatch: `"49", W/"50"`, etag: `"50"`, status: http.StatusNotModified},
+		{ifNoneMatch: `*`, etag: `"50"`, status: http.StatusNotModified},
+		// The runtime can change without the shadow changing, so a tag without it is stale
+		{ifNoneMatch: `"50"`, battery: true, etag: `"50-18000"`, status: http.StatusOK},
+		{ifNoneMatch: `"50-18000"`, battery: true, etag: `"50-18000"`, status: http.StatusNotModified},
+	}
+	for _, params := range testParams {
+		// Create a client
+		_, shdw, _, iotClient, tokens, router := createRealRouter(t)
+		device := &shadow.Shadow{
+			Version:    50,
+			Name:       "Kitchen",
+			Connection: shadow.ConnectionShadow{Status: shadow.CONNECTION_STATUS_CONNECTED},
+			Power:      shadow.PowerShadow{Value: shadow.POWER_STATUS_OFF},
+		}
+		if params.battery {
+			device.Battery = &shadow.BatteryShadow{Percent: 40, Voltage: 3.8}
+			device.Discharge = shadow.DischargeShadow{Rate: 8}
+		}
+		gomock.InOrder(
+			// Expect the auth middleware to check the device belongs to the account
+			tokens.EXPECT().Validate(testToken).Return(testClaims(accountID), nil),
+			iotClient.EXPECT().GetThing(deviceID).Return(&iot.Device{AccountId: accountID}, nil),
+			// Expect the shadow to be fetched
+			shdw.EXPECT().Get(deviceID).Return(device, nil),
+		)
+		// Create a request for the device
+		req := createRequest(t, http.MethodGet, fmt.Sprintf("/v1/devices/%s", deviceID), nil)
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", testToken))
+		if params.ifNoneMatch != "" {
+			req.Header.Set("If-None-Match", params.ifNoneMatch)
+		}
+		// Execute the handler
+		rr := runHandler(router, req)
+		assert.Equal(t, params.status, rr.Code, params.ifNoneMatch)
+		assert.Equal(t, params.etag, rr.Header().Get("ETag"))
+		if params.status == http.StatusNotModified {
+			assert.Empty(t, rr.Body.Bytes())
+			continue
+		}
+		// Inspect the body of the response
+		var resp models.Device
+		assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+		assert.Equal(t, "Kitchen", resp.Name)
+		assert.Equal(t, "off", resp.State.Power)
+	}
+}
+
+func TestGetDeviceConfig(t *testing.T) {
+	const (
+		accountID = "35581BF4-32C8-4908-8377-2E6A021D3D2B"
+		deviceID  = "63eda5eb-7f56-417f-88ed-44a9eb9e5f67"
+	)
+	testParams := []struct {
+		config   shadow.ConfigShadow
+		expected models.DeviceConfigStatus
+	}{
+		{ // The device has applied the config
+			config: shadow.ConfigShadow{
+				Desired:  shadow.DeviceConfig{ReportInterval: 5 * time.Minute},
+				Reported: shadow.DeviceConfig{ReportInterval: 5 * time.Minute, StatusRequestTimeout: 10 * time.Second},
+			},
+			expected: models.DeviceConfigStatus{
+				Desired:  models.DeviceConfig{ReportInterval: 300},
+				Reported: models.DeviceConfig{ReportInterval: 300, StatusRequestTimeout: 10},
+				Applied:  true,
+			},
+		},
+		{ // The device is yet to apply some config
+			config: shadow.ConfigShadow{
+				Desired:  shadow.DeviceConfig{ReportInterval: 5 * time.Minute, LowBatteryThreshold: 15},
+				Reported: shadow.DeviceConfig{ReportInterval: time.Minute, LowBatteryThreshold: 15},
+			},
+			expected: models.DeviceConfigStatus{
+				Desired:  models.DeviceConfig{ReportInterval: 300, LowBatteryThreshold: 15},
+				Reported: models.DeviceConfig{ReportInterval: 60, LowBatteryThreshold: 15},
+				Delta:    models.DeviceConfig{ReportInterval: 300},
+				Applied:  false,
+			},
+		},
+	}
+	for _, params := range testParams {
+		// Create a client
+		_, shdw, _, iotClient, tokens, router := createRealRouter(t)
+		gomock.InOrder(
+			// Expect the auth middleware to check the device belongs to the account
+			tokens.EXPECT().Validate(testToken).Return(testClaims(accountID), nil),
+			iotClient.EXPECT().GetThing(deviceID).Return(&iot.Device{AccountId: accountID}, nil),
+			// Expect the shadow to be fetched
+			shdw.EXPECT().Get(deviceID).Return(&shadow.Shadow{Config: params.config}, nil),
+		)
+		// Create a request for the config
+		req := createRequest(t, http.MethodGet, fmt.Sprintf("/v1/devices/%s/config", deviceID), nil)
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", testToken))
+		// Execute the handler
+		rr := runHandler(router, req)
+		assert.Equal(t, http.StatusOK, rr.Code)
+		// Inspect the body of the response
+		var resp models.DeviceConfigStatus
+		assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+		assert.Equal(t, params.expected, resp)
+	}
+}
+
+func TestUpdateDeviceConfig(t *testing.T) {
+	const (
+		accountID = "35581BF4-32C8-4908-8377-2E6A021D3D2B"
+		deviceID  = "63eda5eb-7f56-417f-88ed-44a9eb9e5f67"
+	)
+	interval := 5 * time.Minute
+	timeout := 10 * time.Second
+	threshold := 15
+	cleared := 0
+	testParams := []struct {
+		body    string
+		update  *shadow.DeviceConfigUpdate
+		desired models.DeviceConfig
+		status  int
+	}{
+		{ // Only the given fields are updated
+			body:    `{"reportInterval":300,"lowBatteryThreshold":15}`,
+			update:  &shadow.DeviceConfigUpdate{ReportInterval: &interval, LowBatteryThreshold: &threshold},
+			desired: models.DeviceConfig{ReportInterval: 300, LowBatteryThreshold: 15},
+			status:  http.StatusOK,
+		},
+		{
+			body:    `{"statusRequestTimeout":10}`,
+			update:  &shadow.DeviceConfigUpdate{StatusRequestTimeout: &timeout},
+			desired: models.DeviceConfig{StatusRequestTimeout: 10},
+			status:  http.StatusOK,
+		},
+		{ // Zero clears a field, so the device goes back to its default
+			body:   `{"lowBatteryThreshold":0}`,
+			update: &shadow.DeviceConfigUpdate{LowBatteryThreshold: &cleared},
+			status: http.StatusOK,
+		},
+		{body: `{"lowBatteryThreshold":101}`, status: http.StatusBadRequest},
+		{body: `{"reportInterval":-1}`, status: http.StatusBadRequest},
+		{body: `{"reportInterval":5}`, status: http.StatusBadRequest},
+		{body: `not json`, status: http.StatusBadRequest},
+	}
+	for _, params := range testParams {
+		// Create a client
+		_, shdw, _, iotClient, tokens, router := createRealRouter(t)
+		gomock.InOrder(
+			// Expect the auth middleware to check the device belongs to the account
+			tokens.EXPECT().Validate(testToken).Return(testClaims(accountID), nil),
+			iotClient.EXPECT().GetThing(deviceID).Return(&iot.Device{AccountId: accountID}, nil),
+		)
+		// Expect the desired config to be updated
+		if params.update != nil {
+			desired := shadow.DeviceConfig{
+				ReportInterval:       time.Duration(params.desired.ReportInterval) * time.Second,
+				StatusRequestTimeout: time.Duration(params.desired.StatusRequestTimeout) * time.Second,
+				LowBatteryThreshold:  params.desired.LowBatteryThreshold,
+			}
+			shdw.EXPECT().UpdateDesiredConfig(deviceID, *params.update).Return(&shadow.Shadow{
+				Config: shadow.ConfigShadow{Desired: desired},
+			}, nil)
+		}
+		// Create a request to update the config
+		req := createRequest(t, http.MethodPatch, fmt.Sprintf("/v1/devices/%s/config", deviceID), []byte(params.body))
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", testToken))
+		// Execute the handler
+		rr := runHandler(router, req)
+		assert.Equal(t, params.status, rr.Code, params.body)
+		if params.status != http.StatusOK {
+			continue
+		}
+		// The device is yet to apply the config
+		var resp models.DeviceConfigStatus
+		assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+		assert.Equal(t, params.desired, resp.Desired)
+		assert.Equal(t, resp.Desired, resp.Delta)
+	}
+}
+
+func TestGetDeviceDiagnostics(t *testing.T) {
+	const (
+		accountID = "35581BF4-32C8-4908-8377-2E6A021D3D2B"
+		deviceID  = "63eda5eb-7f56-417f-88ed-44a9eb9e5f67"
+		megabyte  = 1024 * 1024
+	)
+	testParams := []struct {
+		cellular shadow.CellularShadow
+		cap      int64
+		expected models.DeviceDiagnostics
+	}{
+		{ // Half way through April
+			cellular: shadow.CellularShadow{
+				Signal:   &shadow.SignalShadow{RSSI: -71, RSRP: -98},
+				DataUsed: 10 * megabyte,
+				Samples: []shadow.CellularSample{
+					{Time: createTime(t, "2020/04/16 00:00:00"), Signal: shadow.SignalShadow{RSSI: -71, RSRP: -98}, DataUsed: 10 * megabyte},
+				},
+			},
+			cap: 50 * megabyte,
+			expected: models.DeviceDiagnostics{
+				Signal:             &models.DeviceSignal{RSSI: -71, RSRP: -98},
+				DataUsedBytes:      10 * megabyte,
+				ProjectedDataBytes: 20 * megabyte,
+				DataCapBytes:       50 * megabyte,
+				History: []models.DeviceCellularSample{
+					{Time: createTime(t, "2020/04/16 00:00:00"), Signal: models.DeviceSignal{RSSI: -71, RSRP: -98}, DataUsedBytes: 10 * megabyte},
+				},
+			},
+		},
+		{ // Too early in the month to project, and no allowance
+			cellular: shadow.CellularShadow{
+				DataUsed: megabyte,
+				Samples: []shadow.CellularSample{
+					{Time: createTime(t, "2020/04/01 12:00:00"), DataUsed: megabyte},
+				},
+			},
+			expected: models.DeviceDiagnostics{
+				DataUsedBytes: megabyte,
+				History: []models.DeviceCellularSample{
+					{Time: createTime(t, "2020/04/01 12:00:00"), DataUsedBytes: megabyte},
+				},
+			},
+		},
+		{ // The device doesn't report its cellular connection
+			expected: models.DeviceDiagnostics{History: []models.DeviceCellularSample{}},
+		},
+	}
+	for _, params := range testParams {
+		// Create a client
+		db, shdw, _, iotClient, tokens, router := createRealRouter(t)
+		gomock.InOrder(
+			// Expect the auth middleware to check the device belongs to the account
+			tokens.EXPECT().Validate(testToken).Return(testClaims(accountID), nil),
+			iotClient.EXPECT().GetThing(deviceID).Return(&iot.Device{AccountId: accountID}, nil),
+			// Expect the shadow and account to be fetched
+			shdw.EXPECT().Get(deviceID).Return(&shadow.Shadow{Cellular: params.cellular}, nil),
+			db.EXPECT().GetAccountById(accountID).Return(&database.Account{AccountId: accountID, DataCap: params.cap}, nil),
+		)
+		// Create a request for the diagnostics
+		req := createRequest(t, http.MethodGet, fmt.Sprintf("/v1/devices/%s/diagnostics", deviceID), nil)
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", testToken))
+		// Execute the handler
+		rr := runHandler(router, req)
+		assert.Equal(t, http.StatusOK, rr.Code)
+		// Inspect the body of the response
+		var resp models.DeviceDiagnostics
+		assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+		assert.Equal(t, params.expected, resp)
+	}
+}
+
+func TestPingDevice(t *testing.T) {
+	const (
+		accountID = "35581BF4-32C8-4908-8377-2E6A021D3D2B"
+		deviceID  = "63eda5eb-7f56-417f-88ed-44a9eb9e5f67"
+	)
+	// Create a client
+	_, shdw, _, iotClient, tokens, router := createRealRouter(t)
+	seen := time.Unix(1584803414, 0)
+	device := func(version int, seen time.Time) *shadow.Shadow {
+		return &shadow.Shadow{
+			Version:    version,
+			Seen:       seen,
+			Name:       "Kitchen",
+			Connection: shadow.ConnectionShadow{Status: shadow.CONNECTION_STATUS_CONNECTED},
+			Power:      shadow.PowerShadow{Value: shadow.POWER_STATUS_ON},
+		}
+	}
+	gomock.InOrder(
+		// Expect the auth middleware to check the device belongs to the account
+		tokens.EXPECT().Validate(testToken).Return(testClaims(accountID), nil),
+		iotClient.EXPECT().GetThing(deviceID).Return(&iot.Device{AccountId: accountID}, nil),
+		// Expect the device to be pinged
+		shdw.EXPECT().Get(deviceID).Return(device(50, seen), nil),
+		shdw.EXPECT().RequestStatusUpdate(deviceID),
+		// Expect the shadow to be checked until the device responds
+		shdw.EXPECT().Get(deviceID).Return(device(50, seen), nil),
+		// Something other than the device updating the shadow isn't a response
+		shdw.EXPECT().Get(deviceID).Return(device(51, seen), nil),
+		shdw.EXPECT().Get(deviceID).Return(device(52, seen.Add(5*time.Second)), nil),
+	)
+	// Create a request to ping the device
+	req := createRequest(t, http.MethodPost, fmt.Sprintf("/v1/devices/%s/ping", deviceID), nil)
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", testToken))
+	// Execute the handler
+	rr := runHandler(router, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	// Inspect the body of the response
+	var resp models.Device
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+	assert.Equal(t, "Kitchen", resp.Name)
+	assert.Equal(t, deviceID, resp.DeviceId)
+}
+
+func TestPingDeviceUnresponsive(t *testing.T) {
+	const (
+		accountID = "35581BF4-32C8-4908-8377-2E6A021D3D2B"
+		deviceID  = "63eda5eb-7f56-417f-88ed-44a9eb9e5f67"
+	)
+	// Create a client
+	_, shdw, _, iotClient, tokens, router := createRealRouter(t)
+	gomock.InOrder(
+		// Expect the auth middleware to check the device belongs to the account
+		tokens.EXPECT().Validate(testToken).Return(testClaims(accountID), nil),
+		iotClient.EXPECT().GetThing(deviceID).Return(&iot.Device{AccountId: accountID}, nil),
+		// Expect the device to be pinged
+		shdw.EXPECT().Get(deviceID).Return(&shadow.Shadow{Version: 50, Seen: time.Unix(1584803414, 0)}, nil),
+		shdw.EXPECT().RequestStatusUpdate(deviceID),
+	)
+	// The device never responds, though the shadow is updated by something else
+	shdw.EXPECT().Get(deviceID).Return(&shadow.Shadow{Version: 51, Seen: time.Unix(1584803414, 0)}, nil).AnyTimes()
+	// Create a request to ping the device, that gives up quickly
+	req := createRequest(t, http.MethodPost, fmt.Sprintf("/v1/devices/%s/ping", deviceID), nil)
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", testToken))
+	ctx, cancel := context.WithTimeout(req.Context(), 100*time.Millisecond)
+	defer cancel()
+	// Execute the handler
+	rr := runHandler(router, req.WithContext(ctx))
+	assert.Equal(t, http.StatusGatewayTimeout, rr.Code)
+}
@@ -0,0 +1,64 @@
+package app
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/briggysmalls/detectordag/api/app/models"
+	tkns "github.com/briggysmalls/detectordag/api/app/tokens"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetKeys(t *testing.T) {
+	// Create an RSA key, and an Ed25519 one
+	rsaPrivate, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	rsaKey, err := tkns.ParseKey("2020-03", string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaPrivate)})), time.Now())
+	assert.NoError(t, err)
+	edPublic, edPrivate, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+	der, err := x509.MarshalPKCS8PrivateKey(edPrivate)
+	assert.NoError(t, err)
+	edKey, err := tkns.ParseKey("2020-04", string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})), time.Time{})
+	assert.NoError(t, err)
+	// Create a client
+	_, _, _, _, tokens, router := createRealRouter(t)
+	tokens.EXPECT().Keys().Return([]*tkns.Key{rsaKey, edKey})
+	// Create a request for the keys, which doesn't need a token
+	req := createRequest(t, http.MethodGet, "/.well-known/jwks.json", nil)
+	// Execute the handler
+	rr := runHandler(router, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "public, max-age=300", rr.Header().Get("Cache-Control"))
+	// Inspect the body of the response
+	var resp models.JSONWebKeySet
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+	assert.Equal(t, models.JSONWebKeySet{Keys: []models.JSONWebKey{
+		{
+			Kty: "RSA",
+			Kid: "2020-03",
+			Use: "sig",
+			Alg: "RS256",
+			N:   base64.RawURLEncoding.EncodeToString(rsaPrivate.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(rsaPrivate.E)).Bytes()),
+		},
+		{
+			Kty: "OKP",
+			Kid: "2020-04",
+			Use: "sig",
+			Alg: "EdDSA",
+			Crv: "Ed25519",
+			X:   base64.RawURLEncoding.EncodeToString(edPublic),
+		},
+	}}, resp)
+	assert.Equal(t, "AQAB", resp.Keys[0].E)
+}
@@ -0,0 +1,180 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/briggysmalls/detectordag/api/app/models"
+	"github.com/briggysmalls/detectordag/shared/database"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuthFailure(t *testing.T) {
+	const (
+		username       = "email@example.com"
+		accountID      = "35581BF4-32C8-4908-8377-2E6A021D3D2B"
+		hashedPassword = "$2y$12$Nt3ajpggM4ViynWVGLOpW.JSbnVVVKRjNuw/ZYI71cj1WNG3Fty0K"
+	)
+	now := time.Now()
+	account := &database.Account{AccountId: accountID, Username: username, Password: hashedPassword}
+	testParams := []struct {
+		password   string
+		account    *database.Account
+		accountErr error
+		username   database.LoginAttempts
+		ip         database.LoginAttempts
+		counted    bool
+		reason     string
+		retryAfter int
+		status     int
+		error      string
+	}{
+		// Unknown username
+		{password: "mypassword", accountErr: fmt.Errorf("%w: %s", database.ErrUnknownAccount, username), counted: true, status: http.StatusForbidden, error: "Incorrect username or password"},
+		// Incorrect password
+		{password: "wrong", account: account, counted: true, reason: "Incorrect password", status: http.StatusForbidden, error: "Incorrect username or password"},
+		// Incorrect password, after enough time has passed since the last failure
+		{password: "wrong", account: account, username: database.LoginAttempts{Failures: 5, LastFailure: now.Add(-time.Minute).Unix()}, counted: true, reason: "Incorrect password", status: http.StatusForbidden, error: "Incorrect username or password"},
+		// The username is locked, even with the right password
+		{password: "mypassword", account: account, username: database.LoginAttempts{Failures: 10, LastFailure: now.Unix()}, reason: "Too many attempts", retryAfter: 1800, status: http.StatusTooManyRequests, error: "Too many login attempts, try again later"},
+		// Unknown usernames are locked in the same way
+		{password: "mypassword", accountErr: fmt.Errorf("%w: %s", database.ErrUnknownAccount, username), username: database.LoginAttempts{Failures: 10, LastFailure: now.Unix()}, retryAfter: 1800, status: http.StatusTooManyRequests, error: "Too many login attempts, try again later"},
+		// Attempts from the address are slowed down
+		{password: "mypassword", account: account, ip: database.LoginAttempts{Failures: 22, LastFailure: now.Unix()}, reason: "Too many attempts", retryAfter: 4, status: http.StatusTooManyRequests, error: "Too many login attempts, try again later"},
+		// The database fails, without saying so to the caller
+		{password: "mypassword", accountErr: errors.New("Secret database details"), counted: true, status: http.StatusInternalServerError, error: "Unable to log in, try again later"},
+	}
+	for _, params := range testParams {
+		// Create a mock client
+		db, _, _, _, _, router := createRealRouter(t)
+		// Expect previous failures to be checked
+		params.username.Key = "username:" + username
+		params.ip.Key = "ip:203.0.113.7"
+		db.EXPECT().GetLoginAttempts("username:"+username, gomock.Any()).Return(&params.username, nil)
+		db.EXPECT().GetLoginAttempts("ip:203.0.113.7", gomock.Any()).Return(&params.ip, nil)
+		db.EXPECT().GetAccountByUsername(username).Return(params.account, params.accountErr)
+		// Expect the attempt to be counted before it's checked
+		if params.counted {
+			db.EXPECT().RecordLoginAttempt(params.username, gomock.Any(), gomock.Any()).Return(&database.LoginAttempts{}, nil)
+			db.EXPECT().RecordLoginAttempt(params.ip, gomock.Any(), gomock.Any()).Return(&database.LoginAttempts{}, nil)
+		}
+		// Expect the attempt to be audited
+		if params.reason != "" {
+			db.EXPECT().RecordLogin(gomock.Any()).Do(func(login database.Login) {
+				assert.Equal(t, accountID, login.AccountId)
+				assert.False(t, login.Success)
+				assert.Equal(t, params.reason, login.Reason)
+			})
+		}
+		// Create a request to authenticate
+		req := createRequest(t, http.MethodPost, "/v1/auth", []byte(fmt.Sprintf(`{"username": "%s", "password": "%s"}`, username, params.password)))
+		req.RemoteAddr = "203.0.113.7:51234"
+		// Execute the handler
+		rr := runHandler(router, req)
+		assert.Equal(t, params.status, rr.Code)
+		// Failures are recorded to the second, so the wait can be a second out
+		if params.retryAfter != 0 {
+			retryAfter, err := strconv.Atoi(rr.Header().Get("Retry-After"))
+			assert.NoError(t, err)
+			assert.InDelta(t, params.retryAfter, retryAfter, 1)
+		} else {
+			assert.Empty(t, rr.Header().Get("Retry-After"))
+		}
+		// Check the error doesn't give anything away
+		var resp models.ModelError
+		assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+		assert.Equal(t, params.error, resp.Error_)
+	}
+}
+
+func TestAuthSuccessKeepsAddressAttempts(t *testing.T) {
+	const (
+		username       = "email@example.com"
+		accountID      = "35581BF4-32C8-4908-8377-2E6A021D3D2B"
+		hashedPassword = "$2y$12$Nt3ajpggM4ViynWVGLOpW.JSbnVVVKRjNuw/ZYI71cj1WNG3Fty0K"
+	)
+	usernameAttempts := database.LoginAttempts{Key: "username:" + username}
+	// The address has been guessing at other accounts
+	ipAttempts := database.LoginAttempts{Key: "ip:203.0.113.7", Failures: 15, LastFailure: time.Now().Unix()}
+	// Create a mock client
+	db, _, _, _, tokens, router := createRealRouter(t)
+	db.EXPECT().GetLoginAttempts("username:"+username, gomock.Any()).Return(&usernameAttempts, nil)
+	db.EXPECT().GetLoginAttempts("ip:203.0.113.7", gomock.Any()).Return(&ipAttempts, nil)
+	db.EXPECT().GetAccountByUsername(username).Return(&database.Account{AccountId: accountID, Username: username, Password: hashedPassword}, nil)
+	// Expect the attempt to be counted against both
+	db.EXPECT().RecordLoginAttempt(usernameAttempts, gomock.Any(), gomock.Any()).Return(&database.LoginAttempts{}, nil)
+	db.EXPECT().RecordLoginAttempt(ipAttempts, gomock.Any(), gomock.Any()).Return(&database.LoginAttempts{}, nil)
+	// Expect only the username's attempts to be forgotten, leaving the address's to expire
+	db.EXPECT().ClearLoginAttempts("username:" + username)
+	db.EXPECT().ClearLoginAttempts("ip:203.0.113.7").Times(0)
+	db.EXPECT().RecordLogin(gomock.Any())
+	tokens.EXPECT().Create(accountID).Return("new-token", nil)
+	db.EXPECT().CreateRefreshToken(gomock.Any())
+	// Create a request to authenticate
+	req := createRequest(t, http.MethodPost, "/v1/auth", []byte(fmt.Sprintf(`{"username": "%s", "password": "mypassword"}`, username)))
+	req.RemoteAddr = "203.0.113.7:51234"
+	// Execute the handler
+	rr := runHandler(router, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+}
+
+func TestAuthConcurrentAttempts(t *testing.T) {
+	const (
+		username = "email@example.com"
+		password = "mypassword"
+	)
+	now := time.Now()
+	usernameAttempts := database.LoginAttempts{Key: "username:" + username, Failures: 9, LastFailure: now.Add(-time.Hour).Unix()}
+	ipAttempts := database.LoginAttempts{Key: "ip:203.0.113.7"}
+	// Create a mock client
+	db, _, _, _, _, router := createRealRouter(t)
+	db.EXPECT().GetAccountByUsername(username).Return(nil, fmt.Errorf("%w: %s", database.ErrUnknownAccount, username))
+	// Expect the attempt to lose out to another one, which locks the username
+	gomock.InOrder(
+		db.EXPECT().GetLoginAttempts("username:"+username, gomock.Any()).Return(&usernameAttempts, nil),
+		db.EXPECT().GetLoginAttempts("ip:203.0.113.7", gomock.Any()).Return(&ipAttempts, nil),
+		db.EXPECT().RecordLoginAttempt(usernameAttempts, gomock.Any(), gomock.Any()).Return(nil, database.ErrLoginAttemptsChanged),
+		db.EXPECT().GetLoginAttempts("username:"+username, gomock.Any()).Return(&database.LoginAttempts{Key: "username:" + username, Failures: 10, LastFailure: now.Unix()}, nil),
+	)
+	// Create a request to authenticate
+	req := createRequest(t, http.MethodPost, "/v1/auth", []byte(fmt.Sprintf(`{"username": "%s", "password": "%s"}`, username, password)))
+	req.RemoteAddr = "203.0.113.7:51234"
+	// Execute the handler
+	rr := runHandler(router, req)
+	assert.Equal(t, http.StatusTooManyRequests, rr.Code)
+	retryAfter, err := strconv.Atoi(rr.Header().Get("Retry-After"))
+	assert.NoError(t, err)
+	assert.InDelta(t, 1800, retryAfter, 1)
+}
+
+func TestGetLogins(t *testing.T) {
+	const accountID = "35581BF4-32C8-4908-8377-2E6A021D3D2B"
+	at := time.Date(2020, 4, 18, 9, 0, 0, 0, time.UTC)
+	// Create a client
+	db, _, _, _, tokens, router := createRealRouter(t)
+	tokens.EXPECT().Validate(testToken).Return(testClaims(accountID), nil)
+	// Expect the most recent attempts to be fetched
+	db.EXPECT().GetLogins(accountID, 50).Return([]database.Login{
+		{AccountId: accountID, Timestamp: at.Add(time.Minute).UnixNano(), IP: "203.0.113.7", UserAgent: "test-agent", Success: true},
+		{AccountId: accountID, Timestamp: at.UnixNano(), IP: "198.51.100.2", UserAgent: "curl/7.68.0", Reason: "Incorrect password"},
+	}, nil)
+	// Create a request for the history
+	req := createRequest(t, http.MethodGet, fmt.Sprintf("/v1/accounts/%s/logins", accountID), nil)
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", testToken))
+	// Execute the handler
+	rr := runHandler(router, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	// Inspect the body of the response
+	var resp []models.Login
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+	assert.Equal(t, []models.Login{
+		{Time: at.Add(time.Minute), IP: "203.0.113.7", UserAgent: "test-agent", Success: true},
+		{Time: at, IP: "198.51.100.2", UserAgent: "curl/7.68.0", Reason: "Incorrect password"},
+	}, resp)
+}
@@ -0,0 +1,157 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/briggysmalls/detectordag/api/app/models"
+	"github.com/briggysmalls/detectordag/shared/database"
+	"github.com/briggysmalls/detectordag/shared/iot"
+	"github.com/briggysmalls/detectordag/shared/maintenance"
+	"github.com/briggysmalls/detectordag/shared/shadow"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetMaintenanceWindows(t *testing.T) {
+	const (
+		accountID = "35581BF4-32C8-4908-8377-2E6A021D3D2B"
+		deviceID  = "63eda5eb-7f56-417f-88ed-44a9eb9e5f67"
+	)
+	start := createTime(t, "2020/03/22 01:00:00")
+	// Create a client
+	db, shdw, _, iotClient, tokens, router := createRealRouter(t)
+	gomock.InOrder(
+		// Expect the auth middleware to check the device belongs to the account
+		tokens.EXPECT().Validate(testToken).Return(testClaims(accountID), nil),
+		iotClient.EXPECT().GetThing(deviceID).Return(&iot.Device{AccountId: accountID}, nil),
+	)
+	// Expect the account and shadow to be fetched
+	db.EXPECT().GetAccountById(accountID).Return(&database.Account{AccountId: accountID, Maintenance: []database.MaintenanceWindow{
+		{ID: "nightly", Start: start.Unix(), Duration: 3600, Repeat: "daily"},
+	}}, nil)
+	shdw.EXPECT().Get(deviceID).Return(&shadow.Shadow{Maintenance: []maintenance.Window{
+		{ID: "rewire", Start: start, Duration: 2 * time.Hour, Repeat: maintenance.Once},
+	}}, nil)
+	// Create a request for the windows
+	req := createRequest(t, http.MethodGet, fmt.Sprintf("/v1/devices/%s/maintenance", deviceID), nil)
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", testToken))
+	// Execute the handler
+	rr := runHandler(router, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	// Inspect the body of the response
+	var resp []models.MaintenanceWindow
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+	assert.Len(t, resp, 2)
+	assert.Equal(t, models.MaintenanceWindow{ID: "nightly", Start: resp[0].Start, Duration: 3600, Repeat: "daily", AllDevices: true}, resp[0])
+	assert.Equal(t, models.MaintenanceWindow{ID: "rewire", Start: resp[1].Start, Duration: 7200, Repeat: "once"}, resp[1])
+	assert.True(t, start.Equal(resp[0].Start))
+}
+
+func TestCreateMaintenanceWindow(t *testing.T) {
+	const (
+		accountID = "35581BF4-32C8-4908-8377-2E6A021D3D2B"
+		deviceID  = "63eda5eb-7f56-417f-88ed-44a9eb9e5f67"
+	)
+	// An old one-off window that should be tidied away
+	expired := maintenance.Window{ID: "old", Start: time.Unix(1584803414, 0), Duration: time.Hour, Repeat: maintenance.Once}
+	testParams := []struct {
+		body       string
+		status     int
+		allDevices bool
+		repeat     maintenance.Repeat
+	}{
+		{body: `{"start":"2030-04-18T09:00:00Z","duration":7200}`, status: http.StatusOK, repeat: maintenance.Once},
+		{body: `{"start":"2030-04-18T09:00:00Z","duration":3600,"repeat":"weekly","allDevices":true}`, status: http.StatusOK, allDevices: true, repeat: maintenance.Weekly},
+		{body: `{"start":"2030-04-18T09:00:00Z","duration":3600,"repeat":"monthly"}`, status: http.StatusBadRequest},
+		{body: `{"start":"2030-04-18T09:00:00Z","duration":30}`, status: http.StatusBadRequest},
+		{body: `{"start":"2030-04-18T09:00:00Z","duration":604801}`, status: http.StatusBadRequest},
+		{body: `{"duration":3600}`, status: http.StatusBadRequest},
+		{body: `not json`, status: http.StatusBadRequest},
+	}
+	for _, params := range testParams {
+		// Create a client
+		db, shdw, _, iotClient, tokens, router := createRealRouter(t)
+		gomock.InOrder(
+			// Expect the auth middleware to check the device belongs to the account
+			tokens.EXPECT().Validate(testToken).Return(testClaims(accountID), nil),
+			iotClient.EXPECT().GetThing(deviceID).Return(&iot.Device{AccountId: accountID}, nil),
+		)
+		if params.status == http.StatusOK {
+			account := &database.Account{AccountId: accountID}
+			device := &shadow.Shadow{Maintenance: []maintenance.Window{expired}}
+			db.EXPECT().GetAccountById(accountID).Return(account, nil)
+			shdw.EXPECT().Get(deviceID).Return(device, nil)
+			// Expect the window to be stored in the right place
+			check := func(windows []maintenance.Window) {
+				assert.Len(t, windows, 1)
+				assert.NotEmpty(t, windows[0].ID)
+				assert.Equal(t, params.repeat, windows[0].Repeat)
+			}
+			if params.allDevices {
+				db.EXPECT().UpdateAccountMaintenance(accountID, gomock.Any()).DoAndReturn(func(id string, windows []maintenance.Window) (*database.Account, error) {
+					check(windows)
+					return account, nil
+				})
+			} else {
+				shdw.EXPECT().UpdateMaintenance(deviceID, gomock.Any()).DoAndReturn(func(id string, windows []maintenance.Window) (*shadow.Shadow, error) {
+					// The expired window is dropped
+					check(windows)
+					return &shadow.Shadow{Maintenance: windows}, nil
+				})
+			}
+		}
+		// Create a request to create the window
+		req := createRequest(t, http.MethodPost, fmt.Sprintf("/v1/devices/%s/maintenance", deviceID), []byte(params.body))
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", testToken))
+		// Execute the handler
+		rr := runHandler(router, req)
+		assert.Equal(t, params.status, rr.Code, params.body)
+	}
+}
+
+func TestDeleteMaintenanceWindow(t *testing.T) {
+	const (
+		accountID = "35581BF4-32C8-4908-8377-2E6A021D3D2B"
+		deviceID  = "63eda5eb-7f56-417f-88ed-44a9eb9e5f67"
+		windowID  = "5e3f1c2a-8b1d-4c55-9d8e-3f0b4a2c6d71"
+	)
+	window := maintenance.Window{ID: windowID, Start: time.Unix(1584803414, 0), Duration: time.Hour, Repeat: maintenance.Daily}
+	testParams := []struct {
+		device  []maintenance.Window
+		account []database.MaintenanceWindow
+		status  int
+	}{
+		{device: []maintenance.Window{window}, status: http.StatusOK},
+		{account: []database.MaintenanceWindow{{ID: windowID, Start: 1584803414, Duration: 3600, Repeat: "daily"}}, status: http.StatusOK},
+		{status: http.StatusNotFound},
+	}
+	for _, params := range testParams {
+		// Create a client
+		db, shdw, _, iotClient, tokens, router := createRealRouter(t)
+		gomock.InOrder(
+			// Expect the auth middleware to check the device belongs to the account
+			tokens.EXPECT().Validate(testToken).Return(testClaims(accountID), nil),
+			iotClient.EXPECT().GetThing(deviceID).Return(&iot.Device{AccountId: accountID}, nil),
+		)
+		account := &database.Account{AccountId: accountID, Maintenance: params.account}
+		db.EXPECT().GetAccountById(accountID).Return(account, nil)
+		shdw.EXPECT().Get(deviceID).Return(&shadow.Shadow{Maintenance: params.device}, nil)
+		// Expect the window to be removed from wherever it is kept
+		if params.device != nil {
+			shdw.EXPECT().UpdateMaintenance(deviceID, []maintenance.Window{}).Return(&shadow.Shadow{}, nil)
+		}
+		if params.account != nil {
+			db.EXPECT().UpdateAccountMaintenance(accountID, []maintenance.Window{}).Return(&database.Account{AccountId: accountID}, nil)
+		}
+		// Create a request to delete the window
+		req := createRequest(t, http.MethodDelete, fmt.Sprintf("/v1/devices/%s/maintenance/%s", deviceID, windowID), nil)
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", testToken))
+		// Execute the handler
+		rr := runHandler(router, req)
+		assert.Equal(t, params.status, rr.Code)
+	}
+}
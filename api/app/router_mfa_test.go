@@ -0,0 +1,390 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/briggysmalls/detectordag/api/app/models"
+	tkns "github.com/briggysmalls/detectordag/api/app/tokens"
+	"github.com/briggysmalls/detectordag/api/app/totp"
+	"github.com/briggysmalls/detectordag/shared/database"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+// testMFASecret is the TOTP secret of accounts in tests
+const testMFASecret = "JBSWY3DPEHPK3PXPJBSWY3DPEHPK3PXP"
+
+func TestEnrolMFA(t *testing.T) {
+	const (
+		accountID      = "35581BF4-32C8-4908-8377-2E6A021D3D2B"
+		username       = "user@example.com"
+		hashedPassword = "$2y$12$Nt3ajpggM4ViynWVGLOpW.JSbnVVVKRjNuw/ZYI71cj1WNG3Fty0K"
+	)
+	testParams := []struct {
+		body      string
+		enabled   bool
+		throttled bool
+		status    int
+	}{
+		{body: `{"password":"mypassword"}`, status: http.StatusOK},
+		// The password is wrong
+		{body: `{"password":"wrongpassword"}`, status: http.StatusForbidden},
+		// Too many passwords have been tried
+		{body: `{"password":"mypassword"}`, throttled: true, status: http.StatusTooManyRequests},
+		// Enrolling again mustn't be a way around needing a code
+		{body: `{"password":"mypassword"}`, enabled: true, status: http.StatusConflict},
+		{body: `{}`, status: http.StatusBadRequest},
+	}
+	for _, params := range testParams {
+		// Create a client
+		db, _, _, _, tokens, router := createRealRouter(t)
+		tokens.EXPECT().Validate(testToken).Return(testClaims(accountID), nil)
+		if params.body != `{}` {
+			db.EXPECT().GetAccountById(accountID).Return(&database.Account{
+				AccountId: accountID,
+				Username:  username,
+				Password:  hashedPassword,
+				MFA:       database.MFA{Secret: testMFASecret, Enabled: params.enabled},
+			}, nil)
+		}
+		if params.body != `{}` && !params.enabled {
+			// Expect the attempt to be counted before the password is checked
+			attempts := database.LoginAttempts{Key: "username:" + username}
+			if params.throttled {
+				attempts.Failures = 10
+				attempts.LastFailure = time.Now().Unix()
+			}
+			db.EXPECT().GetLoginAttempts("username:"+username, gomock.Any()).Return(&attempts, nil)
+			if !params.throttled {
+				db.EXPECT().RecordLoginAttempt(attempts, gomock.Any(), gomock.Any()).Return(&database.LoginAttempts{}, nil)
+			}
+		}
+		// Expect the attempts to be forgotten, and the new secret stored without enabling it yet
+		var stored database.MFA
+		if params.status == http.StatusOK {
+			db.EXPECT().ClearLoginAttempts("username:" + username)
+			db.EXPECT().UpdateAccountMFA(accountID, gomock.Any()).DoAndReturn(func(id string, mfa database.MFA) (*database.Account, error) {
+				stored = mfa
+				return &database.Account{AccountId: accountID, MFA: mfa}, nil
+			})
+		}
+		// Create a request to enrol
+		req := createRequest(t, http.MethodPost, fmt.Sprintf("/v1/accounts/%s/mfa", accountID), []byte(params.body))
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", testToken))
+		// Execute the handler
+		rr := runHandler(router, req)
+		assert.Equal(t, params.status, rr.Code, params.body)
+		if params.status != http.StatusOK {
+			continue
+		}
+		// Inspect the body of the response
+		var resp models.MFAEnrolment
+		assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+		assert.NotEqual(t, testMFASecret, resp.Secret)
+		assert.True(t, strings.HasPrefix(resp.URI, "otpauth://totp/detectordag:user@example.com?"))
+		assert.Contains(t, resp.URI, "secret="+resp.Secret)
+		assert.Len(t, resp.RecoveryCodes, 10)
+		// Check only hashes of the recovery codes are stored
+		assert.Equal(t, resp.Secret, stored.Secret)
+		assert.False(t, stored.Enabled)
+		assert.Len(t, stored.RecoveryCodes, 10)
+		assert.NotContains(t, stored.RecoveryCodes, resp.RecoveryCodes[0])
+	}
+}
+
+func TestConfirmMFA(t *testing.T) {
+	const accountID = "35581BF4-32C8-4908-8377-2E6A021D3D2B"
+	step := totp.Step(time.Now())
+	code, err := totp.Code(testMFASecret, step)
+	assert.NoError(t, err)
+	testParams := []struct {
+		body   string
+		mfa    database.MFA
+		status int
+	}{
+		{body: fmt.Sprintf(`{"code":"%s"}`, code), mfa: database.MFA{Secret: testMFASecret}, status: http.StatusNoContent},
+		{body: `{"code":"not-a-code"}`, mfa: database.MFA{Secret: testMFASecret}, status: http.StatusForbidden},
+		// Enrolment hasn't been started
+		{body: fmt.Sprintf(`{"code":"%s"}`, code), status: http.StatusBadRequest},
+		{body: fmt.Sprintf(`{"code":"%s"}`, code), mfa: database.MFA{Secret: testMFASecret, Enabled: true}, status: http.StatusConflict},
+		{body: `{}`, status: http.StatusBadRequest},
+	}
+	for _, params := range testParams {
+		// Create a client
+		db, _, _, _, tokens, router := createRealRouter(t)
+		tokens.EXPECT().Validate(testToken).Return(testClaims(accountID), nil)
+		if params.body != `{}` {
+			db.EXPECT().GetAccountById(accountID).Return(&database.Account{AccountId: accountID, MFA: params.mfa}, nil)
+		}
+		// Expect codes to be needed from now on, and the one used not to be used again
+		if params.status == http.StatusNoContent {
+			enabled := params.mfa
+			enabled.Enabled = true
+			enabled.LastStep = step
+			db.EXPECT().UpdateAccountMFA(accountID, enabled).Return(&database.Account{AccountId: accountID, MFA: enabled}, nil)
+			// Expect sessions started without a code to be ended
+			db.EXPECT().RevokeAccountRefreshTokens(accountID)
+		}
+		// Create a request to confirm enrolment
+		req := createRequest(t, http.MethodPost, fmt.Sprintf("/v1/accounts/%s/mfa/confirm", accountID), []byte(params.body))
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", testToken))
+		// Execute the handler
+		rr := runHandler(router, req)
+		assert.Equal(t, params.status, rr.Code, params.body)
+	}
+}
+
+func TestAuthMFAChallenge(t *testing.T) {
+	const (
+		username       = "email@example.com"
+		accountID      = "35581BF4-32C8-4908-8377-2E6A021D3D2B"
+		hashedPassword = "$2y$12$Nt3ajpggM4ViynWVGLOpW.JSbnVVVKRjNuw/ZYI71cj1WNG3Fty0K"
+	)
+	// Create a mock client
+	db, _, _, _, _, router := createRealRouter(t)
+	db.EXPECT().GetLoginAttempts("username:"+username, gomock.Any()).Return(&database.LoginAttempts{Key: "username:" + username}, nil)
+	db.EXPECT().RecordLoginAttempt(database.LoginAttempts{Key: "username:" + username}, gomock.Any(), gomock.Any()).Return(&database.LoginAttempts{}, nil)
+	db.EXPECT().GetAccountByUsername(username).Return(&database.Account{
+		AccountId: accountID,
+		Username:  username,
+		Password:  hashedPassword,
+		MFA:       database.MFA{Secret: testMFASecret, Enabled: true},
+	}, nil)
+	// Expect a challenge to be stored, rather than tokens issued
+	db.EXPECT().CountMFAChallenges(accountID, gomock.Any()).Return(4, nil)
+	var stored database.MFAChallenge
+	db.EXPECT().CreateMFAChallenge(gomock.Any()).Do(func(challenge database.MFAChallenge) { stored = challenge })
+	// Create a request to authenticate
+	req := createRequest(t, http.MethodPost, "/v1/auth", []byte(fmt.Sprintf(`{"username": "%s", "password": "mypassword"}`, username)))
+	// Execute the handler
+	rr := runHandler(router, req)
+	assert.Equal(t, http.StatusAccepted, rr.Code)
+	// Check only the hash of the challenge is stored
+	var resp models.MFAChallenge
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+	assert.Equal(t, accountID, resp.AccountId)
+	assert.NotEmpty(t, resp.Challenge)
+	assert.Equal(t, database.MFAChallenge{
+		Hash:      tkns.HashRefreshToken(resp.Challenge),
+		AccountId: accountID,
+		Expires:   stored.Expires,
+	}, stored)
+}
+
+func TestAuthMFAChallengeLimit(t *testing.T) {
+	const (
+		username       = "email@example.com"
+		accountID      = "35581BF4-32C8-4908-8377-2E6A021D3D2B"
+		hashedPassword = "$2y$12$Nt3ajpggM4ViynWVGLOpW.JSbnVVVKRjNuw/ZYI71cj1WNG3Fty0K"
+	)
+	// Create a mock client
+	db, _, _, _, _, router := createRealRouter(t)
+	db.EXPECT().GetLoginAttempts("username:"+username, gomock.Any()).Return(&database.LoginAttempts{Key: "username:" + username}, nil)
+	db.EXPECT().RecordLoginAttempt(database.LoginAttempts{Key: "username:" + username}, gomock.Any(), gomock.Any()).Return(&database.LoginAttempts{}, nil)
+	db.EXPECT().GetAccountByUsername(username).Return(&database.Account{
+		AccountId: accountID,
+		Username:  username,
+		Password:  hashedPassword,
+		MFA:       database.MFA{Secret: testMFASecret, Enabled: true},
+	}, nil)
+	// Expect no more challenges to be created, while too many are open (five)
+	db.EXPECT().CountMFAChallenges(accountID, gomock.Any()).Return(5, nil)
+	// Create a request to authenticate
+	req := createRequest(t, http.MethodPost, "/v1/auth", []byte(fmt.Sprintf(`{"username": "%s", "password": "mypassword"}`, username)))
+	// Execute the handler
+	rr := runHandler(router, req)
+	assert.Equal(t, http.StatusTooManyRequests, rr.Code)
+	assert.Equal(t, "300", rr.Header().Get("Retry-After"))
+}
+
+func TestCompleteMFA(t *testing.T) {
+	const (
+		username  = "email@example.com"
+		accountID = "35581BF4-32C8-4908-8377-2E6A021D3D2B"
+	)
+	now := time.Now()
+	code, err := totp.Code(testMFASecret, totp.Step(now))
+	assert.NoError(t, err)
+	testParams := []struct {
+		code      string
+		challenge *database.MFAChallenge
+		used      bool
+		recovery  bool
+		throttled bool
+		status    int
+	}{
+		// Code from the app
+		{code: code, challenge: &database.MFAChallenge{AccountId: accountID, Expires: now.Add(time.Minute).Unix()}, used: true, status: http.StatusOK},
+		// Code from the app that has already been used
+		{code: code, challenge: &database.MFAChallenge{AccountId: accountID, Expires: now.Add(time.Minute).Unix()}, status: http.StatusForbidden},
+		// Recovery code
+		{code: "k3p7x-q2m9d", challenge: &database.MFAChallenge{AccountId: accountID, Expires: now.Add(time.Minute).Unix()}, recovery: true, used: true, status: http.StatusOK},
+		// Unknown recovery code
+		{code: "k3p7x-q2m9d", challenge: &database.MFAChallenge{AccountId: accountID, Expires: now.Add(time.Minute).Unix()}, recovery: true, status: http.StatusForbidden},
+		// The challenge has expired, but hasn't been deleted yet
+		{code: code, challenge: &database.MFAChallenge{AccountId: accountID, Expires: now.Add(-time.Minute).Unix()}, status: http.StatusForbidden},
+		// The challenge has already been attempted
+		{code: code, status: http.StatusForbidden},
+		// Too many codes have been tried, so the code isn't checked
+		{code: code, challenge: &database.MFAChallenge{AccountId: accountID, Expires: now.Add(time.Minute).Unix()}, throttled: true, status: http.StatusTooManyRequests},
+	}
+	for _, params := range testParams {
+		// Create a mock client
+		db, _, _, _, tokens, router := createRealRouter(t)
+		// Expect the challenge to be used up
+		if params.challenge != nil {
+			db.EXPECT().UseMFAChallenge(tkns.HashRefreshToken("my-challenge")).Return(params.challenge, nil)
+		} else {
+			db.EXPECT().UseMFAChallenge(tkns.HashRefreshToken("my-challenge")).Return(nil, database.ErrMFAChallengeNotFound)
+		}
+		if params.challenge != nil && params.challenge.Expires > now.Unix() {
+			db.EXPECT().GetAccountById(accountID).Return(&database.Account{
+				AccountId: accountID,
+				Username:  username,
+				MFA:       database.MFA{Secret: testMFASecret, Enabled: true},
+			}, nil)
+			// Expect the attempt to be counted before the code is checked
+			attempts := database.LoginAttempts{Key: "username:" + username}
+			if params.throttled {
+				attempts.Failures = 10
+				attempts.LastFailure = now.Unix()
+			}
+			db.EXPECT().GetLoginAttempts("username:"+username, gomock.Any()).Return(&attempts, nil)
+			if params.throttled {
+				db.EXPECT().RecordLogin(gomock.Any()).Do(func(login database.Login) { assert.Equal(t, "Too many attempts", login.Reason) })
+			} else {
+				db.EXPECT().RecordLoginAttempt(attempts, gomock.Any(), gomock.Any()).Return(&database.LoginAttempts{}, nil)
+			}
+		}
+		if params.challenge != nil && params.challenge.Expires > now.Unix() && !params.throttled {
+			// Expect the code to be used up
+			if params.recovery {
+				db.EXPECT().UseRecoveryCode(accountID, gomock.Any()).Return(params.used, nil)
+			} else {
+				db.EXPECT().UseMFAStep(accountID, totp.Step(now)).Return(params.used, nil)
+			}
+			if params.used {
+				// Expect the login to succeed
+				db.EXPECT().ClearLoginAttempts("username:" + username)
+				db.EXPECT().RecordLogin(gomock.Any()).Do(func(login database.Login) { assert.True(t, login.Success) })
+				tokens.EXPECT().Create(accountID).Return("new-token", nil)
+				db.EXPECT().CreateRefreshToken(gomock.Any())
+			} else {
+				db.EXPECT().RecordLogin(gomock.Any()).Do(func(login database.Login) { assert.Equal(t, "Incorrect code", login.Reason) })
+			}
+		}
+		// Create a request to complete the challenge
+		req := createRequest(t, http.MethodPost, "/v1/auth/mfa", []byte(fmt.Sprintf(`{"challenge":"my-challenge","code":"%s"}`, params.code)))
+		// Execute the handler
+		rr := runHandler(router, req)
+		assert.Equal(t, params.status, rr.Code, params.code)
+		if params.status != http.StatusOK {
+			continue
+		}
+		var resp models.Token
+		assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+		assert.Equal(t, "new-token", resp.Token)
+	}
+}
+
+func TestDisableMFA(t *testing.T) {
+	const (
+		accountID = "35581BF4-32C8-4908-8377-2E6A021D3D2B"
+		username  = "user@example.com"
+	)
+	now := time.Now()
+	code, err := totp.Code(testMFASecret, totp.Step(now))
+	assert.NoError(t, err)
+	testParams := []struct {
+		body      string
+		enabled   bool
+		used      bool
+		throttled bool
+		status    int
+	}{
+		{body: fmt.Sprintf(`{"code":"%s"}`, code), enabled: true, used: true, status: http.StatusNoContent},
+		// The code has already been used
+		{body: fmt.Sprintf(`{"code":"%s"}`, code), enabled: true, status: http.StatusForbidden},
+		// Too many codes have been tried
+		{body: fmt.Sprintf(`{"code":"%s"}`, code), enabled: true, throttled: true, status: http.StatusTooManyRequests},
+		// Two-factor authentication isn't enabled
+		{body: fmt.Sprintf(`{"code":"%s"}`, code), status: http.StatusConflict},
+		{body: `{}`, status: http.StatusBadRequest},
+	}
+	for _, params := range testParams {
+		// Create a client
+		db, _, _, _, tokens, router := createRealRouter(t)
+		tokens.EXPECT().Validate(testToken).Return(testClaims(accountID), nil)
+		if params.body != `{}` {
+			db.EXPECT().GetAccountById(accountID).Return(&database.Account{
+				AccountId: accountID,
+				Username:  username,
+				MFA:       database.MFA{Secret: testMFASecret, Enabled: params.enabled},
+			}, nil)
+		}
+		if params.enabled {
+			// Expect the attempt to be counted before the code is checked
+			attempts := database.LoginAttempts{Key: "username:" + username}
+			if params.throttled {
+				attempts.Failures = 10
+				attempts.LastFailure = now.Unix()
+			}
+			db.EXPECT().GetLoginAttempts("username:"+username, gomock.Any()).Return(&attempts, nil)
+			if !params.throttled {
+				db.EXPECT().RecordLoginAttempt(attempts, gomock.Any(), gomock.Any()).Return(&database.LoginAttempts{}, nil)
+				db.EXPECT().UseMFAStep(accountID, totp.Step(now)).Return(params.used, nil)
+			}
+		}
+		if params.used {
+			// Expect the attempts to be forgotten, and codes no longer needed
+			db.EXPECT().ClearLoginAttempts("username:" + username)
+			db.EXPECT().UpdateAccountMFA(accountID, database.MFA{}).Return(&database.Account{AccountId: accountID}, nil)
+		}
+		// Create a request to disable two-factor authentication
+		req := createRequest(t, http.MethodPost, fmt.Sprintf("/v1/accounts/%s/mfa/disable", accountID), []byte(params.body))
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", testToken))
+		// Execute the handler
+		rr := runHandler(router, req)
+		assert.Equal(t, params.status, rr.Code, params.body)
+	}
+}
+
+func TestCreateRecoveryCodes(t *testing.T) {
+	const (
+		accountID = "35581BF4-32C8-4908-8377-2E6A021D3D2B"
+		username  = "user@example.com"
+	)
+	// Create a client
+	db, _, _, _, tokens, router := createRealRouter(t)
+	tokens.EXPECT().Validate(testToken).Return(testClaims(accountID), nil)
+	db.EXPECT().GetAccountById(accountID).Return(&database.Account{
+		AccountId: accountID,
+		Username:  username,
+		MFA:       database.MFA{Secret: testMFASecret, Enabled: true},
+	}, nil)
+	// Expect a recovery code to be accepted
+	db.EXPECT().GetLoginAttempts("username:"+username, gomock.Any()).Return(&database.LoginAttempts{Key: "username:" + username}, nil)
+	db.EXPECT().RecordLoginAttempt(database.LoginAttempts{Key: "username:" + username}, gomock.Any(), gomock.Any()).Return(&database.LoginAttempts{}, nil)
+	db.EXPECT().UseRecoveryCode(accountID, gomock.Any()).Return(true, nil)
+	db.EXPECT().ClearLoginAttempts("username:" + username)
+	// Expect the new codes to replace the old ones
+	var stored []string
+	db.EXPECT().UpdateRecoveryCodes(accountID, gomock.Any()).Do(func(id string, hashes []string) { stored = hashes })
+	// Create a request for new codes
+	req := createRequest(t, http.MethodPost, fmt.Sprintf("/v1/accounts/%s/mfa/recovery-codes", accountID), []byte(`{"code":"k3p7x-q2m9d"}`))
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", testToken))
+	// Execute the handler
+	rr := runHandler(router, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	// Check only hashes of the new codes are stored
+	var resp models.MFARecoveryCodes
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+	assert.Len(t, resp.RecoveryCodes, 10)
+	assert.Len(t, stored, 10)
+	assert.NotContains(t, stored, resp.RecoveryCodes[0])
+}
@@ -0,0 +1,118 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/briggysmalls/detectordag/api/app/models"
+	"github.com/briggysmalls/detectordag/shared/iot"
+	"github.com/briggysmalls/detectordag/shared/shadow"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+func celsius(value float64) *float64 {
+	return &value
+}
+
+func TestGetDeviceProbes(t *testing.T) {
+	const (
+		accountID = "35581BF4-32C8-4908-8377-2E6A021D3D2B"
+		deviceID  = "63eda5eb-7f56-417f-88ed-44a9eb9e5f67"
+	)
+	// Create a client
+	_, shdw, _, iotClient, tokens, router := createRealRouter(t)
+	gomock.InOrder(
+		// Expect the auth middleware to check the device belongs to the account
+		tokens.EXPECT().Validate(testToken).Return(testClaims(accountID), nil),
+		iotClient.EXPECT().GetThing(deviceID).Return(&iot.Device{AccountId: accountID}, nil),
+		// Expect the shadow to be fetched
+		shdw.EXPECT().Get(deviceID).Return(&shadow.Shadow{Temperature: shadow.TemperatureShadow{
+			Readings: []shadow.ProbeReading{{Probe: "fridge", Celsius: 4}, {Probe: "freezer", Celsius: -12}},
+			Probes: map[string]shadow.ProbeShadow{
+				"freezer": {Alerting: true, Samples: []shadow.TemperatureSample{
+					{Time: createTime(t, "2020/03/22 01:20:00"), Celsius: -18},
+					{Time: createTime(t, "2020/03/22 01:30:00"), Celsius: -12},
+				}},
+			},
+			Thresholds: map[string]shadow.ProbeThresholds{
+				"freezer": {Max: celsius(-15)},
+				"garage":  {Min: celsius(0)},
+			},
+		}}, nil),
+	)
+	// Create a request for the probes
+	req := createRequest(t, http.MethodGet, fmt.Sprintf("/v1/devices/%s/probes", deviceID), nil)
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", testToken))
+	// Execute the handler
+	rr := runHandler(router, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	// Inspect the body of the response
+	var resp []models.DeviceProbe
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+	assert.Equal(t, []models.DeviceProbe{
+		{
+			Probe:      "freezer",
+			Celsius:    celsius(-12),
+			Thresholds: models.ProbeThresholds{Max: celsius(-15)},
+			Alerting:   true,
+			History: []models.DeviceTemperatureSample{
+				{Time: createTime(t, "2020/03/22 01:20:00"), Celsius: -18},
+				{Time: createTime(t, "2020/03/22 01:30:00"), Celsius: -12},
+			},
+		},
+		{Probe: "fridge", Celsius: celsius(4), History: []models.DeviceTemperatureSample{}},
+		// Thresholds can be set before the probe is reported
+		{Probe: "garage", Thresholds: models.ProbeThresholds{Min: celsius(0)}, History: []models.DeviceTemperatureSample{}},
+	}, resp)
+}
+
+func TestUpdateProbeThresholds(t *testing.T) {
+	const (
+		accountID = "35581BF4-32C8-4908-8377-2E6A021D3D2B"
+		deviceID  = "63eda5eb-7f56-417f-88ed-44a9eb9e5f67"
+	)
+	testParams := []struct {
+		body       string
+		thresholds *shadow.ProbeThresholds
+		status     int
+	}{
+		{body: `{"min":0,"max":5}`, thresholds: &shadow.ProbeThresholds{Min: celsius(0), Max: celsius(5)}, status: http.StatusOK},
+		// Thresholds that are left out are unbounded
+		{body: `{"max":-15}`, thresholds: &shadow.ProbeThresholds{Max: celsius(-15)}, status: http.StatusOK},
+		{body: `{}`, thresholds: &shadow.ProbeThresholds{}, status: http.StatusOK},
+		{body: `{"min":5,"max":0}`, status: http.StatusBadRequest},
+		{body: `not json`, status: http.StatusBadRequest},
+	}
+	for _, params := range testParams {
+		// Create a client
+		_, shdw, _, iotClient, tokens, router := createRealRouter(t)
+		gomock.InOrder(
+			// Expect the auth middleware to check the device belongs to the account
+			tokens.EXPECT().Validate(testToken).Return(testClaims(accountID), nil),
+			iotClient.EXPECT().GetThing(deviceID).Return(&iot.Device{AccountId: accountID}, nil),
+		)
+		// Expect the thresholds to be set
+		if params.thresholds != nil {
+			shdw.EXPECT().UpdateProbeThresholds(deviceID, "freezer", *params.thresholds).Return(&shadow.Shadow{
+				Temperature: shadow.TemperatureShadow{Thresholds: map[string]shadow.ProbeThresholds{"freezer": *params.thresholds}},
+			}, nil)
+		}
+		// Create a request to set the thresholds
+		req := createRequest(t, http.MethodPut, fmt.Sprintf("/v1/devices/%s/probes/freezer/thresholds", deviceID), []byte(params.body))
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", testToken))
+		// Execute the handler
+		rr := runHandler(router, req)
+		assert.Equal(t, params.status, rr.Code, params.body)
+		if params.status != http.StatusOK {
+			continue
+		}
+		// Check the thresholds are returned
+		var resp models.DeviceProbe
+		assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+		assert.Equal(t, "freezer", resp.Probe)
+		assert.Equal(t, models.ProbeThresholds{Min: params.thresholds.Min, Max: params.thresholds.Max}, resp.Thresholds)
+	}
+}
@@ -0,0 +1,130 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/briggysmalls/detectordag/api/app/models"
+	"github.com/briggysmalls/detectordag/shared/iot"
+	"github.com/briggysmalls/detectordag/shared/metrics"
+	"github.com/briggysmalls/detectordag/shared/shadow"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetMetricRules(t *testing.T) {
+	const (
+		accountID = "35581BF4-32C8-4908-8377-2E6A021D3D2B"
+		deviceID  = "63eda5eb-7f56-417f-88ed-44a9eb9e5f67"
+	)
+	// Create a client
+	_, shdw, _, iotClient, tokens, router := createRealRouter(t)
+	gomock.InOrder(
+		// Expect the auth middleware to check the device belongs to the account
+		tokens.EXPECT().Validate(testToken).Return(testClaims(accountID), nil),
+		iotClient.EXPECT().GetThing(deviceID).Return(&iot.Device{AccountId: accountID}, nil),
+		// Expect the shadow to be fetched
+		shdw.EXPECT().Get(deviceID).Return(&shadow.Shadow{Metrics: shadow.MetricsShadow{
+			Rules: []metrics.Rule{
+				{ID: "damp", Metric: "cellar", Condition: metrics.Above, Value: 80, For: 30 * time.Minute},
+				{ID: "brownout", Metric: "mains", Condition: metrics.Below, Value: 207},
+			},
+			States: map[string]metrics.RuleState{"damp": {Since: createTime(t, "2020/03/22 01:20:00"), Alerting: true}},
+		}}, nil),
+	)
+	// Create a request for the rules
+	req := createRequest(t, http.MethodGet, fmt.Sprintf("/v1/devices/%s/rules", deviceID), nil)
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", testToken))
+	// Execute the handler
+	rr := runHandler(router, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	// Inspect the body of the response
+	var resp []models.MetricRule
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+	assert.Equal(t, []models.MetricRule{
+		{ID: "damp", Metric: "cellar", Condition: "above", Value: 80, For: 1800, Alerting: true},
+		{ID: "brownout", Metric: "mains", Condition: "below", Value: 207},
+	}, resp)
+}
+
+func TestUpdateMetricRules(t *testing.T) {
+	const (
+		accountID = "35581BF4-32C8-4908-8377-2E6A021D3D2B"
+		deviceID  = "63eda5eb-7f56-417f-88ed-44a9eb9e5f67"
+	)
+	testParams := []struct {
+		body   string
+		rules  []metrics.Rule
+		status int
+	}{
+		{
+			body:   `[{"id":"damp","metric":"cellar","condition":"above","value":80,"for":1800}]`,
+			rules:  []metrics.Rule{{ID: "damp", Metric: "cellar", Condition: metrics.Above, Value: 80, For: 30 * time.Minute}},
+			status: http.StatusOK,
+		},
+		// All the rules can be removed
+		{body: `[]`, rules: []metrics.Rule{}, status: http.StatusOK},
+		{body: `[{"metric":"cellar","condition":"near","value":80}]`, status: http.StatusBadRequest},
+		{body: `[{"condition":"above","value":80}]`, status: http.StatusBadRequest},
+		{body: `[{"metric":"cellar","condition":"above","value":80,"for":-1}]`, status: http.StatusBadRequest},
+		{body: `[{"id":"damp","metric":"cellar","condition":"above"},{"id":"damp","metric":"mains","condition":"below"}]`, status: http.StatusBadRequest},
+		{body: `not json`, status: http.StatusBadRequest},
+	}
+	for _, params := range testParams {
+		// Create a client
+		_, shdw, _, iotClient, tokens, router := createRealRouter(t)
+		gomock.InOrder(
+			// Expect the auth middleware to check the device belongs to the account
+			tokens.EXPECT().Validate(testToken).Return(testClaims(accountID), nil),
+			iotClient.EXPECT().GetThing(deviceID).Return(&iot.Device{AccountId: accountID}, nil),
+		)
+		// Expect the rules to be replaced
+		if params.rules != nil {
+			shdw.EXPECT().UpdateMetricRules(deviceID, params.rules).Return(&shadow.Shadow{
+				Metrics: shadow.MetricsShadow{Rules: params.rules},
+			}, nil)
+		}
+		// Create a request to replace the rules
+		req := createRequest(t, http.MethodPut, fmt.Sprintf("/v1/devices/%s/rules", deviceID), []byte(params.body))
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", testToken))
+		// Execute the handler
+		rr := runHandler(router, req)
+		assert.Equal(t, params.status, rr.Code, params.body)
+		if params.status != http.StatusOK {
+			continue
+		}
+		// Check the rules are returned
+		var resp []models.MetricRule
+		assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+		assert.Len(t, resp, len(params.rules))
+	}
+}
+
+func TestUpdateMetricRulesCreatesIDs(t *testing.T) {
+	const (
+		accountID = "35581BF4-32C8-4908-8377-2E6A021D3D2B"
+		deviceID  = "63eda5eb-7f56-417f-88ed-44a9eb9e5f67"
+	)
+	// Create a client
+	_, shdw, _, iotClient, tokens, router := createRealRouter(t)
+	gomock.InOrder(
+		// Expect the auth middleware to check the device belongs to the account
+		tokens.EXPECT().Validate(testToken).Return(testClaims(accountID), nil),
+		iotClient.EXPECT().GetThing(deviceID).Return(&iot.Device{AccountId: accountID}, nil),
+	)
+	// Expect the rule to be given an ID
+	shdw.EXPECT().UpdateMetricRules(deviceID, gomock.Any()).DoAndReturn(func(id string, rules []metrics.Rule) (*shadow.Shadow, error) {
+		assert.Len(t, rules, 1)
+		assert.NotEmpty(t, rules[0].ID)
+		return &shadow.Shadow{Metrics: shadow.MetricsShadow{Rules: rules}}, nil
+	})
+	// Create a request to replace the rules
+	req := createRequest(t, http.MethodPut, fmt.Sprintf("/v1/devices/%s/rules", deviceID), []byte(`[{"metric":"mains","condition":"below","value":207}]`))
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", testToken))
+	// Execute the handler
+	rr := runHandler(router, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+}
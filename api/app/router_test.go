@@ -27,10 +27,50 @@ func TestValidRoutes(t *testing.T) {
 		route      string
 		expectFunc expectFunc
 	}{
+		{method: http.MethodGet, route: "/.well-known/jwks.json", expectFunc: func(s *MockServer, _ *MockIoTClient, _ *MockTokens) {
+			// Expect the handler to be called
+			s.EXPECT().GetKeys(gomock.Any(), gomock.Any()).Do(setStatusOk)
+		}},
 		{method: http.MethodPost, route: "/v1/auth", expectFunc: func(s *MockServer, _ *MockIoTClient, _ *MockTokens) {
 			// Expect the handler to be called
 			s.EXPECT().Auth(gomock.Any(), gomock.Any()).Do(setStatusOk)
 		}},
+		{method: http.MethodPost, route: "/v1/auth/refresh", expectFunc: func(s *MockServer, _ *MockIoTClient, _ *MockTokens) {
+			// Expect the handler to be called
+			s.EXPECT().Refresh(gomock.Any(), gomock.Any()).Do(setStatusOk)
+		}},
+		{method: http.MethodPost, route: "/v1/auth/logout", expectFunc: func(s *MockServer, _ *MockIoTClient, _ *MockTokens) {
+			// Expect the handler to be called
+			s.EXPECT().Logout(gomock.Any(), gomock.Any()).Do(setStatusOk)
+		}},
+		{method: http.MethodPost, route: "/v1/auth/mfa", expectFunc: func(s *MockServer, _ *MockIoTClient, _ *MockTokens) {
+			// Expect the handler to be called
+			s.EXPECT().CompleteMFA(gomock.Any(), gomock.Any()).Do(setStatusOk)
+		}},
+		{method: http.MethodPost, route: "/v1/accounts/f88948e6-5f93-4f11-8d58-15d48075069d/mfa", expectFunc: func(s *MockServer, _ *MockIoTClient, tokens *MockTokens) {
+			// Expect the auth middleware to validate the token
+			expectAuth(tokens, "f88948e6-5f93-4f11-8d58-15d48075069d")
+			// Expect the handler to be called
+			s.EXPECT().EnrolMFA(gomock.Any(), gomock.Any()).Do(setStatusOk)
+		}},
+		{method: http.MethodPost, route: "/v1/accounts/f88948e6-5f93-4f11-8d58-15d48075069d/mfa/confirm", expectFunc: func(s *MockServer, _ *MockIoTClient, tokens *MockTokens) {
+			// Expect the auth middleware to validate the token
+			expectAuth(tokens, "f88948e6-5f93-4f11-8d58-15d48075069d")
+			// Expect the handler to be called
+			s.EXPECT().ConfirmMFA(gomock.Any(), gomock.Any()).Do(setStatusOk)
+		}},
+		{method: http.MethodPost, route: "/v1/accounts/f88948e6-5f93-4f11-8d58-15d48075069d/mfa/disable", expectFunc: func(s *MockServer, _ *MockIoTClient, tokens *MockTokens) {
+			// Expect the auth middleware to validate the token
+			expectAuth(tokens, "f88948e6-5f93-4f11-8d58-15d48075069d")
+			// Expect the handler to be called
+			s.EXPECT().DisableMFA(gomock.Any(), gomock.Any()).Do(setStatusOk)
+		}},
+		{method: http.MethodPost, route: "/v1/accounts/f88948e6-5f93-4f11-8d58-15d48075069d/mfa/recovery-codes", expectFunc: func(s *MockServer, _ *MockIoTClient, tokens *MockTokens) {
+			// Expect the auth middleware to validate the token
+			expectAuth(tokens, "f88948e6-5f93-4f11-8d58-15d48075069d")
+			// Expect the handler to be called
+			s.EXPECT().CreateRecoveryCodes(gomock.Any(), gomock.Any()).Do(setStatusOk)
+		}},
 		{method: http.MethodGet, route: "/v1/accounts/33b782d3-a2c8-40be-8aef-db5b44119bd5", expectFunc: func(s *MockServer, _ *MockIoTClient, tokens *MockTokens) {
 			// Expect the auth middleware to validate the token
 			expectAuth(tokens, "33b782d3-a2c8-40be-8aef-db5b44119bd5")
@@ -43,6 +83,12 @@ func TestValidRoutes(t *testing.T) {
 			// Expect the handler to be called
 			s.EXPECT().UpdateAccount(gomock.Any(), gomock.Any()).Do(setStatusOk)
 		}},
+		{method: http.MethodGet, route: "/v1/accounts/f88948e6-5f93-4f11-8d58-15d48075069d/logins", expectFunc: func(s *MockServer, _ *MockIoTClient, tokens *MockTokens) {
+			// Expect the auth middleware to validate the token
+			expectAuth(tokens, "f88948e6-5f93-4f11-8d58-15d48075069d")
+			// Expect the handler to be called
+			s.EXPECT().GetLogins(gomock.Any(), gomock.Any()).Do(setStatusOk)
+		}},
 		{method: http.MethodGet, route: "/v1/accounts/f88948e6-5f93-4f11-8d58-15d48075069d/devices", expectFunc: func(s *MockServer, _ *MockIoTClient, tokens *MockTokens) {
 			// Expect the auth middleware to validate the token
 			expectAuth(tokens, "f88948e6-5f93-4f11-8d58-15d48075069d")
@@ -58,6 +104,123 @@ func TestValidRoutes(t *testing.T) {
 			// Expect the handler to be called
 			s.EXPECT().UpdateDevice(gomock.Any(), gomock.Any()).Do(setStatusOk)
 		}},
+		{method: http.MethodGet, route: "/v1/devices/c0e94a1b-a835-4cc2-9574-642bea13805a/config", expectFunc: func(s *MockServer, i *MockIoTClient, tokens *MockTokens) {
+			// Expect the auth middleware to get the device from database
+			accountID := "f88948e6-5f93-4f11-8d58-15d48075069d"
+			i.EXPECT().GetThing(gomock.Eq("c0e94a1b-a835-4cc2-9574-642bea13805a")).Return(&iot.Device{AccountId: accountID}, nil)
+			// Expect the auth middleware to validate the token
+			expectAuth(tokens, accountID)
+			// Expect the handler to be called
+			s.EXPECT().GetDeviceConfig(gomock.Any(), gomock.Any()).Do(setStatusOk)
+		}},
+		{method: http.MethodPatch, route: "/v1/devices/c0e94a1b-a835-4cc2-9574-642bea13805a/config", expectFunc: func(s *MockServer, i *MockIoTClient, tokens *MockTokens) {
+			// Expect the auth middleware to get the device from database
+			accountID := "f88948e6-5f93-4f11-8d58-15d48075069d"
+			i.EXPECT().GetThing(gomock.Eq("c0e94a1b-a835-4cc2-9574-642bea13805a")).Return(&iot.Device{AccountId: accountID}, nil)
+			// Expect the auth middleware to validate the token
+			expectAuth(tokens, accountID)
+			// Expect the handler to be called
+			s.EXPECT().UpdateDeviceConfig(gomock.Any(), gomock.Any()).Do(setStatusOk)
+		}},
+		{method: http.MethodGet, route: "/v1/devices/c0e94a1b-a835-4cc2-9574-642bea13805a/diagnostics", expectFunc: func(s *MockServer, i *MockIoTClient, tokens *MockTokens) {
+			// Expect the auth middleware to get the device from database
+			accountID := "f88948e6-5f93-4f11-8d58-15d48075069d"
+			i.EXPECT().GetThing(gomock.Eq("c0e94a1b-a835-4cc2-9574-642bea13805a")).Return(&iot.Device{AccountId: accountID}, nil)
+			// Expect the auth middleware to validate the token
+			expectAuth(tokens, accountID)
+			// Expect the handler to be called
+			s.EXPECT().GetDeviceDiagnostics(gomock.Any(), gomock.Any()).Do(setStatusOk)
+		}},
+		{method: http.MethodGet, route: "/v1/devices/c0e94a1b-a835-4cc2-9574-642bea13805a/probes", expectFunc: func(s *MockServer, i *MockIoTClient, tokens *MockTokens) {
+			// Expect the auth middleware to get the device from database
+			accountID := "f88948e6-5f93-4f11-8d58-15d48075069d"
+			i.EXPECT().GetThing(gomock.Eq("c0e94a1b-a835-4cc2-9574-642bea13805a")).Return(&iot.Device{AccountId: accountID}, nil)
+			// Expect the auth middleware to validate the token
+			expectAuth(tokens, accountID)
+			// Expect the handler to be called
+			s.EXPECT().GetDeviceProbes(gomock.Any(), gomock.Any()).Do(setStatusOk)
+		}},
+		{method: http.MethodPut, route: "/v1/devices/c0e94a1b-a835-4cc2-9574-642bea13805a/probes/freezer/thresholds", expectFunc: func(s *MockServer, i *MockIoTClient, tokens *MockTokens) {
+			// Expect the auth middleware to get the device from database
+			accountID := "f88948e6-5f93-4f11-8d58-15d48075069d"
+			i.EXPECT().GetThing(gomock.Eq("c0e94a1b-a835-4cc2-9574-642bea13805a")).Return(&iot.Device{AccountId: accountID}, nil)
+			// Expect the auth middleware to validate the token
+			expectAuth(tokens, accountID)
+			// Expect the handler to be called
+			s.EXPECT().UpdateProbeThresholds(gomock.Any(), gomock.Any()).Do(setStatusOk)
+		}},
+		{method: http.MethodGet, route: "/v1/devices/c0e94a1b-a835-4cc2-9574-642bea13805a/rules", expectFunc: func(s *MockServer, i *MockIoTClient, tokens *MockTokens) {
+			// Expect the auth middleware to get the device from database
+			accountID := "f88948e6-5f93-4f11-8d58-15d48075069d"
+			i.EXPECT().GetThing(gomock.Eq("c0e94a1b-a835-4cc2-9574-642bea13805a")).Return(&iot.Device{AccountId: accountID}, nil)
+			// Expect the auth middleware to validate the token
+			expectAuth(tokens, accountID)
+			// Expect the handler to be called
+			s.EXPECT().GetMetricRules(gomock.Any(), gomock.Any()).Do(setStatusOk)
+		}},
+		{method: http.MethodPut, route: "/v1/devices/c0e94a1b-a835-4cc2-9574-642bea13805a/rules", expectFunc: func(s *MockServer, i *MockIoTClient, tokens *MockTokens) {
+			// Expect the auth middleware to get the device from database
+			accountID := "f88948e6-5f93-4f11-8d58-15d48075069d"
+			i.EXPECT().GetThing(gomock.Eq("c0e94a1b-a835-4cc2-9574-642bea13805a")).Return(&iot.Device{AccountId: accountID}, nil)
+			// Expect the auth middleware to validate the token
+			expectAuth(tokens, accountID)
+			// Expect the handler to be called
+			s.EXPECT().UpdateMetricRules(gomock.Any(), gomock.Any()).Do(setStatusOk)
+		}},
+		{method: http.MethodPut, route: "/v1/devices/c0e94a1b-a835-4cc2-9574-642bea13805a/appliance", expectFunc: func(s *MockServer, i *MockIoTClient, tokens *MockTokens) {
+			// Expect the auth middleware to get the device from database
+			accountID := "f88948e6-5f93-4f11-8d58-15d48075069d"
+			i.EXPECT().GetThing(gomock.Eq("c0e94a1b-a835-4cc2-9574-642bea13805a")).Return(&iot.Device{AccountId: accountID}, nil)
+			// Expect the auth middleware to validate the token
+			expectAuth(tokens, accountID)
+			// Expect the handler to be called
+			s.EXPECT().UpdateDeviceAppliance(gomock.Any(), gomock.Any()).Do(setStatusOk)
+		}},
+		{method: http.MethodGet, route: "/v1/devices/c0e94a1b-a835-4cc2-9574-642bea13805a", expectFunc: func(s *MockServer, i *MockIoTClient, tokens *MockTokens) {
+			// Expect the auth middleware to get the device from database
+			accountID := "f88948e6-5f93-4f11-8d58-15d48075069d"
+			i.EXPECT().GetThing(gomock.Eq("c0e94a1b-a835-4cc2-9574-642bea13805a")).Return(&iot.Device{AccountId: accountID}, nil)
+			// Expect the auth middleware to validate the token
+			expectAuth(tokens, accountID)
+			// Expect the handler to be called
+			s.EXPECT().GetDevice(gomock.Any(), gomock.Any()).Do(setStatusOk)
+		}},
+		{method: http.MethodPost, route: "/v1/devices/c0e94a1b-a835-4cc2-9574-642bea13805a/ping", expectFunc: func(s *MockServer, i *MockIoTClient, tokens *MockTokens) {
+			// Expect the auth middleware to get the device from database
+			accountID := "f88948e6-5f93-4f11-8d58-15d48075069d"
+			i.EXPECT().GetThing(gomock.Eq("c0e94a1b-a835-4cc2-9574-642bea13805a")).Return(&iot.Device{AccountId: accountID}, nil)
+			// Expect the auth middleware to validate the token
+			expectAuth(tokens, accountID)
+			// Expect the handler to be called
+			s.EXPECT().PingDevice(gomock.Any(), gomock.Any()).Do(setStatusOk)
+		}},
+		{method: http.MethodGet, route: "/v1/devices/c0e94a1b-a835-4cc2-9574-642bea13805a/maintenance", expectFunc: func(s *MockServer, i *MockIoTClient, tokens *MockTokens) {
+			// Expect the auth middleware to get the device from database
+			accountID := "f88948e6-5f93-4f11-8d58-15d48075069d"
+			i.EXPECT().GetThing(gomock.Eq("c0e94a1b-a835-4cc2-9574-642bea13805a")).Return(&iot.Device{AccountId: accountID}, nil)
+			// Expect the auth middleware to validate the token
+			expectAuth(tokens, accountID)
+			// Expect the handler to be called
+			s.EXPECT().GetMaintenanceWindows(gomock.Any(), gomock.Any()).Do(setStatusOk)
+		}},
+		{method: http.MethodPost, route: "/v1/devices/c0e94a1b-a835-4cc2-9574-642bea13805a/maintenance", expectFunc: func(s *MockServer, i *MockIoTClient, tokens *MockTokens) {
+			// Expect the auth middleware to get the device from database
+			accountID := "f88948e6-5f93-4f11-8d58-15d48075069d"
+			i.EXPECT().GetThing(gomock.Eq("c0e94a1b-a835-4cc2-9574-642bea13805a")).Return(&iot.Device{AccountId: accountID}, nil)
+			// Expect the auth middleware to validate the token
+			expectAuth(tokens, accountID)
+			// Expect the handler to be called
+			s.EXPECT().CreateMaintenanceWindow(gomock.Any(), gomock.Any()).Do(setStatusOk)
+		}},
+		{method: http.MethodDelete, route: "/v1/devices/c0e94a1b-a835-4cc2-9574-642bea13805a/maintenance/5e3f1c2a-8b1d-4c55-9d8e-3f0b4a2c6d71", expectFunc: func(s *MockServer, i *MockIoTClient, tokens *MockTokens) {
+			// Expect the auth middleware to get the device from database
+			accountID := "f88948e6-5f93-4f11-8d58-15d48075069d"
+			i.EXPECT().GetThing(gomock.Eq("c0e94a1b-a835-4cc2-9574-642bea13805a")).Return(&iot.Device{AccountId: accountID}, nil)
+			// Expect the auth middleware to validate the token
+			expectAuth(tokens, accountID)
+			// Expect the handler to be called
+			s.EXPECT().DeleteMaintenanceWindow(gomock.Any(), gomock.Any()).Do(setStatusOk)
+		}},
 	}
 	// Run the test iterations
 	for _, params := range tps {
@@ -87,6 +250,7 @@ func TestOptionsRoutes(t *testing.T) {
 		{route: "/v1/accounts/33b782d3-a2c8-40be-8aef-db5b44119bd5"},
 		{route: "/v1/accounts/f88948e6-5f93-4f11-8d58-15d48075069d/devices"},
 		{route: "/v1/devices/c0e94a1b-a835-4cc2-9574-642bea13805a"},
+		{route: "/v1/devices/c0e94a1b-a835-4cc2-9574-642bea13805a/config"},
 	}
 	// Run the test iterations
 	for _, params := range tps {
@@ -102,7 +266,8 @@ func TestOptionsRoutes(t *testing.T) {
 		allowedHeaders := strings.Split(getHeaderValue(t, w.Header, "Access-Control-Allow-Headers"), ",")
 		assert.Contains(t, allowedHeaders, "Content-Type")
 		assert.Contains(t, allowedHeaders, "Authorization")
-		assert.Len(t, allowedHeaders, 2)
+		assert.Contains(t, allowedHeaders, "If-None-Match")
+		assert.Len(t, allowedHeaders, 3)
 		// Ensure we have the expected methods
 		allowedMethods := strings.Split(getHeaderValue(t, w.Header, "Access-Control-Allow-Headers"), ",")
 		for _, method := range params.methods {
@@ -133,7 +298,7 @@ func setStatusOk(w http.ResponseWriter, r *http.Request) {
 
 func expectAuth(tokens *MockTokens, accountID string) {
 	// Expect the auth middleware to validate the token
-	tokens.EXPECT().Validate(gomock.Eq(testToken)).Return(accountID, nil)
+	tokens.EXPECT().Validate(gomock.Eq(testToken)).Return(testClaims(accountID), nil)
 }
 
 func createStubbedRouter(t *testing.T) (*MockIoTClient, *MockTokens, *MockServer, *mux.Router) {
@@ -145,8 +310,11 @@ func createStubbedRouter(t *testing.T) (*MockIoTClient, *MockTokens, *MockServer
 	s := NewMockServer(ctrl)
 	// Create mock tokens
 	tokens := NewMockTokens(ctrl)
+	// Create mock database, which never has the test token revoked
+	db := NewMockDBClient(ctrl)
+	db.EXPECT().IsTokenRevoked(testTokenID).Return(false, nil).AnyTimes()
 	// Create the new router
-	return i, tokens, s, NewRouter(i, s, tokens)
+	return i, tokens, s, NewRouter(i, db, s, tokens)
 }
 
 func getHeaderValue(t *testing.T, header http.Header, key string) string {
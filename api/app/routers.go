@@ -23,12 +23,15 @@ import (
 
 	"github.com/briggysmalls/detectordag/api/app/server"
 	"github.com/briggysmalls/detectordag/api/app/tokens"
+	"github.com/briggysmalls/detectordag/shared/database"
 	"github.com/briggysmalls/detectordag/shared/iot"
 	"github.com/gorilla/mux"
 )
 
 const (
-	uuidRegex = `[0-9a-fA-F]{8}\-[0-9a-fA-F]{4}\-[0-9a-fA-F]{4}\-[0-9a-fA-F]{4}\-[0-9a-fA-F]{12}`
+	// Probe names are kept in the shadow, so are limited to simple keys
+	probeRegex = `[a-zA-Z0-9_\-]{1,32}`
+	uuidRegex  = `[0-9a-fA-F]{8}\-[0-9a-fA-F]{4}\-[0-9a-fA-F]{4}\-[0-9a-fA-F]{4}\-[0-9a-fA-F]{12}`
 )
 
 type Route struct {
@@ -40,9 +43,20 @@ type Route struct {
 
 type Routes []Route
 
-func NewRouter(iot iot.Client, server server.Server, tokens tokens.Tokens) *mux.Router {
+func NewRouter(iot iot.Client, db database.Client, server server.Server, tokens tokens.Tokens) *mux.Router {
 	// Create the router
 	router := mux.NewRouter().StrictSlash(true)
+	// Create subrouter for well-known locations, which sit outside of the API version
+	wellKnown := router.PathPrefix("/.well-known").Subrouter()
+	addRoutes(wellKnown, Routes{
+		// Keys that tokens can be verified with, for third parties (see RFC 7517)
+		Route{
+			"GetKeys",
+			http.MethodGet,
+			"/jwks.json",
+			server.GetKeys,
+		},
+	})
 	// Create subrouter for 'v1'
 	api := router.PathPrefix("/v1").Subrouter()
 	// Add the non-auth routes
@@ -51,15 +65,67 @@ func NewRouter(iot iot.Client, server server.Server, tokens tokens.Tokens) *mux.
 		//
 		// Obtain token for the site
 		//
+		// Repeated failures slow down further attempts, and then lock the account for a while
+		//
+		// If the account has enabled two-factor authentication, a challenge is given instead of tokens
+		//
 		//     Responses:
 		//       200: tokenResponse
+		//       202: mfaChallengeResponse
 		//       403: authFailedResponse
+		//       429: tooManyAttemptsResponse
 		Route{
 			"Auth",
 			http.MethodPost,
 			"/auth",
 			server.Auth,
 		},
+		// swagger:route POST /auth/mfa authentication completeMFA
+		//
+		// Complete two-factor authentication
+		//
+		// Obtain tokens by giving a code for the challenge from authentication.
+		// Each challenge can only be attempted once
+		//
+		//     Responses:
+		//       200: tokenResponse
+		//       403: authFailedResponse
+		Route{
+			"CompleteMFA",
+			http.MethodPost,
+			"/auth/mfa",
+			server.CompleteMFA,
+		},
+		// swagger:route POST /auth/refresh authentication refresh
+		//
+		// Exchange a refresh token for new tokens
+		//
+		// Each refresh token can only be used once, and is replaced by the new one
+		//
+		//     Responses:
+		//       200: tokenResponse
+		//       403: authFailedResponse
+		Route{
+			"Refresh",
+			http.MethodPost,
+			"/auth/refresh",
+			server.Refresh,
+		},
+		// swagger:route POST /auth/logout authentication logout
+		//
+		// Log out
+		//
+		// Revoke the access token, and forget the refresh token
+		//
+		//     Responses:
+		//       204: loggedOutResponse
+		//       403: authFailedResponse
+		Route{
+			"Logout",
+			http.MethodPost,
+			"/auth/logout",
+			server.Logout,
+		},
 	}
 	addRoutes(api, nonAuthRoutes)
 
@@ -85,8 +151,12 @@ func NewRouter(iot iot.Client, server server.Server, tokens tokens.Tokens) *mux.
 		//
 		// Get all devices associated with the user's account
 		//
+		// Devices that can't be fetched are listed with an error, rather than failing the whole request
+		// Send the ETag of a previous response in If-None-Match to get 304 if none have changed
+		//
 		//     Responses:
 		//       200: getDevicesResponse
+		//       304: notModifiedResponse
 		//       400: accountNotFoundResponse
 		//       401: unauthenticatedResponse
 		//       403: unauthorizedResponse
@@ -113,11 +183,124 @@ func NewRouter(iot iot.Client, server server.Server, tokens tokens.Tokens) *mux.
 			fmt.Sprintf("/{accountId:%s}", uuidRegex),
 			server.UpdateAccount,
 		},
+		// swagger:route GET /accounts/{accountId}/logins accounts getLogins
+		//
+		// Get account login history
+		//
+		// Get the most recent attempts to log in to the account, newest first
+		//
+		//     Responses:
+		//       200: getLoginsResponse
+		//       400: accountNotFoundResponse
+		//       401: unauthenticatedResponse
+		//       403: unauthorizedResponse
+		Route{
+			"GetLogins",
+			http.MethodGet,
+			fmt.Sprintf("/{accountId:%s}/logins", uuidRegex),
+			server.GetLogins,
+		},
+		// swagger:route POST /accounts/{accountId}/mfa accounts enrolMFA
+		//
+		// Start two-factor authentication enrolment
+		//
+		// Create a secret for an authenticator app, and recovery codes for if it is lost.
+		// Codes aren't needed to log in until enrolment is confirmed.
+		// The account's password must be given, and is counted like a login attempt
+		//
+		//     Responses:
+		//       200: mfaEnrolmentResponse
+		//       400: accountNotFoundResponse
+		//       401: unauthenticatedResponse
+		//       403: unauthorizedResponse
+		//       409: mfaConflictResponse
+		//       429: tooManyAttemptsResponse
+		Route{
+			"EnrolMFA",
+			http.MethodPost,
+			fmt.Sprintf("/{accountId:%s}/mfa", uuidRegex),
+			server.EnrolMFA,
+		},
+		// swagger:route POST /accounts/{accountId}/mfa/confirm accounts confirmMFA
+		//
+		// Confirm two-factor authentication enrolment
+		//
+		// Give a code from the authenticator app, to show it has been set up. Codes are needed to log in from then on
+		//
+		//     Responses:
+		//       204: mfaEnabledResponse
+		//       400: accountNotFoundResponse
+		//       401: unauthenticatedResponse
+		//       403: unauthorizedResponse
+		//       409: mfaConflictResponse
+		Route{
+			"ConfirmMFA",
+			http.MethodPost,
+			fmt.Sprintf("/{accountId:%s}/mfa/confirm", uuidRegex),
+			server.ConfirmMFA,
+		},
+		// swagger:route POST /accounts/{accountId}/mfa/disable accounts disableMFA
+		//
+		// Disable two-factor authentication
+		//
+		// Give a code from the authenticator app (or a recovery code) to stop needing codes to log in
+		//
+		//     Responses:
+		//       204: mfaDisabledResponse
+		//       400: accountNotFoundResponse
+		//       401: unauthenticatedResponse
+		//       403: unauthorizedResponse
+		//       409: mfaConflictResponse
+		//       429: tooManyAttemptsResponse
+		Route{
+			"DisableMFA",
+			http.MethodPost,
+			fmt.Sprintf("/{accountId:%s}/mfa/disable", uuidRegex),
+			server.DisableMFA,
+		},
+		// swagger:route POST /accounts/{accountId}/mfa/recovery-codes accounts createRecoveryCodes
+		//
+		// Create new recovery codes
+		//
+		// Give a code from the authenticator app (or a recovery code) to replace the recovery codes with new ones
+		//
+		//     Responses:
+		//       200: mfaRecoveryCodesResponse
+		//       400: accountNotFoundResponse
+		//       401: unauthenticatedResponse
+		//       403: unauthorizedResponse
+		//       409: mfaConflictResponse
+		//       429: tooManyAttemptsResponse
+		Route{
+			"CreateRecoveryCodes",
+			http.MethodPost,
+			fmt.Sprintf("/{accountId:%s}/mfa/recovery-codes", uuidRegex),
+			server.CreateRecoveryCodes,
+		},
 	})
 
 	// Create subrouter for devices
 	devices := api.PathPrefix("/devices").Subrouter()
 	addRoutes(devices, Routes{
+		// swagger:route GET /devices/{deviceId} devices getDevice
+		//
+		// Get a device
+		//
+		// Get the device's current state
+		// Send the ETag of a previous response in If-None-Match to get 304 if it hasn't changed
+		//
+		//     Responses:
+		//       200: getDeviceResponse
+		//       304: notModifiedResponse
+		//       400: deviceNotFoundResponse
+		//       401: unauthenticatedResponse
+		//       403: unauthorizedResponse
+		Route{
+			"GetDevice",
+			http.MethodGet,
+			fmt.Sprintf("/{deviceId:%s}", uuidRegex),
+			server.GetDevice,
+		},
 		// swagger:route PATCH /devices/{deviceId} devices updateDevice
 		//
 		// Update a device
@@ -135,16 +318,224 @@ func NewRouter(iot iot.Client, server server.Server, tokens tokens.Tokens) *mux.
 			fmt.Sprintf("/{deviceId:%s}", uuidRegex),
 			server.UpdateDevice,
 		},
+		// swagger:route POST /devices/{deviceId}/ping devices pingDevice
+		//
+		// Ping a device
+		//
+		// Ask the device to report its status, and wait for it to respond
+		//
+		//     Responses:
+		//       200: getDeviceResponse
+		//       400: deviceNotFoundResponse
+		//       401: unauthenticatedResponse
+		//       403: unauthorizedResponse
+		//       504: deviceUnresponsiveResponse
+		Route{
+			"PingDevice",
+			http.MethodPost,
+			fmt.Sprintf("/{deviceId:%s}/ping", uuidRegex),
+			server.PingDevice,
+		},
+		// swagger:route GET /devices/{deviceId}/config devices getDeviceConfig
+		//
+		// Get device configuration
+		//
+		// Get the configuration the device has been asked to apply, and whether it has applied it
+		//
+		//     Responses:
+		//       200: getDeviceConfigResponse
+		//       400: deviceNotFoundResponse
+		//       401: unauthenticatedResponse
+		//       403: unauthorizedResponse
+		Route{
+			"GetDeviceConfig",
+			http.MethodGet,
+			fmt.Sprintf("/{deviceId:%s}/config", uuidRegex),
+			server.GetDeviceConfig,
+		},
+		// swagger:route PATCH /devices/{deviceId}/config devices updateDeviceConfig
+		//
+		// Update device configuration
+		//
+		// Ask the device to apply new configuration
+		//
+		//     Responses:
+		//       200: getDeviceConfigResponse
+		//       400: deviceNotFoundResponse
+		//       401: unauthenticatedResponse
+		//       403: unauthorizedResponse
+		Route{
+			"UpdateDeviceConfig",
+			http.MethodPatch,
+			fmt.Sprintf("/{deviceId:%s}/config", uuidRegex),
+			server.UpdateDeviceConfig,
+		},
+		// swagger:route GET /devices/{deviceId}/diagnostics devices getDeviceDiagnostics
+		//
+		// Get device diagnostics
+		//
+		// Get the device's cellular signal quality and data usage
+		//
+		//     Responses:
+		//       200: getDeviceDiagnosticsResponse
+		//       400: deviceNotFoundResponse
+		//       401: unauthenticatedResponse
+		//       403: unauthorizedResponse
+		Route{
+			"GetDeviceDiagnostics",
+			http.MethodGet,
+			fmt.Sprintf("/{deviceId:%s}/diagnostics", uuidRegex),
+			server.GetDeviceDiagnostics,
+		},
+		// swagger:route GET /devices/{deviceId}/probes devices getDeviceProbes
+		//
+		// Get device temperature probes
+		//
+		// Get the readings and thresholds of the device's temperature probes
+		//
+		//     Responses:
+		//       200: getDeviceProbesResponse
+		//       400: deviceNotFoundResponse
+		//       401: unauthenticatedResponse
+		//       403: unauthorizedResponse
+		Route{
+			"GetDeviceProbes",
+			http.MethodGet,
+			fmt.Sprintf("/{deviceId:%s}/probes", uuidRegex),
+			server.GetDeviceProbes,
+		},
+		// swagger:route PUT /devices/{deviceId}/probes/{probe}/thresholds devices updateProbeThresholds
+		//
+		// Set temperature probe thresholds
+		//
+		// Set the temperatures a probe should stay between, outside of which the account is warned
+		//
+		//     Responses:
+		//       200: getDeviceProbeResponse
+		//       400: deviceNotFoundResponse
+		//       401: unauthenticatedResponse
+		//       403: unauthorizedResponse
+		Route{
+			"UpdateProbeThresholds",
+			http.MethodPut,
+			fmt.Sprintf("/{deviceId:%s}/probes/{probe:%s}/thresholds", uuidRegex, probeRegex),
+			server.UpdateProbeThresholds,
+		},
+		// swagger:route GET /devices/{deviceId}/rules devices getMetricRules
+		//
+		// Get device metric rules
+		//
+		// Get the rules the device's metrics are checked against, and whether they are broken
+		//
+		//     Responses:
+		//       200: getMetricRulesResponse
+		//       400: deviceNotFoundResponse
+		//       401: unauthenticatedResponse
+		//       403: unauthorizedResponse
+		Route{
+			"GetMetricRules",
+			http.MethodGet,
+			fmt.Sprintf("/{deviceId:%s}/rules", uuidRegex),
+			server.GetMetricRules,
+		},
+		// swagger:route PUT /devices/{deviceId}/rules devices updateMetricRules
+		//
+		// Set device metric rules
+		//
+		// Replace the rules the device's metrics are checked against
+		//
+		//     Responses:
+		//       200: getMetricRulesResponse
+		//       400: deviceNotFoundResponse
+		//       401: unauthenticatedResponse
+		//       403: unauthorizedResponse
+		Route{
+			"UpdateMetricRules",
+			http.MethodPut,
+			fmt.Sprintf("/{deviceId:%s}/rules", uuidRegex),
+			server.UpdateMetricRules,
+		},
+		// swagger:route PUT /devices/{deviceId}/appliance devices updateDeviceAppliance
+		//
+		// Set device appliance
+		//
+		// Set the appliance the device is watching over, so the account is advised when a power cut would spoil its food
+		//
+		//     Responses:
+		//       200: getDeviceApplianceResponse
+		//       400: deviceNotFoundResponse
+		//       401: unauthenticatedResponse
+		//       403: unauthorizedResponse
+		Route{
+			"UpdateDeviceAppliance",
+			http.MethodPut,
+			fmt.Sprintf("/{deviceId:%s}/appliance", uuidRegex),
+			server.UpdateDeviceAppliance,
+		},
+		// swagger:route GET /devices/{deviceId}/maintenance devices getMaintenanceWindows
+		//
+		// Get device maintenance windows
+		//
+		// Get the windows during which notifications for the device are held back, including those for the whole account
+		//
+		//     Responses:
+		//       200: getMaintenanceWindowsResponse
+		//       400: deviceNotFoundResponse
+		//       401: unauthenticatedResponse
+		//       403: unauthorizedResponse
+		Route{
+			"GetMaintenanceWindows",
+			http.MethodGet,
+			fmt.Sprintf("/{deviceId:%s}/maintenance", uuidRegex),
+			server.GetMaintenanceWindows,
+		},
+		// swagger:route POST /devices/{deviceId}/maintenance devices createMaintenanceWindow
+		//
+		// Create maintenance window
+		//
+		// Hold back notifications for the device (or all the account's devices) during a one-off or recurring window.
+		// If the power is still off when the window ends, the account is notified then
+		//
+		//     Responses:
+		//       200: getMaintenanceWindowsResponse
+		//       400: deviceNotFoundResponse
+		//       401: unauthenticatedResponse
+		//       403: unauthorizedResponse
+		Route{
+			"CreateMaintenanceWindow",
+			http.MethodPost,
+			fmt.Sprintf("/{deviceId:%s}/maintenance", uuidRegex),
+			server.CreateMaintenanceWindow,
+		},
+		// swagger:route DELETE /devices/{deviceId}/maintenance/{windowId} devices deleteMaintenanceWindow
+		//
+		// Delete maintenance window
+		//
+		// Stop holding back notifications during a window
+		//
+		//     Responses:
+		//       200: getMaintenanceWindowsResponse
+		//       400: deviceNotFoundResponse
+		//       401: unauthenticatedResponse
+		//       403: unauthorizedResponse
+		Route{
+			"DeleteMaintenanceWindow",
+			http.MethodDelete,
+			fmt.Sprintf("/{deviceId:%s}/maintenance/{windowId:%s}", uuidRegex, uuidRegex),
+			server.DeleteMaintenanceWindow,
+		},
 	})
 
 	// Add CORS header on all responses
 	api.Use(mux.CORSMethodMiddleware(api))
 	api.Use(corsMiddleware)
+	wellKnown.Use(corsMiddleware)
 
 	// Add authentication middleware
 	a := auth{
 		tokens: tokens,
 		iot:    iot,
+		db:     db,
 	}
 	accounts.Use(a.middleware)
 	devices.Use(a.middleware)
@@ -1,11 +1,31 @@
 package server
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
 	"net/http"
+	"time"
 
 	"github.com/briggysmalls/detectordag/api/app/models"
+	"github.com/briggysmalls/detectordag/shared"
 	"github.com/briggysmalls/detectordag/shared/database"
+	"github.com/briggysmalls/detectordag/shared/shadow"
+)
+
+const (
+	// maxShadowFetches is how many device shadows are requested at once
+	maxShadowFetches = 8
+	// shadowFetchTimeout is how long is spent requesting shadows, before giving up on those that are left
+	shadowFetchTimeout = 5 * time.Second
+)
+
+var (
+	ErrShadowFetchTimeout = errors.New("Timed out fetching device")
+	ErrShadowFetchFailed  = errors.New("Failed to fetch device")
+	ErrUnknownTimeZone    = errors.New("Unknown time zone")
 )
 
 func (s *server) GetAccount(w http.ResponseWriter, r *http.Request) {
@@ -45,28 +65,39 @@ func (s *server) GetDevices(w http.ResponseWriter, r *http.Request) {
 		SetError(w, err, http.StatusInternalServerError)
 		return
 	}
-	// Request each device's shadow
-	payload := make([]models.Device, len(devices))
+	// Request the devices' shadows
+	ids := make([]string, len(devices))
 	for i, device := range devices {
-		// Request the shadow
-		shdw, err := s.shadow.Get(device.DeviceId)
-		if err != nil {
-			SetError(w, err, http.StatusInternalServerError)
-			return
+		ids[i] = device.DeviceId
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), shadowFetchTimeout)
+	defer cancel()
+	shadows, errs := s.fetchShadows(ctx, ids)
+	// Build the payload, reporting any devices that couldn't be fetched
+	payload := make([]models.Device, len(devices))
+	versions := make([]int, len(devices))
+	failed := false
+	for i, id := range ids {
+		if errs[i] == nil {
+			payload[i], errs[i] = newDevice(id, shadows[i])
 		}
-		// Build the payload
-		payload[i] = models.Device{
-			Name:     shdw.Name,
-			DeviceId: device.DeviceId,
-			State: &models.DeviceState{
-				Power:   shdw.Power.Value,
-				Updated: shdw.Power.Updated,
-			},
-			Connection: &models.DeviceConnection{
-				Status:  shdw.Connection.Status,
-				Updated: shdw.Connection.Updated,
-			},
+		if errs[i] != nil {
+			log.Printf("Failed to fetch device '%s': %v", id, errs[i])
+			// Only say why if it was us giving up, rather than leaking the details of what went wrong
+			reason := ErrShadowFetchFailed
+			if errors.Is(errs[i], ErrShadowFetchTimeout) {
+				reason = ErrShadowFetchTimeout
+			}
+			payload[i] = models.Device{DeviceId: id, Error: reason.Error()}
+			failed = true
+			continue
 		}
+		versions[i] = shadows[i].Version
+	}
+	// Skip the response if the client already has it
+	// Partial results are never tagged, so that they are always fetched again
+	if !failed && notModified(w, r, devicesETag(ids, versions, payload)) {
+		return
 	}
 	// Prepare the JSON response
 	body, err := json.Marshal(payload)
@@ -78,6 +109,55 @@ func (s *server) GetDevices(w http.ResponseWriter, r *http.Request) {
 	w.Write(body)
 }
 
+// fetchShadows requests the shadows of the devices a few at a time, until the context is done
+// The error for each device is set if its shadow couldn't be fetched
+func (s *server) fetchShadows(ctx context.Context, ids []string) ([]*shadow.Shadow, []error) {
+	type result struct {
+		index  int
+		shadow *shadow.Shadow
+		err    error
+	}
+	// Queue up the devices to fetch
+	jobs := make(chan int, len(ids))
+	for i := range ids {
+		jobs <- i
+	}
+	close(jobs)
+	// Start the workers, which carry on until there are no more devices or time runs out
+	// The results channel is buffered, so that workers are never blocked once we stop listening
+	results := make(chan result, len(ids))
+	workers := maxShadowFetches
+	if len(ids) < workers {
+		workers = len(ids)
+	}
+	for w := 0; w < workers; w++ {
+		go func() {
+			for i := range jobs {
+				if ctx.Err() != nil {
+					return
+				}
+				shdw, err := s.shadow.GetWithContext(ctx, ids[i])
+				results <- result{index: i, shadow: shdw, err: err}
+			}
+		}()
+	}
+	// Collect the results, assuming any we don't hear back about have timed out
+	shadows := make([]*shadow.Shadow, len(ids))
+	errs := make([]error, len(ids))
+	for i := range errs {
+		errs[i] = ErrShadowFetchTimeout
+	}
+	for received := 0; received < len(ids); received++ {
+		select {
+		case <-ctx.Done():
+			return shadows, errs
+		case res := <-results:
+			shadows[res.index], errs[res.index] = res.shadow, res.err
+		}
+	}
+	return shadows, errs
+}
+
 func (s *server) UpdateAccount(w http.ResponseWriter, r *http.Request) {
 	// Ensure the auth middleware provided us with the account ID
 	accountID, err := getAccountId(r.Context())
@@ -85,21 +165,47 @@ func (s *server) UpdateAccount(w http.ResponseWriter, r *http.Request) {
 		SetError(w, ErrAccountIDMissing, http.StatusInternalServerError)
 		return
 	}
-	// Parse the emails from the request
-	var emails models.Emails
-	err = json.NewDecoder(r.Body).Decode(&emails)
+	// Parse the updates from the request
+	var updates models.MutableAccount
+	err = json.NewDecoder(r.Body).Decode(&updates)
 	if err != nil {
 		SetError(w, err, http.StatusBadRequest)
 		return
 	}
-	// Request that emails are verified
-	err = s.email.VerifyEmailsIfNecessary(emails.Emails)
-	if err != nil {
-		SetError(w, err, http.StatusInternalServerError)
+	if err := shared.Validate.Struct(updates); err != nil {
+		SetError(w, err, http.StatusBadRequest)
 		return
 	}
+	if updates.TimeZone != nil {
+		if _, err := time.LoadLocation(*updates.TimeZone); err != nil {
+			SetError(w, fmt.Errorf("%w: %s", ErrUnknownTimeZone, *updates.TimeZone), http.StatusBadRequest)
+			return
+		}
+	}
+	// Request that emails are verified
+	if updates.Emails != nil {
+		err = s.email.VerifyEmailsIfNecessary(*updates.Emails)
+		if err != nil {
+			SetError(w, err, http.StatusInternalServerError)
+			return
+		}
+	}
 	// Update the database
-	account, err := s.db.UpdateAccountEmails(accountID, emails.Emails)
+	update := database.AccountUpdate{
+		Emails:      updates.Emails,
+		DataCap:     updates.DataCapBytes,
+		TimeZone:    updates.TimeZone,
+		GracePeriod: updates.GracePeriodSeconds,
+	}
+	if updates.Branding != nil {
+		update.Branding = &database.Branding{
+			LogoURL:      updates.Branding.LogoURL,
+			HeaderColour: updates.Branding.HeaderColour,
+			ButtonColour: updates.Branding.ButtonColour,
+			SenderName:   updates.Branding.SenderName,
+		}
+	}
+	account, err := s.db.UpdateAccount(accountID, update)
 	if err != nil {
 		SetError(w, err, http.StatusInternalServerError)
 		return
@@ -119,8 +225,18 @@ func (s *server) UpdateAccount(w http.ResponseWriter, r *http.Request) {
 func (s *server) createAccountPayload(account *database.Account) ([]byte, error) {
 	// Build the response
 	payload := models.Account{
-		Username: account.Username,
-		Emails:   models.Emails{Emails: account.Emails},
+		Username:           account.Username,
+		Emails:             models.Emails{Emails: account.Emails},
+		MFAEnabled:         account.MFA.Enabled,
+		DataCapBytes:       account.DataCap,
+		TimeZone:           account.TimeZone,
+		GracePeriodSeconds: account.GracePeriod,
+		Branding: models.Branding{
+			LogoURL:      account.Branding.LogoURL,
+			HeaderColour: account.Branding.HeaderColour,
+			ButtonColour: account.Branding.ButtonColour,
+			SenderName:   account.Branding.SenderName,
+		},
 	}
 	// Ensure empty slices appear as '[]' in JSON
 	if payload.Emails.Emails == nil {
@@ -0,0 +1,55 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/briggysmalls/detectordag/api/app/models"
+	"github.com/briggysmalls/detectordag/shared"
+	"github.com/briggysmalls/detectordag/shared/food"
+	"github.com/briggysmalls/detectordag/shared/shadow"
+	"github.com/gorilla/mux"
+)
+
+func (s *server) UpdateDeviceAppliance(w http.ResponseWriter, r *http.Request) {
+	// Get the device ID
+	id := mux.Vars(r)["deviceId"]
+	// Try to parse the body
+	var updates models.Appliance
+	if err := json.NewDecoder(r.Body).Decode(&updates); err != nil {
+		SetError(w, err, http.StatusBadRequest)
+		return
+	}
+	if err := shared.Validate.Struct(updates); err != nil {
+		SetError(w, err, http.StatusBadRequest)
+		return
+	}
+	// Set the appliance
+	shdw, err := s.shadow.UpdateAppliance(id, shadow.ApplianceShadow{
+		Type:    updates.Type,
+		SafeFor: time.Duration(updates.SafeFor) * time.Second,
+	})
+	if err != nil {
+		SetError(w, err, http.StatusInternalServerError)
+		return
+	}
+	// Build response content
+	body, err := json.Marshal(newAppliance(shdw.Appliance))
+	if err != nil {
+		SetError(w, err, http.StatusInternalServerError)
+		return
+	}
+	// Write the response
+	w.WriteHeader(http.StatusOK)
+	w.Write(body)
+}
+
+// newAppliance builds the payload for an appliance, filling in its usual limit
+func newAppliance(appliance shadow.ApplianceShadow) *models.Appliance {
+	limit, ok := food.Limit(appliance)
+	if !ok {
+		return nil
+	}
+	return &models.Appliance{Type: appliance.Type, SafeFor: int(limit.Seconds())}
+}
@@ -2,9 +2,35 @@ package server
 
 import (
 	"encoding/json"
+	"errors"
 	"github.com/briggysmalls/detectordag/api/app/models"
+	"github.com/briggysmalls/detectordag/api/app/tokens"
+	"github.com/briggysmalls/detectordag/shared"
+	"github.com/briggysmalls/detectordag/shared/database"
+	"github.com/google/uuid"
 	"golang.org/x/crypto/bcrypt"
+	"log"
 	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	AuthenticationHeaderPrefix = "Bearer "
+	// refreshTokenDuration is how long a refresh token can be exchanged for a new access token
+	refreshTokenDuration = 30 * 24 * time.Hour
+	// dummyPasswordHash is checked against when there is no account with the username
+	dummyPasswordHash = "$2a$12$ZBwMGCgSyQCrKdl2r6n6Redqnw1O/iNpWIzILcQQQFkeJdRVt3oS2"
+)
+
+var (
+	ErrNoAuthHeader           = errors.New("Authorization header not set")
+	ErrMalformattedAuthHeader = errors.New("Authorization header badly formed")
+	ErrBadRefreshToken        = errors.New("Refresh token invalid or expired")
+	// The same error is given whether or not the username exists
+	ErrBadCredentials       = errors.New("Incorrect username or password")
+	ErrTooManyLoginAttempts = errors.New("Too many login attempts, try again later")
+	ErrLoginUnavailable     = errors.New("Unable to log in, try again later")
 )
 
 func (s *server) Auth(w http.ResponseWriter, r *http.Request) {
@@ -16,33 +42,219 @@ func (s *server) Auth(w http.ResponseWriter, r *http.Request) {
 		SetError(w, err, http.StatusBadRequest)
 		return
 	}
+	now := time.Now()
+	login := database.Login{
+		Timestamp: now.UnixNano(),
+		IP:        clientIP(r),
+		UserAgent: r.UserAgent(),
+	}
+	sources := loginSources(creds.Username, login.IP)
+	// Count the attempt up front (unless attempts are being held back, after too many failures)
+	// so that concurrent attempts can't all be checked before any of them are counted
+	retry, err := s.claimLoginAttempt(sources, now)
+	if err != nil {
+		log.Printf("Failed to check login attempts: %v", err)
+		SetError(w, ErrLoginUnavailable, http.StatusInternalServerError)
+		return
+	}
 	// Query for an account with the given username
 	account, err := s.db.GetAccountByUsername(creds.Username)
-	if err != nil {
-		SetError(w, err, http.StatusForbidden)
+	if err != nil && !errors.Is(err, database.ErrUnknownAccount) {
+		log.Printf("Failed to get account: %v", err)
+		SetError(w, ErrLoginUnavailable, http.StatusInternalServerError)
+		return
+	}
+	if account != nil {
+		login.AccountId = account.AccountId
+	}
+	// Refuse the attempt without checking it, if it's too soon
+	if retry.After(now) {
+		if account != nil {
+			login.Reason = loginReasonThrottled
+			s.recordLogin(login)
+		}
+		setRetryAfter(w, retry, now)
+		SetError(w, ErrTooManyLoginAttempts, http.StatusTooManyRequests)
 		return
 	}
 	// Check that the password is correct
-	err = bcrypt.CompareHashAndPassword([]byte(account.Password), []byte(creds.Password))
+	// Unknown usernames are checked against a dummy, so they can't be told apart by how long they take
+	hash := dummyPasswordHash
+	if account != nil {
+		hash = account.Password
+	}
+	err = bcrypt.CompareHashAndPassword([]byte(hash), []byte(creds.Password))
+	if account == nil || err != nil {
+		// The attempt has already been counted, so guesses are slowed down
+		if account != nil {
+			login.Reason = loginReasonPassword
+			s.recordLogin(login)
+		}
+		SetError(w, ErrBadCredentials, http.StatusForbidden)
+		return
+	}
+	// Ask for a code too, if the account has enabled two-factor authentication
+	// The attempt stays counted until the code has been given as well
+	if account.MFA.Enabled {
+		s.writeMFAChallenge(w, account.AccountId)
+		return
+	}
+	// Create tokens for the authenticated user
+	s.loggedIn(login, creds.Username)
+	s.writeTokens(w, account.AccountId, "")
+}
+
+func (s *server) Refresh(w http.ResponseWriter, r *http.Request) {
+	// Try to parse the body
+	var refresh models.Refresh
+	if err := json.NewDecoder(r.Body).Decode(&refresh); err != nil {
+		SetError(w, err, http.StatusBadRequest)
+		return
+	}
+	if err := shared.Validate.Struct(refresh); err != nil {
+		SetError(w, err, http.StatusBadRequest)
+		return
+	}
+	// Use up the refresh token, so that it can't be used again
+	stored, err := s.db.UseRefreshToken(tokens.HashRefreshToken(refresh.RefreshToken))
+	if errors.Is(err, database.ErrRefreshTokenNotFound) {
+		SetError(w, ErrBadRefreshToken, http.StatusForbidden)
+		return
+	}
+	if errors.Is(err, database.ErrRefreshTokenReused) {
+		// The token has been copied, so we can't tell who is legitimate: revoke the whole login
+		log.Printf("Refresh token reused for account '%s', revoking its family", stored.AccountId)
+		if err := s.db.RevokeRefreshTokens(*stored); err != nil {
+			SetError(w, err, http.StatusInternalServerError)
+			return
+		}
+		SetError(w, ErrBadRefreshToken, http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		SetError(w, err, http.StatusInternalServerError)
+		return
+	}
+	// Check the refresh token hasn't expired (DynamoDB is slow to delete them)
+	if time.Unix(stored.Expires, 0).Before(time.Now()) {
+		SetError(w, ErrBadRefreshToken, http.StatusForbidden)
+		return
+	}
+	// Create new tokens in the same family, replacing the one used
+	s.writeTokens(w, stored.AccountId, stored.Family)
+}
+
+func (s *server) Logout(w http.ResponseWriter, r *http.Request) {
+	// Check the access token
+	token, err := GetToken(&r.Header)
 	if err != nil {
 		SetError(w, err, http.StatusForbidden)
 		return
 	}
-	// Create a token for the authenticated user
-	token, err := s.tokens.Create(account.AccountId)
+	claims, err := s.tokens.Validate(token)
+	switch err {
+	case nil:
+		break
+	case tokens.ErrBadToken:
+		SetError(w, err, http.StatusForbidden)
+		return
+	default:
+		SetError(w, err, http.StatusInternalServerError)
+		return
+	}
+	// Try to parse the body (the refresh token is optional)
+	var refresh models.Refresh
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&refresh); err != nil {
+			SetError(w, err, http.StatusBadRequest)
+			return
+		}
+	}
+	// Look up the refresh token, if we were given one
+	var stored *database.RefreshToken
+	if refresh.RefreshToken != "" {
+		stored, err = s.db.GetRefreshToken(tokens.HashRefreshToken(refresh.RefreshToken))
+		if err != nil && !errors.Is(err, database.ErrRefreshTokenNotFound) {
+			SetError(w, err, http.StatusInternalServerError)
+			return
+		}
+		// Refuse to log out someone else's session
+		if stored != nil && stored.AccountId != claims.AccountId {
+			SetError(w, ErrBadRefreshToken, http.StatusForbidden)
+			return
+		}
+	}
+	// Revoke the access token, until it would have expired anyway
+	if err := s.db.RevokeToken(claims.Id, time.Unix(claims.ExpiresAt, 0)); err != nil {
+		SetError(w, err, http.StatusInternalServerError)
+		return
+	}
+	// Forget the refresh token, along with the others from the same login
+	if stored != nil {
+		if err := s.db.RevokeRefreshTokens(*stored); err != nil {
+			SetError(w, err, http.StatusInternalServerError)
+			return
+		}
+	}
+	// Write the response
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// writeTokens creates an access token and a refresh token for the account, and writes them
+// The refresh token joins the given family, or starts a new one if it is empty
+func (s *server) writeTokens(w http.ResponseWriter, accountID, family string) {
+	// Create an access token
+	token, err := s.tokens.Create(accountID)
+	if err != nil {
+		SetError(w, err, http.StatusInternalServerError)
+		return
+	}
+	// Create a refresh token, keeping only its hash
+	refresh, hash, err := tokens.NewRefreshToken()
 	if err != nil {
 		SetError(w, err, http.StatusInternalServerError)
+		return
+	}
+	if family == "" {
+		family = uuid.New().String()
+	}
+	err = s.db.CreateRefreshToken(database.RefreshToken{
+		Hash:      hash,
+		AccountId: accountID,
+		Family:    family,
+		Expires:   time.Now().Add(refreshTokenDuration).Unix(),
+	})
+	if err != nil {
+		SetError(w, err, http.StatusInternalServerError)
+		return
 	}
 	// Build response content
 	content := models.Token{
-		AccountId: account.AccountId,
-		Token:     token,
+		AccountId:    accountID,
+		Token:        token,
+		RefreshToken: refresh,
 	}
 	body, err := json.Marshal(content)
 	if err != nil {
 		SetError(w, err, http.StatusInternalServerError)
+		return
 	}
 	// Write the response
 	w.WriteHeader(http.StatusOK)
 	w.Write(body)
 }
+
+// GetToken gets the access token from the Authorization header
+func GetToken(header *http.Header) (string, error) {
+	// Check the auth header is set
+	authHeader := header.Get("Authorization")
+	if authHeader == "" {
+		return "", ErrNoAuthHeader
+	}
+	// Ensure we've been given a JWT how we expect
+	if !strings.HasPrefix(authHeader, AuthenticationHeaderPrefix) {
+		return "", ErrMalformattedAuthHeader
+	}
+	// Return the token
+	return strings.TrimPrefix(authHeader, AuthenticationHeaderPrefix), nil
+}
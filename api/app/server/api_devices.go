@@ -1,14 +1,32 @@
 package server
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
+	"time"
 
 	"github.com/briggysmalls/detectordag/api/app/models"
+	"github.com/briggysmalls/detectordag/shared"
+	"github.com/briggysmalls/detectordag/shared/cellular"
+	"github.com/briggysmalls/detectordag/shared/discharge"
+	"github.com/briggysmalls/detectordag/shared/metrics"
+	"github.com/briggysmalls/detectordag/shared/shadow"
+	"github.com/briggysmalls/detectordag/shared/state"
 	"github.com/gorilla/mux"
 )
 
+const (
+	// pingTimeout is how long to wait for a device to respond to a ping
+	pingTimeout = 10 * time.Second
+	// pingInterval is how often the shadow is checked for a response to a ping
+	pingInterval = 500 * time.Millisecond
+)
+
+var ErrDeviceUnresponsive = errors.New("Device did not respond in time")
+
 func (s *server) UpdateDevice(w http.ResponseWriter, r *http.Request) {
 	var err error
 	// Get the device ID
@@ -29,25 +47,301 @@ func (s *server) UpdateDevice(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	// Build the payload
-	payload := models.Device{
+	payload, err := newDevice(id, shdw)
+	if err != nil {
+		log.Printf("Device has invalid state")
+		SetError(w, err, http.StatusInternalServerError)
+		return
+	}
+	// Build response content
+	body, err := json.Marshal(payload)
+	if err != nil {
+		log.Printf("Failed to serialise response")
+		SetError(w, err, http.StatusInternalServerError)
+	}
+	// Write the response
+	w.WriteHeader(http.StatusOK)
+	w.Write(body)
+}
+
+func (s *server) GetDevice(w http.ResponseWriter, r *http.Request) {
+	// Get the device ID
+	id := mux.Vars(r)["deviceId"]
+	// Request the shadow
+	shdw, err := s.shadow.Get(id)
+	if err != nil {
+		SetError(w, err, http.StatusInternalServerError)
+		return
+	}
+	// Build the payload
+	payload, err := newDevice(id, shdw)
+	if err != nil {
+		SetError(w, err, http.StatusInternalServerError)
+		return
+	}
+	// Skip the response if the client already has it
+	if notModified(w, r, deviceETag(shdw.Version, payload)) {
+		return
+	}
+	// Write the response
+	writeDevice(w, payload)
+}
+
+func (s *server) PingDevice(w http.ResponseWriter, r *http.Request) {
+	// Get the device ID
+	id := mux.Vars(r)["deviceId"]
+	// Request the shadow, to see when it has been updated
+	shdw, err := s.shadow.Get(id)
+	if err != nil {
+		SetError(w, err, http.StatusInternalServerError)
+		return
+	}
+	// Ask the device to report its status
+	if err := s.shadow.RequestStatusUpdate(id); err != nil {
+		SetError(w, err, http.StatusInternalServerError)
+		return
+	}
+	// Wait for the device to respond
+	ctx, cancel := context.WithTimeout(r.Context(), pingTimeout)
+	defer cancel()
+	shdw, err = s.awaitShadowUpdate(ctx, id, shdw.Seen)
+	if errors.Is(err, ErrDeviceUnresponsive) {
+		SetError(w, err, http.StatusGatewayTimeout)
+		return
+	}
+	if err != nil {
+		SetError(w, err, http.StatusInternalServerError)
+		return
+	}
+	// Build the payload
+	payload, err := newDevice(id, shdw)
+	if err != nil {
+		SetError(w, err, http.StatusInternalServerError)
+		return
+	}
+	// Write the response
+	writeDevice(w, payload)
+}
+
+// awaitShadowUpdate polls the shadow until the device itself reports something after the time given
+// Other writes to the shadow (e.g. by our lambdas) don't count as the device responding
+func (s *server) awaitShadowUpdate(ctx context.Context, id string, seen time.Time) (*shadow.Shadow, error) {
+	ticker := time.NewTicker(pingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, ErrDeviceUnresponsive
+		case <-ticker.C:
+		}
+		shdw, err := s.shadow.Get(id)
+		if err != nil {
+			return nil, err
+		}
+		if shdw.Seen.After(seen) {
+			return shdw, nil
+		}
+	}
+}
+
+// writeDevice writes the device payload
+func writeDevice(w http.ResponseWriter, payload models.Device) {
+	// Build response content
+	body, err := json.Marshal(payload)
+	if err != nil {
+		SetError(w, err, http.StatusInternalServerError)
+		return
+	}
+	// Write the response
+	w.WriteHeader(http.StatusOK)
+	w.Write(body)
+}
+
+// newDevice builds the device payload from its shadow
+func newDevice(id string, shdw *shadow.Shadow) (models.Device, error) {
+	// Check the shadow holds a valid state
+	st, err := state.FromShadow(shdw)
+	if err != nil {
+		return models.Device{}, err
+	}
+	device := models.Device{
 		Name:     shdw.Name,
 		DeviceId: id,
 		State: &models.DeviceState{
-			Power:   shdw.Power.Value,
+			Power:   st.Power(),
 			Updated: shdw.Power.Updated,
 		},
 		Connection: &models.DeviceConnection{
-			Status:  shdw.Connection.Status,
+			Status:  st.Connection(),
 			Updated: shdw.Connection.Updated,
 		},
+		Unstable:  shdw.Stability.Unstable,
+		Appliance: newAppliance(shdw.Appliance),
+	}
+	for _, reading := range shdw.Temperature.Readings {
+		device.Temperatures = append(device.Temperatures, models.DeviceTemperature{Probe: reading.Probe, Celsius: reading.Celsius})
+	}
+	for name, reading := range shdw.Metrics.Readings {
+		if device.Metrics == nil {
+			device.Metrics = make(map[string]models.DeviceMetric, len(shdw.Metrics.Readings))
+		}
+		kind, _ := metrics.Lookup(reading.Kind)
+		device.Metrics[name] = models.DeviceMetric{
+			Kind:        reading.Kind,
+			DisplayName: kind.DisplayName,
+			Value:       reading.Value,
+			Unit:        reading.Unit,
+			Symbol:      kind.Symbol,
+		}
+	}
+	if shdw.Battery != nil {
+		device.State.Battery = &models.DeviceBattery{
+			Percent:  shdw.Battery.Percent,
+			Voltage:  shdw.Battery.Voltage,
+			Charging: shdw.Battery.Charging,
+		}
+		if runtime, ok := discharge.Remaining(shdw, time.Now()); ok {
+			device.State.Battery.Runtime = int(runtime.Seconds())
+		}
+	}
+	return device, nil
+}
+
+func (s *server) GetDeviceConfig(w http.ResponseWriter, r *http.Request) {
+	// Get the device ID
+	id := mux.Vars(r)["deviceId"]
+	// Request the shadow
+	shdw, err := s.shadow.Get(id)
+	if err != nil {
+		SetError(w, err, http.StatusInternalServerError)
+		return
+	}
+	// Write the response
+	writeDeviceConfig(w, shdw)
+}
+
+func (s *server) UpdateDeviceConfig(w http.ResponseWriter, r *http.Request) {
+	// Get the device ID
+	id := mux.Vars(r)["deviceId"]
+	// Try to parse the body
+	var updates models.MutableDeviceConfig
+	if err := json.NewDecoder(r.Body).Decode(&updates); err != nil {
+		SetError(w, err, http.StatusBadRequest)
+		return
+	}
+	if err := shared.Validate.Struct(updates); err != nil {
+		SetError(w, err, http.StatusBadRequest)
+		return
+	}
+	// Ask the device to apply the config
+	shdw, err := s.shadow.UpdateDesiredConfig(id, newDeviceConfigUpdate(updates))
+	if err != nil {
+		SetError(w, err, http.StatusInternalServerError)
+		return
 	}
+	// Write the response
+	writeDeviceConfig(w, shdw)
+}
+
+// writeDeviceConfig writes the device's desired and reported config, and whether they agree
+func writeDeviceConfig(w http.ResponseWriter, shdw *shadow.Shadow) {
 	// Build response content
-	body, err := json.Marshal(payload)
+	body, err := json.Marshal(models.DeviceConfigStatus{
+		Desired:  newDeviceConfig(shdw.Config.Desired),
+		Reported: newDeviceConfig(shdw.Config.Reported),
+		Delta:    newDeviceConfig(shdw.Config.Delta()),
+		Applied:  shdw.Config.Applied(),
+	})
 	if err != nil {
-		log.Printf("Failed to serialise response")
 		SetError(w, err, http.StatusInternalServerError)
+		return
 	}
 	// Write the response
 	w.WriteHeader(http.StatusOK)
 	w.Write(body)
 }
+
+// newDeviceConfigUpdate converts the fields given in the request, leaving the rest unset
+func newDeviceConfigUpdate(updates models.MutableDeviceConfig) shadow.DeviceConfigUpdate {
+	var update shadow.DeviceConfigUpdate
+	if updates.ReportInterval != nil {
+		interval := time.Duration(*updates.ReportInterval) * time.Second
+		update.ReportInterval = &interval
+	}
+	if updates.StatusRequestTimeout != nil {
+		timeout := time.Duration(*updates.StatusRequestTimeout) * time.Second
+		update.StatusRequestTimeout = &timeout
+	}
+	update.LowBatteryThreshold = updates.LowBatteryThreshold
+	return update
+}
+
+func newDeviceConfig(config shadow.DeviceConfig) models.DeviceConfig {
+	return models.DeviceConfig{
+		ReportInterval:       int(config.ReportInterval.Seconds()),
+		StatusRequestTimeout: int(config.StatusRequestTimeout.Seconds()),
+		LowBatteryThreshold:  config.LowBatteryThreshold,
+	}
+}
+
+func (s *server) GetDeviceDiagnostics(w http.ResponseWriter, r *http.Request) {
+	// Ensure the auth middleware provided us with the account ID
+	accountID, err := getAccountId(r.Context())
+	if err != nil {
+		SetError(w, ErrAccountIDMissing, http.StatusInternalServerError)
+		return
+	}
+	// Get the device ID
+	id := mux.Vars(r)["deviceId"]
+	// Request the shadow
+	shdw, err := s.shadow.Get(id)
+	if err != nil {
+		SetError(w, err, http.StatusInternalServerError)
+		return
+	}
+	// Request the account, for its data allowance
+	account, err := s.db.GetAccountById(accountID)
+	if err != nil {
+		SetError(w, err, http.StatusInternalServerError)
+		return
+	}
+	// Build response content
+	body, err := json.Marshal(newDeviceDiagnostics(shdw, account.DataCap))
+	if err != nil {
+		SetError(w, err, http.StatusInternalServerError)
+		return
+	}
+	// Write the response
+	w.WriteHeader(http.StatusOK)
+	w.Write(body)
+}
+
+// newDeviceDiagnostics builds the diagnostics payload from the device's shadow
+func newDeviceDiagnostics(shdw *shadow.Shadow, cap int64) models.DeviceDiagnostics {
+	diagnostics := models.DeviceDiagnostics{
+		DataUsedBytes: shdw.Cellular.DataUsed,
+		DataCapBytes:  cap,
+		History:       make([]models.DeviceCellularSample, len(shdw.Cellular.Samples)),
+	}
+	if shdw.Cellular.Signal != nil {
+		diagnostics.Signal = &models.DeviceSignal{
+			RSSI: shdw.Cellular.Signal.RSSI,
+			RSRP: shdw.Cellular.Signal.RSRP,
+		}
+	}
+	for i, sample := range shdw.Cellular.Samples {
+		diagnostics.History[i] = models.DeviceCellularSample{
+			Time:          sample.Time,
+			Signal:        models.DeviceSignal{RSSI: sample.Signal.RSSI, RSRP: sample.Signal.RSRP},
+			DataUsedBytes: sample.DataUsed,
+		}
+	}
+	// Project usage from when it was last reported
+	if len(shdw.Cellular.Samples) > 0 {
+		latest := shdw.Cellular.Samples[len(shdw.Cellular.Samples)-1]
+		if projected, ok := cellular.ProjectMonthlyUsage(latest.DataUsed, latest.Time); ok {
+			diagnostics.ProjectedDataBytes = projected
+		}
+	}
+	return diagnostics
+}
@@ -0,0 +1,63 @@
+package server
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+
+	"github.com/briggysmalls/detectordag/api/app/models"
+	"github.com/briggysmalls/detectordag/api/app/tokens"
+)
+
+// keysMaxAge is how long verifiers can cache the keys for (seconds)
+// It's kept well short of the grace period, so they pick up new keys before the old ones are dropped
+const keysMaxAge = 300
+
+// GetKeys publishes the public keys that tokens can be verified with, as a JSON Web Key Set
+func (s *server) GetKeys(w http.ResponseWriter, r *http.Request) {
+	// Convert the keys
+	payload := models.JSONWebKeySet{Keys: []models.JSONWebKey{}}
+	for _, key := range s.tokens.Keys() {
+		jwk, err := newJSONWebKey(key)
+		if err != nil {
+			SetError(w, err, http.StatusInternalServerError)
+			return
+		}
+		payload.Keys = append(payload.Keys, jwk)
+	}
+	// Build response content
+	body, err := json.Marshal(payload)
+	if err != nil {
+		SetError(w, err, http.StatusInternalServerError)
+		return
+	}
+	// Write the response
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", keysMaxAge))
+	w.WriteHeader(http.StatusOK)
+	w.Write(body)
+}
+
+func newJSONWebKey(key *tokens.Key) (models.JSONWebKey, error) {
+	jwk := models.JSONWebKey{
+		Kid: key.ID,
+		Use: "sig",
+		Alg: key.Algorithm(),
+	}
+	switch public := key.Public().(type) {
+	case *rsa.PublicKey:
+		jwk.Kty = "RSA"
+		jwk.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+		jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+	case ed25519.PublicKey:
+		jwk.Kty = "OKP"
+		jwk.Crv = "Ed25519"
+		jwk.X = base64.RawURLEncoding.EncodeToString(public)
+	default:
+		return jwk, fmt.Errorf("Unsupported key type: %T", public)
+	}
+	return jwk, nil
+}
@@ -0,0 +1,211 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/awslabs/aws-lambda-go-api-proxy/core"
+	"github.com/briggysmalls/detectordag/api/app/models"
+	"github.com/briggysmalls/detectordag/shared/database"
+)
+
+const (
+	// loginBackoff is how long to wait after the first failure beyond those allowed, doubling with each failure
+	loginBackoff = time.Second
+	// maxLoginBackoff is the longest wait between attempts (other than when an account is locked)
+	maxLoginBackoff = 5 * time.Minute
+	// lockoutDuration is how long a username is locked for, after too many failures
+	lockoutDuration = 30 * time.Minute
+	// loginAttemptsWindow is how long failures are remembered, after the last one
+	loginAttemptsWindow = 24 * time.Hour
+	// maxLogins is how many of the most recent attempts are listed in an account's audit trail
+	maxLogins = 50
+	// maxLoginClaims is how many times an attempt is counted again, after losing out to concurrent ones
+	maxLoginClaims = 3
+)
+
+const (
+	loginReasonPassword  = "Incorrect password"
+	loginReasonThrottled = "Too many attempts"
+	loginReasonCode      = "Incorrect code"
+)
+
+// loginThrottle is how failed logins are held back for one source of attempts
+type loginThrottle struct {
+	prefix string
+	// How many failures are allowed before attempts are slowed down
+	free int
+	// How many failures lock out further attempts (or zero to never lock)
+	lockout int
+}
+
+var (
+	// Guessing a password is slowed down, then the username is locked
+	usernameThrottle = loginThrottle{prefix: "username:", free: 3, lockout: 10}
+	// Addresses can be shared by many people, so are only slowed down
+	ipThrottle = loginThrottle{prefix: "ip:", free: 20}
+)
+
+// retryAfter gets when another attempt will be allowed (zero if one is allowed already)
+func (t loginThrottle) retryAfter(attempts *database.LoginAttempts) time.Time {
+	if attempts.Failures < t.free {
+		return time.Time{}
+	}
+	last := time.Unix(attempts.LastFailure, 0)
+	if t.lockout != 0 && attempts.Failures >= t.lockout {
+		return last.Add(lockoutDuration)
+	}
+	// Back off exponentially (taking care not to overflow)
+	backoff := maxLoginBackoff
+	if doublings := attempts.Failures - t.free; doublings < 16 {
+		backoff = loginBackoff << doublings
+	}
+	if backoff > maxLoginBackoff {
+		backoff = maxLoginBackoff
+	}
+	return last.Add(backoff)
+}
+
+// loginSource is a source of login attempts (a username or an IP address)
+type loginSource struct {
+	throttle loginThrottle
+	value    string
+}
+
+// loginSources gets the sources that a login attempt is tracked against
+func loginSources(username, ip string) []loginSource {
+	sources := []loginSource{{throttle: usernameThrottle, value: username}}
+	if ip != "" {
+		sources = append(sources, loginSource{throttle: ipThrottle, value: ip})
+	}
+	return sources
+}
+
+// claimLoginAttempt counts an attempt against all of its sources, before it's checked
+// Returns when another attempt will be allowed if this one must be refused, or zero if it can go ahead
+func (s *server) claimLoginAttempt(sources []loginSource, now time.Time) (time.Time, error) {
+	// Check none of the sources are being held back
+	seen := make([]*database.LoginAttempts, len(sources))
+	var retry time.Time
+	for i, source := range sources {
+		attempts, err := s.db.GetLoginAttempts(source.throttle.prefix+source.value, now)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if after := source.throttle.retryAfter(attempts); after.After(retry) {
+			retry = after
+		}
+		seen[i] = attempts
+	}
+	if retry.After(now) {
+		return retry, nil
+	}
+	// Count the attempt against each source
+	for i, source := range sources {
+		attempts := seen[i]
+		for claims := 1; ; claims++ {
+			_, err := s.db.RecordLoginAttempt(*attempts, now, now.Add(loginAttemptsWindow))
+			if err == nil {
+				break
+			}
+			if !errors.Is(err, database.ErrLoginAttemptsChanged) {
+				return time.Time{}, err
+			}
+			// Another attempt was counted first, so check whether this one is still allowed
+			if claims == maxLoginClaims {
+				return now.Add(loginBackoff), nil
+			}
+			attempts, err = s.db.GetLoginAttempts(source.throttle.prefix+source.value, now)
+			if err != nil {
+				return time.Time{}, err
+			}
+			if after := source.throttle.retryAfter(attempts); after.After(now) {
+				return after, nil
+			}
+		}
+	}
+	return time.Time{}, nil
+}
+
+// setRetryAfter tells the caller when to try again, after being held back
+func setRetryAfter(w http.ResponseWriter, retry time.Time, now time.Time) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retry.Sub(now).Seconds()))))
+}
+
+// forgetLoginAttempts forgets about previous attempts for the username, once one has succeeded
+// Attempts from the address are left to expire, so logging in to one account can't be used
+// to reset the count of guesses made at others
+func (s *server) forgetLoginAttempts(username string) {
+	if err := s.db.ClearLoginAttempts(usernameThrottle.prefix + username); err != nil {
+		log.Printf("Failed to clear login attempts: %v", err)
+	}
+}
+
+// recordLogin adds an attempt to the account's audit trail
+// Failing to do so doesn't stop the account being logged in to
+func (s *server) recordLogin(login database.Login) {
+	if err := s.db.RecordLogin(login); err != nil {
+		log.Printf("Failed to audit login: %v", err)
+	}
+}
+
+// loggedIn records a successful login, forgetting about previous attempts for the username
+func (s *server) loggedIn(login database.Login, username string) {
+	s.forgetLoginAttempts(username)
+	login.Success = true
+	s.recordLogin(login)
+}
+
+func (s *server) GetLogins(w http.ResponseWriter, r *http.Request) {
+	// Ensure the auth middleware provided us with the account ID
+	accountID, err := getAccountId(r.Context())
+	if err != nil {
+		SetError(w, ErrAccountIDMissing, http.StatusInternalServerError)
+		return
+	}
+	// Request the most recent attempts
+	logins, err := s.db.GetLogins(accountID, maxLogins)
+	if err != nil {
+		SetError(w, err, http.StatusInternalServerError)
+		return
+	}
+	// Build response content
+	payload := []models.Login{}
+	for _, login := range logins {
+		payload = append(payload, models.Login{
+			Time:      login.Time(),
+			IP:        login.IP,
+			UserAgent: login.UserAgent,
+			Success:   login.Success,
+			Reason:    login.Reason,
+		})
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		SetError(w, err, http.StatusInternalServerError)
+		return
+	}
+	// Write the response
+	w.WriteHeader(http.StatusOK)
+	w.Write(body)
+}
+
+// clientIP gets the address the request came from
+func clientIP(r *http.Request) string {
+	// API Gateway knows where the request really came from
+	if ctx, ok := core.GetAPIGatewayContextFromContext(r.Context()); ok && ctx.Identity.SourceIP != "" {
+		return ctx.Identity.SourceIP
+	}
+	// Otherwise use the connection's address
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
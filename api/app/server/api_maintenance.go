@@ -0,0 +1,186 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/briggysmalls/detectordag/api/app/models"
+	"github.com/briggysmalls/detectordag/shared"
+	"github.com/briggysmalls/detectordag/shared/database"
+	"github.com/briggysmalls/detectordag/shared/maintenance"
+	"github.com/briggysmalls/detectordag/shared/shadow"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+// maxMaintenanceWindows is how many windows can be set up for a device, or for an account
+const maxMaintenanceWindows = 20
+
+var (
+	ErrTooManyWindows = errors.New("Too many maintenance windows")
+	ErrWindowNotFound = errors.New("Maintenance window not found")
+)
+
+func (s *server) GetMaintenanceWindows(w http.ResponseWriter, r *http.Request) {
+	// Get the device and its account
+	account, shdw, ok := s.getMaintenanceOwners(w, r)
+	if !ok {
+		return
+	}
+	// Write the response
+	writeMaintenanceWindows(w, account, shdw)
+}
+
+func (s *server) CreateMaintenanceWindow(w http.ResponseWriter, r *http.Request) {
+	// Try to parse the body
+	var update models.MutableMaintenanceWindow
+	if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+		SetError(w, err, http.StatusBadRequest)
+		return
+	}
+	if err := shared.Validate.Struct(update); err != nil {
+		SetError(w, err, http.StatusBadRequest)
+		return
+	}
+	window := maintenance.Window{
+		ID:       uuid.New().String(),
+		Start:    update.Start,
+		Duration: time.Duration(update.Duration) * time.Second,
+		Repeat:   maintenance.Repeat(update.Repeat),
+	}
+	if window.Repeat == "" {
+		window.Repeat = maintenance.Once
+	}
+	// Get the device and its account
+	account, shdw, ok := s.getMaintenanceOwners(w, r)
+	if !ok {
+		return
+	}
+	// Add the window to the account or the device, dropping any that are over
+	var err error
+	if update.AllDevices {
+		windows := addMaintenanceWindow(account.MaintenanceWindows(), window)
+		if len(windows) > maxMaintenanceWindows {
+			SetError(w, ErrTooManyWindows, http.StatusBadRequest)
+			return
+		}
+		account, err = s.db.UpdateAccountMaintenance(account.AccountId, windows)
+	} else {
+		windows := addMaintenanceWindow(shdw.Maintenance, window)
+		if len(windows) > maxMaintenanceWindows {
+			SetError(w, ErrTooManyWindows, http.StatusBadRequest)
+			return
+		}
+		shdw, err = s.shadow.UpdateMaintenance(mux.Vars(r)["deviceId"], windows)
+	}
+	if err != nil {
+		SetError(w, err, http.StatusInternalServerError)
+		return
+	}
+	// Write the response
+	writeMaintenanceWindows(w, account, shdw)
+}
+
+func (s *server) DeleteMaintenanceWindow(w http.ResponseWriter, r *http.Request) {
+	windowID := mux.Vars(r)["windowId"]
+	// Get the device and its account
+	account, shdw, ok := s.getMaintenanceOwners(w, r)
+	if !ok {
+		return
+	}
+	// Remove the window from whichever holds it
+	var err error
+	if windows, found := removeMaintenanceWindow(shdw.Maintenance, windowID); found {
+		shdw, err = s.shadow.UpdateMaintenance(mux.Vars(r)["deviceId"], windows)
+	} else if windows, found := removeMaintenanceWindow(account.MaintenanceWindows(), windowID); found {
+		account, err = s.db.UpdateAccountMaintenance(account.AccountId, windows)
+	} else {
+		SetError(w, ErrWindowNotFound, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		SetError(w, err, http.StatusInternalServerError)
+		return
+	}
+	// Write the response
+	writeMaintenanceWindows(w, account, shdw)
+}
+
+// getMaintenanceOwners gets the account and device shadow that maintenance windows are kept in
+// An error response is written if they can't be fetched
+func (s *server) getMaintenanceOwners(w http.ResponseWriter, r *http.Request) (*database.Account, *shadow.Shadow, bool) {
+	// Ensure the auth middleware provided us with the account ID
+	accountID, err := getAccountId(r.Context())
+	if err != nil {
+		SetError(w, ErrAccountIDMissing, http.StatusInternalServerError)
+		return nil, nil, false
+	}
+	// Request the account
+	account, err := s.db.GetAccountById(accountID)
+	if err != nil {
+		SetError(w, err, http.StatusInternalServerError)
+		return nil, nil, false
+	}
+	// Request the shadow
+	shdw, err := s.shadow.Get(mux.Vars(r)["deviceId"])
+	if err != nil {
+		SetError(w, err, http.StatusInternalServerError)
+		return nil, nil, false
+	}
+	return account, shdw, true
+}
+
+// addMaintenanceWindow adds a window to those already set up, dropping any that won't occur again
+func addMaintenanceWindow(windows []maintenance.Window, window maintenance.Window) []maintenance.Window {
+	now := time.Now()
+	kept := []maintenance.Window{}
+	for _, existing := range windows {
+		if !existing.Expired(now) {
+			kept = append(kept, existing)
+		}
+	}
+	return append(kept, window)
+}
+
+// removeMaintenanceWindow removes the window with the given ID, indicating whether it was found
+func removeMaintenanceWindow(windows []maintenance.Window, id string) ([]maintenance.Window, bool) {
+	kept := []maintenance.Window{}
+	for _, window := range windows {
+		if window.ID != id {
+			kept = append(kept, window)
+		}
+	}
+	return kept, len(kept) != len(windows)
+}
+
+// writeMaintenanceWindows writes the windows covering the device, from both the account and the device itself
+func writeMaintenanceWindows(w http.ResponseWriter, account *database.Account, shdw *shadow.Shadow) {
+	payload := []models.MaintenanceWindow{}
+	for _, window := range account.MaintenanceWindows() {
+		payload = append(payload, newMaintenanceWindow(window, true))
+	}
+	for _, window := range shdw.Maintenance {
+		payload = append(payload, newMaintenanceWindow(window, false))
+	}
+	// Build response content
+	body, err := json.Marshal(payload)
+	if err != nil {
+		SetError(w, err, http.StatusInternalServerError)
+		return
+	}
+	// Write the response
+	w.WriteHeader(http.StatusOK)
+	w.Write(body)
+}
+
+func newMaintenanceWindow(window maintenance.Window, allDevices bool) models.MaintenanceWindow {
+	return models.MaintenanceWindow{
+		ID:         window.ID,
+		Start:      window.Start,
+		Duration:   int(window.Duration.Seconds()),
+		Repeat:     string(window.Repeat),
+		AllDevices: allDevices,
+	}
+}
@@ -0,0 +1,439 @@
+package server
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/briggysmalls/detectordag/api/app/models"
+	"github.com/briggysmalls/detectordag/api/app/tokens"
+	"github.com/briggysmalls/detectordag/api/app/totp"
+	"github.com/briggysmalls/detectordag/shared"
+	"github.com/briggysmalls/detectordag/shared/database"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	// mfaIssuer is how authenticator apps label our codes
+	mfaIssuer = "detectordag"
+	// mfaChallengeDuration is how long there is to give a code, after giving the password
+	mfaChallengeDuration = 5 * time.Minute
+	// recoveryCodeCount is how many recovery codes an account is given
+	recoveryCodeCount = 10
+	// recoveryCodeBytes is how much randomness is in a recovery code
+	recoveryCodeBytes = 5
+	// maxMFAChallenges is how many challenges an account can have open at once
+	maxMFAChallenges = 5
+)
+
+var (
+	ErrMFAEnabled        = errors.New("Two-factor authentication is already enabled")
+	ErrMFANotEnrolled    = errors.New("Two-factor authentication enrolment hasn't been started")
+	ErrMFANotEnabled     = errors.New("Two-factor authentication isn't enabled")
+	ErrBadMFACode        = errors.New("Incorrect code")
+	ErrBadPassword       = errors.New("Incorrect password")
+	ErrBadChallenge      = errors.New("Challenge invalid or expired, log in again")
+	ErrTooManyChallenges = errors.New("Too many logins awaiting a code, try again later")
+)
+
+// recoveryCodeEncoding is used for recovery codes, which are read and typed in by people
+var recoveryCodeEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+func (s *server) EnrolMFA(w http.ResponseWriter, r *http.Request) {
+	// Try to parse the body
+	var request models.MFAEnrolRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		SetError(w, err, http.StatusBadRequest)
+		return
+	}
+	if err := shared.Validate.Struct(request); err != nil {
+		SetError(w, err, http.StatusBadRequest)
+		return
+	}
+	// Get the account
+	account, ok := s.getMFAAccount(w, r)
+	if !ok {
+		return
+	}
+	// Count the attempt before checking the password, unless attempts are being held back
+	now := time.Now()
+	sources := loginSources(account.Username, clientIP(r))
+	retry, err := s.claimLoginAttempt(sources, now)
+	if err != nil {
+		SetError(w, err, http.StatusInternalServerError)
+		return
+	}
+	if retry.After(now) {
+		setRetryAfter(w, retry, now)
+		SetError(w, ErrTooManyLoginAttempts, http.StatusTooManyRequests)
+		return
+	}
+	// Check the password, so a stolen access token can't be used to enrol
+	if err := bcrypt.CompareHashAndPassword([]byte(account.Password), []byte(request.Password)); err != nil {
+		SetError(w, ErrBadPassword, http.StatusForbidden)
+		return
+	}
+	s.forgetLoginAttempts(account.Username)
+	// Create a new secret, and recovery codes for if it's lost
+	secret, err := totp.NewSecret()
+	if err != nil {
+		SetError(w, err, http.StatusInternalServerError)
+		return
+	}
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		SetError(w, err, http.StatusInternalServerError)
+		return
+	}
+	// Store them, ready for enrolment to be confirmed
+	_, err = s.db.UpdateAccountMFA(account.AccountId, database.MFA{Secret: secret, RecoveryCodes: hashes})
+	if err != nil {
+		SetError(w, err, http.StatusInternalServerError)
+		return
+	}
+	// Build response content
+	body, err := json.Marshal(models.MFAEnrolment{
+		Secret:        secret,
+		URI:           totp.URI(mfaIssuer, account.Username, secret),
+		RecoveryCodes: codes,
+	})
+	if err != nil {
+		SetError(w, err, http.StatusInternalServerError)
+		return
+	}
+	// Write the response
+	w.WriteHeader(http.StatusOK)
+	w.Write(body)
+}
+
+func (s *server) ConfirmMFA(w http.ResponseWriter, r *http.Request) {
+	// Try to parse the body
+	var code models.MFACode
+	if err := json.NewDecoder(r.Body).Decode(&code); err != nil {
+		SetError(w, err, http.StatusBadRequest)
+		return
+	}
+	if err := shared.Validate.Struct(code); err != nil {
+		SetError(w, err, http.StatusBadRequest)
+		return
+	}
+	// Get the account
+	account, ok := s.getMFAAccount(w, r)
+	if !ok {
+		return
+	}
+	if account.MFA.Secret == "" {
+		SetError(w, ErrMFANotEnrolled, http.StatusBadRequest)
+		return
+	}
+	// Check the authenticator app has been set up properly
+	step, valid, err := totp.Validate(account.MFA.Secret, code.Code, time.Now())
+	if err != nil {
+		SetError(w, err, http.StatusInternalServerError)
+		return
+	}
+	if !valid {
+		SetError(w, ErrBadMFACode, http.StatusForbidden)
+		return
+	}
+	// Require a code to log in from now on
+	mfa := account.MFA
+	mfa.Enabled = true
+	mfa.LastStep = step
+	if _, err := s.db.UpdateAccountMFA(account.AccountId, mfa); err != nil {
+		SetError(w, err, http.StatusInternalServerError)
+		return
+	}
+	// Sessions started with just the password must log in again
+	if err := s.db.RevokeAccountRefreshTokens(account.AccountId); err != nil {
+		SetError(w, err, http.StatusInternalServerError)
+		return
+	}
+	// Write the response
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *server) DisableMFA(w http.ResponseWriter, r *http.Request) {
+	// Get the account, checking it's allowed to make changes
+	account, ok := s.getMFAEnabledAccount(w, r)
+	if !ok {
+		return
+	}
+	// Stop requiring a code to log in, forgetting the secret and recovery codes
+	if _, err := s.db.UpdateAccountMFA(account.AccountId, database.MFA{}); err != nil {
+		SetError(w, err, http.StatusInternalServerError)
+		return
+	}
+	// Write the response
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *server) CreateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	// Get the account, checking it's allowed to make changes
+	account, ok := s.getMFAEnabledAccount(w, r)
+	if !ok {
+		return
+	}
+	// Create new recovery codes, replacing any that are left
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		SetError(w, err, http.StatusInternalServerError)
+		return
+	}
+	if err := s.db.UpdateRecoveryCodes(account.AccountId, hashes); err != nil {
+		SetError(w, err, http.StatusInternalServerError)
+		return
+	}
+	// Build response content
+	body, err := json.Marshal(models.MFARecoveryCodes{RecoveryCodes: codes})
+	if err != nil {
+		SetError(w, err, http.StatusInternalServerError)
+		return
+	}
+	// Write the response
+	w.WriteHeader(http.StatusOK)
+	w.Write(body)
+}
+
+func (s *server) CompleteMFA(w http.ResponseWriter, r *http.Request) {
+	// Try to parse the body
+	var response models.MFAResponse
+	if err := json.NewDecoder(r.Body).Decode(&response); err != nil {
+		SetError(w, err, http.StatusBadRequest)
+		return
+	}
+	if err := shared.Validate.Struct(response); err != nil {
+		SetError(w, err, http.StatusBadRequest)
+		return
+	}
+	// Use up the challenge, so that only one code can be tried for it
+	challenge, err := s.db.UseMFAChallenge(tokens.HashRefreshToken(response.Challenge))
+	if errors.Is(err, database.ErrMFAChallengeNotFound) {
+		SetError(w, ErrBadChallenge, http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		SetError(w, err, http.StatusInternalServerError)
+		return
+	}
+	// Check the challenge hasn't expired (DynamoDB is slow to delete them)
+	now := time.Now()
+	if time.Unix(challenge.Expires, 0).Before(now) {
+		SetError(w, ErrBadChallenge, http.StatusForbidden)
+		return
+	}
+	// Get the account
+	account, err := s.db.GetAccountById(challenge.AccountId)
+	if err != nil {
+		SetError(w, err, http.StatusInternalServerError)
+		return
+	}
+	login := database.Login{
+		AccountId: account.AccountId,
+		Timestamp: now.UnixNano(),
+		IP:        clientIP(r),
+		UserAgent: r.UserAgent(),
+	}
+	// Count the attempt before checking the code, unless attempts are being held back
+	sources := loginSources(account.Username, login.IP)
+	retry, err := s.claimLoginAttempt(sources, now)
+	if err != nil {
+		log.Printf("Failed to check login attempts: %v", err)
+		SetError(w, ErrLoginUnavailable, http.StatusInternalServerError)
+		return
+	}
+	if retry.After(now) {
+		login.Reason = loginReasonThrottled
+		s.recordLogin(login)
+		setRetryAfter(w, retry, now)
+		SetError(w, ErrTooManyLoginAttempts, http.StatusTooManyRequests)
+		return
+	}
+	// Check the code
+	valid, err := s.checkMFACode(account, response.Code, now)
+	if err != nil {
+		SetError(w, err, http.StatusInternalServerError)
+		return
+	}
+	if !valid {
+		// The attempt has already been counted, so guesses are slowed down
+		login.Reason = loginReasonCode
+		s.recordLogin(login)
+		SetError(w, ErrBadMFACode, http.StatusForbidden)
+		return
+	}
+	// Log in
+	s.loggedIn(login, account.Username)
+	s.writeTokens(w, account.AccountId, "")
+}
+
+// getMFAAccount gets the account to enrol, which mustn't have already enrolled
+// An error response is written if it can't be
+func (s *server) getMFAAccount(w http.ResponseWriter, r *http.Request) (*database.Account, bool) {
+	// Ensure the auth middleware provided us with the account ID
+	accountID, err := getAccountId(r.Context())
+	if err != nil {
+		SetError(w, ErrAccountIDMissing, http.StatusInternalServerError)
+		return nil, false
+	}
+	// Request the account
+	account, err := s.db.GetAccountById(accountID)
+	if err != nil {
+		SetError(w, err, http.StatusInternalServerError)
+		return nil, false
+	}
+	// Changing the secret must not be a way around needing a code
+	if account.MFA.Enabled {
+		SetError(w, ErrMFAEnabled, http.StatusConflict)
+		return nil, false
+	}
+	return account, true
+}
+
+// getMFAEnabledAccount gets an account whose two-factor authentication is to be changed
+// A code must be given, counted like a login attempt so it can't be guessed with just an access token
+// An error response is written if it can't be
+func (s *server) getMFAEnabledAccount(w http.ResponseWriter, r *http.Request) (*database.Account, bool) {
+	// Try to parse the body
+	var code models.MFACode
+	if err := json.NewDecoder(r.Body).Decode(&code); err != nil {
+		SetError(w, err, http.StatusBadRequest)
+		return nil, false
+	}
+	if err := shared.Validate.Struct(code); err != nil {
+		SetError(w, err, http.StatusBadRequest)
+		return nil, false
+	}
+	// Ensure the auth middleware provided us with the account ID
+	accountID, err := getAccountId(r.Context())
+	if err != nil {
+		SetError(w, ErrAccountIDMissing, http.StatusInternalServerError)
+		return nil, false
+	}
+	// Request the account
+	account, err := s.db.GetAccountById(accountID)
+	if err != nil {
+		SetError(w, err, http.StatusInternalServerError)
+		return nil, false
+	}
+	if !account.MFA.Enabled {
+		SetError(w, ErrMFANotEnabled, http.StatusConflict)
+		return nil, false
+	}
+	// Count the attempt before checking the code, unless attempts are being held back
+	now := time.Now()
+	sources := loginSources(account.Username, clientIP(r))
+	retry, err := s.claimLoginAttempt(sources, now)
+	if err != nil {
+		SetError(w, err, http.StatusInternalServerError)
+		return nil, false
+	}
+	if retry.After(now) {
+		setRetryAfter(w, retry, now)
+		SetError(w, ErrTooManyLoginAttempts, http.StatusTooManyRequests)
+		return nil, false
+	}
+	// Check the code
+	valid, err := s.checkMFACode(account, code.Code, now)
+	if err != nil {
+		SetError(w, err, http.StatusInternalServerError)
+		return nil, false
+	}
+	if !valid {
+		SetError(w, ErrBadMFACode, http.StatusForbidden)
+		return nil, false
+	}
+	s.forgetLoginAttempts(account.Username)
+	return account, true
+}
+
+// checkMFACode checks a code from the account's authenticator app, or one of its recovery codes
+// Each code can only be used once
+func (s *server) checkMFACode(account *database.Account, code string, now time.Time) (bool, error) {
+	// Codes from the app are all digits, unlike recovery codes
+	if len(code) == totp.Digits && strings.Trim(code, "0123456789") == "" {
+		step, valid, err := totp.Validate(account.MFA.Secret, code, now)
+		if err != nil || !valid {
+			return false, err
+		}
+		return s.db.UseMFAStep(account.AccountId, step)
+	}
+	return s.db.UseRecoveryCode(account.AccountId, hashRecoveryCode(code))
+}
+
+// writeMFAChallenge creates a challenge for the account, which must be completed with a code to get tokens
+func (s *server) writeMFAChallenge(w http.ResponseWriter, accountID string) {
+	// Limit how many codes can be tried at once
+	now := time.Now()
+	open, err := s.db.CountMFAChallenges(accountID, now)
+	if err != nil {
+		SetError(w, err, http.StatusInternalServerError)
+		return
+	}
+	if open >= maxMFAChallenges {
+		// The oldest challenge will have expired by then
+		setRetryAfter(w, now.Add(mfaChallengeDuration), now)
+		SetError(w, ErrTooManyChallenges, http.StatusTooManyRequests)
+		return
+	}
+	// Create a challenge, keeping only its hash
+	challenge, hash, err := tokens.NewRefreshToken()
+	if err != nil {
+		SetError(w, err, http.StatusInternalServerError)
+		return
+	}
+	err = s.db.CreateMFAChallenge(database.MFAChallenge{
+		Hash:      hash,
+		AccountId: accountID,
+		Expires:   now.Add(mfaChallengeDuration).Unix(),
+	})
+	if err != nil {
+		SetError(w, err, http.StatusInternalServerError)
+		return
+	}
+	// Build response content
+	body, err := json.Marshal(models.MFAChallenge{
+		Challenge: challenge,
+		AccountId: accountID,
+	})
+	if err != nil {
+		SetError(w, err, http.StatusInternalServerError)
+		return
+	}
+	// Write the response
+	w.WriteHeader(http.StatusAccepted)
+	w.Write(body)
+}
+
+// newRecoveryCodes creates random recovery codes, and the hashes they should be stored under
+func newRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		raw := make([]byte, recoveryCodeBytes)
+		if _, err := rand.Read(raw); err != nil {
+			return nil, nil, err
+		}
+		// Split the code in two, so it's easier to read
+		encoded := strings.ToLower(recoveryCodeEncoding.EncodeToString(raw))
+		codes[i] = fmt.Sprintf("%s-%s", encoded[:len(encoded)/2], encoded[len(encoded)/2:])
+		hashes[i] = hashRecoveryCode(codes[i])
+	}
+	return codes, hashes, nil
+}
+
+// hashRecoveryCode gets the hash a recovery code is stored under
+// Codes are hashed ignoring case and separators, in case they're typed in differently
+func hashRecoveryCode(code string) string {
+	normalised := strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	sum := sha256.Sum256([]byte(normalised))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,119 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+
+	"github.com/briggysmalls/detectordag/api/app/models"
+	"github.com/briggysmalls/detectordag/shared/shadow"
+	"github.com/gorilla/mux"
+)
+
+var (
+	ErrThresholdsOverlap = errors.New("Minimum temperature must be below the maximum")
+)
+
+func (s *server) GetDeviceProbes(w http.ResponseWriter, r *http.Request) {
+	// Get the device ID
+	id := mux.Vars(r)["deviceId"]
+	// Request the shadow
+	shdw, err := s.shadow.Get(id)
+	if err != nil {
+		SetError(w, err, http.StatusInternalServerError)
+		return
+	}
+	// Build the payload, in name order
+	names := probeNames(shdw.Temperature)
+	payload := make([]models.DeviceProbe, len(names))
+	for i, name := range names {
+		payload[i] = newDeviceProbe(name, shdw.Temperature)
+	}
+	// Build response content
+	body, err := json.Marshal(payload)
+	if err != nil {
+		SetError(w, err, http.StatusInternalServerError)
+		return
+	}
+	// Write the response
+	w.WriteHeader(http.StatusOK)
+	w.Write(body)
+}
+
+func (s *server) UpdateProbeThresholds(w http.ResponseWriter, r *http.Request) {
+	// Get the device ID and probe
+	vars := mux.Vars(r)
+	id, probe := vars["deviceId"], vars["probe"]
+	// Try to parse the body
+	var thresholds models.ProbeThresholds
+	if err := json.NewDecoder(r.Body).Decode(&thresholds); err != nil {
+		SetError(w, err, http.StatusBadRequest)
+		return
+	}
+	if thresholds.Min != nil && thresholds.Max != nil && *thresholds.Min >= *thresholds.Max {
+		SetError(w, ErrThresholdsOverlap, http.StatusBadRequest)
+		return
+	}
+	// Set the thresholds
+	shdw, err := s.shadow.UpdateProbeThresholds(id, probe, shadow.ProbeThresholds{
+		Min: thresholds.Min,
+		Max: thresholds.Max,
+	})
+	if err != nil {
+		SetError(w, err, http.StatusInternalServerError)
+		return
+	}
+	// Build response content
+	body, err := json.Marshal(newDeviceProbe(probe, shdw.Temperature))
+	if err != nil {
+		SetError(w, err, http.StatusInternalServerError)
+		return
+	}
+	// Write the response
+	w.WriteHeader(http.StatusOK)
+	w.Write(body)
+}
+
+// probeNames gets the names of all the probes we know about, in order
+// A probe may have thresholds before the device reports it, or history after it stops
+func probeNames(temperature shadow.TemperatureShadow) []string {
+	seen := map[string]bool{}
+	for _, reading := range temperature.Readings {
+		seen[reading.Probe] = true
+	}
+	for name := range temperature.Probes {
+		seen[name] = true
+	}
+	for name := range temperature.Thresholds {
+		seen[name] = true
+	}
+	names := make([]string, 0, len(seen))
+	for name := range seen {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// newDeviceProbe builds the payload for a probe from the device's shadow
+func newDeviceProbe(name string, temperature shadow.TemperatureShadow) models.DeviceProbe {
+	probe := temperature.Probes[name]
+	thresholds := temperature.Thresholds[name]
+	payload := models.DeviceProbe{
+		Probe:      name,
+		Thresholds: models.ProbeThresholds{Min: thresholds.Min, Max: thresholds.Max},
+		Alerting:   probe.Alerting,
+		History:    make([]models.DeviceTemperatureSample, len(probe.Samples)),
+	}
+	for _, reading := range temperature.Readings {
+		if reading.Probe == name {
+			celsius := reading.Celsius
+			payload.Celsius = &celsius
+		}
+	}
+	for i, sample := range probe.Samples {
+		payload.History[i] = models.DeviceTemperatureSample{Time: sample.Time, Celsius: sample.Celsius}
+	}
+	return payload
+}
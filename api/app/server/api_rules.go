@@ -0,0 +1,107 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/briggysmalls/detectordag/api/app/models"
+	"github.com/briggysmalls/detectordag/shared"
+	"github.com/briggysmalls/detectordag/shared/metrics"
+	"github.com/briggysmalls/detectordag/shared/shadow"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+// maxMetricRules is how many rules can be set up for a device
+const maxMetricRules = 20
+
+var (
+	ErrTooManyRules   = errors.New("Too many rules")
+	ErrDuplicateRules = errors.New("Rule IDs must be unique")
+)
+
+func (s *server) GetMetricRules(w http.ResponseWriter, r *http.Request) {
+	// Get the device ID
+	id := mux.Vars(r)["deviceId"]
+	// Request the shadow
+	shdw, err := s.shadow.Get(id)
+	if err != nil {
+		SetError(w, err, http.StatusInternalServerError)
+		return
+	}
+	// Write the response
+	writeMetricRules(w, shdw)
+}
+
+func (s *server) UpdateMetricRules(w http.ResponseWriter, r *http.Request) {
+	// Get the device ID
+	id := mux.Vars(r)["deviceId"]
+	// Try to parse the body
+	var updates []models.MutableMetricRule
+	if err := json.NewDecoder(r.Body).Decode(&updates); err != nil {
+		SetError(w, err, http.StatusBadRequest)
+		return
+	}
+	if len(updates) > maxMetricRules {
+		SetError(w, ErrTooManyRules, http.StatusBadRequest)
+		return
+	}
+	// Check the rules, giving an ID to any without
+	rules := make([]metrics.Rule, len(updates))
+	seen := map[string]bool{}
+	for i, update := range updates {
+		if err := shared.Validate.Struct(update); err != nil {
+			SetError(w, err, http.StatusBadRequest)
+			return
+		}
+		if update.ID == "" {
+			update.ID = uuid.New().String()
+		}
+		if seen[update.ID] {
+			SetError(w, ErrDuplicateRules, http.StatusBadRequest)
+			return
+		}
+		seen[update.ID] = true
+		rules[i] = metrics.Rule{
+			ID:        update.ID,
+			Metric:    update.Metric,
+			Condition: metrics.Condition(update.Condition),
+			Value:     update.Value,
+			For:       time.Duration(update.For) * time.Second,
+		}
+	}
+	// Replace the rules
+	shdw, err := s.shadow.UpdateMetricRules(id, rules)
+	if err != nil {
+		SetError(w, err, http.StatusInternalServerError)
+		return
+	}
+	// Write the response
+	writeMetricRules(w, shdw)
+}
+
+// writeMetricRules writes the rules set up for the device, and how they stand
+func writeMetricRules(w http.ResponseWriter, shdw *shadow.Shadow) {
+	payload := make([]models.MetricRule, len(shdw.Metrics.Rules))
+	for i, rule := range shdw.Metrics.Rules {
+		payload[i] = models.MetricRule{
+			ID:        rule.ID,
+			Metric:    rule.Metric,
+			Condition: string(rule.Condition),
+			Value:     rule.Value,
+			For:       int(rule.For.Seconds()),
+			Alerting:  shdw.Metrics.States[rule.ID].Alerting,
+		}
+	}
+	// Build response content
+	body, err := json.Marshal(payload)
+	if err != nil {
+		SetError(w, err, http.StatusInternalServerError)
+		return
+	}
+	// Write the response
+	w.WriteHeader(http.StatusOK)
+	w.Write(body)
+}
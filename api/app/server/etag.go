@@ -0,0 +1,49 @@
+package server
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/briggysmalls/detectordag/api/app/models"
+)
+
+// deviceETag gets the entity tag of a device payload
+// It changes with the device's shadow, and with the battery runtime (which runs down without the shadow changing)
+func deviceETag(version int, device models.Device) string {
+	return fmt.Sprintf(`"%d%s"`, version, runtimeTag(device))
+}
+
+// devicesETag gets the entity tag of a list of device payloads
+// It changes if any of the devices' tags would, or the list of devices does
+func devicesETag(ids []string, versions []int, devices []models.Device) string {
+	hash := sha1.New()
+	for i, id := range ids {
+		fmt.Fprintf(hash, "%s:%d%s\n", id, versions[i], runtimeTag(devices[i]))
+	}
+	return fmt.Sprintf(`"%x"`, hash.Sum(nil))
+}
+
+// runtimeTag gets the part of an entity tag for the device's battery runtime, if it has one
+func runtimeTag(device models.Device) string {
+	if device.State == nil || device.State.Battery == nil || device.State.Battery.Runtime == 0 {
+		return ""
+	}
+	return fmt.Sprintf("-%d", device.State.Battery.Runtime)
+}
+
+// notModified sets the entity tag of the response, and indicates whether the client already has it
+// If so, a 'not modified' response is written
+func notModified(w http.ResponseWriter, r *http.Request, etag string) bool {
+	w.Header().Set("ETag", etag)
+	for _, candidate := range strings.Split(r.Header.Get("If-None-Match"), ",") {
+		// Comparison is weak, so any 'weak' indicator is ignored
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == etag || candidate == "*" {
+			w.WriteHeader(http.StatusNotModified)
+			return true
+		}
+	}
+	return false
+}
@@ -32,10 +32,32 @@ type server struct {
 
 type Server interface {
 	Auth(w http.ResponseWriter, r *http.Request)
+	Refresh(w http.ResponseWriter, r *http.Request)
+	Logout(w http.ResponseWriter, r *http.Request)
+	CompleteMFA(w http.ResponseWriter, r *http.Request)
+	GetKeys(w http.ResponseWriter, r *http.Request)
 	GetAccount(w http.ResponseWriter, r *http.Request)
 	GetDevices(w http.ResponseWriter, r *http.Request)
 	UpdateAccount(w http.ResponseWriter, r *http.Request)
+	GetLogins(w http.ResponseWriter, r *http.Request)
+	EnrolMFA(w http.ResponseWriter, r *http.Request)
+	ConfirmMFA(w http.ResponseWriter, r *http.Request)
+	DisableMFA(w http.ResponseWriter, r *http.Request)
+	CreateRecoveryCodes(w http.ResponseWriter, r *http.Request)
+	GetDevice(w http.ResponseWriter, r *http.Request)
 	UpdateDevice(w http.ResponseWriter, r *http.Request)
+	PingDevice(w http.ResponseWriter, r *http.Request)
+	GetDeviceConfig(w http.ResponseWriter, r *http.Request)
+	UpdateDeviceConfig(w http.ResponseWriter, r *http.Request)
+	GetDeviceDiagnostics(w http.ResponseWriter, r *http.Request)
+	GetDeviceProbes(w http.ResponseWriter, r *http.Request)
+	UpdateProbeThresholds(w http.ResponseWriter, r *http.Request)
+	GetMetricRules(w http.ResponseWriter, r *http.Request)
+	UpdateMetricRules(w http.ResponseWriter, r *http.Request)
+	UpdateDeviceAppliance(w http.ResponseWriter, r *http.Request)
+	GetMaintenanceWindows(w http.ResponseWriter, r *http.Request)
+	CreateMaintenanceWindow(w http.ResponseWriter, r *http.Request)
+	DeleteMaintenanceWindow(w http.ResponseWriter, r *http.Request)
 }
 
 func New(db database.Client, shadow shadow.Client, email email.Verifier, iot iot.Client, tokens tokens.Tokens) Server {
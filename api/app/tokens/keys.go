@@ -0,0 +1,83 @@
+package tokens
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+var (
+	ErrBadKey             = errors.New("Key must be a PEM-encoded RSA or Ed25519 private key")
+	ErrNoSigningKey       = errors.New("Exactly one key must be current (not retired)")
+	ErrDuplicateKeyID     = errors.New("Key IDs must be unique")
+	ErrInsufficientGrace  = errors.New("Retired keys must be accepted for at least as long as tokens last")
+	ErrUnknownKey         = errors.New("Token signed by an unknown or retired key")
+	ErrMissingKeyIDHeader = errors.New("Token doesn't say which key signed it")
+)
+
+// Key is a key that tokens are signed with, or were signed with until it was retired
+type Key struct {
+	// ID is put in the header of tokens ('kid'), so they can be matched with the key
+	ID string
+	// Retired is when the key stopped being used to sign tokens (zero if it hasn't)
+	Retired time.Time
+	method  jwt.SigningMethod
+	private crypto.Signer
+}
+
+// ParseKey parses a PEM-encoded RSA (signing with RS256) or Ed25519 (signing with EdDSA) private key
+func ParseKey(id string, pemKey string, retired time.Time) (*Key, error) {
+	block, _ := pem.Decode([]byte(pemKey))
+	if block == nil {
+		return nil, ErrBadKey
+	}
+	// Parse the key, which could be in either of the common formats
+	var private interface{}
+	var err error
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		private, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		private, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	default:
+		return nil, ErrBadKey
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse key '%s': %w", id, err)
+	}
+	// Pick the signing method for the type of key
+	key := &Key{ID: id, Retired: retired}
+	switch private := private.(type) {
+	case *rsa.PrivateKey:
+		key.method = jwt.SigningMethodRS256
+		key.private = private
+	case ed25519.PrivateKey:
+		key.method = SigningMethodEdDSA
+		key.private = private
+	default:
+		return nil, ErrBadKey
+	}
+	return key, nil
+}
+
+// Algorithm gets the JWS algorithm the key signs tokens with
+func (k *Key) Algorithm() string {
+	return k.method.Alg()
+}
+
+// Public gets the public part of the key, that tokens are verified with
+func (k *Key) Public() crypto.PublicKey {
+	return k.private.Public()
+}
+
+// accepted indicates whether tokens signed with the key are still accepted
+func (k *Key) accepted(now time.Time, grace time.Duration) bool {
+	return k.Retired.IsZero() || now.Before(k.Retired.Add(grace))
+}
@@ -0,0 +1,36 @@
+package tokens
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestParseKey(t *testing.T) {
+	// Check the supported keys sign with the right algorithm
+	assert.Equal(t, "RS256", createRSAKey(t, "rsa", time.Time{}).Algorithm())
+	assert.Equal(t, "EdDSA", createEd25519Key(t, "ed25519", time.Time{}).Algorithm())
+	// Create an unsupported key
+	private, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	assert.NoError(t, err)
+	// Create some test inputs
+	testParams := []struct {
+		key   string
+		error error
+	}{
+		{key: "not a key", error: ErrBadKey},
+		{key: string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})), error: ErrBadKey},
+		{key: string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})), error: ErrBadKey},
+	}
+	for _, params := range testParams {
+		_, err := ParseKey("key", params.key, time.Time{})
+		assert.Equal(t, params.error, err)
+	}
+}
@@ -1,14 +1,22 @@
 package tokens
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"errors"
 	"github.com/dgrijalva/jwt-go"
+	"github.com/google/uuid"
 	"log"
+	"sort"
 	"time"
 )
 
 const (
 	issuer = "detectordag"
+	// refreshTokenBytes is how much randomness is in a refresh token
+	refreshTokenBytes = 32
 )
 
 var (
@@ -19,12 +27,15 @@ var (
 
 type Tokens interface {
 	Create(accountID string) (string, error)
-	Validate(token string) (string, error)
+	Validate(token string) (*CustomAuthClaims, error)
+	Keys() []*Key
 }
 
 type tokens struct {
-	secret   string
+	keys     map[string]*Key
+	signing  *Key
 	duration time.Duration
+	grace    time.Duration
 }
 
 type CustomAuthClaims struct {
@@ -32,11 +43,35 @@ type CustomAuthClaims struct {
 	jwt.StandardClaims
 }
 
-func New(secret string, duration time.Duration) Tokens {
-	return &tokens{
-		secret:   secret,
+// New creates tokens that are signed with the current key, and accepted if signed by any key
+// Tokens signed with a retired key are accepted for the grace period after it is retired
+func New(keys []*Key, duration time.Duration, grace time.Duration) (Tokens, error) {
+	// Tokens signed just before a key is retired must last until they expire
+	if grace < duration {
+		return nil, ErrInsufficientGrace
+	}
+	t := tokens{
+		keys:     map[string]*Key{},
 		duration: duration,
+		grace:    grace,
+	}
+	for _, key := range keys {
+		if _, ok := t.keys[key.ID]; ok {
+			return nil, ErrDuplicateKeyID
+		}
+		t.keys[key.ID] = key
+		// Find the key to sign new tokens with
+		if key.Retired.IsZero() {
+			if t.signing != nil {
+				return nil, ErrNoSigningKey
+			}
+			t.signing = key
+		}
+	}
+	if t.signing == nil {
+		return nil, ErrNoSigningKey
 	}
+	return &t, nil
 }
 
 func (t *tokens) Create(accountID string) (string, error) {
@@ -44,27 +79,42 @@ func (t *tokens) Create(accountID string) (string, error) {
 	claims := CustomAuthClaims{
 		accountID,
 		jwt.StandardClaims{
+			// The ID lets the token be revoked before it expires
+			Id:        uuid.New().String(),
 			ExpiresAt: time.Now().Add(t.duration).Unix(),
 			Issuer:    issuer,
 		},
 	}
 	// Create a new token object, specifying signing method and the claims
 	// you would like it to contain.
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString([]byte(t.secret))
+	token := jwt.NewWithClaims(t.signing.method, claims)
+	// Say which key signed the token, so it can be verified after the key is rotated
+	token.Header["kid"] = t.signing.ID
+	return token.SignedString(t.signing.private)
 }
 
-// Validate checks that the provided token is valid
-func (t *tokens) Validate(tokenString string) (string, error) {
+// Validate checks that the provided token is valid, and gets its claims
+// It is up to the caller to check the token hasn't been revoked
+func (t *tokens) Validate(tokenString string) (*CustomAuthClaims, error) {
 	// Parse takes the token string and a function for looking up the key.
 	token, err := jwt.ParseWithClaims(tokenString, &CustomAuthClaims{}, func(token *jwt.Token) (interface{}, error) {
-		// Validate the alg is what we expect
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+		// Find the key that signed the token
+		kid, ok := token.Header["kid"].(string)
+		if !ok {
+			return nil, ErrMissingKeyIDHeader
+		}
+		key, ok := t.keys[kid]
+		if !ok || !key.accepted(jwt.TimeFunc(), t.grace) {
+			log.Printf("Unknown or retired key: %s", kid)
+			return nil, ErrUnknownKey
+		}
+		// Validate the alg is what we expect for the key
+		if token.Method.Alg() != key.Algorithm() {
 			log.Printf("Unexpected signing method: %v", token.Header["alg"])
 			return nil, ErrUnexpectedSigningMethod
 		}
-		// Return our secret
-		return []byte(t.secret), nil
+		// Return the key's public part
+		return key.Public(), nil
 	})
 	// Short-circuit on the happy path
 	if err == nil {
@@ -72,24 +122,63 @@ func (t *tokens) Validate(tokenString string) (string, error) {
 		claims, ok := token.Claims.(*CustomAuthClaims)
 		if !ok || !token.Valid {
 			// We'd expect to have already returned due to 'err'
-			return "", ErrInternalError
+			return nil, ErrInternalError
+		}
+		// Tokens without an ID couldn't be revoked
+		if claims.Id == "" {
+			return nil, ErrBadToken
 		}
-		return claims.AccountId, nil
+		return claims, nil
 	}
 	// Parse the JWS library error
 	vErr, ok := err.(*jwt.ValidationError)
 	if !ok {
 		// A ParseWithClaims error should always be a jwt.ValidationError
-		return "", ErrInternalError
+		return nil, ErrInternalError
 	}
-	// If we have a signing error due to an incorrect algorithm, it's _their_ fault
-	if vErr.Errors&jwt.ValidationErrorSignatureInvalid == 0 && vErr.Inner == ErrUnexpectedSigningMethod {
-		return "", ErrBadToken
+	// If we have a signing error due to an incorrect algorithm or key, it's _their_ fault
+	if vErr.Errors&jwt.ValidationErrorSignatureInvalid == 0 && isKeyError(vErr.Inner) {
+		return nil, ErrBadToken
 	}
 	// Remap errors to ones we care about
 	if vErr.Errors&jwt.ValidationErrorUnverifiable != 0 || vErr.Errors&jwt.ValidationErrorSignatureInvalid != 0 {
-		return "", ErrInternalError
+		return nil, ErrInternalError
 	}
 	// The token was parsed fine, but failed some claim
-	return "", ErrBadToken
+	return nil, ErrBadToken
+}
+
+// Keys gets the keys that tokens are currently accepted from, so they can be published
+func (t *tokens) Keys() []*Key {
+	now := jwt.TimeFunc()
+	keys := []*Key{}
+	for _, key := range t.keys {
+		if key.accepted(now, t.grace) {
+			keys = append(keys, key)
+		}
+	}
+	// Keep the order stable, so the published set only changes with the keys
+	sort.Slice(keys, func(i, j int) bool { return keys[i].ID < keys[j].ID })
+	return keys
+}
+
+// isKeyError indicates whether a token couldn't be verified because of the key (or algorithm) it claims to use
+func isKeyError(err error) bool {
+	return err == ErrUnexpectedSigningMethod || err == ErrUnknownKey || err == ErrMissingKeyIDHeader
+}
+
+// NewRefreshToken creates a random refresh token, and the hash it should be stored under
+func NewRefreshToken() (string, string, error) {
+	raw := make([]byte, refreshTokenBytes)
+	if _, err := rand.Read(raw); err != nil {
+		return "", "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(raw)
+	return token, HashRefreshToken(token), nil
+}
+
+// HashRefreshToken gets the hash a refresh token is stored under
+func HashRefreshToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
 }
@@ -0,0 +1,93 @@
+// Package totp implements time-based one-time passwords (RFC 6238), as used by authenticator apps
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// Digits is how long codes are
+	Digits = 6
+	// Period is how long each code lasts
+	Period = 30 * time.Second
+	// secretBytes is how much randomness is in a secret (the length of an SHA1 HMAC)
+	secretBytes = 20
+	// skew is how many periods either side of now are accepted, allowing for clocks being out
+	skew = 1
+)
+
+// encoding is how secrets are given to authenticator apps
+var encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// NewSecret creates a random secret, base32-encoded
+func NewSecret() (string, error) {
+	raw := make([]byte, secretBytes)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	return encoding.EncodeToString(raw), nil
+}
+
+// URI gets the provisioning URI for a secret, which authenticator apps read from a QR code
+func URI(issuer, account, secret string) string {
+	label := url.PathEscape(fmt.Sprintf("%s:%s", issuer, account))
+	params := url.Values{}
+	params.Set("secret", secret)
+	params.Set("issuer", issuer)
+	params.Set("algorithm", "SHA1")
+	params.Set("digits", fmt.Sprint(Digits))
+	params.Set("period", fmt.Sprint(int(Period.Seconds())))
+	return fmt.Sprintf("otpauth://totp/%s?%s", label, params.Encode())
+}
+
+// Step gets the time step that a time falls in
+func Step(at time.Time) int64 {
+	return at.Unix() / int64(Period.Seconds())
+}
+
+// Code gets the code for a secret during a time step
+func Code(secret string, step int64) (string, error) {
+	key, err := encoding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", fmt.Errorf("Bad TOTP secret: %w", err)
+	}
+	// Take the HMAC of the step
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+	// Dynamically truncate it (RFC 4226 section 5.3)
+	offset := sum[len(sum)-1] & 0xf
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	modulo := uint32(1)
+	for i := 0; i < Digits; i++ {
+		modulo *= 10
+	}
+	return fmt.Sprintf("%0*d", Digits, value%modulo), nil
+}
+
+// Validate checks a code against a secret, allowing for a little clock skew
+// If it's valid, the time step it was for is given, so the caller can stop it being used again
+func Validate(secret, code string, at time.Time) (int64, bool, error) {
+	now := Step(at)
+	for step := now - skew; step <= now+skew; step++ {
+		expected, err := Code(secret, step)
+		if err != nil {
+			return 0, false, err
+		}
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return step, true, nil
+		}
+	}
+	return 0, false, nil
+}
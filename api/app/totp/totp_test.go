@@ -0,0 +1,76 @@
+package totp
+
+import (
+	"encoding/base32"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCode(t *testing.T) {
+	// The SHA1 test vectors from RFC 6238 (keeping the last six digits)
+	secret := base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+	testParams := []struct {
+		at   int64
+		code string
+	}{
+		{at: 59, code: "287082"},
+		{at: 1111111109, code: "081804"},
+		{at: 1111111111, code: "050471"},
+		{at: 1234567890, code: "005924"},
+		{at: 2000000000, code: "279037"},
+		{at: 20000000000, code: "353130"},
+	}
+	for _, params := range testParams {
+		code, err := Code(secret, Step(time.Unix(params.at, 0)))
+		assert.NoError(t, err)
+		assert.Equal(t, params.code, code)
+	}
+	// Check a bad secret is rejected
+	_, err := Code("not base32!", 1)
+	assert.Error(t, err)
+}
+
+func TestValidate(t *testing.T) {
+	// A fixed secret, so that none of the wrong codes can happen to be right
+	secret := base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+	now := time.Unix(1587200400, 0)
+	code, err := Code(secret, Step(now))
+	assert.NoError(t, err)
+	// Create some test inputs
+	testParams := []struct {
+		code  string
+		at    time.Time
+		valid bool
+	}{
+		{code: code, at: now, valid: true},
+		// A little clock skew is allowed
+		{code: code, at: now.Add(Period), valid: true},
+		{code: code, at: now.Add(-Period), valid: true},
+		// But not too much
+		{code: code, at: now.Add(2 * Period)},
+		{code: "000000", at: now},
+		{code: "", at: now},
+	}
+	for _, params := range testParams {
+		step, valid, err := Validate(secret, params.code, params.at)
+		assert.NoError(t, err)
+		assert.Equal(t, params.valid, valid)
+		if valid {
+			assert.Equal(t, Step(now), step)
+		}
+	}
+}
+
+func TestURI(t *testing.T) {
+	uri, err := url.Parse(URI("detectordag", "user@example.com", "JBSWY3DPEHPK3PXP"))
+	assert.NoError(t, err)
+	assert.Equal(t, "otpauth", uri.Scheme)
+	assert.Equal(t, "totp", uri.Host)
+	assert.Equal(t, "/detectordag:user@example.com", uri.Path)
+	assert.Equal(t, "JBSWY3DPEHPK3PXP", uri.Query().Get("secret"))
+	assert.Equal(t, "detectordag", uri.Query().Get("issuer"))
+	assert.Equal(t, "6", uri.Query().Get("digits"))
+}
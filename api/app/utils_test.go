@@ -9,6 +9,8 @@ package app
 import (
 	"bytes"
 	"github.com/briggysmalls/detectordag/api/app/server"
+	"github.com/briggysmalls/detectordag/api/app/tokens"
+	"github.com/dgrijalva/jwt-go"
 	"github.com/golang/mock/gomock"
 	"github.com/gorilla/mux"
 	"github.com/stretchr/testify/assert"
@@ -19,6 +21,9 @@ import (
 	"time"
 )
 
+// testTokenID is the ID of the token used in tests
+const testTokenID = "0b9c6a52-4d3e-4f0a-8c1e-7a5d2f6b3e91"
+
 var testDuration time.Duration
 
 func init() {
@@ -44,8 +49,18 @@ func createRealRouter(t *testing.T) (*MockDBClient, *MockShadowClient, *MockVeri
 	tokens := NewMockTokens(ctrl)
 	// Create real server
 	s := server.New(db, shadow, email, iot, tokens)
+	// Let the auth middleware know the test token hasn't been revoked
+	db.EXPECT().IsTokenRevoked(testTokenID).Return(false, nil).AnyTimes()
 	// Create the new router
-	return db, shadow, email, iot, tokens, NewRouter(iot, s, tokens)
+	return db, shadow, email, iot, tokens, NewRouter(iot, db, s, tokens)
+}
+
+// testClaims gets the claims of the test token, when it authenticates the given account
+func testClaims(accountID string) *tokens.CustomAuthClaims {
+	return &tokens.CustomAuthClaims{
+		AccountId:      accountID,
+		StandardClaims: jwt.StandardClaims{Id: testTokenID},
+	}
 }
 
 func runHandler(router *mux.Router, req *http.Request) *httptest.ResponseRecorder {
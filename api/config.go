@@ -1,14 +1,36 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
+	"github.com/briggysmalls/detectordag/api/app/tokens"
 	"github.com/kelseyhightower/envconfig"
 	"time"
 )
 
 type config struct {
-	JwtSecret   string `split_words:"true"`
-	JwtDuration string `split_words:"true"`
+	// Keys tokens are signed with, as JSON (see keyConfig)
+	JwtKeys     keyConfigs `split_words:"true" required:"true"`
+	JwtDuration string     `split_words:"true"`
+	// How long tokens signed with a retired key are still accepted for (defaults to the token duration)
+	JwtKeyGrace string `split_words:"true"`
+}
+
+// keyConfig describes a key that tokens are signed with
+// Rotate keys by adding a new one, and setting 'retired' on the old one
+type keyConfig struct {
+	ID string `json:"kid"`
+	// PEM-encoded RSA or Ed25519 private key
+	Key string `json:"key"`
+	// When the key stopped signing tokens (unset for the current key)
+	Retired time.Time `json:"retired"`
+}
+
+type keyConfigs []keyConfig
+
+// Decode parses the keys from their JSON representation
+func (k *keyConfigs) Decode(value string) error {
+	return json.Unmarshal([]byte(value), k)
 }
 
 func loadConfig() (*config, error) {
@@ -27,9 +49,32 @@ func loadConfig() (*config, error) {
 	if dur.Seconds() < 1 {
 		return nil, fmt.Errorf("JWT expiry duration insufficient: %f", dur.Seconds())
 	}
+	// Ensure grace period is valid
+	if _, err := c.ParseGrace(); err != nil {
+		return nil, err
+	}
 	return &c, nil
 }
 
 func (c *config) ParseDuration() (time.Duration, error) {
 	return time.ParseDuration(c.JwtDuration)
 }
+
+func (c *config) ParseGrace() (time.Duration, error) {
+	if c.JwtKeyGrace == "" {
+		return c.ParseDuration()
+	}
+	return time.ParseDuration(c.JwtKeyGrace)
+}
+
+func (c *config) ParseKeys() ([]*tokens.Key, error) {
+	keys := []*tokens.Key{}
+	for _, k := range c.JwtKeys {
+		key, err := tokens.ParseKey(k.ID, k.Key, k.Retired)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
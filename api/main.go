@@ -52,7 +52,7 @@ func init() {
 	// Create the server
 	s := server.New(db, shadow, verifier, iot, tokens)
 	// Create the router
-	r := app.NewRouter(iot, s, createTokens())
+	r := app.NewRouter(iot, db, s, tokens)
 	// Create an adapter for aws lambda
 	adapter = gorillamux.New(r)
 }
@@ -79,8 +79,18 @@ func createTokens() tokens.Tokens {
 	if err != nil {
 		shared.LogErrorAndExit(err)
 	}
-	// Get the token duration
+	// Get the token duration, and how long retired keys are still accepted
 	tokenDuration, _ := c.ParseDuration()
+	grace, _ := c.ParseGrace()
+	// Get the keys
+	keys, err := c.ParseKeys()
+	if err != nil {
+		shared.LogErrorAndExit(err)
+	}
 	// Create a tokens
-	return tokens.New(c.JwtSecret, tokenDuration)
+	t, err := tokens.New(keys, tokenDuration, grace)
+	if err != nil {
+		shared.LogErrorAndExit(err)
+	}
+	return t
 }
@@ -0,0 +1,106 @@
+package app
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/briggysmalls/detectordag/shared"
+	"github.com/briggysmalls/detectordag/shared/discharge"
+	"github.com/briggysmalls/detectordag/shared/email"
+	"github.com/briggysmalls/detectordag/shared/shadow"
+	"github.com/briggysmalls/detectordag/shared/state"
+	"github.com/briggysmalls/detectordag/shared/telemetry"
+)
+
+// DefaultLowBatteryThreshold is the charge (percent) below which a battery is low,
+// for devices that haven't been configured otherwise
+const DefaultLowBatteryThreshold = 20
+
+// BatteryUpdatedEvent is sent by the 'BatteryDischarging' IoT rule
+type BatteryUpdatedEvent struct {
+	DeviceId  string `validate:"required"`
+	Timestamp int64  `validate:"required"`
+	Status    string `validate:"required,eq=on|eq=off"`
+	Battery   struct {
+		Percent  int `validate:"min=0,max=100"`
+		Charging bool
+	}
+	PreviousPercent int `validate:"min=0,max=100"`
+}
+
+type app struct {
+	*telemetry.Clients
+}
+
+type App interface {
+	HandleRequest(ctx context.Context, event BatteryUpdatedEvent) error
+}
+
+// New gets an App that warns accounts when a device's battery runs low during a power cut
+func New(clients *telemetry.Clients) App {
+	return &app{Clients: clients}
+}
+
+// HandleRequest handles a lambda call
+func (a *app) HandleRequest(ctx context.Context, event BatteryUpdatedEvent) error {
+	// Print the event
+	log.Printf("%v\n", event)
+	// Validate the event
+	if err := shared.Validate.Struct(event); err != nil {
+		return err
+	}
+	// The battery only matters when it's powering the device
+	if event.Status != shadow.POWER_STATUS_OFF || event.Battery.Charging {
+		return nil
+	}
+	// Get the device shadow
+	shdw, err := a.Shadow.Get(event.DeviceId)
+	if err != nil {
+		return err
+	}
+	// Record the reading, to estimate how long the battery will last
+	sample := shadow.BatterySample{Percent: event.Battery.Percent, Time: time.Unix(event.Timestamp, 0)}
+	shdw.Discharge = discharge.Record(shdw.Discharge, event.PreviousPercent, sample)
+	// Only notify once per discharge, when the charge is below the threshold
+	threshold := LowBatteryThreshold(shdw.Config)
+	if event.Battery.Percent >= threshold || shdw.Discharge.Alerted {
+		if err := a.Shadow.UpdateDischarge(event.DeviceId, shdw.Discharge); err != nil {
+			log.Printf("Failed to record discharge of device '%s': %v", event.DeviceId, err)
+		}
+		return nil
+	}
+	log.Printf("Battery of device '%s' has fallen to %d%%", event.DeviceId, event.Battery.Percent)
+	// Get the account
+	account, err := a.Account(event.DeviceId)
+	if err != nil {
+		return err
+	}
+	// We are connected if we've been given a battery update
+	current, err := state.New(shadow.CONNECTION_STATUS_CONNECTED, event.Status)
+	if err != nil {
+		return err
+	}
+	// Send 'battery low' emails
+	update := telemetry.NewContext(shdw, account, time.Unix(event.Timestamp, 0))
+	update.Runtime, _ = discharge.Remaining(shdw, update.Time)
+	log.Printf("Send emails to: %s", account.Emails)
+	if err := a.Emailer.SendAlert(account.Emails, email.LowBattery, current, update); err != nil {
+		return shared.LogErrorAndReturn(err)
+	}
+	// Remember we've warned about this discharge
+	shdw.Discharge.Alerted = true
+	return a.Shadow.UpdateDischarge(event.DeviceId, shdw.Discharge)
+}
+
+// LowBatteryThreshold gets the charge (percent) below which a device's battery is low
+// The desired configuration is used, as it is what the account has asked for
+func LowBatteryThreshold(config shadow.ConfigShadow) int {
+	if config.Desired.LowBatteryThreshold != 0 {
+		return config.Desired.LowBatteryThreshold
+	}
+	if config.Reported.LowBatteryThreshold != 0 {
+		return config.Reported.LowBatteryThreshold
+	}
+	return DefaultLowBatteryThreshold
+}
@@ -0,0 +1,196 @@
+package app
+
+//go:generate go run github.com/golang/mock/mockgen -destination mock_db.go -package app -mock_names Client=MockDBClient github.com/briggysmalls/detectordag/shared/database Client
+//go:generate go run github.com/golang/mock/mockgen -destination mock_iot.go -package app -mock_names Client=MockIoTClient github.com/briggysmalls/detectordag/shared/iot Client
+//go:generate go run github.com/golang/mock/mockgen -destination mock_shadow.go -package app -mock_names Client=MockShadowClient github.com/briggysmalls/detectordag/shared/shadow Client
+//go:generate go run github.com/golang/mock/mockgen -destination mock_email.go -package app github.com/briggysmalls/detectordag/shared/email Emailer
+
+import (
+	"testing"
+	"time"
+
+	"github.com/briggysmalls/detectordag/shared/database"
+	"github.com/briggysmalls/detectordag/shared/discharge"
+	"github.com/briggysmalls/detectordag/shared/email"
+	"github.com/briggysmalls/detectordag/shared/iot"
+	"github.com/briggysmalls/detectordag/shared/shadow"
+	"github.com/briggysmalls/detectordag/shared/state"
+	"github.com/briggysmalls/detectordag/shared/telemetry"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	deviceID  = "792ac520-0733-4ffe-8137-8aba3ca446d7"
+	accountID = "c6d62b30-00ac-49c4-9268-88559a46889f"
+	timestamp = 1584803414
+)
+
+type mocks struct {
+	db      *MockDBClient
+	iot     *MockIoTClient
+	shadow  *MockShadowClient
+	emailer *MockEmailer
+}
+
+func TestLowBattery(t *testing.T) {
+	testParams := []struct {
+		previous  int
+		percent   int
+		threshold int
+		alerted   bool
+		notified  bool
+	}{
+		// Falling below the default threshold
+		{previous: 21, percent: 19, notified: true},
+		{previous: 20, percent: 19, notified: true},
+		// Already warned during this discharge
+		{previous: 19, percent: 18, alerted: true, notified: false},
+		// Already below the threshold, but not yet warned (e.g. the threshold was raised)
+		{previous: 19, percent: 18, notified: true},
+		// Still above the threshold
+		{previous: 30, percent: 25, notified: false},
+		// Falling below a configured threshold
+		{previous: 30, percent: 25, threshold: 30, notified: true},
+		{previous: 21, percent: 19, threshold: 10, notified: false},
+	}
+	for _, params := range testParams {
+		// Create app under test
+		app, m := getStubbedApp(t)
+		// Return the shadow of a device that's discharging
+		earlier := shadow.BatterySample{Percent: params.previous, Time: time.Unix(timestamp, 0).Add(-time.Hour)}
+		shdw := &shadow.Shadow{
+			Name:      "My Dag",
+			Config:    shadow.ConfigShadow{Desired: shadow.DeviceConfig{LowBatteryThreshold: params.threshold}},
+			Discharge: shadow.DischargeShadow{Samples: []shadow.BatterySample{earlier}, Alerted: params.alerted},
+		}
+		m.shadow.EXPECT().Get(deviceID).Return(shdw, nil)
+		// Expect the reading to be recorded, along with whether the account has been warned
+		expected := discharge.Record(shdw.Discharge, params.previous, shadow.BatterySample{Percent: params.percent, Time: time.Unix(timestamp, 0)})
+		expected.Alerted = params.alerted || params.notified
+		if params.notified {
+			// Expect the account to be notified, and the warning to be remembered
+			gomock.InOrder(
+				m.iot.EXPECT().GetThing(deviceID).Return(&iot.Device{DeviceId: deviceID, AccountId: accountID}, nil),
+				m.db.EXPECT().GetAccountById(accountID).Return(&database.Account{AccountId: accountID, Emails: []string{"owner@example.com"}}, nil),
+				m.emailer.EXPECT().SendAlert(
+					[]string{"owner@example.com"},
+					email.LowBattery,
+					state.Off,
+					email.ContextData{DeviceName: "My Dag", Time: time.Unix(timestamp, 0)},
+				),
+				m.shadow.EXPECT().UpdateDischarge(deviceID, expected),
+			)
+		} else {
+			m.shadow.EXPECT().UpdateDischarge(deviceID, expected)
+		}
+		// Run the test
+		event := createEvent(shadow.POWER_STATUS_OFF, params.previous, params.percent, false)
+		assert.NoError(t, app.HandleRequest(nil, event))
+	}
+}
+
+func TestDischargeRecorded(t *testing.T) {
+	// Create app under test
+	app, m := getStubbedApp(t)
+	// Return a shadow of a device that's been discharging for a while
+	start := time.Unix(timestamp, 0).Add(-2 * time.Hour)
+	shdw := &shadow.Shadow{
+		Name:    "My Dag",
+		Power:   shadow.PowerShadow{Value: shadow.POWER_STATUS_OFF},
+		Battery: &shadow.BatteryShadow{Percent: 19},
+		Discharge: shadow.DischargeShadow{Samples: []shadow.BatterySample{
+			{Percent: 30, Time: start},
+			{Percent: 21, Time: start.Add(time.Hour)},
+		}, Rate: 9},
+	}
+	gomock.InOrder(
+		m.shadow.EXPECT().Get(deviceID).Return(shdw, nil),
+		m.iot.EXPECT().GetThing(deviceID).Return(&iot.Device{DeviceId: deviceID, AccountId: accountID}, nil),
+		m.db.EXPECT().GetAccountById(accountID).Return(&database.Account{AccountId: accountID, Emails: []string{"owner@example.com"}}, nil),
+		// Expect the email to say how long the battery will last
+		m.emailer.EXPECT().SendAlert(gomock.Any(), email.LowBattery, state.Off, email.ContextData{
+			DeviceName: "My Dag",
+			Time:       time.Unix(timestamp, 0),
+			Runtime:    3*time.Hour + 27*time.Minute,
+		}),
+		// Expect the reading to be recorded
+		m.shadow.EXPECT().UpdateDischarge(deviceID, shadow.DischargeShadow{Samples: []shadow.BatterySample{
+			{Percent: 30, Time: start},
+			{Percent: 21, Time: start.Add(time.Hour)},
+			{Percent: 19, Time: time.Unix(timestamp, 0)},
+		}, Rate: 5.5, Alerted: true}),
+	)
+	// Run the test
+	assert.NoError(t, app.HandleRequest(nil, createEvent(shadow.POWER_STATUS_OFF, 21, 19, false)))
+}
+
+func TestBatteryIgnored(t *testing.T) {
+	testParams := []BatteryUpdatedEvent{
+		// The power is on
+		createEvent(shadow.POWER_STATUS_ON, 21, 19, false),
+		// The battery is charging
+		createEvent(shadow.POWER_STATUS_OFF, 21, 19, true),
+	}
+	for _, event := range testParams {
+		// Create app under test, which expects nothing to be fetched or sent
+		app, _ := getStubbedApp(t)
+		// Run the test
+		assert.NoError(t, app.HandleRequest(nil, event))
+	}
+}
+
+func TestInvalidEvent(t *testing.T) {
+	testParams := []BatteryUpdatedEvent{
+		createEvent("dummy", 21, 19, false),
+		createEvent(shadow.POWER_STATUS_OFF, 21, 120, false),
+		{Status: shadow.POWER_STATUS_OFF},
+	}
+	for _, event := range testParams {
+		app, _ := getStubbedApp(t)
+		assert.Error(t, app.HandleRequest(nil, event))
+	}
+}
+
+func TestLowBatteryThreshold(t *testing.T) {
+	testParams := []struct {
+		config    shadow.ConfigShadow
+		threshold int
+	}{
+		{threshold: DefaultLowBatteryThreshold},
+		{config: shadow.ConfigShadow{Reported: shadow.DeviceConfig{LowBatteryThreshold: 15}}, threshold: 15},
+		{config: shadow.ConfigShadow{
+			Desired:  shadow.DeviceConfig{LowBatteryThreshold: 30},
+			Reported: shadow.DeviceConfig{LowBatteryThreshold: 15},
+		}, threshold: 30},
+	}
+	for _, params := range testParams {
+		assert.Equal(t, params.threshold, LowBatteryThreshold(params.config))
+	}
+}
+
+func createEvent(status string, previous, percent int, charging bool) BatteryUpdatedEvent {
+	event := BatteryUpdatedEvent{
+		DeviceId:        deviceID,
+		Timestamp:       timestamp,
+		Status:          status,
+		PreviousPercent: previous,
+	}
+	event.Battery.Percent = percent
+	event.Battery.Charging = charging
+	return event
+}
+
+func getStubbedApp(t *testing.T) (App, mocks) {
+	// Create mock controller
+	ctrl := gomock.NewController(t)
+	// Create the mocks
+	m := mocks{
+		db:      NewMockDBClient(ctrl),
+		iot:     NewMockIoTClient(ctrl),
+		shadow:  NewMockShadowClient(ctrl),
+		emailer: NewMockEmailer(ctrl),
+	}
+	// Create the app
+	return New(&telemetry.Clients{DB: m.db, IoT: m.iot, Shadow: m.shadow, Emailer: m.emailer}), m
+}
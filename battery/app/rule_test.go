@@ -0,0 +1,51 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/briggysmalls/detectordag/shared/iotsql"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestBatteryDischargingRule checks the events the IoT rule sends us can be handled
+func TestBatteryDischargingRule(t *testing.T) {
+	const topic = "$aws/things/" + deviceID + "/shadow/update/documents"
+	// Load the rule
+	rule, err := iotsql.Load("../../config/rules/battery_discharging.yaml")
+	assert.NoError(t, err)
+	testParams := []struct {
+		status   string
+		previous int
+		current  int
+		fired    bool
+	}{
+		{status: "off", previous: 21, current: 19, fired: true},
+		// Only falling charge is sent
+		{status: "off", previous: 19, current: 21, fired: false},
+		{status: "off", previous: 19, current: 19, fired: false},
+		// Only during a power cut
+		{status: "on", previous: 21, current: 19, fired: false},
+	}
+	for _, params := range testParams {
+		// Prepare shadow documents, as published by the shadow service
+		documents := fmt.Sprintf(`{
+			"timestamp": %d,
+			"previous": {"state": {"reported": {"status": "%s", "battery": {"percent": %d, "voltage": 3.7, "charging": false}}}},
+			"current": {"state": {"reported": {"status": "%s", "battery": {"percent": %d, "voltage": 3.6, "charging": false}}}}
+		}`, timestamp, params.status, params.previous, params.status, params.current)
+		// Run the rule
+		payload, fired, err := rule.Evaluate(topic, []byte(documents), time.Unix(timestamp, 0))
+		assert.NoError(t, err)
+		assert.Equal(t, params.fired, fired)
+		if !fired {
+			continue
+		}
+		// Check we get the event we expect
+		var event BatteryUpdatedEvent
+		assert.NoError(t, json.Unmarshal(payload, &event))
+		assert.Equal(t, createEvent(params.status, params.previous, params.current, false), event)
+	}
+}
@@ -0,0 +1,102 @@
+package app
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/briggysmalls/detectordag/shared"
+	"github.com/briggysmalls/detectordag/shared/cellular"
+	"github.com/briggysmalls/detectordag/shared/email"
+	"github.com/briggysmalls/detectordag/shared/shadow"
+	"github.com/briggysmalls/detectordag/shared/state"
+	"github.com/briggysmalls/detectordag/shared/telemetry"
+)
+
+// CellularUpdatedEvent is sent by the 'CellularReported' IoT rule
+type CellularUpdatedEvent struct {
+	DeviceId  string `validate:"required"`
+	Timestamp int64  `validate:"required"`
+	// Signal is nil for devices that don't report it
+	Signal *struct {
+		RSSI int
+		RSRP int
+	}
+	DataUsedBytes int64 `validate:"min=0"`
+}
+
+type app struct {
+	*telemetry.Clients
+}
+
+type App interface {
+	HandleRequest(ctx context.Context, event CellularUpdatedEvent) error
+}
+
+// New gets an App that records devices' cellular connections, and warns accounts of data overages
+func New(clients *telemetry.Clients) App {
+	return &app{Clients: clients}
+}
+
+// HandleRequest handles a lambda call
+func (a *app) HandleRequest(ctx context.Context, event CellularUpdatedEvent) error {
+	// Print the event
+	log.Printf("%v\n", event)
+	// Validate the event
+	if err := shared.Validate.Struct(event); err != nil {
+		return err
+	}
+	// Get the device shadow
+	shdw, err := a.Shadow.Get(event.DeviceId)
+	if err != nil {
+		return err
+	}
+	// Add the reading to the history
+	at := time.Unix(event.Timestamp, 0)
+	sample := shadow.CellularSample{Time: at, DataUsed: event.DataUsedBytes}
+	if event.Signal != nil {
+		sample.Signal = shadow.SignalShadow{RSSI: event.Signal.RSSI, RSRP: event.Signal.RSRP}
+	}
+	shdw.Cellular.Samples = cellular.Record(shdw.Cellular.Samples, sample)
+	// Warn the account (once a month) if the device is on course to use more than its allowance
+	var warnErr error
+	month := cellular.Month(at)
+	if projected, ok := cellular.ProjectMonthlyUsage(event.DataUsedBytes, at); ok && shdw.Cellular.CapWarned != month {
+		var warned bool
+		warned, warnErr = a.warnOverage(event.DeviceId, shdw, projected, at)
+		if warned {
+			shdw.Cellular.CapWarned = month
+		}
+	}
+	// Record the history, even if we failed to warn
+	if err := a.Shadow.UpdateCellularHistory(event.DeviceId, shdw.Cellular); err != nil {
+		return err
+	}
+	return warnErr
+}
+
+// warnOverage emails the account if the projected data usage is over its allowance
+func (a *app) warnOverage(deviceID string, shdw *shadow.Shadow, projected int64, at time.Time) (bool, error) {
+	// Get the account
+	account, err := a.Account(deviceID)
+	if err != nil {
+		return false, err
+	}
+	// Check the allowance
+	if account.DataCap == 0 || projected <= account.DataCap {
+		return false, nil
+	}
+	log.Printf("Device '%s' is projected to use %d of %d bytes this month", deviceID, projected, account.DataCap)
+	// We are connected if we've been given a cellular update
+	current, err := state.New(shadow.CONNECTION_STATUS_CONNECTED, shdw.Power.Value)
+	if err != nil {
+		return false, err
+	}
+	// Send 'data overage' emails
+	update := telemetry.NewContext(shdw, account, at)
+	log.Printf("Send emails to: %s", account.Emails)
+	if err := a.Emailer.SendAlert(account.Emails, email.DataOverage, current, update); err != nil {
+		return false, shared.LogErrorAndReturn(err)
+	}
+	return true, nil
+}
@@ -0,0 +1,152 @@
+package app
+
+//go:generate go run github.com/golang/mock/mockgen -destination mock_db.go -package app -mock_names Client=MockDBClient github.com/briggysmalls/detectordag/shared/database Client
+//go:generate go run github.com/golang/mock/mockgen -destination mock_iot.go -package app -mock_names Client=MockIoTClient github.com/briggysmalls/detectordag/shared/iot Client
+//go:generate go run github.com/golang/mock/mockgen -destination mock_shadow.go -package app -mock_names Client=MockShadowClient github.com/briggysmalls/detectordag/shared/shadow Client
+//go:generate go run github.com/golang/mock/mockgen -destination mock_email.go -package app github.com/briggysmalls/detectordag/shared/email Emailer
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/briggysmalls/detectordag/shared/database"
+	"github.com/briggysmalls/detectordag/shared/email"
+	"github.com/briggysmalls/detectordag/shared/iot"
+	"github.com/briggysmalls/detectordag/shared/shadow"
+	"github.com/briggysmalls/detectordag/shared/state"
+	"github.com/briggysmalls/detectordag/shared/telemetry"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	deviceID  = "792ac520-0733-4ffe-8137-8aba3ca446d7"
+	accountID = "c6d62b30-00ac-49c4-9268-88559a46889f"
+	// Half way through April 2020
+	timestamp = 1586952000
+	megabyte  = 1024 * 1024
+)
+
+type mocks struct {
+	db      *MockDBClient
+	iot     *MockIoTClient
+	shadow  *MockShadowClient
+	emailer *MockEmailer
+}
+
+func TestHistoryRecorded(t *testing.T) {
+	// Create app under test
+	app, m := getStubbedApp(t)
+	// Return a shadow with some history
+	previous := shadow.CellularSample{Time: time.Unix(timestamp-3600, 0), Signal: shadow.SignalShadow{RSSI: -80, RSRP: -110}, DataUsed: 9 * megabyte}
+	m.shadow.EXPECT().Get(deviceID).Return(&shadow.Shadow{Cellular: shadow.CellularShadow{
+		Samples:   []shadow.CellularSample{previous},
+		CapWarned: "2020-04",
+	}}, nil)
+	// Expect the reading to be added
+	m.shadow.EXPECT().UpdateCellularHistory(deviceID, shadow.CellularShadow{
+		Samples: []shadow.CellularSample{
+			previous,
+			{Time: time.Unix(timestamp, 0), Signal: shadow.SignalShadow{RSSI: -71, RSRP: -98}, DataUsed: 10 * megabyte},
+		},
+		CapWarned: "2020-04",
+	})
+	// Run the test
+	assert.NoError(t, app.HandleRequest(nil, createEvent(10*megabyte)))
+}
+
+func TestDataOverage(t *testing.T) {
+	testParams := []struct {
+		used   int64
+		cap    int64
+		warned bool
+	}{
+		// Projected to use 20MB
+		{used: 10 * megabyte, cap: 15 * megabyte, warned: true},
+		{used: 10 * megabyte, cap: 25 * megabyte, warned: false},
+		// No allowance
+		{used: 10 * megabyte, cap: 0, warned: false},
+	}
+	for _, params := range testParams {
+		// Create app under test
+		app, m := getStubbedApp(t)
+		shdw := &shadow.Shadow{
+			Name:     "My Dag",
+			Power:    shadow.PowerShadow{Value: shadow.POWER_STATUS_ON},
+			Cellular: shadow.CellularShadow{CapWarned: "2020-03"},
+		}
+		m.shadow.EXPECT().Get(deviceID).Return(shdw, nil)
+		// Expect the account's allowance to be checked
+		m.iot.EXPECT().GetThing(deviceID).Return(&iot.Device{DeviceId: deviceID, AccountId: accountID}, nil)
+		m.db.EXPECT().GetAccountById(accountID).Return(&database.Account{AccountId: accountID, Emails: []string{"owner@example.com"}, DataCap: params.cap}, nil)
+		warned := "2020-03"
+		if params.warned {
+			// Expect the account to be warned
+			m.emailer.EXPECT().SendAlert(
+				[]string{"owner@example.com"},
+				email.DataOverage,
+				state.On,
+				email.ContextData{DeviceName: "My Dag", Time: time.Unix(timestamp, 0)},
+			)
+			warned = "2020-04"
+		}
+		// Expect the warning to be remembered
+		m.shadow.EXPECT().UpdateCellularHistory(deviceID, gomock.Any()).Do(func(id string, cellular shadow.CellularShadow) {
+			assert.Equal(t, warned, cellular.CapWarned)
+		})
+		// Run the test
+		assert.NoError(t, app.HandleRequest(nil, createEvent(params.used)))
+	}
+}
+
+func TestWarningFailed(t *testing.T) {
+	// Create app under test
+	app, m := getStubbedApp(t)
+	m.shadow.EXPECT().Get(deviceID).Return(&shadow.Shadow{}, nil)
+	// Fail to get the account
+	m.iot.EXPECT().GetThing(deviceID).Return(nil, errors.New("Oops"))
+	// Assert the history is still recorded
+	m.shadow.EXPECT().UpdateCellularHistory(deviceID, gomock.Any())
+	// Run the test
+	assert.Error(t, app.HandleRequest(nil, createEvent(10*megabyte)))
+}
+
+func TestInvalidEvent(t *testing.T) {
+	testParams := []CellularUpdatedEvent{
+		{Timestamp: timestamp},
+		{DeviceId: deviceID},
+		{DeviceId: deviceID, Timestamp: timestamp, DataUsedBytes: -1},
+	}
+	for _, event := range testParams {
+		app, _ := getStubbedApp(t)
+		assert.Error(t, app.HandleRequest(nil, event))
+	}
+}
+
+func createEvent(used int64) CellularUpdatedEvent {
+	event := CellularUpdatedEvent{
+		DeviceId:      deviceID,
+		Timestamp:     timestamp,
+		DataUsedBytes: used,
+	}
+	event.Signal = &struct {
+		RSSI int
+		RSRP int
+	}{RSSI: -71, RSRP: -98}
+	return event
+}
+
+func getStubbedApp(t *testing.T) (App, mocks) {
+	// Create mock controller
+	ctrl := gomock.NewController(t)
+	// Create the mocks
+	m := mocks{
+		db:      NewMockDBClient(ctrl),
+		iot:     NewMockIoTClient(ctrl),
+		shadow:  NewMockShadowClient(ctrl),
+		emailer: NewMockEmailer(ctrl),
+	}
+	// Create the app
+	return New(&telemetry.Clients{DB: m.db, IoT: m.iot, Shadow: m.shadow, Emailer: m.emailer}), m
+}
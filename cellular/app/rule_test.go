@@ -0,0 +1,47 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/briggysmalls/detectordag/shared/iotsql"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestCellularReportedRule checks the events the IoT rule sends us can be handled
+func TestCellularReportedRule(t *testing.T) {
+	const topic = "$aws/things/" + deviceID + "/shadow/update/documents"
+	// Load the rule
+	rule, err := iotsql.Load("../../config/rules/cellular_reported.yaml")
+	assert.NoError(t, err)
+	testParams := []struct {
+		previous int64
+		current  int64
+		fired    bool
+	}{
+		{previous: 9 * megabyte, current: 10 * megabyte, fired: true},
+		// Other updates are ignored
+		{previous: 10 * megabyte, current: 10 * megabyte, fired: false},
+	}
+	for _, params := range testParams {
+		// Prepare shadow documents, as published by the shadow service
+		documents := fmt.Sprintf(`{
+			"timestamp": %d,
+			"previous": {"state": {"reported": {"status": "on", "signal": {"rssi": -80, "rsrp": -110}, "dataUsedBytes": %d}}},
+			"current": {"state": {"reported": {"status": "on", "signal": {"rssi": -71, "rsrp": -98}, "dataUsedBytes": %d}}}
+		}`, timestamp, params.previous, params.current)
+		// Run the rule
+		payload, fired, err := rule.Evaluate(topic, []byte(documents), time.Unix(timestamp, 0))
+		assert.NoError(t, err)
+		assert.Equal(t, params.fired, fired)
+		if !fired {
+			continue
+		}
+		// Check we get the event we expect
+		var event CellularUpdatedEvent
+		assert.NoError(t, json.Unmarshal(payload, &event))
+		assert.Equal(t, createEvent(params.current), event)
+	}
+}
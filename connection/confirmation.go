@@ -0,0 +1,98 @@
+package connection
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/briggysmalls/detectordag/shared/sqs"
+)
+
+const (
+	// Confirmation delays used if none are configured
+	DefaultConfirmationDelays = "5s"
+	// The type of job scheduled to check a device is still disconnected
+	JobTypeConfirmDisconnection = "confirm-disconnection"
+)
+
+// ConfirmationPolicy describes how hard we try to confirm a device has disconnected
+// The device is asked for a status update once per delay, and we wait the delay
+// for it to respond. If it never responds we wait a further grace period (if any)
+// before declaring the device disconnected.
+type ConfirmationPolicy struct {
+	Delays []time.Duration
+}
+
+// ConfirmationStep is the action to take to progress a confirmation
+type ConfirmationStep struct {
+	// State to record in the next queued event
+	State sqs.ConfirmationState
+	// How long to wait before the next check
+	Delay time.Duration
+	// Whether the device should be asked for a status update
+	RequestStatus bool
+	// Whether the disconnection has been confirmed (and there is nothing to queue)
+	Confirmed bool
+}
+
+// ParseConfirmationPolicy creates a policy from a comma-separated list of delays (e.g. '5s,30s,1m')
+func ParseConfirmationPolicy(delays string) (ConfirmationPolicy, error) {
+	policy := ConfirmationPolicy{}
+	for _, d := range strings.Split(delays, ",") {
+		delay, err := time.ParseDuration(strings.TrimSpace(d))
+		if err != nil {
+			return ConfirmationPolicy{}, err
+		}
+		policy.Delays = append(policy.Delays, delay)
+	}
+	return policy, policy.Validate()
+}
+
+// Validate checks the policy can be executed
+func (p ConfirmationPolicy) Validate() error {
+	if len(p.Delays) == 0 {
+		return errors.New("Confirmation policy requires at least one delay")
+	}
+	for _, delay := range p.Delays {
+		if delay < 0 {
+			return fmt.Errorf("Confirmation delay out of range: %s", delay)
+		}
+	}
+	return nil
+}
+
+// Start gets the first step in confirming a disconnection
+func (p ConfirmationPolicy) Start() ConfirmationStep {
+	return ConfirmationStep{
+		State:         sqs.ConfirmationState{Stage: sqs.ConfirmationStageRequesting},
+		Delay:         p.Delays[0],
+		RequestStatus: true,
+	}
+}
+
+// Next gets the step following the provided state, for a device that still hasn't responded
+func (p ConfirmationPolicy) Next(state sqs.ConfirmationState, grace time.Duration) ConfirmationStep {
+	switch state.Stage {
+	case sqs.ConfirmationStageGrace:
+		// The grace period has elapsed
+		return ConfirmationStep{Confirmed: true}
+	default:
+		// Ask again, if we have attempts remaining
+		if next := state.Attempt + 1; next < len(p.Delays) {
+			return ConfirmationStep{
+				State:         sqs.ConfirmationState{Stage: sqs.ConfirmationStageRequesting, Attempt: next},
+				Delay:         p.Delays[next],
+				RequestStatus: true,
+			}
+		}
+		// Otherwise allow the grace period to pass, if there is one
+		if grace > 0 {
+			return ConfirmationStep{
+				State: sqs.ConfirmationState{Stage: sqs.ConfirmationStageGrace, Attempt: state.Attempt},
+				Delay: grace,
+			}
+		}
+		return ConfirmationStep{Confirmed: true}
+	}
+}
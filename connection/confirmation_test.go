@@ -0,0 +1,60 @@
+package connection
+
+import (
+	"testing"
+	"time"
+
+	"github.com/briggysmalls/detectordag/shared/sqs"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseConfirmationPolicy(t *testing.T) {
+	testParams := []struct {
+		delays string
+		policy ConfirmationPolicy
+		valid  bool
+	}{
+		{delays: "5s", policy: ConfirmationPolicy{Delays: []time.Duration{5 * time.Second}}, valid: true},
+		{delays: "5s, 30s,2m", policy: ConfirmationPolicy{Delays: []time.Duration{5 * time.Second, 30 * time.Second, 2 * time.Minute}}, valid: true},
+		{delays: "", valid: false},
+		{delays: "5s,soon", valid: false},
+		{delays: "5s,-1s", valid: false},
+	}
+	for _, params := range testParams {
+		policy, err := ParseConfirmationPolicy(params.delays)
+		if !params.valid {
+			assert.Error(t, err)
+			continue
+		}
+		assert.NoError(t, err)
+		assert.Equal(t, params.policy, policy)
+	}
+}
+
+func TestConfirmationSteps(t *testing.T) {
+	policy := ConfirmationPolicy{Delays: []time.Duration{5 * time.Second, 30 * time.Second}}
+	// The first step requests a status update
+	step := policy.Start()
+	assert.Equal(t, ConfirmationStep{
+		State:         sqs.ConfirmationState{Stage: sqs.ConfirmationStageRequesting},
+		Delay:         5 * time.Second,
+		RequestStatus: true,
+	}, step)
+	// The device is asked again
+	step = policy.Next(step.State, time.Hour)
+	assert.Equal(t, ConfirmationStep{
+		State:         sqs.ConfirmationState{Stage: sqs.ConfirmationStageRequesting, Attempt: 1},
+		Delay:         30 * time.Second,
+		RequestStatus: true,
+	}, step)
+	// Retries exhausted, so the grace period starts
+	grace := policy.Next(step.State, time.Hour)
+	assert.Equal(t, ConfirmationStep{
+		State: sqs.ConfirmationState{Stage: sqs.ConfirmationStageGrace, Attempt: 1},
+		Delay: time.Hour,
+	}, grace)
+	// Disconnection is confirmed after the grace period
+	assert.Equal(t, ConfirmationStep{Confirmed: true}, policy.Next(grace.State, time.Hour))
+	// Disconnection is confirmed immediately without a grace period
+	assert.Equal(t, ConfirmationStep{Confirmed: true}, policy.Next(step.State, 0))
+}
@@ -11,7 +11,9 @@ import (
 	"github.com/briggysmalls/detectordag/shared/database"
 	"github.com/briggysmalls/detectordag/shared/email"
 	"github.com/briggysmalls/detectordag/shared/iot"
+	"github.com/briggysmalls/detectordag/shared/maintenance"
 	"github.com/briggysmalls/detectordag/shared/shadow"
+	"github.com/briggysmalls/detectordag/shared/state"
 )
 
 type connectionUpdater struct {
@@ -25,9 +27,9 @@ type ConnectionUpdater interface {
 	UpdateConnectionStatus(deviceID string, timestamp time.Time, status string) error
 }
 
-func NewConnectionUpdater(sesh *session.Session, db database.Client, shadow shadow.Client, iot iot.Client, sender string) (ConnectionUpdater, error) {
+func NewConnectionUpdater(sesh *session.Session, db database.Client, shadow shadow.Client, iot iot.Client, sender string, templates email.TemplateSource) (ConnectionUpdater, error) {
 	// Create a new email client
-	email, err := email.NewEmailer(ses.New(sesh), sender)
+	email, err := email.NewEmailer(ses.New(sesh), sender, templates)
 	if err != nil {
 		return nil, err
 	}
@@ -42,7 +44,21 @@ func (e *connectionUpdater) UpdateConnectionStatus(deviceID string, timestamp ti
 	}
 	log.Printf("Sending visibility email for device: %s with state '%s'", DeviceString(device), status)
 	// Update the internal record of connection status
-	shdw, err := e.shadow.UpdateConnectionStatus(device.DeviceId, status, timestamp)
+	previous, shdw, err := e.shadow.UpdateConnectionStatus(device.DeviceId, status, timestamp)
+	if errors.Is(err, shadow.ErrStaleUpdate) {
+		// A newer status has already been recorded, so this one is old news
+		log.Printf("Discarding stale '%s' status for %s", status, DeviceString(device))
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	// Work out what changed, ignoring updates that don't change the connection (e.g. repeated events)
+	event, err := connectionEvent(previous, status)
+	if errors.Is(err, state.ErrIllegalTransition) {
+		log.Printf("Not notifying of %s: %v", DeviceString(device), err)
+		return nil
+	}
 	if err != nil {
 		return err
 	}
@@ -51,38 +67,42 @@ func (e *connectionUpdater) UpdateConnectionStatus(deviceID string, timestamp ti
 	if err != nil {
 		return err
 	}
+	// Hold back notifications during maintenance
+	if InMaintenance(device.DeviceId, account, shdw, timestamp) {
+		return nil
+	}
 	// Assemble the visibility status context
 	context := email.ContextData{
 		DeviceName: shdw.Name,
 		Time:       timestamp,
-	}
-	state, transition, err := connectionStatusToEnums(shdw)
-	if err != nil {
-		return err
+		Branding:   email.Branding(account.Branding),
 	}
 	// Send the email.
-	return e.email.SendUpdate(account.Emails, state, transition, context)
+	return e.email.SendUpdate(account.Emails, event, context)
 }
 
 func DeviceString(device *iot.Device) string {
 	return fmt.Sprintf("Device '%s'", device.DeviceId)
 }
 
-func connectionStatusToEnums(shdw *shadow.Shadow) (email.StateType, email.TransitionType, error) {
-	connection := shdw.Connection.Status
-	power := shdw.Power.Value
-	// Lookup the state
-	state, err := email.ToStateType(connection, power)
+// InMaintenance indicates notifications for the device are being held back at the given time
+func InMaintenance(deviceID string, account *database.Account, shdw *shadow.Shadow, at time.Time) bool {
+	window, ok := maintenance.Find(account.MaintenanceWindowsFor(shdw.Maintenance), at)
+	if ok {
+		log.Printf("Device '%s' is in maintenance window '%s', not notifying", deviceID, window.ID)
+	}
+	return ok
+}
+
+// connectionEvent gets the state event for a change of connection status, from the shadow it replaced
+func connectionEvent(previous *shadow.Shadow, status string) (state.Event, error) {
+	from, err := state.FromShadow(previous)
 	if err != nil {
-		return 0, 0, err
+		return state.Event{}, err
 	}
-	// Set the transition type
-	switch connection {
-	case shadow.CONNECTION_STATUS_CONNECTED:
-		return state, email.TransitionTypeConnected, nil
-	case shadow.CONNECTION_STATUS_DISCONNECTED:
-		return state, email.TransitionTypeDisconnected, nil
-	default:
-		return 0, 0, errors.New("Unexpected power status")
+	transition, err := state.ConnectionTransition(status)
+	if err != nil {
+		return state.Event{}, err
 	}
+	return from.Apply(transition)
 }
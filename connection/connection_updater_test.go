@@ -0,0 +1,96 @@
+package connection
+
+import (
+	"testing"
+	"time"
+
+	"github.com/briggysmalls/detectordag/shared/database"
+	"github.com/briggysmalls/detectordag/shared/email"
+	"github.com/briggysmalls/detectordag/shared/iot"
+	"github.com/briggysmalls/detectordag/shared/maintenance"
+	"github.com/briggysmalls/detectordag/shared/shadow"
+	"github.com/briggysmalls/detectordag/shared/state"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInMaintenance(t *testing.T) {
+	at := time.Unix(1584803414, 0)
+	nightly := database.MaintenanceWindow{ID: "nightly", Start: at.Add(-49 * time.Hour).Unix(), Duration: 7200, Repeat: "daily"}
+	works := maintenance.Window{ID: "works", Start: at.Add(-time.Hour), Duration: 30 * time.Minute, Repeat: maintenance.Once}
+	testParams := []struct {
+		account  database.MaintenanceWindow
+		device   []maintenance.Window
+		expected bool
+	}{
+		// No windows
+		{},
+		// The account's window covers all its devices
+		{account: nightly, expected: true},
+		// The device's window has passed
+		{device: []maintenance.Window{works}},
+		// The device's window is still going
+		{device: []maintenance.Window{{ID: "works", Start: at.Add(-time.Hour), Duration: 2 * time.Hour}}, expected: true},
+	}
+	for _, params := range testParams {
+		account := &database.Account{}
+		if params.account.ID != "" {
+			account.Maintenance = []database.MaintenanceWindow{params.account}
+		}
+		shdw := &shadow.Shadow{Maintenance: params.device}
+		assert.Equal(t, params.expected, InMaintenance("my-device", account, shdw, at))
+	}
+}
+
+func TestUpdateConnectionStatus(t *testing.T) {
+	const (
+		deviceID  = "792ac520-0733-4ffe-8137-8aba3ca446d7"
+		accountID = "35581BF4-32C8-4908-8377-2E6A021D3D2B"
+	)
+	at := time.Unix(1584803414, 0)
+	testParams := []struct {
+		previous shadow.ConnectionShadow
+		status   string
+		event    *state.Event
+	}{
+		// The device disconnects
+		{
+			previous: shadow.ConnectionShadow{Status: shadow.CONNECTION_STATUS_CONNECTED},
+			status:   shadow.CONNECTION_STATUS_DISCONNECTED,
+			event:    &state.Event{From: state.On, To: state.WasOn, Transition: state.Disconnected},
+		},
+		// The device reconnects
+		{
+			previous: shadow.ConnectionShadow{Status: shadow.CONNECTION_STATUS_DISCONNECTED},
+			status:   shadow.CONNECTION_STATUS_CONNECTED,
+			event:    &state.Event{From: state.WasOn, To: state.On, Transition: state.Connected},
+		},
+		// The device was already connected, so nothing has changed
+		{
+			previous: shadow.ConnectionShadow{Status: shadow.CONNECTION_STATUS_CONNECTED},
+			status:   shadow.CONNECTION_STATUS_CONNECTED,
+		},
+	}
+	for _, params := range testParams {
+		// Create the updater under test
+		ctrl := gomock.NewController(t)
+		shdwClient := NewMockShadowClient(ctrl)
+		db := NewMockDBClient(ctrl)
+		iotClient := NewMockIoTClient(ctrl)
+		emailer := NewMockEmailer(ctrl)
+		updater := &connectionUpdater{email: emailer, db: db, shadow: shdwClient, iot: iotClient}
+		// Configure expectations
+		iotClient.EXPECT().GetThing(deviceID).Return(&iot.Device{DeviceId: deviceID, AccountId: accountID}, nil)
+		previous := &shadow.Shadow{Name: "Kitchen", Connection: params.previous, Power: shadow.PowerShadow{Value: shadow.POWER_STATUS_ON}}
+		updated := &shadow.Shadow{Name: "Kitchen", Connection: shadow.ConnectionShadow{Status: params.status}, Power: previous.Power}
+		shdwClient.EXPECT().UpdateConnectionStatus(deviceID, params.status, at).Return(previous, updated, nil)
+		if params.event != nil {
+			// Assert the owner is told what changed
+			db.EXPECT().GetAccountById(accountID).Return(&database.Account{Emails: []string{"me@example.com"}}, nil)
+			emailer.EXPECT().SendUpdate([]string{"me@example.com"}, *params.event, email.ContextData{DeviceName: "Kitchen", Time: at})
+		}
+		// Run the test
+		assert.NoError(t, updater.UpdateConnectionStatus(deviceID, at, params.status))
+		ctrl.Finish()
+	}
+}
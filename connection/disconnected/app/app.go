@@ -3,48 +3,79 @@ package app
 import (
 	"context"
 	"encoding/json"
+	"log"
+	"time"
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/briggysmalls/detectordag/connection"
+	"github.com/briggysmalls/detectordag/shared/database"
+	"github.com/briggysmalls/detectordag/shared/iot"
+	"github.com/briggysmalls/detectordag/shared/scheduler"
 	"github.com/briggysmalls/detectordag/shared/shadow"
 	"github.com/briggysmalls/detectordag/shared/sqs"
 )
 
+const (
+	// claimLifetime is how long after an event we remember that it was notified
+	claimLifetime = 7 * 24 * time.Hour
+)
+
 type app struct {
 	updater connection.ConnectionUpdater
 	shadow  shadow.Client
+	queue   scheduler.Queue
+	db      database.Client
+	iot     iot.Client
+	policy  connection.ConfirmationPolicy
 }
 
 type App interface {
-	Handler(ctx context.Context, sqsEvent events.SQSEvent) error
+	Handler(ctx context.Context, sqsEvent events.SQSEvent) (sqs.BatchResponse, error)
 }
 
 func New(
 	updater connection.ConnectionUpdater,
 	shadow shadow.Client,
+	queue scheduler.Queue,
+	dispatcher *scheduler.Dispatcher,
+	db database.Client,
+	iot iot.Client,
+	policy connection.ConfirmationPolicy,
 ) App {
-	return &app{
+	a := &app{
 		shadow:  shadow,
 		updater: updater,
+		queue:   queue,
+		db:      db,
+		iot:     iot,
+		policy:  policy,
 	}
+	// Handle the jobs scheduled to confirm disconnections
+	dispatcher.Register(connection.JobTypeConfirmDisconnection, a.confirmDisconnection)
+	return a
 }
 
 // hander handles SQS events
 // The messages all indicate a disconnected event, which we are debouncing
-func (a *app) Handler(ctx context.Context, sqsEvent events.SQSEvent) error {
+// Failed messages are reported individually, so only they are retried
+func (a *app) Handler(ctx context.Context, sqsEvent events.SQSEvent) (sqs.BatchResponse, error) {
+	response := sqs.BatchResponse{}
 	// Handle SQS events
 	for _, message := range sqsEvent.Records {
-		if err := a.processMessage(message); err != nil {
-			return err
+		if err := a.queue.Handle(message.Body); err != nil {
+			log.Printf("Failed to handle message '%s': %v", message.MessageId, err)
+			response.BatchItemFailures = append(response.BatchItemFailures, sqs.BatchItemFailure{
+				ItemIdentifier: message.MessageId,
+			})
 		}
 	}
-	return nil
+	return response, nil
 }
 
-func (a *app) processMessage(message events.SQSMessage) error {
+func (a *app) confirmDisconnection(body json.RawMessage) error {
 	// Deserialise the disconnection message
 	var payload sqs.ConnectionEventPayload
-	err := json.Unmarshal([]byte(message.Body), &payload)
+	err := json.Unmarshal(body, &payload)
 	if err != nil {
 		return err
 	}
@@ -62,6 +93,58 @@ func (a *app) processMessage(message events.SQSMessage) error {
 		// Some other status change has occurred since, ignore
 		return nil
 	}
+	// The device hasn't responded, so work out what to do next
+	step, err := a.nextStep(payload)
+	if err != nil {
+		return err
+	}
+	if !step.Confirmed {
+		// We're not sure yet, so check again later
+		log.Printf("Device '%s' unresponsive, checking again in %s", payload.DeviceID, step.Delay)
+		if step.RequestStatus {
+			// Carry on if we can't, as the next check will still confirm the disconnection
+			if err := a.shadow.RequestStatusUpdate(payload.DeviceID); err != nil {
+				log.Printf("Failed to request status update from device '%s': %v", payload.DeviceID, err)
+			}
+		}
+		payload.Confirmation = step.State
+		return a.queue.ScheduleAfter(connection.JobTypeConfirmDisconnection, payload, step.Delay)
+	}
+	// Make sure a redelivered event never notifies twice
+	claimed, err := a.db.ClaimEvent(payload.ID, payload.Time.Add(claimLifetime))
+	if err != nil {
+		return err
+	}
+	if !claimed {
+		log.Printf("Event '%s' has already been notified", payload.ID)
+		return nil
+	}
 	// Send emails to indicate the updated status
-	return a.updater.UpdateConnectionStatus(payload.DeviceID, payload.Time, payload.Status)
+	if err := a.updater.UpdateConnectionStatus(payload.DeviceID, payload.Time, payload.Status); err != nil {
+		// Give up our claim, so that a retry can notify
+		if releaseErr := a.db.ReleaseEvent(payload.ID); releaseErr != nil {
+			log.Printf("Failed to release event '%s': %v", payload.ID, releaseErr)
+		}
+		return err
+	}
+	return nil
+}
+
+func (a *app) nextStep(payload sqs.ConnectionEventPayload) (connection.ConfirmationStep, error) {
+	// Only look up the grace period when there is one to start
+	step := a.policy.Next(payload.Confirmation, 0)
+	if !step.Confirmed || payload.Confirmation.Stage == sqs.ConfirmationStageGrace {
+		return step, nil
+	}
+	// Get the account that owns the device
+	device, err := a.iot.GetThing(payload.DeviceID)
+	if err != nil {
+		return connection.ConfirmationStep{}, err
+	}
+	account, err := a.db.GetAccountById(device.AccountId)
+	if err != nil {
+		return connection.ConfirmationStep{}, err
+	}
+	// Apply the account's grace period
+	return a.policy.Next(payload.Confirmation, account.DisconnectionGracePeriod()), nil
 }
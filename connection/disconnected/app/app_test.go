@@ -2,37 +2,49 @@ package app
 
 //go:generate go run github.com/golang/mock/mockgen -destination mock_shadow.go -package app -mock_names Client=MockShadowClient github.com/briggysmalls/detectordag/shared/shadow Client
 //go:generate go run github.com/golang/mock/mockgen -destination mock_connection_updater.go -package app github.com/briggysmalls/detectordag/connection ConnectionUpdater
+//go:generate go run github.com/golang/mock/mockgen -destination mock_sqs.go -package app -mock_names Client=MockSQSClient github.com/briggysmalls/detectordag/shared/sqs Client
+//go:generate go run github.com/golang/mock/mockgen -destination mock_db.go -package app -mock_names Client=MockDBClient github.com/briggysmalls/detectordag/shared/database Client
+//go:generate go run github.com/golang/mock/mockgen -destination mock_iot.go -package app -mock_names Client=MockIoTClient github.com/briggysmalls/detectordag/shared/iot Client
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"testing"
 	"time"
 
 	"github.com/aws/aws-lambda-go/events"
+	"github.com/briggysmalls/detectordag/connection"
+	"github.com/briggysmalls/detectordag/shared/database"
+	"github.com/briggysmalls/detectordag/shared/iot"
+	"github.com/briggysmalls/detectordag/shared/scheduler"
 	"github.com/briggysmalls/detectordag/shared/shadow"
+	"github.com/briggysmalls/detectordag/shared/sqs"
 	"github.com/golang/mock/gomock"
 	"github.com/stretchr/testify/assert"
 )
 
+// The time at which the tests are run
+const nowStr = "2020-12-12T20:00:00+00:00"
+
 func TestInvalidPayload(t *testing.T) {
 	testParams := []struct {
 		event string
 	}{
 		{event: "other"},
 		{event: `{"dummy":"text"}`},
-		{event: `{"deviceId:"e35238bb-ca2c-4e2b-88da-3d305ffe904c"}`},
-		{event: `{"deviceId":"not-uuid","time":"2020-12-12T19:58:16+00:00"}`},
-		{event: `{"deviceId:"e35238bb-ca2c-4e2b-88da-3d305ffe904c","time":"some-bad-time"}`},
+		{event: jobMessage(t, `{"dummy":"text"}`)},
+		{event: jobMessage(t, `{"deviceId":"not-uuid","time":"2020-12-12T19:58:16+00:00"}`)},
+		{event: jobMessage(t, `{"deviceId":"e35238bb-ca2c-4e2b-88da-3d305ffe904c","time":"some-bad-time"}`)},
 	}
 	// Run the test
 	for _, params := range testParams {
 		// Create app under test
-		app, _, _ := getStubbedApp(t)
+		app, _ := getStubbedApp(t)
 		// Prepare an event
 		event := events.SQSEvent{Records: []events.SQSMessage{{Body: params.event}}}
 		// Run the test
-		assert.NotNil(t, app.Handler(nil, event))
+		assertFailures(t, app, event, 1)
 	}
 }
 
@@ -41,17 +53,22 @@ func TestConnectionStatusLookupFailed(t *testing.T) {
 		deviceID = "e35238bb-ca2c-4e2b-88da-3d305ffe904c"
 	)
 	// Create app under test
-	app, shadow, _ := getStubbedApp(t)
+	app, mocks := getStubbedApp(t)
 	// Construct the event
 	event := events.SQSEvent{
 		Records: []events.SQSMessage{
-			{Body: `{"deviceId":"e35238bb-ca2c-4e2b-88da-3d305ffe904c","time":"2020-12-12T19:58:16+00:00"}`},
+			{Body: jobMessage(t, `{
+				"deviceId":"e35238bb-ca2c-4e2b-88da-3d305ffe904c",
+				"id":"4e0a66f2-c928-4ad5-8870-b0c72ded0ae4",
+				"time":"2020-12-12T19:58:16+00:00",
+				"type":"disconnected"
+			}`)},
 		},
 	}
 	// Expect a call to shadow
-	shadow.EXPECT().Get(deviceID).Return(nil, errors.New("Something went wrong"))
+	mocks.shadow.EXPECT().Get(deviceID).Return(nil, errors.New("Something went wrong"))
 	// Run the test
-	assert.NotNil(t, app.Handler(nil, event))
+	assertFailures(t, app, event, 1)
 }
 
 func TestStaleEvent(t *testing.T) {
@@ -59,20 +76,20 @@ func TestStaleEvent(t *testing.T) {
 		deviceID = "e35238bb-ca2c-4e2b-88da-3d305ffe904c"
 	)
 	// Create app under test
-	app, mockShadow, _ := getStubbedApp(t)
+	app, mocks := getStubbedApp(t)
 	// Construct the event
 	event := events.SQSEvent{
 		Records: []events.SQSMessage{
-			{Body: `{
+			{Body: jobMessage(t, `{
 				"deviceId":"e35238bb-ca2c-4e2b-88da-3d305ffe904c",
 				"id":"4e0a66f2-c928-4ad5-8870-b0c72ded0ae4",
 				"time":"2020-12-12T19:58:16+00:00",
 				"type":"connected"
-			}`},
+			}`)},
 		},
 	}
 	// Expect a call to shadow
-	mockShadow.EXPECT().Get(deviceID).Return(
+	mocks.shadow.EXPECT().Get(deviceID).Return(
 		&shadow.Shadow{Connection: shadow.ConnectionShadow{
 			Status:      shadow.CONNECTION_STATUS_CONNECTED,
 			TransientID: "52068a06-f89d-4256-9b64-48fa990088d9",
@@ -81,7 +98,26 @@ func TestStaleEvent(t *testing.T) {
 		nil,
 	)
 	// Run the test
-	assert.Nil(t, app.Handler(nil, event))
+	assertFailures(t, app, event, 0)
+}
+
+func TestJobNotDue(t *testing.T) {
+	// Create app under test
+	app, mocks := getStubbedApp(t)
+	// Construct a job due in an hour
+	now, err := time.Parse(time.RFC3339, nowStr)
+	assert.NoError(t, err)
+	job, err := json.Marshal(scheduler.Job{
+		Type:    connection.JobTypeConfirmDisconnection,
+		RunAt:   now.Add(time.Hour),
+		Payload: json.RawMessage(`{}`),
+	})
+	assert.NoError(t, err)
+	// Expect the job to be sent back to the queue, without being run
+	mocks.sqs.EXPECT().Send(job, sqs.MaxDelay)
+	// Run the test
+	event := events.SQSEvent{Records: []events.SQSMessage{{Body: string(job)}}}
+	assertFailures(t, app, event, 0)
 }
 
 func TestEmailsSent(t *testing.T) {
@@ -94,12 +130,12 @@ func TestEmailsSent(t *testing.T) {
 	)
 	connectionStatus := shadow.CONNECTION_STATUS_CONNECTED
 	// Create app under test
-	app, mockShadow, mockUpdater := getStubbedApp(t)
+	app, mocks := getStubbedApp(t)
 	eventTime, err := time.Parse(time.RFC3339, eventTimeStr)
 	assert.Nil(t, err)
 	gomock.InOrder(
 		// Expect a call to shadow
-		mockShadow.EXPECT().Get(deviceID).Return(
+		mocks.shadow.EXPECT().Get(deviceID).Return(
 			// Indicate the status hasn't been updated for a while
 			&shadow.Shadow{Connection: shadow.ConnectionShadow{
 				Status:      shadow.CONNECTION_STATUS_CONNECTED,
@@ -108,33 +144,247 @@ func TestEmailsSent(t *testing.T) {
 			}},
 			nil,
 		),
+		// Expect the account to be checked for a grace period
+		mocks.iot.EXPECT().GetThing(deviceID).Return(&iot.Device{DeviceId: deviceID, AccountId: accountID}, nil),
+		mocks.db.EXPECT().GetAccountById(accountID).Return(&database.Account{AccountId: accountID}, nil),
+		// Expect the event to be claimed
+		mocks.db.EXPECT().ClaimEvent(transientID, eventTime.Add(claimLifetime)).Return(true, nil),
 		// Expect a call to update status
-		mockUpdater.EXPECT().UpdateConnectionStatus(deviceID, eventTime, connectionStatus),
+		mocks.updater.EXPECT().UpdateConnectionStatus(deviceID, eventTime, connectionStatus),
 	)
 	// Run test
 	// Prepare an event
 	event := events.SQSEvent{
 		Records: []events.SQSMessage{
-			{Body: fmt.Sprintf(`{
+			{Body: jobMessage(t, fmt.Sprintf(`{
 				"deviceId":"%s",
 				"id":"%s",
 				"type":"%s",
-				"time":"%s"
-			}`, deviceID, transientID, connectionStatus, eventTimeStr)},
+				"time":"%s",
+				"confirmation":{"stage":"requesting","attempt":1}
+			}`, deviceID, transientID, connectionStatus, eventTimeStr))},
 		},
 	}
-	assert.Nil(t, app.Handler(nil, event))
+	assertFailures(t, app, event, 0)
+}
+
+func TestConfirmationRetried(t *testing.T) {
+	const (
+		deviceID     = "b6d62b30-00ac-49c4-9268-88559a46889f"
+		eventTimeStr = "2020-12-12T19:58:16+00:00"
+		transientID  = "52068a06-f89d-4256-9b64-48fa990088d9"
+	)
+	// Failing to ask the device again shouldn't stop the next attempt
+	for _, requestErr := range []error{nil, errors.New("Publish failed")} {
+		// Create app under test
+		app, mocks := getStubbedApp(t)
+		gomock.InOrder(
+			// Expect a call to shadow, indicating the device still hasn't responded
+			mocks.shadow.EXPECT().Get(deviceID).Return(
+				&shadow.Shadow{Connection: shadow.ConnectionShadow{
+					Status:      shadow.CONNECTION_STATUS_CONNECTED,
+					TransientID: transientID,
+				}},
+				nil,
+			),
+			// Expect the device to be asked again
+			mocks.shadow.EXPECT().RequestStatusUpdate(deviceID).Return(requestErr),
+			// Expect the event to be queued for the next attempt
+			mocks.sqs.EXPECT().Send(gomock.Any(), time.Minute).Do(func(body []byte, delay time.Duration) {
+				payload := unpackJob(t, body)
+				assert.Equal(t, transientID, payload.ID)
+				assert.Equal(t, sqs.ConfirmationState{Stage: sqs.ConfirmationStageRequesting, Attempt: 1}, payload.Confirmation)
+			}),
+		)
+		// Prepare an event for the first attempt
+		event := events.SQSEvent{
+			Records: []events.SQSMessage{
+				{Body: jobMessage(t, fmt.Sprintf(`{
+					"deviceId":"%s",
+					"id":"%s",
+					"type":"disconnected",
+					"time":"%s",
+					"confirmation":{"stage":"requesting","attempt":0}
+				}`, deviceID, transientID, eventTimeStr))},
+			},
+		}
+		assertFailures(t, app, event, 0)
+	}
 }
 
-func getStubbedApp(t *testing.T) (*app, *MockShadowClient, *MockConnectionUpdater) {
+func TestGracePeriod(t *testing.T) {
+	const (
+		deviceID     = "b6d62b30-00ac-49c4-9268-88559a46889f"
+		accountID    = "c6d62b30-00ac-49c4-9268-88559a46889f"
+		eventTimeStr = "2020-12-12T19:58:16+00:00"
+		transientID  = "52068a06-f89d-4256-9b64-48fa990088d9"
+	)
+	eventTime, err := time.Parse(time.RFC3339, eventTimeStr)
+	assert.Nil(t, err)
+	unresponsive := &shadow.Shadow{Connection: shadow.ConnectionShadow{
+		Status:      shadow.CONNECTION_STATUS_CONNECTED,
+		TransientID: transientID,
+	}}
+	// Create app under test
+	app, mocks := getStubbedApp(t)
+	gomock.InOrder(
+		// The final attempt goes unanswered
+		mocks.shadow.EXPECT().Get(deviceID).Return(unresponsive, nil),
+		// Expect the account to be checked for a grace period
+		mocks.iot.EXPECT().GetThing(deviceID).Return(&iot.Device{DeviceId: deviceID, AccountId: accountID}, nil),
+		mocks.db.EXPECT().GetAccountById(accountID).Return(&database.Account{AccountId: accountID, GracePeriod: 600}, nil),
+		// Expect the event to be queued until the grace period has elapsed
+		mocks.sqs.EXPECT().Send(gomock.Any(), 10*time.Minute).Do(func(body []byte, delay time.Duration) {
+			payload := unpackJob(t, body)
+			assert.Equal(t, sqs.ConfirmationState{Stage: sqs.ConfirmationStageGrace, Attempt: 1}, payload.Confirmation)
+		}),
+		// The grace period then elapses
+		mocks.shadow.EXPECT().Get(deviceID).Return(unresponsive, nil),
+		mocks.db.EXPECT().ClaimEvent(transientID, gomock.Any()).Return(true, nil),
+		mocks.updater.EXPECT().UpdateConnectionStatus(deviceID, eventTime, shadow.CONNECTION_STATUS_DISCONNECTED),
+	)
+	// Run the final attempt, then the grace period
+	for _, stage := range []string{sqs.ConfirmationStageRequesting, sqs.ConfirmationStageGrace} {
+		event := events.SQSEvent{
+			Records: []events.SQSMessage{
+				{Body: jobMessage(t, fmt.Sprintf(`{
+					"deviceId":"%s",
+					"id":"%s",
+					"type":"disconnected",
+					"time":"%s",
+					"confirmation":{"stage":"%s","attempt":1}
+				}`, deviceID, transientID, eventTimeStr, stage))},
+			},
+		}
+		assertFailures(t, app, event, 0)
+	}
+}
+
+func TestEventAlreadyNotified(t *testing.T) {
+	const (
+		deviceID    = "b6d62b30-00ac-49c4-9268-88559a46889f"
+		transientID = "52068a06-f89d-4256-9b64-48fa990088d9"
+	)
+	// Create app under test
+	app, mocks := getStubbedApp(t)
+	gomock.InOrder(
+		mocks.shadow.EXPECT().Get(deviceID).Return(&shadow.Shadow{Connection: shadow.ConnectionShadow{
+			Status:      shadow.CONNECTION_STATUS_CONNECTED,
+			TransientID: transientID,
+		}}, nil),
+		// Indicate the event has already been claimed by an earlier delivery
+		mocks.db.EXPECT().ClaimEvent(transientID, gomock.Any()).Return(false, nil),
+		// Nothing is sent
+	)
+	// Run the test, with no grace period to look up
+	event := events.SQSEvent{
+		Records: []events.SQSMessage{
+			{Body: jobMessage(t, fmt.Sprintf(`{
+				"deviceId":"%s",
+				"id":"%s",
+				"type":"disconnected",
+				"time":"2020-12-12T19:58:16+00:00",
+				"confirmation":{"stage":"grace","attempt":1}
+			}`, deviceID, transientID))},
+		},
+	}
+	assertFailures(t, app, event, 0)
+}
+
+func TestPartialBatchFailure(t *testing.T) {
+	const (
+		deviceOne   = "b6d62b30-00ac-49c4-9268-88559a46889f"
+		deviceTwo   = "e35238bb-ca2c-4e2b-88da-3d305ffe904c"
+		transientID = "52068a06-f89d-4256-9b64-48fa990088d9"
+	)
+	// Create app under test
+	app, mocks := getStubbedApp(t)
+	gomock.InOrder(
+		// The first device is notified, but the email fails to send
+		mocks.shadow.EXPECT().Get(deviceOne).Return(&shadow.Shadow{Connection: shadow.ConnectionShadow{
+			Status:      shadow.CONNECTION_STATUS_CONNECTED,
+			TransientID: transientID,
+		}}, nil),
+		mocks.db.EXPECT().ClaimEvent(transientID, gomock.Any()).Return(true, nil),
+		mocks.updater.EXPECT().UpdateConnectionStatus(deviceOne, gomock.Any(), shadow.CONNECTION_STATUS_DISCONNECTED).Return(errors.New("Oops")),
+		// Expect the claim to be released for the retry
+		mocks.db.EXPECT().ReleaseEvent(transientID),
+		// The second device has since changed, so is ignored
+		mocks.shadow.EXPECT().Get(deviceTwo).Return(&shadow.Shadow{}, nil),
+	)
+	// Prepare an event for each device
+	messages := []events.SQSMessage{}
+	for i, deviceID := range []string{deviceOne, deviceTwo} {
+		messages = append(messages, events.SQSMessage{
+			MessageId: fmt.Sprintf("message-%d", i),
+			Body: jobMessage(t, fmt.Sprintf(`{
+				"deviceId":"%s",
+				"id":"%s",
+				"type":"disconnected",
+				"time":"2020-12-12T19:58:16+00:00",
+				"confirmation":{"stage":"grace","attempt":1}
+			}`, deviceID, transientID)),
+		})
+	}
+	// Run the test, asserting only the first message is retried
+	response, err := app.Handler(nil, events.SQSEvent{Records: messages})
+	assert.NoError(t, err)
+	assert.Equal(t, []sqs.BatchItemFailure{{ItemIdentifier: "message-0"}}, response.BatchItemFailures)
+}
+
+type mocks struct {
+	shadow  *MockShadowClient
+	updater *MockConnectionUpdater
+	sqs     *MockSQSClient
+	db      *MockDBClient
+	iot     *MockIoTClient
+}
+
+func getStubbedApp(t *testing.T) (App, mocks) {
 	// Create mock controller
 	ctrl := gomock.NewController(t)
-	// Create mock shadow
-	shadow := NewMockShadowClient(ctrl)
-	// Create mock connection updater
-	updater := NewMockConnectionUpdater(ctrl)
+	// Create mocks
+	m := mocks{
+		shadow:  NewMockShadowClient(ctrl),
+		updater: NewMockConnectionUpdater(ctrl),
+		sqs:     NewMockSQSClient(ctrl),
+		db:      NewMockDBClient(ctrl),
+		iot:     NewMockIoTClient(ctrl),
+	}
+	// Create a queue that runs on a fake clock
+	now, err := time.Parse(time.RFC3339, nowStr)
+	assert.NoError(t, err)
+	dispatcher := scheduler.NewDispatcher()
+	queue := scheduler.NewSQS(m.sqs, scheduler.NewFakeClock(now), dispatcher)
+	// Create a confirmation policy
+	policy := connection.ConfirmationPolicy{Delays: []time.Duration{5 * time.Second, time.Minute}}
 	// Bundle up into an app
-	return &app{shadow: shadow, updater: updater}, shadow, updater
+	return New(m.updater, m.shadow, queue, dispatcher, m.db, m.iot, policy), m
+}
+
+// assertFailures runs the handler, checking how many messages were reported as failed
+func assertFailures(t *testing.T, app App, event events.SQSEvent, failures int) {
+	response, err := app.Handler(nil, event)
+	assert.NoError(t, err)
+	assert.Len(t, response.BatchItemFailures, failures)
+}
+
+// jobMessage wraps the payload in a confirmation job that is due to be run
+func jobMessage(t *testing.T, payload string) string {
+	now, err := time.Parse(time.RFC3339, nowStr)
+	assert.NoError(t, err)
+	return fmt.Sprintf(`{"type":"%s","runAt":"%s","payload":%s}`,
+		connection.JobTypeConfirmDisconnection, now.Format(time.RFC3339), payload)
+}
+
+// unpackJob gets the connection event from a queued job
+func unpackJob(t *testing.T, body []byte) sqs.ConnectionEventPayload {
+	var job scheduler.Job
+	assert.NoError(t, json.Unmarshal(body, &job))
+	assert.Equal(t, connection.JobTypeConfirmDisconnection, job.Type)
+	var payload sqs.ConnectionEventPayload
+	assert.NoError(t, json.Unmarshal(job.Payload, &payload))
+	return payload
 }
 
 func createTime(t *testing.T, timeString string) time.Time {
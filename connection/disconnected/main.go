@@ -11,12 +11,17 @@ import (
 	"github.com/briggysmalls/detectordag/connection/disconnected/app"
 	"github.com/briggysmalls/detectordag/shared"
 	"github.com/briggysmalls/detectordag/shared/database"
+	"github.com/briggysmalls/detectordag/shared/email"
 	"github.com/briggysmalls/detectordag/shared/iot"
+	"github.com/briggysmalls/detectordag/shared/scheduler"
 	"github.com/briggysmalls/detectordag/shared/shadow"
+	"github.com/briggysmalls/detectordag/shared/sqs"
 )
 
 const (
-	senderEnvVar = "SENDER_EMAIL"
+	senderEnvVar             = "SENDER_EMAIL"
+	templateLocationEnvVar   = "TEMPLATE_LOCATION"
+	confirmationDelaysEnvVar = "CONFIRMATION_DELAYS"
 )
 
 // Prepare an application to reuse across lambda runs
@@ -34,6 +39,11 @@ func init() {
 	if err != nil {
 		log.Fatal(err.Error())
 	}
+	// Create a new SQS queue client
+	sqsQueue, err := sqs.New(sesh, os.Getenv("DELAY_QUEUE_URL"))
+	if err != nil {
+		log.Fatal(err.Error())
+	}
 	// Create a new database client
 	dbClient, err := database.New(sesh)
 	if err != nil {
@@ -49,14 +59,32 @@ func init() {
 	if err != nil {
 		log.Fatal(err.Error())
 	}
+	// Create a source for the email templates
+	templates, err := email.NewTemplateSource(sesh, os.Getenv(templateLocationEnvVar))
+	if err != nil {
+		log.Fatal(err.Error())
+	}
 	// Create a new session just for emailing (there is no emailing service in eu-west-2)
 	emailSesh := shared.CreateSession(aws.Config{Region: aws.String("eu-west-1")})
-	connectionUpdater, err := connection.NewConnectionUpdater(emailSesh, dbClient, shadowClient, iotClient, sender)
+	connectionUpdater, err := connection.NewConnectionUpdater(emailSesh, dbClient, shadowClient, iotClient, sender, templates)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	// Load the disconnection confirmation policy
+	delays := os.Getenv(confirmationDelaysEnvVar)
+	if delays == "" {
+		delays = connection.DefaultConfirmationDelays
+	}
+	policy, err := connection.ParseConfirmationPolicy(delays)
 	if err != nil {
 		log.Fatal(err.Error())
 	}
 	// Create the application
-	emailer = app.New(connectionUpdater, shadowClient)
+	dispatcher := scheduler.NewDispatcher()
+	// Accept checks that were queued before they were scheduled as jobs
+	dispatcher.SetLegacyType(connection.JobTypeConfirmDisconnection)
+	queue := scheduler.NewSQS(sqsQueue, scheduler.NewClock(), dispatcher)
+	emailer = app.New(connectionUpdater, shadowClient, queue, dispatcher, dbClient, iotClient, policy)
 }
 
 // main is the entrypoint to the lambda function
@@ -2,20 +2,24 @@ package app
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"time"
 
 	"github.com/briggysmalls/detectordag/connection"
+	"github.com/briggysmalls/detectordag/shared/scheduler"
 	"github.com/briggysmalls/detectordag/shared/shadow"
 	"github.com/briggysmalls/detectordag/shared/sqs"
 	"github.com/google/uuid"
 )
 
 type app struct {
-	sqs     sqs.Client
-	shadow  shadow.Client
-	updater connection.ConnectionUpdater
+	scheduler scheduler.Scheduler
+	shadow    shadow.Client
+	updater   connection.ConnectionUpdater
+	policy    connection.ConfirmationPolicy
+	stability connection.StabilityTracker
 }
 
 type App interface {
@@ -25,12 +29,16 @@ type App interface {
 func New(
 	updater connection.ConnectionUpdater,
 	shadow shadow.Client,
-	sqs sqs.Client,
+	scheduler scheduler.Scheduler,
+	policy connection.ConfirmationPolicy,
+	stability connection.StabilityTracker,
 ) App {
 	return &app{
-		updater: updater,
-		sqs:     sqs,
-		shadow:  shadow,
+		updater:   updater,
+		scheduler: scheduler,
+		shadow:    shadow,
+		policy:    policy,
+		stability: stability,
 	}
 }
 
@@ -43,7 +51,7 @@ type DeviceLifecycleEvent struct {
 
 // RunJob handles a lambda call
 // The visibility status approach depends on the following invariants:
-// - Connection events will always alternate (never consecutive "connected" or "disconnected")
+// - Connection events may arrive late or be duplicated, so are ordered by their timestamp
 // - "Disconnected" events may be spurious (quickly followed with a "connected" event)
 func (a *app) RunJob(ctx context.Context, event DeviceLifecycleEvent) error {
 	// Print the event
@@ -60,13 +68,30 @@ func (a *app) RunJob(ctx context.Context, event DeviceLifecycleEvent) error {
 	if err := connectionEventPayload.Validate(); err != nil {
 		return err
 	}
-	// Always update the transient state
-	a.shadow.UpdateConnectionTransientID(event.DeviceID, id)
 	// Get the device shadow
 	shdw, err := a.shadow.Get(event.DeviceID)
 	if err != nil {
 		return err
 	}
+	// Record every event for the stability analytics, even if it is debounced later
+	recordTime := time.Unix(0, event.Timestamp*int64(time.Millisecond)).UTC()
+	if err := a.stability.Record(event.DeviceID, event.EventType, recordTime, shdw); err != nil {
+		log.Printf("Failed to record connection event for device '%s': %v", event.DeviceID, err)
+	}
+	// Discard events older than the status we already have
+	if eventTime.Before(shdw.Connection.Updated) || eventTime.Before(shdw.Connection.LastEvent) {
+		log.Printf("Discarding stale '%s' event for device '%s'", event.EventType, event.DeviceID)
+		return nil
+	}
+	// Always update the transient state (as long as a newer event hasn't beaten us to it)
+	err = a.shadow.UpdateConnectionTransientID(event.DeviceID, id, eventTime)
+	if errors.Is(err, shadow.ErrStaleUpdate) {
+		log.Printf("Discarding stale '%s' event for device '%s'", event.EventType, event.DeviceID)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
 	// Check if we need to enqueue a handler
 	if event.EventType == shdw.Connection.Status {
 		// This event won't change the state
@@ -78,11 +103,17 @@ func (a *app) RunJob(ctx context.Context, event DeviceLifecycleEvent) error {
 		// Send emails to indicate the updated status
 		return a.updater.UpdateConnectionStatus(event.DeviceID, eventTime, event.EventType)
 	} else if event.EventType == shadow.CONNECTION_STATUS_DISCONNECTED {
+		// Start confirming the disconnection
+		step := a.policy.Start()
+		connectionEventPayload.Confirmation = step.State
 		// Ask the device to confirm if it's connected
 		log.Printf("Request status update from device '%s' to confirm disconnection", event.DeviceID)
-		a.shadow.RequestStatusUpdate(event.DeviceID)
-		// Enqueue a callback to check if the device responds
-		return a.sqs.QueueConnectionEvent(connectionEventPayload)
+		if err := a.shadow.RequestStatusUpdate(event.DeviceID); err != nil {
+			// The scheduled check still confirms the disconnection, it just can't be cut short
+			log.Printf("Failed to request status update from device '%s': %v", event.DeviceID, err)
+		}
+		// Schedule a check to see if the device responds
+		return a.scheduler.ScheduleAfter(connection.JobTypeConfirmDisconnection, connectionEventPayload, step.Delay)
 	} else {
 		return fmt.Errorf("Unexpected connection status: %s", event.EventType)
 	}
@@ -1,14 +1,16 @@
 package app
 
-//go:generate go run github.com/golang/mock/mockgen -destination mock_sqs.go -package app -mock_names Client=MockSQSClient github.com/briggysmalls/detectordag/shared/sqs Client
+//go:generate go run github.com/golang/mock/mockgen -destination mock_scheduler.go -package app github.com/briggysmalls/detectordag/shared/scheduler Scheduler
 //go:generate go run github.com/golang/mock/mockgen -destination mock_shadow.go -package app -mock_names Client=MockShadowClient github.com/briggysmalls/detectordag/shared/shadow Client
 //go:generate go run github.com/golang/mock/mockgen -destination mock_iot.go -package app -mock_names Client=MockIoTClient github.com/briggysmalls/detectordag/shared/iot Client
-//go:generate go run github.com/golang/mock/mockgen -destination mock_connection.go -package app github.com/briggysmalls/detectordag/connection ConnectionUpdater
+//go:generate go run github.com/golang/mock/mockgen -destination mock_connection.go -package app github.com/briggysmalls/detectordag/connection ConnectionUpdater,StabilityTracker
 
 import (
+	"errors"
 	"testing"
 	"time"
 
+	"github.com/briggysmalls/detectordag/connection"
 	"github.com/briggysmalls/detectordag/shared/shadow"
 	"github.com/briggysmalls/detectordag/shared/sqs"
 	"github.com/golang/mock/gomock"
@@ -49,22 +51,24 @@ func TestDisconnectedEvents(t *testing.T) {
 	}
 	for _, params := range testParams {
 		// Create app under test
-		app, _, mockShadowClient, mockSQSClient := getStubbedApp(t)
+		app, _, mockShadowClient, mockScheduler := getStubbedApp(t)
 		// Prepare a shadow to return
 		gomock.InOrder(
-			// We expect to always update the transient status
-			mockShadowClient.EXPECT().UpdateConnectionTransientID(deviceID, gomock.Any()),
-			// We also always get the shadow
+			// We always get the shadow
 			mockShadowClient.EXPECT().Get(deviceID).Return(&shadow.Shadow{Connection: shadow.ConnectionShadow{
 				Status: params.currentStatus,
 			}}, nil),
+			// We then update the transient status
+			mockShadowClient.EXPECT().UpdateConnectionTransientID(deviceID, gomock.Any(), createTime(t, timeString)),
 			// Expect a
 			mockShadowClient.EXPECT().RequestStatusUpdate(deviceID),
 			// This test checks that events are enqueued
-			mockSQSClient.EXPECT().QueueConnectionEvent(gomock.Any()).Do(func(payload sqs.ConnectionEventPayload) {
+			mockScheduler.EXPECT().ScheduleAfter(connection.JobTypeConfirmDisconnection, gomock.Any(), 5*time.Second).Do(func(jobType string, p interface{}, delay time.Duration) {
+				payload := p.(sqs.ConnectionEventPayload)
 				assert.Equal(t, deviceID, payload.DeviceID)
 				assert.Equal(t, params.newStatus, payload.Status)
 				assert.Equal(t, createTime(t, timeString), payload.Time)
+				assert.Equal(t, sqs.ConfirmationState{Stage: sqs.ConfirmationStageRequesting}, payload.Confirmation)
 			}),
 		)
 		// Prepare an event
@@ -99,12 +103,12 @@ func TestConnectedEvents(t *testing.T) {
 		app, mockUpdater, mockShadowClient, _ := getStubbedApp(t)
 		// Prepare a shadow to return
 		gomock.InOrder(
-			// We expect to always update the transient status
-			mockShadowClient.EXPECT().UpdateConnectionTransientID(deviceID, gomock.Any()),
-			// We also always get the shadow
+			// We always get the shadow
 			mockShadowClient.EXPECT().Get(deviceID).Return(&shadow.Shadow{Connection: shadow.ConnectionShadow{
 				Status: params.currentStatus,
 			}}, nil),
+			// We then update the transient status
+			mockShadowClient.EXPECT().UpdateConnectionTransientID(deviceID, gomock.Any(), createTime(t, timeString)),
 			// Expect a call to update status
 			mockUpdater.EXPECT().UpdateConnectionStatus(deviceID, createTime(t, timeString), shadow.CONNECTION_STATUS_CONNECTED),
 		)
@@ -146,10 +150,10 @@ func TestIgnoreConnectionEvents(t *testing.T) {
 			Status: params.currentStatus,
 		}}
 		gomock.InOrder(
-			// We expect to always update the transient status
-			mockShadowClient.EXPECT().UpdateConnectionTransientID(deviceID, gomock.Any()),
-			// We also always get the shadow
+			// We always get the shadow
 			mockShadowClient.EXPECT().Get(deviceID).Return(&device, nil),
+			// We then update the transient status
+			mockShadowClient.EXPECT().UpdateConnectionTransientID(deviceID, gomock.Any(), createTime(t, timeString)),
 			// Nothing is enqueued
 		)
 		// Prepare an event
@@ -163,17 +167,118 @@ func TestIgnoreConnectionEvents(t *testing.T) {
 	}
 }
 
-func getStubbedApp(t *testing.T) (*app, *MockConnectionUpdater, *MockShadowClient, *MockSQSClient) {
+func TestStaleEvents(t *testing.T) {
+	const (
+		deviceID  = "792ac520-0733-4ffe-8137-8aba3ca446d7"
+		timestamp = 1584803414000
+	)
+	testParams := []struct {
+		eventType  string
+		connection shadow.ConnectionShadow
+	}{
+		// The status changed after the event occurred
+		{eventType: shadow.CONNECTION_STATUS_CONNECTED, connection: shadow.ConnectionShadow{Status: shadow.CONNECTION_STATUS_CONNECTED, Updated: time.Unix(1584803415, 0)}},
+		{eventType: shadow.CONNECTION_STATUS_DISCONNECTED, connection: shadow.ConnectionShadow{Status: shadow.CONNECTION_STATUS_CONNECTED, Updated: time.Unix(1584803415, 0)}},
+		// A later event didn't change the status
+		{eventType: shadow.CONNECTION_STATUS_DISCONNECTED, connection: shadow.ConnectionShadow{
+			Status:    shadow.CONNECTION_STATUS_CONNECTED,
+			Updated:   time.Unix(1584800000, 0),
+			LastEvent: time.Unix(1584803415, 0),
+		}},
+	}
+	for _, params := range testParams {
+		// Create app under test
+		app, _, mockShadowClient, _ := getStubbedApp(t)
+		// Return a shadow updated after the event occurred
+		mockShadowClient.EXPECT().Get(deviceID).Return(&shadow.Shadow{Connection: params.connection}, nil)
+		// Nothing is updated or enqueued
+		event := DeviceLifecycleEvent{
+			DeviceID:  deviceID,
+			EventType: params.eventType,
+			Timestamp: timestamp,
+		}
+		// Run the test
+		assert.Nil(t, app.RunJob(nil, event))
+	}
+}
+
+func TestTransientIDUpdateFailed(t *testing.T) {
+	const (
+		deviceID  = "792ac520-0733-4ffe-8137-8aba3ca446d7"
+		timestamp = 1584803414000
+	)
+	testParams := []struct {
+		updateErr error
+		failed    bool
+	}{
+		// A newer event was recorded between reading and updating the shadow
+		{updateErr: shadow.ErrStaleUpdate, failed: false},
+		{updateErr: errors.New("Update failed"), failed: true},
+	}
+	for _, params := range testParams {
+		// Create app under test
+		app, _, mockShadowClient, _ := getStubbedApp(t)
+		gomock.InOrder(
+			mockShadowClient.EXPECT().Get(deviceID).Return(&shadow.Shadow{Connection: shadow.ConnectionShadow{
+				Status: shadow.CONNECTION_STATUS_CONNECTED,
+			}}, nil),
+			// Fail to record the event, so nothing is enqueued
+			mockShadowClient.EXPECT().UpdateConnectionTransientID(deviceID, gomock.Any(), time.Unix(1584803414, 0).UTC()).Return(params.updateErr),
+		)
+		event := DeviceLifecycleEvent{
+			DeviceID:  deviceID,
+			EventType: shadow.CONNECTION_STATUS_DISCONNECTED,
+			Timestamp: timestamp,
+		}
+		// Run the test
+		err := app.RunJob(nil, event)
+		assert.Equal(t, params.failed, err != nil)
+	}
+}
+
+func TestEventsRecorded(t *testing.T) {
+	const (
+		deviceID  = "792ac520-0733-4ffe-8137-8aba3ca446d7"
+		timestamp = 1584803414123
+	)
+	// Create app under test
+	ctrl := gomock.NewController(t)
+	shadowClient := NewMockShadowClient(ctrl)
+	stability := NewMockStabilityTracker(ctrl)
+	app := &app{shadow: shadowClient, stability: stability}
+	// Return a shadow updated after the event occurred
+	shdw := &shadow.Shadow{Connection: shadow.ConnectionShadow{
+		Status:  shadow.CONNECTION_STATUS_CONNECTED,
+		Updated: time.Unix(1584803415, 0),
+	}}
+	shadowClient.EXPECT().Get(deviceID).Return(shdw, nil)
+	// Expect the event to be recorded anyway, to the millisecond
+	stability.EXPECT().Record(deviceID, shadow.CONNECTION_STATUS_DISCONNECTED, time.Unix(1584803414, 123000000).UTC(), shdw)
+	// Run the test
+	event := DeviceLifecycleEvent{
+		DeviceID:  deviceID,
+		EventType: shadow.CONNECTION_STATUS_DISCONNECTED,
+		Timestamp: timestamp,
+	}
+	assert.Nil(t, app.RunJob(nil, event))
+}
+
+func getStubbedApp(t *testing.T) (*app, *MockConnectionUpdater, *MockShadowClient, *MockScheduler) {
 	// Create mock controller
 	ctrl := gomock.NewController(t)
 	// Create mock updater client
 	updater := NewMockConnectionUpdater(ctrl)
-	// Create mock sqs
-	sqs := NewMockSQSClient(ctrl)
+	// Create mock scheduler
+	scheduler := NewMockScheduler(ctrl)
 	// Create mock shadow client
 	shadow := NewMockShadowClient(ctrl)
+	// Create a confirmation policy
+	policy := connection.ConfirmationPolicy{Delays: []time.Duration{5 * time.Second, time.Minute}}
+	// Accept any events being recorded (see TestEventsRecorded)
+	stability := NewMockStabilityTracker(ctrl)
+	stability.EXPECT().Record(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).AnyTimes()
 	// Bundle up into an app
-	return &app{updater: updater, shadow: shadow, sqs: sqs}, updater, shadow, sqs
+	return &app{updater: updater, shadow: shadow, scheduler: scheduler, policy: policy, stability: stability}, updater, shadow, scheduler
 }
 
 func createTime(t *testing.T, timeString string) time.Time {
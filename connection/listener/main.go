@@ -7,17 +7,22 @@ import (
 
 	"github.com/aws/aws-lambda-go/lambda"
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ses"
 	"github.com/briggysmalls/detectordag/connection"
 	"github.com/briggysmalls/detectordag/connection/listener/app"
 	"github.com/briggysmalls/detectordag/shared"
 	"github.com/briggysmalls/detectordag/shared/database"
+	"github.com/briggysmalls/detectordag/shared/email"
 	"github.com/briggysmalls/detectordag/shared/iot"
+	"github.com/briggysmalls/detectordag/shared/scheduler"
 	"github.com/briggysmalls/detectordag/shared/shadow"
 	"github.com/briggysmalls/detectordag/shared/sqs"
 )
 
 const (
-	senderEnvVar = "SENDER_EMAIL"
+	senderEnvVar             = "SENDER_EMAIL"
+	templateLocationEnvVar   = "TEMPLATE_LOCATION"
+	confirmationDelaysEnvVar = "CONFIRMATION_DELAYS"
 )
 
 // Prepare an application to reuse across lambda runs
@@ -55,14 +60,41 @@ func init() {
 	if err != nil {
 		log.Fatal(err.Error())
 	}
+	// Create a source for the email templates
+	templates, err := email.NewTemplateSource(sesh, os.Getenv(templateLocationEnvVar))
+	if err != nil {
+		log.Fatal(err.Error())
+	}
 	// Create a new session just for emailing (there is no emailing service in eu-west-2)
 	emailSesh := shared.CreateSession(aws.Config{Region: aws.String("eu-west-1")})
-	connectionUpdater, err := connection.NewConnectionUpdater(emailSesh, dbClient, shadowClient, iotClient, sender)
+	connectionUpdater, err := connection.NewConnectionUpdater(emailSesh, dbClient, shadowClient, iotClient, sender, templates)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	// Load the disconnection confirmation policy
+	delays := os.Getenv(confirmationDelaysEnvVar)
+	if delays == "" {
+		delays = connection.DefaultConfirmationDelays
+	}
+	policy, err := connection.ParseConfirmationPolicy(delays)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	// Create a tracker of connection stability
+	emailer, err := email.NewEmailer(ses.New(emailSesh), sender, templates)
 	if err != nil {
 		log.Fatal(err.Error())
 	}
+	clock := scheduler.NewClock()
+	stability := connection.NewStabilityTracker(dbClient, shadowClient, iotClient, emailer, clock)
 	// Create the application
-	listener = app.New(connectionUpdater, shadowClient, sqsQueue)
+	listener = app.New(
+		connectionUpdater,
+		shadowClient,
+		scheduler.NewSQS(sqsQueue, clock, scheduler.NewDispatcher()),
+		policy,
+		stability,
+	)
 }
 
 // main is the entrypoint to the lambda function
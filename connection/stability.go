@@ -0,0 +1,169 @@
+package connection
+
+import (
+	"errors"
+	"log"
+	"time"
+
+	"github.com/briggysmalls/detectordag/shared/database"
+	"github.com/briggysmalls/detectordag/shared/email"
+	"github.com/briggysmalls/detectordag/shared/iot"
+	"github.com/briggysmalls/detectordag/shared/scheduler"
+	"github.com/briggysmalls/detectordag/shared/shadow"
+	"github.com/briggysmalls/detectordag/shared/state"
+)
+
+const (
+	// StabilityWindow is the period over which connection stability is measured
+	StabilityWindow = 24 * time.Hour
+	// UnstableDisconnects is how many disconnections within the window make a device unstable
+	UnstableDisconnects = 10
+	// StableDisconnects is how few disconnections within the window make an unstable device stable again
+	// This is lower than UnstableDisconnects, so that a device near the limit doesn't keep notifying
+	StableDisconnects = 5
+	// maxStabilityAttempts is how many times the stability is updated, when racing other updates to the shadow
+	maxStabilityAttempts = 5
+)
+
+// StabilityTracker keeps track of how reliable device connections are
+type StabilityTracker interface {
+	Record(deviceID, status string, at time.Time, shdw *shadow.Shadow) error
+	// Refresh re-evaluates a device's stability without a new event, so that old events stop counting
+	Refresh(deviceID string, shdw *shadow.Shadow) error
+}
+
+type stabilityTracker struct {
+	db     database.Client
+	shadow shadow.Client
+	iot    iot.Client
+	email  email.Emailer
+	clock  scheduler.Clock
+}
+
+// NewStabilityTracker gets a StabilityTracker, which emails the owner when a device becomes unstable
+func NewStabilityTracker(db database.Client, shadow shadow.Client, iot iot.Client, emailer email.Emailer, clock scheduler.Clock) StabilityTracker {
+	return &stabilityTracker{db: db, shadow: shadow, iot: iot, email: emailer, clock: clock}
+}
+
+// Record adds a connection event to the device's history, and updates its stability
+// Every event should be recorded, including those that are later debounced
+func (t *stabilityTracker) Record(deviceID, status string, at time.Time, shdw *shadow.Shadow) error {
+	// Add the event to the history
+	if err := t.db.RecordConnectionEvent(deviceID, status, at); err != nil {
+		return err
+	}
+	return t.update(deviceID, shdw)
+}
+
+// Refresh updates the device's stability from its existing history
+func (t *stabilityTracker) Refresh(deviceID string, shdw *shadow.Shadow) error {
+	return t.update(deviceID, shdw)
+}
+
+// update works out the device's recent stability, letting the owner know once when it becomes unstable
+// The update is conditional on the shadow version, so only one of several concurrent updates can flag the device
+func (t *stabilityTracker) update(deviceID string, shdw *shadow.Shadow) error {
+	for attempt := 1; ; attempt++ {
+		// Work out the device's recent stability
+		now := t.clock.Now()
+		events, err := t.db.GetConnectionEvents(deviceID, now.Add(-StabilityWindow))
+		if err != nil {
+			return err
+		}
+		stability := ComputeStability(events, shdw.Stability.Unstable, now)
+		if stability == shdw.Stability {
+			// Nothing has changed
+			return nil
+		}
+		err = t.shadow.UpdateStability(deviceID, stability, shdw.Version)
+		if errors.Is(err, shadow.ErrVersionConflict) && attempt < maxStabilityAttempts {
+			// The shadow changed since we read it (perhaps another event has flagged the device), so start again
+			if shdw, err = t.shadow.Get(deviceID); err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		// Let the owner know once, when the device becomes unstable
+		if !stability.Unstable || shdw.Stability.Unstable {
+			return nil
+		}
+		log.Printf("Device '%s' has become unstable after %d disconnections", deviceID, stability.Disconnects)
+		return t.notifyUnstable(deviceID, shdw)
+	}
+}
+
+func (t *stabilityTracker) notifyUnstable(deviceID string, shdw *shadow.Shadow) error {
+	// Get the account
+	device, err := t.iot.GetThing(deviceID)
+	if err != nil {
+		return err
+	}
+	account, err := t.db.GetAccountById(device.AccountId)
+	if err != nil {
+		return err
+	}
+	// Hold back notifications during maintenance
+	now := t.clock.Now()
+	if InMaintenance(deviceID, account, shdw, now) {
+		return nil
+	}
+	// Send the email
+	current, err := state.FromShadow(shdw)
+	if err != nil {
+		return err
+	}
+	event, err := current.Apply(state.Unstable)
+	if err != nil {
+		return err
+	}
+	context := email.ContextData{
+		DeviceName: shdw.Name,
+		Time:       now,
+		Branding:   email.Branding(account.Branding),
+	}
+	return t.email.SendUpdate(account.Emails, event, context)
+}
+
+// ComputeStability summarises a device's connection history (oldest event first) up until now
+func ComputeStability(events []database.ConnectionEvent, wasUnstable bool, now time.Time) shadow.StabilityShadow {
+	var stability shadow.StabilityShadow
+	// Count the disconnections, and measure each connected session
+	var sessionStart *time.Time
+	var sessions int
+	var total time.Duration
+	for _, event := range events {
+		switch event.Status {
+		case shadow.CONNECTION_STATUS_CONNECTED:
+			// Repeated connected events don't start a new session
+			if sessionStart == nil {
+				start := event.Time()
+				sessionStart = &start
+			}
+		case shadow.CONNECTION_STATUS_DISCONNECTED:
+			stability.Disconnects++
+			if sessionStart != nil {
+				total += event.Time().Sub(*sessionStart)
+				sessions++
+				sessionStart = nil
+			}
+		}
+	}
+	// Include the current session, if there is one
+	if sessionStart != nil {
+		total += now.Sub(*sessionStart)
+		sessions++
+	}
+	if sessions > 0 {
+		stability.MeanSession = total / time.Duration(sessions)
+	}
+	// Decide whether the device is unstable
+	if wasUnstable {
+		stability.Unstable = stability.Disconnects > StableDisconnects
+	} else {
+		stability.Unstable = stability.Disconnects >= UnstableDisconnects
+	}
+	return stability
+}
@@ -0,0 +1,133 @@
+package connection
+
+//go:generate go run github.com/golang/mock/mockgen -destination mock_shadow.go -package connection -mock_names Client=MockShadowClient github.com/briggysmalls/detectordag/shared/shadow Client
+//go:generate go run github.com/golang/mock/mockgen -destination mock_db.go -package connection -mock_names Client=MockDBClient github.com/briggysmalls/detectordag/shared/database Client
+//go:generate go run github.com/golang/mock/mockgen -destination mock_iot.go -package connection -mock_names Client=MockIoTClient github.com/briggysmalls/detectordag/shared/iot Client
+//go:generate go run github.com/golang/mock/mockgen -destination mock_email.go -package connection github.com/briggysmalls/detectordag/shared/email Emailer
+
+import (
+	"testing"
+	"time"
+
+	"github.com/briggysmalls/detectordag/shared/database"
+	"github.com/briggysmalls/detectordag/shared/scheduler"
+	"github.com/briggysmalls/detectordag/shared/shadow"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	stabilityDeviceID = "792ac520-0733-4ffe-8137-8aba3ca446d7"
+)
+
+func TestComputeStability(t *testing.T) {
+	now := time.Unix(1584803414, 0)
+	// Create a helper for building events relative to now
+	event := func(ago time.Duration, status string) database.ConnectionEvent {
+		return database.ConnectionEvent{
+			Timestamp: now.Add(-ago).UnixNano() / int64(time.Millisecond),
+			Status:    status,
+		}
+	}
+	// Create a history of a device that flaps every hour
+	flapping := []database.ConnectionEvent{}
+	for i := 23; i > 0; i-- {
+		flapping = append(flapping,
+			event(time.Duration(i)*time.Hour, shadow.CONNECTION_STATUS_CONNECTED),
+			event(time.Duration(i)*time.Hour-30*time.Minute, shadow.CONNECTION_STATUS_DISCONNECTED),
+		)
+	}
+	testParams := []struct {
+		events      []database.ConnectionEvent
+		wasUnstable bool
+		expected    shadow.StabilityShadow
+	}{
+		{ // No history
+			expected: shadow.StabilityShadow{},
+		},
+		{ // Connected all day, with a duplicated event
+			events: []database.ConnectionEvent{
+				event(20*time.Hour, shadow.CONNECTION_STATUS_CONNECTED),
+				event(10*time.Hour, shadow.CONNECTION_STATUS_CONNECTED),
+			},
+			expected: shadow.StabilityShadow{MeanSession: 20 * time.Hour},
+		},
+		{ // A couple of sessions, finishing disconnected
+			events: []database.ConnectionEvent{
+				event(6*time.Hour, shadow.CONNECTION_STATUS_CONNECTED),
+				event(5*time.Hour, shadow.CONNECTION_STATUS_DISCONNECTED),
+				event(4*time.Hour, shadow.CONNECTION_STATUS_CONNECTED),
+				event(1*time.Hour, shadow.CONNECTION_STATUS_DISCONNECTED),
+			},
+			expected: shadow.StabilityShadow{Disconnects: 2, MeanSession: 2 * time.Hour},
+		},
+		{ // Flapping
+			events:   flapping,
+			expected: shadow.StabilityShadow{Unstable: true, Disconnects: 23, MeanSession: 30 * time.Minute},
+		},
+		{ // Still unstable, despite falling under the limit
+			events:      flapping[:2*(UnstableDisconnects-1)],
+			wasUnstable: true,
+			expected:    shadow.StabilityShadow{Unstable: true, Disconnects: UnstableDisconnects - 1, MeanSession: 30 * time.Minute},
+		},
+		{ // Stable again
+			events:      flapping[:2*StableDisconnects],
+			wasUnstable: true,
+			expected:    shadow.StabilityShadow{Disconnects: StableDisconnects, MeanSession: 30 * time.Minute},
+		},
+	}
+	for _, params := range testParams {
+		assert.Equal(t, params.expected, ComputeStability(params.events, params.wasUnstable, now))
+	}
+}
+
+func TestRecordConcurrently(t *testing.T) {
+	now := time.Unix(1584803414, 0)
+	tracker, shdwClient, db, _ := createStabilityTracker(t, now)
+	// Create a history that makes the device unstable
+	events := flappingEvents(now, UnstableDisconnects)
+	unstable := ComputeStability(events, false, now)
+	db.EXPECT().RecordConnectionEvent(stabilityDeviceID, shadow.CONNECTION_STATUS_DISCONNECTED, now)
+	db.EXPECT().GetConnectionEvents(stabilityDeviceID, now.Add(-StabilityWindow)).Return(events, nil).Times(2)
+	gomock.InOrder(
+		// Another event flags the device before we do
+		shdwClient.EXPECT().UpdateStability(stabilityDeviceID, unstable, 10).Return(shadow.ErrVersionConflict),
+		shdwClient.EXPECT().Get(stabilityDeviceID).Return(&shadow.Shadow{Version: 11, Stability: unstable}, nil),
+	)
+	// Assert the owner isn't emailed again
+	assert.NoError(t, tracker.Record(stabilityDeviceID, shadow.CONNECTION_STATUS_DISCONNECTED, now, &shadow.Shadow{Version: 10}))
+}
+
+func TestRefresh(t *testing.T) {
+	now := time.Unix(1584803414, 0)
+	tracker, shdwClient, db, _ := createStabilityTracker(t, now)
+	// The disconnections have aged out of the window
+	events := flappingEvents(now, StableDisconnects)
+	db.EXPECT().GetConnectionEvents(stabilityDeviceID, now.Add(-StabilityWindow)).Return(events, nil)
+	// Assert the device is no longer flagged, without anyone being emailed
+	shdwClient.EXPECT().UpdateStability(stabilityDeviceID, ComputeStability(events, true, now), 10)
+	shdw := &shadow.Shadow{Version: 10, Stability: shadow.StabilityShadow{Unstable: true, Disconnects: UnstableDisconnects}}
+	assert.NoError(t, tracker.Refresh(stabilityDeviceID, shdw))
+}
+
+func createStabilityTracker(t *testing.T, now time.Time) (StabilityTracker, *MockShadowClient, *MockDBClient, *MockEmailer) {
+	ctrl := gomock.NewController(t)
+	shdw := NewMockShadowClient(ctrl)
+	db := NewMockDBClient(ctrl)
+	emailer := NewMockEmailer(ctrl)
+	tracker := NewStabilityTracker(db, shdw, NewMockIoTClient(ctrl), emailer, scheduler.NewFakeClock(now))
+	return tracker, shdw, db, emailer
+}
+
+// flappingEvents creates a history of a device that has disconnected every hour
+func flappingEvents(now time.Time, disconnects int) []database.ConnectionEvent {
+	events := []database.ConnectionEvent{}
+	for i := disconnects; i > 0; i-- {
+		start := now.Add(-time.Duration(i) * time.Hour)
+		events = append(events,
+			database.ConnectionEvent{Timestamp: start.UnixNano() / int64(time.Millisecond), Status: shadow.CONNECTION_STATUS_CONNECTED},
+			database.ConnectionEvent{Timestamp: start.Add(30*time.Minute).UnixNano() / int64(time.Millisecond), Status: shadow.CONNECTION_STATUS_DISCONNECTED},
+		)
+	}
+	return events
+}
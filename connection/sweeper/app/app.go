@@ -0,0 +1,89 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/briggysmalls/detectordag/connection"
+	"github.com/briggysmalls/detectordag/shared/iot"
+	"github.com/briggysmalls/detectordag/shared/scheduler"
+	"github.com/briggysmalls/detectordag/shared/shadow"
+)
+
+type app struct {
+	updater   connection.ConnectionUpdater
+	stability connection.StabilityTracker
+	shadow    shadow.Client
+	iot       iot.Client
+	clock     scheduler.Clock
+	heartbeat time.Duration
+}
+
+type App interface {
+	Handler(ctx context.Context, event events.CloudWatchEvent) error
+}
+
+// New gets an App that sweeps for devices that have stopped responding
+// The heartbeat interval must be longer than the period between sweeps, so that
+// connected devices have a chance to respond to the previous sweep's request
+// Devices with a history of disconnections also have their stability re-evaluated, so it clears once they settle
+func New(updater connection.ConnectionUpdater, stability connection.StabilityTracker, shadow shadow.Client, iot iot.Client, clock scheduler.Clock, heartbeat time.Duration) App {
+	return &app{
+		updater:   updater,
+		stability: stability,
+		shadow:    shadow,
+		iot:       iot,
+		clock:     clock,
+		heartbeat: heartbeat,
+	}
+}
+
+// Handler handles scheduled events, checking every device for a recent heartbeat
+func (a *app) Handler(ctx context.Context, event events.CloudWatchEvent) error {
+	// Get all the devices
+	ids, err := a.iot.GetThingsInGroup()
+	if err != nil {
+		return err
+	}
+	// Check each device, carrying on if one fails
+	failed := 0
+	for _, id := range ids {
+		if err := a.checkDevice(id); err != nil {
+			log.Printf("Failed to check device '%s': %v", id, err)
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("Failed to check %d of %d devices", failed, len(ids))
+	}
+	return nil
+}
+
+func (a *app) checkDevice(id string) error {
+	// Get the current device shadow
+	shdw, err := a.shadow.Get(id)
+	if err != nil {
+		return err
+	}
+	// Let old disconnections stop counting against the device, carrying on if this fails
+	if shdw.Stability.Unstable || shdw.Stability.Disconnects > 0 {
+		if err := a.stability.Refresh(id, shdw); err != nil {
+			log.Printf("Failed to refresh stability of device '%s': %v", id, err)
+		}
+	}
+	// Devices we already know are disconnected need nothing more
+	if shdw.Connection.Status == shadow.CONNECTION_STATUS_DISCONNECTED {
+		return nil
+	}
+	// Check when we last heard from the device
+	now := a.clock.Now()
+	if now.Sub(shdw.Seen) > a.heartbeat {
+		log.Printf("Device '%s' last seen at %s, marking as disconnected", id, shdw.Seen)
+		return a.updater.UpdateConnectionStatus(id, now, shadow.CONNECTION_STATUS_DISCONNECTED)
+	}
+	// Ask the device to respond before the next sweep
+	return a.shadow.RequestStatusUpdate(id)
+}
@@ -0,0 +1,142 @@
+package app
+
+//go:generate go run github.com/golang/mock/mockgen -destination mock_shadow.go -package app -mock_names Client=MockShadowClient github.com/briggysmalls/detectordag/shared/shadow Client
+//go:generate go run github.com/golang/mock/mockgen -destination mock_iot.go -package app -mock_names Client=MockIoTClient github.com/briggysmalls/detectordag/shared/iot Client
+//go:generate go run github.com/golang/mock/mockgen -destination mock_connection.go -package app github.com/briggysmalls/detectordag/connection ConnectionUpdater,StabilityTracker
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/briggysmalls/detectordag/shared/scheduler"
+	"github.com/briggysmalls/detectordag/shared/shadow"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	heartbeat = time.Hour
+)
+
+type mocks struct {
+	iot       *MockIoTClient
+	shadow    *MockShadowClient
+	updater   *MockConnectionUpdater
+	stability *MockStabilityTracker
+}
+
+func TestSweep(t *testing.T) {
+	const (
+		deviceID = "792ac520-0733-4ffe-8137-8aba3ca446d7"
+		now      = "2020/03/21 15:10:14"
+	)
+	testParams := []struct {
+		status   string
+		lastSeen string
+		stale    bool
+	}{
+		{status: shadow.CONNECTION_STATUS_CONNECTED, lastSeen: "2020/03/21 14:30:00", stale: false},
+		{status: shadow.CONNECTION_STATUS_CONNECTED, lastSeen: "2020/03/21 14:10:13", stale: true},
+		{status: shadow.CONNECTION_STATUS_DISCONNECTED, lastSeen: "2020/03/20 12:00:00", stale: false},
+	}
+	// The power hasn't changed for a long time, which doesn't mean the device is gone
+	const powerUpdated = "2020/03/01 00:00:00"
+	for _, params := range testParams {
+		// Create app under test
+		app, m := getStubbedApp(t, createTime(t, now))
+		// Return a single device
+		m.iot.EXPECT().GetThingsInGroup().Return([]string{deviceID}, nil)
+		m.shadow.EXPECT().Get(deviceID).Return(&shadow.Shadow{
+			Seen:       createTime(t, params.lastSeen),
+			Connection: shadow.ConnectionShadow{Status: params.status},
+			Power:      shadow.PowerShadow{Updated: createTime(t, powerUpdated)},
+		}, nil)
+		switch {
+		case params.stale:
+			// Stale devices are marked as disconnected
+			m.updater.EXPECT().UpdateConnectionStatus(deviceID, createTime(t, now), shadow.CONNECTION_STATUS_DISCONNECTED)
+		case params.status == shadow.CONNECTION_STATUS_CONNECTED:
+			// Live devices are asked to respond before the next sweep
+			m.shadow.EXPECT().RequestStatusUpdate(deviceID)
+		}
+		// Run the test
+		assert.NoError(t, app.Handler(nil, events.CloudWatchEvent{}))
+	}
+}
+
+func TestSweepContinuesAfterFailure(t *testing.T) {
+	const (
+		deviceOne = "792ac520-0733-4ffe-8137-8aba3ca446d7"
+		deviceTwo = "f80103e1-ba55-4b55-b80e-b24f5dd518bb"
+		now       = "2020/03/21 15:10:14"
+	)
+	// Create app under test
+	app, m := getStubbedApp(t, createTime(t, now))
+	m.iot.EXPECT().GetThingsInGroup().Return([]string{deviceOne, deviceTwo}, nil)
+	// Fail to get the first device
+	m.shadow.EXPECT().Get(deviceOne).Return(nil, errors.New("Oops"))
+	// Assert the second device is still checked
+	m.shadow.EXPECT().Get(deviceTwo).Return(&shadow.Shadow{
+		Seen:       createTime(t, now),
+		Connection: shadow.ConnectionShadow{Status: shadow.CONNECTION_STATUS_CONNECTED},
+	}, nil)
+	m.shadow.EXPECT().RequestStatusUpdate(deviceTwo)
+	// Run the test
+	assert.Error(t, app.Handler(nil, events.CloudWatchEvent{}))
+}
+
+func TestSweepRefreshesStability(t *testing.T) {
+	const (
+		deviceID = "792ac520-0733-4ffe-8137-8aba3ca446d7"
+		now      = "2020/03/21 15:10:14"
+	)
+	testParams := []struct {
+		stability shadow.StabilityShadow
+		refreshed bool
+	}{
+		{stability: shadow.StabilityShadow{}, refreshed: false},
+		{stability: shadow.StabilityShadow{Disconnects: 3}, refreshed: true},
+		{stability: shadow.StabilityShadow{Unstable: true, Disconnects: 10}, refreshed: true},
+	}
+	for _, params := range testParams {
+		// Create app under test
+		app, m := getStubbedApp(t, createTime(t, now))
+		// Return a single live device
+		m.iot.EXPECT().GetThingsInGroup().Return([]string{deviceID}, nil)
+		shdw := &shadow.Shadow{
+			Seen:       createTime(t, now),
+			Connection: shadow.ConnectionShadow{Status: shadow.CONNECTION_STATUS_CONNECTED},
+			Stability:  params.stability,
+		}
+		m.shadow.EXPECT().Get(deviceID).Return(shdw, nil)
+		m.shadow.EXPECT().RequestStatusUpdate(deviceID)
+		// Assert only devices with a history of disconnections are refreshed, and failures are tolerated
+		if params.refreshed {
+			m.stability.EXPECT().Refresh(deviceID, shdw).Return(errors.New("Oops"))
+		}
+		// Run the test
+		assert.NoError(t, app.Handler(nil, events.CloudWatchEvent{}))
+	}
+}
+
+func getStubbedApp(t *testing.T, now time.Time) (App, mocks) {
+	// Create mock controller
+	ctrl := gomock.NewController(t)
+	// Create the mocks
+	m := mocks{
+		iot:       NewMockIoTClient(ctrl),
+		shadow:    NewMockShadowClient(ctrl),
+		updater:   NewMockConnectionUpdater(ctrl),
+		stability: NewMockStabilityTracker(ctrl),
+	}
+	// Create the app
+	return New(m.updater, m.stability, m.shadow, m.iot, scheduler.NewFakeClock(now), heartbeat), m
+}
+
+func createTime(t *testing.T, timeString string) time.Time {
+	tme, err := time.Parse("2006/01/02 15:04:05", timeString)
+	assert.NoError(t, err)
+	return tme
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ses"
+	"github.com/briggysmalls/detectordag/connection"
+	"github.com/briggysmalls/detectordag/connection/sweeper/app"
+	"github.com/briggysmalls/detectordag/shared"
+	"github.com/briggysmalls/detectordag/shared/database"
+	"github.com/briggysmalls/detectordag/shared/email"
+	"github.com/briggysmalls/detectordag/shared/iot"
+	"github.com/briggysmalls/detectordag/shared/scheduler"
+	"github.com/briggysmalls/detectordag/shared/shadow"
+)
+
+const (
+	senderEnvVar            = "SENDER_EMAIL"
+	templateLocationEnvVar  = "TEMPLATE_LOCATION"
+	heartbeatIntervalEnvVar = "HEARTBEAT_INTERVAL"
+	defaultHeartbeat        = "1h"
+)
+
+// Prepare an application to reuse across lambda runs
+var sweeper app.App
+
+func init() {
+	// Add file/line number to the default logger
+	log.SetFlags(log.LstdFlags | log.Lshortfile)
+	var err error
+	// Create an AWS session
+	// Good practice will share this session for all services
+	sesh := shared.CreateSession(aws.Config{})
+	// Create a new shadow client
+	shadowClient, err := shadow.New(sesh)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	// Create a new database client
+	dbClient, err := database.New(sesh)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	// Get the email sender
+	sender := os.Getenv(senderEnvVar)
+	if sender == "" {
+		shared.LogErrorAndReturn(fmt.Errorf("Env var '%s' unset", senderEnvVar))
+	}
+	// Create a new iot client
+	iotClient, err := iot.New(sesh)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	// Create a source for the email templates
+	templates, err := email.NewTemplateSource(sesh, os.Getenv(templateLocationEnvVar))
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	// Create a new session just for emailing (there is no emailing service in eu-west-2)
+	emailSesh := shared.CreateSession(aws.Config{Region: aws.String("eu-west-1")})
+	connectionUpdater, err := connection.NewConnectionUpdater(emailSesh, dbClient, shadowClient, iotClient, sender, templates)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	// Create a tracker of connection stability
+	emailer, err := email.NewEmailer(ses.New(emailSesh), sender, templates)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	clock := scheduler.NewClock()
+	stability := connection.NewStabilityTracker(dbClient, shadowClient, iotClient, emailer, clock)
+	// Load the heartbeat interval
+	interval := os.Getenv(heartbeatIntervalEnvVar)
+	if interval == "" {
+		interval = defaultHeartbeat
+	}
+	heartbeat, err := time.ParseDuration(interval)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	// Create the application
+	sweeper = app.New(connectionUpdater, stability, shadowClient, iotClient, clock, heartbeat)
+}
+
+// main is the entrypoint to the lambda function
+func main() {
+	lambda.Start(sweeper.Handler)
+}
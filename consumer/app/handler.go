@@ -2,24 +2,19 @@ package app
 
 import (
 	"context"
-	"errors"
-	"fmt"
 	"log"
-	"os"
 	"time"
 
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/aws/aws-sdk-go/service/ses"
 	"github.com/briggysmalls/detectordag/shared"
 	"github.com/briggysmalls/detectordag/shared/database"
+	"github.com/briggysmalls/detectordag/shared/discharge"
 	"github.com/briggysmalls/detectordag/shared/email"
-	iotp "github.com/briggysmalls/detectordag/shared/iot"
+	"github.com/briggysmalls/detectordag/shared/food"
+	"github.com/briggysmalls/detectordag/shared/iot"
+	"github.com/briggysmalls/detectordag/shared/maintenance"
+	"github.com/briggysmalls/detectordag/shared/scheduler"
 	"github.com/briggysmalls/detectordag/shared/shadow"
-)
-
-const (
-	senderEnvVar = "SENDER_EMAIL"
+	"github.com/briggysmalls/detectordag/shared/state"
 )
 
 type StatusUpdatedEvent struct {
@@ -33,53 +28,37 @@ type StatusUpdatedEvent struct {
 			Timestamp int64 `validate:"required"`
 		}
 	}
+	// The power status the device reported before this one
+	PreviousStatus string `validate:"omitempty,eq=on|eq=off"`
 }
 
-var db database.Client
-var iot iotp.Client
-var emailClient email.Emailer
-var shadowClient shadow.Client
+type app struct {
+	db        database.Client
+	iot       iot.Client
+	shadow    shadow.Client
+	emailer   email.Emailer
+	scheduler scheduler.Scheduler
+}
 
-func init() {
-	// Create an AWS session
-	// Good practice will share this session for all services
-	sesh, err := session.NewSessionWithOptions(session.Options{
-		SharedConfigState: session.SharedConfigEnable,
-	})
-	if err != nil {
-		shared.LogErrorAndExit(err)
-	}
-	// Create a database client
-	db, err = database.New(sesh)
-	if err != nil {
-		shared.LogErrorAndExit(err)
-	}
-	// Create an IOT client
-	iot, err = iotp.New(sesh)
-	if err != nil {
-		shared.LogErrorAndExit(err)
-	}
-	// Create a new shadow client
-	shadowClient, err = shadow.New(sesh)
-	if err != nil {
-		log.Fatal(err.Error())
-	}
-	// Get the email sender
-	sender := os.Getenv(senderEnvVar)
-	if sender == "" {
-		shared.LogErrorAndReturn(fmt.Errorf("Env var '%s' unset", senderEnvVar))
-	}
-	// Create a new session just for emailing (there is no emailing service in eu-west-2)
-	sesh = shared.CreateSession(aws.Config{Region: aws.String("eu-west-1")})
-	// Create a new email client
-	emailClient, err = email.NewEmailer(ses.New(sesh), sender)
-	if err != nil {
-		shared.LogErrorAndExit(err)
+type App interface {
+	HandleRequest(ctx context.Context, event StatusUpdatedEvent) error
+}
+
+// New gets an App that notifies accounts of power status updates
+// Food-safety advisories are scheduled for when a power cut would spoil food
+// Notifications are held back while the device is in a maintenance window
+func New(db database.Client, iot iot.Client, shadow shadow.Client, emailer email.Emailer, scheduler scheduler.Scheduler) App {
+	return &app{
+		db:        db,
+		iot:       iot,
+		shadow:    shadow,
+		emailer:   emailer,
+		scheduler: scheduler,
 	}
 }
 
 // HandleRequest handles a lambda call
-func HandleRequest(ctx context.Context, event StatusUpdatedEvent) error {
+func (a *app) HandleRequest(ctx context.Context, event StatusUpdatedEvent) error {
 	// Print the event
 	log.Printf("%v\n", event)
 	// Validate the event
@@ -87,47 +66,112 @@ func HandleRequest(ctx context.Context, event StatusUpdatedEvent) error {
 		return err
 	}
 	// Get the device
-	device, err := iot.GetThing(event.DeviceId)
+	device, err := a.iot.GetThing(event.DeviceId)
 	if err != nil {
-		shared.LogErrorAndExit(err)
+		return shared.LogErrorAndReturn(err)
 	}
 	// Get the device shadow
-	shdw, err := shadowClient.Get(event.DeviceId)
+	shdw, err := a.shadow.Get(event.DeviceId)
 	if err != nil {
 		return err
 	}
 	accountID := device.AccountId
 	log.Printf("Device '%s' associated with account '%s'", event.DeviceId, accountID)
 	// Get the account
-	account, err := db.GetAccountById(accountID)
+	account, err := a.db.GetAccountById(accountID)
 	if err != nil {
-		shared.LogErrorAndExit(err)
+		return shared.LogErrorAndReturn(err)
+	}
+	// Determine the state transition from the status the device reported before
+	// We assume we are connected if we've been given a status update
+	previous := *shdw
+	previous.Connection.Status = shadow.CONNECTION_STATUS_CONNECTED
+	previous.Power.Value = event.PreviousStatus
+	if previous.Power.Value == "" {
+		// Events raised before the rule included the previous status can only have come from the other one
+		previous.Power.Value = shadow.POWER_STATUS_ON
+		if event.State.Status == shadow.POWER_STATUS_ON {
+			previous.Power.Value = shadow.POWER_STATUS_OFF
+		}
 	}
-	// Determine parameters for the email
-	stateType, transitionType, err := powerStatusToEnums(event.State.Status)
+	from, err := state.FromShadow(&previous)
+	if err != nil {
+		return err
+	}
+	transition, err := state.PowerTransition(event.State.Status)
+	if err != nil {
+		return err
+	}
+	stateEvent, err := from.Apply(transition)
 	if err != nil {
 		return err
 	}
 	// Construct an event to pass to the emailer
+	at := time.Unix(event.Updated.Status.Timestamp, 0)
 	update := email.ContextData{
 		DeviceName: shdw.Name,
-		Time:       time.Unix(event.Updated.Status.Timestamp, 0),
+		Time:       at,
+		Branding:   email.Branding(account.Branding),
+	}
+	update.Runtime, _ = discharge.Remaining(shdw, at)
+	if transition == state.PowerOn {
+		update.Food = foodAdvice(shdw, at)
+	}
+	// Hold back notifications during maintenance
+	if window, ok := maintenance.Find(account.MaintenanceWindowsFor(shdw.Maintenance), at); ok {
+		log.Printf("Device '%s' is in maintenance window '%s', not notifying", event.DeviceId, window.ID)
+		// Nothing is being sent, so keep track of the power cut straight away
+		if err := a.trackOutage(event.DeviceId, shdw, transition, at); err != nil {
+			return err
+		}
+		if transition != state.PowerOff {
+			return nil
+		}
+		// Check the power is back when the window ends
+		payload := maintenance.EndedPayload{DeviceID: event.DeviceId, Ended: window.End(at)}
+		return a.scheduler.Schedule(maintenance.JobTypeEnded, payload, payload.Ended)
 	}
 	// Send 'power status updated' emails
 	log.Printf("Send emails to: %s", account.Emails)
-	err = emailClient.SendUpdate(account.Emails, stateType, transitionType, update)
-	if err != nil {
-		shared.LogErrorAndExit(err)
+	if err := a.emailer.SendUpdate(account.Emails, stateEvent, update); err != nil {
+		return shared.LogErrorAndReturn(err)
 	}
-	return nil
+	// Keep track of the power cut once the account has been told, so a retry gives the same advice
+	return a.trackOutage(event.DeviceId, shdw, transition, at)
 }
 
-func powerStatusToEnums(status string) (email.StateType, email.TransitionType, error) {
-	// We assume we are connected if we've been given a status update
-	if status == shadow.POWER_STATUS_ON {
-		return email.StateTypeOn, email.TransitionTypeOn, nil
-	} else if status == shadow.POWER_STATUS_OFF {
-		return email.StateTypeOff, email.TransitionTypeOff, nil
+// trackOutage records the start of a power cut, or forgets it once the power is back
+func (a *app) trackOutage(deviceID string, shdw *shadow.Shadow, transition state.Transition, at time.Time) error {
+	if transition == state.PowerOff {
+		return a.startOutage(deviceID, shdw, at)
+	}
+	return a.shadow.UpdateOutage(deviceID, shadow.OutageShadow{})
+}
+
+// startOutage records when the power went off, and schedules an advisory for when food would spoil
+func (a *app) startOutage(deviceID string, shdw *shadow.Shadow, at time.Time) error {
+	if err := a.shadow.UpdateOutage(deviceID, shadow.OutageShadow{Started: at}); err != nil {
+		return err
+	}
+	limit, ok := food.Limit(shdw.Appliance)
+	if !ok {
+		// The account hasn't told us what appliance to advise on
+		return nil
 	}
-	return 0, 0, errors.New("Unexpected power status")
+	log.Printf("Advising on food safety for device '%s' at %s", deviceID, at.Add(limit))
+	return a.scheduler.Schedule(food.JobTypeAdvisory, food.AdvisoryPayload{DeviceID: deviceID, OutageStarted: at}, at.Add(limit))
+}
+
+// foodAdvice gets the food-safety advice to include if the power cut lasted too long
+func foodAdvice(shdw *shadow.Shadow, at time.Time) *email.FoodData {
+	// Check if the food is still safe
+	if shdw.Outage.Started.IsZero() {
+		return nil
+	}
+	outage := at.Sub(shdw.Outage.Started)
+	if !food.Exceeded(shdw.Appliance, outage) {
+		return nil
+	}
+	limit, _ := food.Limit(shdw.Appliance)
+	return &email.FoodData{Appliance: shdw.Appliance.Type, Limit: limit, Outage: outage}
 }
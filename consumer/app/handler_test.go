@@ -0,0 +1,216 @@
+package app
+
+//go:generate go run github.com/golang/mock/mockgen -destination mock_db.go -package app -mock_names Client=MockDBClient github.com/briggysmalls/detectordag/shared/database Client
+//go:generate go run github.com/golang/mock/mockgen -destination mock_iot.go -package app -mock_names Client=MockIoTClient github.com/briggysmalls/detectordag/shared/iot Client
+//go:generate go run github.com/golang/mock/mockgen -destination mock_shadow.go -package app -mock_names Client=MockShadowClient github.com/briggysmalls/detectordag/shared/shadow Client
+//go:generate go run github.com/golang/mock/mockgen -destination mock_email.go -package app github.com/briggysmalls/detectordag/shared/email Emailer
+//go:generate go run github.com/golang/mock/mockgen -destination mock_scheduler.go -package app github.com/briggysmalls/detectordag/shared/scheduler Scheduler
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/briggysmalls/detectordag/shared/database"
+	"github.com/briggysmalls/detectordag/shared/email"
+	"github.com/briggysmalls/detectordag/shared/food"
+	"github.com/briggysmalls/detectordag/shared/iot"
+	"github.com/briggysmalls/detectordag/shared/maintenance"
+	"github.com/briggysmalls/detectordag/shared/shadow"
+	"github.com/briggysmalls/detectordag/shared/state"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	deviceID  = "792ac520-0733-4ffe-8137-8aba3ca446d7"
+	accountID = "c6d62b30-00ac-49c4-9268-88559a46889f"
+	timestamp = 1586952000
+)
+
+type mocks struct {
+	db        *MockDBClient
+	iot       *MockIoTClient
+	shadow    *MockShadowClient
+	emailer   *MockEmailer
+	scheduler *MockScheduler
+}
+
+func TestPowerOffSchedulesAdvisory(t *testing.T) {
+	testParams := []struct {
+		appliance shadow.ApplianceShadow
+		advisory  time.Duration
+	}{
+		{appliance: shadow.ApplianceShadow{Type: shadow.APPLIANCE_FRIDGE}, advisory: 4 * time.Hour},
+		{appliance: shadow.ApplianceShadow{Type: shadow.APPLIANCE_FREEZER, SafeFor: 48 * time.Hour}, advisory: 48 * time.Hour},
+		// No appliance has been set
+		{},
+	}
+	for _, params := range testParams {
+		// Create app under test
+		app, m := getStubbedApp(t)
+		expectAccount(m, &shadow.Shadow{Name: "My Dag", Appliance: params.appliance})
+		// Expect the usual email
+		at := time.Unix(timestamp, 0)
+		send := m.emailer.EXPECT().SendUpdate(
+			[]string{"owner@example.com"},
+			state.Event{From: state.On, To: state.Off, Transition: state.PowerOff},
+			email.ContextData{DeviceName: "My Dag", Time: at},
+		)
+		// Expect the outage to be recorded once the email has been sent
+		record := m.shadow.EXPECT().UpdateOutage(deviceID, shadow.OutageShadow{Started: at}).After(send)
+		if params.advisory != 0 {
+			// Expect an advisory to be scheduled for when the food spoils
+			m.scheduler.EXPECT().Schedule(food.JobTypeAdvisory, food.AdvisoryPayload{DeviceID: deviceID, OutageStarted: at}, at.Add(params.advisory)).After(record)
+		}
+		// Run the test
+		assert.NoError(t, app.HandleRequest(nil, createEvent(shadow.POWER_STATUS_OFF)))
+	}
+}
+
+func TestPowerOnAdvisesFood(t *testing.T) {
+	fridge := shadow.ApplianceShadow{Type: shadow.APPLIANCE_FRIDGE}
+	testParams := []struct {
+		appliance shadow.ApplianceShadow
+		outage    time.Duration
+		food      *email.FoodData
+	}{
+		{appliance: fridge, outage: 5 * time.Hour, food: &email.FoodData{Appliance: "fridge", Limit: 4 * time.Hour, Outage: 5 * time.Hour}},
+		// The power came back in time
+		{appliance: fridge, outage: 3 * time.Hour},
+		// No appliance has been set
+		{outage: 72 * time.Hour},
+		// We don't know when the power went
+		{appliance: fridge},
+	}
+	for _, params := range testParams {
+		// Create app under test
+		app, m := getStubbedApp(t)
+		at := time.Unix(timestamp, 0)
+		shdw := &shadow.Shadow{Name: "My Dag", Appliance: params.appliance}
+		if params.outage != 0 {
+			shdw.Outage.Started = at.Add(-params.outage)
+		}
+		expectAccount(m, shdw)
+		gomock.InOrder(
+			// Expect the email to advise on the food
+			m.emailer.EXPECT().SendUpdate(
+				[]string{"owner@example.com"},
+				state.Event{From: state.Off, To: state.On, Transition: state.PowerOn},
+				email.ContextData{DeviceName: "My Dag", Time: at, Food: params.food},
+			),
+			// Expect the outage to be forgotten once the email has been sent
+			m.shadow.EXPECT().UpdateOutage(deviceID, shadow.OutageShadow{}),
+		)
+		// Run the test
+		assert.NoError(t, app.HandleRequest(nil, createEvent(shadow.POWER_STATUS_ON)))
+	}
+}
+
+func TestOutageKeptWhenEmailFails(t *testing.T) {
+	testParams := []struct {
+		status string
+	}{
+		{status: shadow.POWER_STATUS_OFF},
+		{status: shadow.POWER_STATUS_ON},
+	}
+	for _, params := range testParams {
+		// Create app under test
+		app, m := getStubbedApp(t)
+		expectAccount(m, &shadow.Shadow{Name: "My Dag", Appliance: shadow.ApplianceShadow{Type: shadow.APPLIANCE_FRIDGE}})
+		// Fail to send the email
+		m.emailer.EXPECT().SendUpdate(gomock.Any(), gomock.Any(), gomock.Any()).Return(errors.New("SES is down"))
+		// Assert the outage isn't recorded or forgotten, so a retry gives the same advice
+		assert.Error(t, app.HandleRequest(nil, createEvent(params.status)))
+	}
+}
+
+func TestMaintenanceHoldsBackEmails(t *testing.T) {
+	at := time.Unix(timestamp, 0)
+	window := maintenance.Window{ID: "works", Start: at.Add(-time.Hour), Duration: 3 * time.Hour, Repeat: maintenance.Once}
+	testParams := []struct {
+		status string
+	}{
+		{status: shadow.POWER_STATUS_OFF},
+		{status: shadow.POWER_STATUS_ON},
+	}
+	for _, params := range testParams {
+		// Create app under test
+		app, m := getStubbedApp(t)
+		expectAccount(m, &shadow.Shadow{Name: "My Dag", Maintenance: []maintenance.Window{window}})
+		// Expect the outage to still be tracked
+		m.shadow.EXPECT().UpdateOutage(deviceID, gomock.Any())
+		if params.status == shadow.POWER_STATUS_OFF {
+			// Expect a check on the power once the window ends (in the account's time zone)
+			end := at.Add(2 * time.Hour).UTC()
+			m.scheduler.EXPECT().Schedule(maintenance.JobTypeEnded, maintenance.EndedPayload{DeviceID: deviceID, Ended: end}, end)
+		}
+		// Run the test (no emails are expected)
+		assert.NoError(t, app.HandleRequest(nil, createEvent(params.status)))
+	}
+}
+
+func TestIllegalTransitionRejected(t *testing.T) {
+	// Create app under test
+	app, m := getStubbedApp(t)
+	expectAccount(m, &shadow.Shadow{Name: "My Dag"})
+	// Report the power going off when it was already off
+	event := createEvent(shadow.POWER_STATUS_OFF)
+	event.PreviousStatus = shadow.POWER_STATUS_OFF
+	// Assert nothing is recorded or sent
+	err := app.HandleRequest(nil, event)
+	assert.True(t, errors.Is(err, state.ErrIllegalTransition), "%v", err)
+}
+
+func TestLegacyEventAccepted(t *testing.T) {
+	// Create app under test
+	app, m := getStubbedApp(t)
+	expectAccount(m, &shadow.Shadow{Name: "My Dag"})
+	m.shadow.EXPECT().UpdateOutage(deviceID, shadow.OutageShadow{})
+	// Raise an event without the previous status
+	event := createEvent(shadow.POWER_STATUS_ON)
+	event.PreviousStatus = ""
+	// Assert the power is assumed to have been off
+	m.emailer.EXPECT().SendUpdate(
+		[]string{"owner@example.com"},
+		state.Event{From: state.Off, To: state.On, Transition: state.PowerOn},
+		email.ContextData{DeviceName: "My Dag", Time: time.Unix(timestamp, 0)},
+	)
+	assert.NoError(t, app.HandleRequest(nil, event))
+}
+
+func expectAccount(m mocks, shdw *shadow.Shadow) {
+	m.iot.EXPECT().GetThing(deviceID).Return(&iot.Device{DeviceId: deviceID, AccountId: accountID}, nil)
+	m.shadow.EXPECT().Get(deviceID).Return(shdw, nil)
+	m.db.EXPECT().GetAccountById(accountID).Return(&database.Account{AccountId: accountID, Emails: []string{"owner@example.com"}}, nil)
+}
+
+func createEvent(status string) StatusUpdatedEvent {
+	event := StatusUpdatedEvent{
+		DeviceId:  deviceID,
+		Timestamp: timestamp,
+	}
+	event.State.Status = status
+	// The rule only fires when the status changes
+	event.PreviousStatus = shadow.POWER_STATUS_ON
+	if status == shadow.POWER_STATUS_ON {
+		event.PreviousStatus = shadow.POWER_STATUS_OFF
+	}
+	event.Updated.Status.Timestamp = timestamp
+	return event
+}
+
+func getStubbedApp(t *testing.T) (App, mocks) {
+	// Create mock controller
+	ctrl := gomock.NewController(t)
+	// Create the mocks
+	m := mocks{
+		db:        NewMockDBClient(ctrl),
+		iot:       NewMockIoTClient(ctrl),
+		shadow:    NewMockShadowClient(ctrl),
+		emailer:   NewMockEmailer(ctrl),
+		scheduler: NewMockScheduler(ctrl),
+	}
+	// Create the app
+	return New(m.db, m.iot, m.shadow, m.emailer, m.scheduler), m
+}
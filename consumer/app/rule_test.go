@@ -0,0 +1,109 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/briggysmalls/detectordag/shared"
+	"github.com/briggysmalls/detectordag/shared/iotsql"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestPowerStatusChangedRule checks the events the IoT rule sends us can be handled
+func TestPowerStatusChangedRule(t *testing.T) {
+	const (
+		deviceID = "92f59eeb298c4f8c8773e4704d9afe65"
+		topic    = "$aws/things/" + deviceID + "/shadow/update/documents"
+	)
+	// Load the rule
+	rule, err := iotsql.Load("../../config/rules/power_status_changed.yaml")
+	assert.NoError(t, err)
+	testParams := []struct {
+		previous string
+		current  string
+		fired    bool
+	}{
+		{previous: "on", current: "off", fired: true},
+		{previous: "off", current: "on", fired: true},
+		{previous: "on", current: "on", fired: false},
+	}
+	for _, params := range testParams {
+		// Prepare shadow documents, as published by the shadow service
+		documents := fmt.Sprintf(`{
+			"timestamp": 1584803414,
+			"previous": {
+				"state": {"reported": {"status": "%s", "name": "Kitchen"}},
+				"metadata": {"reported": {"status": {"timestamp": 1584803000}, "name": {"timestamp": 1584800000}}},
+				"version": 10
+			},
+			"current": {
+				"state": {"reported": {"status": "%s", "name": "Kitchen"}},
+				"metadata": {"reported": {"status": {"timestamp": 1584803410}, "name": {"timestamp": 1584800000}}},
+				"version": 11
+			}
+		}`, params.previous, params.current)
+		// Run the rule
+		payload, fired, err := rule.Evaluate(topic, []byte(documents), time.Unix(1584803415, 0))
+		assert.NoError(t, err)
+		assert.Equal(t, params.fired, fired)
+		if !fired {
+			continue
+		}
+		// Check we get the event we expect
+		var event StatusUpdatedEvent
+		assert.NoError(t, json.Unmarshal(payload, &event))
+		assert.NoError(t, shared.Validate.Struct(event))
+		assert.Equal(t, deviceID, event.DeviceId)
+		assert.Equal(t, 1584803414, event.Timestamp)
+		assert.Equal(t, params.current, event.State.Status)
+		assert.Equal(t, params.previous, event.PreviousStatus)
+		assert.Equal(t, int64(1584803410), event.Updated.Status.Timestamp)
+	}
+}
+
+// TestDeviceSeenRule checks the DeviceSeen rule reports every shadow update from a device
+func TestDeviceSeenRule(t *testing.T) {
+	const (
+		deviceID = "92f59eeb298c4f8c8773e4704d9afe65"
+		topic    = "$aws/things/" + deviceID + "/shadow/update/documents"
+	)
+	// Load the rule
+	rule, err := iotsql.Load("../../config/rules/device_seen.yaml")
+	assert.NoError(t, err)
+	// Prepare shadow documents in which the status hasn't changed
+	documents := `{
+		"timestamp": 1584803414,
+		"previous": {
+			"state": {"reported": {"status": "on"}},
+			"metadata": {"reported": {"status": {"timestamp": 1584803000}}},
+			"version": 10
+		},
+		"current": {
+			"state": {"reported": {"status": "on"}},
+			"metadata": {"reported": {"status": {"timestamp": 1584803410}}},
+			"version": 11
+		}
+	}`
+	// Run the rule, asserting it fires
+	payload, fired, err := rule.Evaluate(topic, []byte(documents), time.Unix(1584803415, 0))
+	assert.NoError(t, err)
+	assert.True(t, fired)
+	// Messages to other topics are ignored
+	_, fired, err = rule.Evaluate("$aws/things/"+deviceID+"/shadow/update", []byte(documents), time.Unix(1584803415, 0))
+	assert.NoError(t, err)
+	assert.False(t, fired)
+	// Check we get the event we expect
+	var event struct {
+		DeviceId string
+		Updated  struct {
+			Status struct {
+				Timestamp int64
+			}
+		}
+	}
+	assert.NoError(t, json.Unmarshal(payload, &event))
+	assert.Equal(t, deviceID, event.DeviceId)
+	assert.Equal(t, int64(1584803410), event.Updated.Status.Timestamp)
+}
@@ -1,17 +1,87 @@
 package main
 
 import (
+	"fmt"
+	"log"
+	"os"
+
 	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ses"
 	"github.com/briggysmalls/detectordag/consumer/app"
-	"log"
+	"github.com/briggysmalls/detectordag/shared"
+	"github.com/briggysmalls/detectordag/shared/database"
+	"github.com/briggysmalls/detectordag/shared/email"
+	"github.com/briggysmalls/detectordag/shared/iot"
+	"github.com/briggysmalls/detectordag/shared/scheduler"
+	"github.com/briggysmalls/detectordag/shared/shadow"
+	"github.com/briggysmalls/detectordag/shared/sqs"
 )
 
+const (
+	senderEnvVar           = "SENDER_EMAIL"
+	templateLocationEnvVar = "TEMPLATE_LOCATION"
+	advisoryQueueEnvVar    = "ADVISORY_QUEUE_URL"
+)
+
+// Prepare an application to reuse across lambda runs
+var consumer app.App
+
 func init() {
 	// Add file/line number to the default logger
 	log.SetFlags(log.LstdFlags | log.Lshortfile)
+	// Create an AWS session
+	// Good practice will share this session for all services
+	sesh, err := session.NewSessionWithOptions(session.Options{
+		SharedConfigState: session.SharedConfigEnable,
+	})
+	if err != nil {
+		shared.LogErrorAndExit(err)
+	}
+	// Create a database client
+	db, err := database.New(sesh)
+	if err != nil {
+		shared.LogErrorAndExit(err)
+	}
+	// Create an IOT client
+	iotClient, err := iot.New(sesh)
+	if err != nil {
+		shared.LogErrorAndExit(err)
+	}
+	// Create a new shadow client
+	shadowClient, err := shadow.New(sesh)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	// Get the email sender
+	sender := os.Getenv(senderEnvVar)
+	if sender == "" {
+		shared.LogErrorAndReturn(fmt.Errorf("Env var '%s' unset", senderEnvVar))
+	}
+	// Create a source for the email templates
+	templates, err := email.NewTemplateSource(sesh, os.Getenv(templateLocationEnvVar))
+	if err != nil {
+		shared.LogErrorAndExit(err)
+	}
+	// Create a new session just for emailing (there is no emailing service in eu-west-2)
+	emailSesh := shared.CreateSession(aws.Config{Region: aws.String("eu-west-1")})
+	// Create a new email client
+	emailClient, err := email.NewEmailer(ses.New(emailSesh), sender, templates)
+	if err != nil {
+		shared.LogErrorAndExit(err)
+	}
+	// Create a queue to schedule food-safety advisories on
+	advisoryQueue, err := sqs.New(sesh, os.Getenv(advisoryQueueEnvVar))
+	if err != nil {
+		shared.LogErrorAndExit(err)
+	}
+	queue := scheduler.NewSQS(advisoryQueue, scheduler.NewClock(), scheduler.NewDispatcher())
+	// Create the application
+	consumer = app.New(db, iotClient, shadowClient, emailClient, queue)
 }
 
 // main is the entrypoint to the lambda function
 func main() {
-	lambda.Start(app.HandleRequest)
+	lambda.Start(consumer.HandleRequest)
 }
@@ -0,0 +1,121 @@
+package app
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/briggysmalls/detectordag/shared"
+	"github.com/briggysmalls/detectordag/shared/email"
+	"github.com/briggysmalls/detectordag/shared/metrics"
+	"github.com/briggysmalls/detectordag/shared/shadow"
+	"github.com/briggysmalls/detectordag/shared/state"
+	"github.com/briggysmalls/detectordag/shared/telemetry"
+)
+
+// MetricsUpdatedEvent is sent by the 'MetricsReported' IoT rule
+type MetricsUpdatedEvent struct {
+	DeviceId  string                         `validate:"required"`
+	Timestamp int64                          `validate:"required"`
+	Metrics   map[string]shadow.MetricSchema `validate:"required"`
+}
+
+type app struct {
+	*telemetry.Clients
+}
+
+type App interface {
+	HandleRequest(ctx context.Context, event MetricsUpdatedEvent) error
+}
+
+// New gets an App that checks devices' metrics against the rules set up for them
+func New(clients *telemetry.Clients) App {
+	return &app{Clients: clients}
+}
+
+// HandleRequest handles a lambda call
+func (a *app) HandleRequest(ctx context.Context, event MetricsUpdatedEvent) error {
+	// Print the event
+	log.Printf("%v\n", event)
+	// Validate the event
+	if err := shared.Validate.Struct(event); err != nil {
+		return err
+	}
+	// Get the device shadow
+	shdw, err := a.Shadow.Get(event.DeviceId)
+	if err != nil {
+		return err
+	}
+	// Check each rule against the reading it is about
+	at := time.Unix(event.Timestamp, 0)
+	readings := shadow.ExtractReadings(event.Metrics)
+	states := make(map[string]metrics.RuleState)
+	alerts := make(map[string]email.MetricData)
+	for _, rule := range shdw.Metrics.Rules {
+		reading, ok := readings[rule.Metric]
+		if !ok {
+			continue
+		}
+		ruleState, alert := rule.Evaluate(shdw.Metrics.States[rule.ID], reading, at)
+		states[rule.ID] = ruleState
+		if alert {
+			log.Printf("Metric '%s' of device '%s' broke rule '%s': %g", rule.Metric, event.DeviceId, rule.ID, reading.Value)
+			kind, _ := metrics.Lookup(reading.Kind)
+			alerts[rule.ID] = email.MetricData{
+				Name:        rule.Metric,
+				DisplayName: kind.DisplayName,
+				Symbol:      kind.Symbol,
+				Value:       reading.Value,
+				Condition:   string(rule.Condition),
+				Limit:       rule.Value,
+				For:         at.Sub(ruleState.Since),
+			}
+		}
+	}
+	// There's nothing to record if no rules are about these metrics
+	if len(states) == 0 {
+		return nil
+	}
+	// Warn the account
+	var alertErr error
+	if len(alerts) > 0 {
+		if alertErr = a.alert(event.DeviceId, shdw, alerts, at); alertErr != nil {
+			// Try again with the next reading
+			for id := range alerts {
+				ruleState := states[id]
+				ruleState.Alerting = false
+				states[id] = ruleState
+			}
+		}
+	}
+	// Record how the rules stand, even if we failed to alert
+	if err := a.Shadow.UpdateRuleStates(event.DeviceId, states); err != nil {
+		return err
+	}
+	return alertErr
+}
+
+// alert emails the account about rules that have been broken
+func (a *app) alert(deviceID string, shdw *shadow.Shadow, alerts map[string]email.MetricData, at time.Time) error {
+	// Get the account
+	account, err := a.Account(deviceID)
+	if err != nil {
+		return err
+	}
+	// We are connected if we've been given a metrics update
+	current, err := state.New(shadow.CONNECTION_STATUS_CONNECTED, shdw.Power.Value)
+	if err != nil {
+		return err
+	}
+	// Send a 'metric alert' email for each rule
+	log.Printf("Send emails to: %s", account.Emails)
+	for _, alert := range alerts {
+		metric := alert
+		update := telemetry.NewContext(shdw, account, at)
+		update.Metric = &metric
+		if err := a.Emailer.SendAlert(account.Emails, email.MetricAlert, current, update); err != nil {
+			return shared.LogErrorAndReturn(err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,152 @@
+package app
+
+//go:generate go run github.com/golang/mock/mockgen -destination mock_db.go -package app -mock_names Client=MockDBClient github.com/briggysmalls/detectordag/shared/database Client
+//go:generate go run github.com/golang/mock/mockgen -destination mock_iot.go -package app -mock_names Client=MockIoTClient github.com/briggysmalls/detectordag/shared/iot Client
+//go:generate go run github.com/golang/mock/mockgen -destination mock_shadow.go -package app -mock_names Client=MockShadowClient github.com/briggysmalls/detectordag/shared/shadow Client
+//go:generate go run github.com/golang/mock/mockgen -destination mock_email.go -package app github.com/briggysmalls/detectordag/shared/email Emailer
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/briggysmalls/detectordag/shared/database"
+	"github.com/briggysmalls/detectordag/shared/email"
+	"github.com/briggysmalls/detectordag/shared/iot"
+	"github.com/briggysmalls/detectordag/shared/metrics"
+	"github.com/briggysmalls/detectordag/shared/shadow"
+	"github.com/briggysmalls/detectordag/shared/state"
+	"github.com/briggysmalls/detectordag/shared/telemetry"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	deviceID  = "792ac520-0733-4ffe-8137-8aba3ca446d7"
+	accountID = "c6d62b30-00ac-49c4-9268-88559a46889f"
+	timestamp = 1586952000
+)
+
+var damp = metrics.Rule{ID: "damp", Metric: "cellar", Condition: metrics.Above, Value: 80, For: 30 * time.Minute}
+
+type mocks struct {
+	db      *MockDBClient
+	iot     *MockIoTClient
+	shadow  *MockShadowClient
+	emailer *MockEmailer
+}
+
+func TestRuleEvaluated(t *testing.T) {
+	testParams := []struct {
+		state    metrics.RuleState
+		humidity float64
+		alert    *email.MetricData
+		expected metrics.RuleState
+	}{
+		// The condition starts holding
+		{humidity: 85, expected: metrics.RuleState{Since: time.Unix(timestamp, 0)}},
+		// The condition has held for long enough
+		{
+			state:    metrics.RuleState{Since: time.Unix(timestamp-3600, 0)},
+			humidity: 85,
+			alert:    &email.MetricData{Name: "cellar", DisplayName: "Humidity", Symbol: "%", Value: 85, Condition: "above", Limit: 80, For: time.Hour},
+			expected: metrics.RuleState{Since: time.Unix(timestamp-3600, 0), Alerting: true},
+		},
+		// The condition no longer holds
+		{state: metrics.RuleState{Since: time.Unix(timestamp-3600, 0), Alerting: true}, humidity: 60},
+	}
+	for _, params := range testParams {
+		// Create app under test
+		app, m := getStubbedApp(t)
+		m.shadow.EXPECT().Get(deviceID).Return(&shadow.Shadow{
+			Name:  "My Dag",
+			Power: shadow.PowerShadow{Value: shadow.POWER_STATUS_ON},
+			Metrics: shadow.MetricsShadow{
+				Rules:  []metrics.Rule{damp},
+				States: map[string]metrics.RuleState{"damp": params.state},
+			},
+		}, nil)
+		if params.alert != nil {
+			// Expect the account to be warned
+			m.iot.EXPECT().GetThing(deviceID).Return(&iot.Device{DeviceId: deviceID, AccountId: accountID}, nil)
+			m.db.EXPECT().GetAccountById(accountID).Return(&database.Account{AccountId: accountID, Emails: []string{"owner@example.com"}}, nil)
+			m.emailer.EXPECT().SendAlert(
+				[]string{"owner@example.com"},
+				email.MetricAlert,
+				state.On,
+				email.ContextData{DeviceName: "My Dag", Time: time.Unix(timestamp, 0), Metric: params.alert},
+			)
+		}
+		// Expect the rule's state to be recorded
+		m.shadow.EXPECT().UpdateRuleStates(deviceID, map[string]metrics.RuleState{"damp": params.expected})
+		// Run the test
+		assert.NoError(t, app.HandleRequest(nil, createEvent(params.humidity)))
+	}
+}
+
+func TestUnrelatedMetrics(t *testing.T) {
+	testParams := []map[string]shadow.MetricSchema{
+		// The rule is about a different metric
+		{"mains": {Kind: "voltage", Value: 230, Unit: "volts"}},
+		// The reading is invalid
+		{"cellar": {Kind: "humidity", Value: 0.85, Unit: "fraction"}},
+	}
+	for _, reported := range testParams {
+		// Create app under test
+		app, m := getStubbedApp(t)
+		m.shadow.EXPECT().Get(deviceID).Return(&shadow.Shadow{Metrics: shadow.MetricsShadow{Rules: []metrics.Rule{damp}}}, nil)
+		// Assert nothing is recorded
+		assert.NoError(t, app.HandleRequest(nil, MetricsUpdatedEvent{DeviceId: deviceID, Timestamp: timestamp, Metrics: reported}))
+	}
+}
+
+func TestAlertFailed(t *testing.T) {
+	// Create app under test
+	app, m := getStubbedApp(t)
+	m.shadow.EXPECT().Get(deviceID).Return(&shadow.Shadow{Metrics: shadow.MetricsShadow{
+		Rules: []metrics.Rule{{ID: "damp", Metric: "cellar", Condition: metrics.Above, Value: 80}},
+	}}, nil)
+	// Fail to get the account
+	m.iot.EXPECT().GetThing(deviceID).Return(nil, errors.New("Oops"))
+	// Assert the state is still recorded, ready to alert again
+	m.shadow.EXPECT().UpdateRuleStates(deviceID, map[string]metrics.RuleState{"damp": {Since: time.Unix(timestamp, 0)}})
+	// Run the test
+	assert.Error(t, app.HandleRequest(nil, createEvent(85)))
+}
+
+func TestInvalidEvent(t *testing.T) {
+	testParams := []MetricsUpdatedEvent{
+		{Timestamp: timestamp, Metrics: createEvent(85).Metrics},
+		{DeviceId: deviceID, Metrics: createEvent(85).Metrics},
+		{DeviceId: deviceID, Timestamp: timestamp},
+	}
+	for _, event := range testParams {
+		app, _ := getStubbedApp(t)
+		assert.Error(t, app.HandleRequest(nil, event))
+	}
+}
+
+func createEvent(humidity float64) MetricsUpdatedEvent {
+	return MetricsUpdatedEvent{
+		DeviceId:  deviceID,
+		Timestamp: timestamp,
+		Metrics: map[string]shadow.MetricSchema{
+			"cellar": {Kind: "humidity", Value: humidity, Unit: "percent"},
+			"mains":  {Kind: "voltage", Value: 230, Unit: "volts"},
+		},
+	}
+}
+
+func getStubbedApp(t *testing.T) (App, mocks) {
+	// Create mock controller
+	ctrl := gomock.NewController(t)
+	// Create the mocks
+	m := mocks{
+		db:      NewMockDBClient(ctrl),
+		iot:     NewMockIoTClient(ctrl),
+		shadow:  NewMockShadowClient(ctrl),
+		emailer: NewMockEmailer(ctrl),
+	}
+	// Create the app
+	return New(&telemetry.Clients{DB: m.db, IoT: m.iot, Shadow: m.shadow, Emailer: m.emailer}), m
+}
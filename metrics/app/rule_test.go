@@ -0,0 +1,54 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/briggysmalls/detectordag/shared/iotsql"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestMetricsReportedRule checks the events the IoT rule sends us can be handled
+func TestMetricsReportedRule(t *testing.T) {
+	const topic = "$aws/things/" + deviceID + "/shadow/update/documents"
+	// Load the rule
+	rule, err := iotsql.Load("../../config/rules/metrics_reported.yaml")
+	assert.NoError(t, err)
+	testParams := []struct {
+		previous int64
+		current  int64
+		fired    bool
+	}{
+		// The same readings reported again still count
+		{previous: timestamp - 300, current: timestamp, fired: true},
+		// Other updates are ignored
+		{previous: timestamp - 300, current: timestamp - 300, fired: false},
+	}
+	for _, params := range testParams {
+		// Prepare shadow documents, as published by the shadow service
+		documents := fmt.Sprintf(`{
+			"timestamp": %d,
+			"previous": {
+				"state": {"reported": {"metrics": {"cellar": {"kind": "humidity", "value": 85, "unit": "percent"}, "mains": {"kind": "voltage", "value": 230, "unit": "volts"}}}},
+				"metadata": {"reported": {"metrics": {"cellar": {"value": {"timestamp": %d}}}}}
+			},
+			"current": {
+				"state": {"reported": {"metrics": {"cellar": {"kind": "humidity", "value": 85, "unit": "percent"}, "mains": {"kind": "voltage", "value": 230, "unit": "volts"}}}},
+				"metadata": {"reported": {"metrics": {"cellar": {"value": {"timestamp": %d}}}}}
+			}
+		}`, timestamp, params.previous, params.current)
+		// Run the rule
+		payload, fired, err := rule.Evaluate(topic, []byte(documents), time.Unix(timestamp, 0))
+		assert.NoError(t, err)
+		assert.Equal(t, params.fired, fired)
+		if !fired {
+			continue
+		}
+		// Check we get the event we expect
+		var event MetricsUpdatedEvent
+		assert.NoError(t, json.Unmarshal(payload, &event))
+		assert.Equal(t, createEvent(85), event)
+	}
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"log"
+
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/briggysmalls/detectordag/metrics/app"
+	"github.com/briggysmalls/detectordag/shared"
+	"github.com/briggysmalls/detectordag/shared/telemetry"
+)
+
+// Prepare an application to reuse across lambda runs
+var metrics app.App
+
+func init() {
+	// Add file/line number to the default logger
+	log.SetFlags(log.LstdFlags | log.Lshortfile)
+	// Create the clients, sharing an AWS session between them
+	clients, err := telemetry.NewClients(shared.CreateSession(aws.Config{}))
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	// Create the application
+	metrics = app.New(clients)
+}
+
+// main is the entrypoint to the lambda function
+func main() {
+	lambda.Start(metrics.HandleRequest)
+}
@@ -0,0 +1,46 @@
+// Package cellular keeps track of the signal and data usage of devices' cellular connections
+package cellular
+
+import (
+	"time"
+
+	"github.com/briggysmalls/detectordag/shared/shadow"
+)
+
+const (
+	// MaxSamples is how many readings are kept in a device's history
+	MaxSamples = 48
+	// MinProjectionPeriod is how far into a month usage must be before it is projected
+	// (earlier projections are mostly noise)
+	MinProjectionPeriod = 3 * 24 * time.Hour
+	// monthFormat is how months are recorded
+	monthFormat = "2006-01"
+)
+
+// Record adds a reading to the device's history, forgetting the oldest if necessary
+func Record(samples []shadow.CellularSample, sample shadow.CellularSample) []shadow.CellularSample {
+	samples = append(samples, sample)
+	if len(samples) > MaxSamples {
+		samples = samples[len(samples)-MaxSamples:]
+	}
+	return samples
+}
+
+// ProjectMonthlyUsage projects the data used so far this month to the end of the month
+func ProjectMonthlyUsage(used int64, at time.Time) (int64, bool) {
+	// Work out how far through the month we are
+	at = at.UTC()
+	start := time.Date(at.Year(), at.Month(), 1, 0, 0, 0, 0, time.UTC)
+	end := start.AddDate(0, 1, 0)
+	elapsed := at.Sub(start)
+	if elapsed < MinProjectionPeriod {
+		return 0, false
+	}
+	// Assume usage continues at the same rate
+	return int64(float64(used) * float64(end.Sub(start)) / float64(elapsed)), true
+}
+
+// Month gets the name of the month a time falls in (e.g. '2020-03')
+func Month(at time.Time) string {
+	return at.UTC().Format(monthFormat)
+}
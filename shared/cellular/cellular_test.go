@@ -0,0 +1,46 @@
+package cellular
+
+import (
+	"testing"
+	"time"
+
+	"github.com/briggysmalls/detectordag/shared/shadow"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecord(t *testing.T) {
+	var samples []shadow.CellularSample
+	start := time.Unix(1584800000, 0)
+	for i := 0; i < MaxSamples+2; i++ {
+		samples = Record(samples, shadow.CellularSample{Time: start.Add(time.Duration(i) * time.Hour), DataUsed: int64(i)})
+	}
+	// Assert the oldest are forgotten
+	assert.Len(t, samples, MaxSamples)
+	assert.Equal(t, int64(2), samples[0].DataUsed)
+	assert.Equal(t, int64(MaxSamples+1), samples[MaxSamples-1].DataUsed)
+}
+
+func TestProjectMonthlyUsage(t *testing.T) {
+	testParams := []struct {
+		used      int64
+		at        time.Time
+		projected int64
+		ok        bool
+	}{
+		// A third of the way through April
+		{used: 100, at: time.Date(2020, time.April, 11, 0, 0, 0, 0, time.UTC), projected: 300, ok: true},
+		// Half way through February (in a leap year)
+		{used: 100, at: time.Date(2020, time.February, 15, 12, 0, 0, 0, time.UTC), projected: 200, ok: true},
+		// Too early in the month
+		{used: 100, at: time.Date(2020, time.April, 2, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, params := range testParams {
+		projected, ok := ProjectMonthlyUsage(params.used, params.at)
+		assert.Equal(t, params.ok, ok)
+		assert.Equal(t, params.projected, projected)
+	}
+}
+
+func TestMonth(t *testing.T) {
+	assert.Equal(t, "2020-03", Month(time.Date(2020, time.March, 31, 23, 59, 0, 0, time.UTC)))
+}
@@ -0,0 +1,89 @@
+package database
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/expression"
+)
+
+const (
+	CONNECTION_HISTORY_TABLE = "connection-history"
+	// How long connection events are kept for
+	connectionHistoryRetention = 30 * 24 * time.Hour
+)
+
+// ConnectionEvent is a connection status change seen for a device
+type ConnectionEvent struct {
+	DeviceId string `dynamodbav:"device-id"`
+	// Milliseconds since the epoch
+	Timestamp int64  `dynamodbav:"timestamp"`
+	Status    string `dynamodbav:"status"`
+}
+
+// Time gets when the event occurred
+func (e *ConnectionEvent) Time() time.Time {
+	return time.Unix(0, e.Timestamp*int64(time.Millisecond)).UTC()
+}
+
+// RecordConnectionEvent adds an event to a device's connection history
+// Recording the same event twice has no further effect
+func (d *client) RecordConnectionEvent(deviceID, status string, at time.Time) error {
+	item, err := dynamodbattribute.MarshalMap(ConnectionEvent{
+		DeviceId:  deviceID,
+		Timestamp: at.UnixNano() / int64(time.Millisecond),
+		Status:    status,
+	})
+	if err != nil {
+		return err
+	}
+	// Let DynamoDB tidy up old events
+	item["expires"] = &dynamodb.AttributeValue{N: aws.String(strconv.FormatInt(at.Add(connectionHistoryRetention).Unix(), 10))}
+	_, err = d.db.PutItem(&dynamodb.PutItemInput{
+		TableName: aws.String(CONNECTION_HISTORY_TABLE),
+		Item:      item,
+	})
+	if err != nil {
+		return fmt.Errorf("Failed to record connection event for '%s': %w", deviceID, err)
+	}
+	return nil
+}
+
+// GetConnectionEvents gets a device's connection history since the given time, oldest first
+func (d *client) GetConnectionEvents(deviceID string, since time.Time) ([]ConnectionEvent, error) {
+	// Build an expression
+	kc := expression.Key("device-id").Equal(expression.Value(deviceID)).
+		And(expression.Key("timestamp").GreaterThanEqual(expression.Value(since.UnixNano() / int64(time.Millisecond))))
+	expr, err := expression.NewBuilder().WithKeyCondition(kc).Build()
+	if err != nil {
+		return nil, fmt.Errorf("Failed build dynamodb query for device '%s': %w", deviceID, err)
+	}
+	// Request the events
+	events := []ConnectionEvent{}
+	var unmarshalErr error
+	err = d.db.QueryPages(&dynamodb.QueryInput{
+		TableName:                 aws.String(CONNECTION_HISTORY_TABLE),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+		KeyConditionExpression:    expr.KeyCondition(),
+		ScanIndexForward:          aws.Bool(true),
+	}, func(page *dynamodb.QueryOutput, last bool) bool {
+		var pageEvents []ConnectionEvent
+		if unmarshalErr = dynamodbattribute.UnmarshalListOfMaps(page.Items, &pageEvents); unmarshalErr != nil {
+			return false
+		}
+		events = append(events, pageEvents...)
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get connection events for '%s': %w", deviceID, err)
+	}
+	if unmarshalErr != nil {
+		return nil, unmarshalErr
+	}
+	return events, nil
+}
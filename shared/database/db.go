@@ -3,11 +3,17 @@ package database
 import (
 	"errors"
 	"fmt"
+	"log"
+	"time"
+	// Embed the time zone database, as the lambda runtime might not have one
+	_ "time/tzdata"
+
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
 	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
 	"github.com/aws/aws-sdk-go/service/dynamodb/expression"
+	"github.com/briggysmalls/detectordag/shared/maintenance"
 )
 
 const (
@@ -17,6 +23,8 @@ const (
 	DEVICES_GSI_NAME  = "account-id-index"
 )
 
+var ErrUnknownAccount = errors.New("Unknown account")
+
 type client struct {
 	db *dynamodb.DynamoDB
 }
@@ -25,7 +33,31 @@ type client struct {
 type Client interface {
 	GetAccountById(id string) (*Account, error)
 	GetAccountByUsername(username string) (*Account, error)
-	UpdateAccountEmails(accountId string, emails []string) (*Account, error)
+	UpdateAccount(accountId string, update AccountUpdate) (*Account, error)
+	UpdateAccountMaintenance(accountId string, windows []maintenance.Window) (*Account, error)
+	UpdateAccountMFA(accountId string, mfa MFA) (*Account, error)
+	UseMFAStep(accountId string, step int64) (bool, error)
+	UseRecoveryCode(accountId string, hash string) (bool, error)
+	UpdateRecoveryCodes(accountId string, hashes []string) error
+	CreateMFAChallenge(challenge MFAChallenge) error
+	CountMFAChallenges(accountId string, now time.Time) (int, error)
+	UseMFAChallenge(hash string) (*MFAChallenge, error)
+	ClaimEvent(id string, expires time.Time) (bool, error)
+	ReleaseEvent(id string) error
+	CreateRefreshToken(token RefreshToken) error
+	UseRefreshToken(hash string) (*RefreshToken, error)
+	GetRefreshToken(hash string) (*RefreshToken, error)
+	RevokeRefreshTokens(token RefreshToken) error
+	RevokeAccountRefreshTokens(accountId string) error
+	RevokeToken(id string, expires time.Time) error
+	IsTokenRevoked(id string) (bool, error)
+	GetLoginAttempts(key string, now time.Time) (*LoginAttempts, error)
+	RecordLoginAttempt(seen LoginAttempts, at time.Time, expires time.Time) (*LoginAttempts, error)
+	ClearLoginAttempts(key string) error
+	RecordLogin(login Login) error
+	GetLogins(accountID string, limit int) ([]Login, error)
+	RecordConnectionEvent(deviceID, status string, at time.Time) error
+	GetConnectionEvents(deviceID string, since time.Time) ([]ConnectionEvent, error)
 }
 
 // account represents an 'accounts' table entry
@@ -34,6 +66,48 @@ type Account struct {
 	Emails    []string `dynamodbav:"emails"`
 	Username  string   `dynamodbav:"username"`
 	Password  string   `dynamodbav:"password"`
+	Branding  Branding `dynamodbav:"branding"`
+	// Seconds to wait before notifying that a device has disconnected
+	GracePeriod int `dynamodbav:"grace-period"`
+	// Monthly data allowance (bytes) of each device's plan, or zero for no allowance
+	DataCap int64 `dynamodbav:"data-cap"`
+	// Windows during which notifications for all the account's devices are held back
+	Maintenance []MaintenanceWindow `dynamodbav:"maintenance"`
+	// IANA name of the time zone that recurring maintenance windows follow, or empty for UTC
+	TimeZone string `dynamodbav:"time-zone"`
+	// Two-factor authentication, if the account has enrolled
+	MFA MFA `dynamodbav:"mfa"`
+}
+
+// AccountUpdate holds the account fields to change, leaving those that are nil unchanged
+type AccountUpdate struct {
+	Emails *[]string
+	// Monthly data allowance (bytes), or zero to remove the allowance
+	DataCap *int64
+	// IANA time zone name, or empty for UTC
+	TimeZone *string
+	// Seconds to wait before notifying that a device has disconnected
+	GracePeriod *int
+	// Replaces all of the branding overrides
+	Branding *Branding
+}
+
+// MaintenanceWindow is the database representation of a maintenance.Window
+type MaintenanceWindow struct {
+	ID string `dynamodbav:"id"`
+	// Unix time
+	Start int64 `dynamodbav:"start"`
+	// Seconds
+	Duration int    `dynamodbav:"duration"`
+	Repeat   string `dynamodbav:"repeat"`
+}
+
+// Branding holds an account's overrides for the look of notifications
+type Branding struct {
+	LogoURL      string `dynamodbav:"logo-url"`
+	HeaderColour string `dynamodbav:"header-colour"`
+	ButtonColour string `dynamodbav:"button-colour"`
+	SenderName   string `dynamodbav:"sender-name"`
 }
 
 // New gets a new Client
@@ -65,7 +139,7 @@ func (d *client) GetAccountById(id string) (*Account, error) {
 	}
 	// Check we got exactly one account
 	if result.Item == nil {
-		return nil, fmt.Errorf("Unknown account: %s", id)
+		return nil, fmt.Errorf("%w: %s", ErrUnknownAccount, id)
 	}
 	// Unmarshal the account
 	return unmarshalAccount(result.Item)
@@ -92,23 +166,81 @@ func (d *client) GetAccountByUsername(username string) (*Account, error) {
 	}
 	// Check we got exactly one account
 	if len(result.Items) != 1 {
-		return nil, fmt.Errorf("Unknown account: %s", username)
+		return nil, fmt.Errorf("%w: %s", ErrUnknownAccount, username)
 	}
 	return unmarshalAccount(result.Items[0])
 }
 
-func (d *client) UpdateAccountEmails(accountId string, emails []string) (*Account, error) {
+func (d *client) UpdateAccount(accountId string, update AccountUpdate) (*Account, error) {
+	// Build an update expression from the fields that were given
+	var changes expression.UpdateBuilder
+	changed := false
+	if update.Emails != nil {
+		changes = changes.Set(expression.Name("emails"), expression.Value(*update.Emails))
+		changed = true
+	}
+	if update.DataCap != nil {
+		changes = changes.Set(expression.Name("data-cap"), expression.Value(*update.DataCap))
+		changed = true
+	}
+	if update.TimeZone != nil {
+		changes = changes.Set(expression.Name("time-zone"), expression.Value(*update.TimeZone))
+		changed = true
+	}
+	if update.GracePeriod != nil {
+		changes = changes.Set(expression.Name("grace-period"), expression.Value(*update.GracePeriod))
+		changed = true
+	}
+	if update.Branding != nil {
+		changes = changes.Set(expression.Name("branding"), expression.Value(*update.Branding))
+		changed = true
+	}
+	// There's nothing to write if nothing was given
+	if !changed {
+		return d.GetAccountById(accountId)
+	}
+	// Create the DynamoDB expression from the Update.
+	expr, err := expression.NewBuilder().WithUpdate(changes).Build()
+	if err != nil {
+		return nil, err
+	}
+	// Update the account (request updated response)
+	result, err := d.db.UpdateItem(&dynamodb.UpdateItemInput{
+		TableName:                 aws.String(ACCOUNTS_TABLE),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+		Key:                       map[string]*dynamodb.AttributeValue{"account-id": {S: aws.String(accountId)}},
+		UpdateExpression:          expr.Update(),
+		ReturnValues:              aws.String(dynamodb.ReturnValueAllNew),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return unmarshalAccount(result.Attributes)
+}
+
+func (d *client) UpdateAccountMaintenance(accountId string, windows []maintenance.Window) (*Account, error) {
+	// Convert the windows into their database representation
+	records := make([]MaintenanceWindow, len(windows))
+	for i, window := range windows {
+		records[i] = MaintenanceWindow{
+			ID:       window.ID,
+			Start:    window.Start.Unix(),
+			Duration: int(window.Duration.Seconds()),
+			Repeat:   string(window.Repeat),
+		}
+	}
 	// Build an update expression
 	update := expression.Set(
-		expression.Name("emails"),
-		expression.Value(emails),
+		expression.Name("maintenance"),
+		expression.Value(records),
 	)
 	// Create the DynamoDB expression from the Update.
 	expr, err := expression.NewBuilder().WithUpdate(update).Build()
 	if err != nil {
 		return nil, err
 	}
-	// Update the emails (request updated response)
+	// Update the windows (request updated response)
 	result, err := d.db.UpdateItem(&dynamodb.UpdateItemInput{
 		TableName:                 aws.String(ACCOUNTS_TABLE),
 		ExpressionAttributeNames:  expr.Names(),
@@ -123,6 +255,50 @@ func (d *client) UpdateAccountEmails(accountId string, emails []string) (*Accoun
 	return unmarshalAccount(result.Attributes)
 }
 
+// MaintenanceWindows gets the windows during which notifications for all the account's devices are held back
+func (a *Account) MaintenanceWindows() []maintenance.Window {
+	loc := a.Location()
+	windows := make([]maintenance.Window, len(a.Maintenance))
+	for i, record := range a.Maintenance {
+		windows[i] = maintenance.Window{
+			ID:       record.ID,
+			Start:    time.Unix(record.Start, 0),
+			Duration: time.Duration(record.Duration) * time.Second,
+			Repeat:   maintenance.Repeat(record.Repeat),
+		}.In(loc)
+	}
+	return windows
+}
+
+// MaintenanceWindowsFor gets the account's maintenance windows along with a device's own,
+// all repeating in the account's time zone
+func (a *Account) MaintenanceWindowsFor(device []maintenance.Window) []maintenance.Window {
+	loc := a.Location()
+	windows := a.MaintenanceWindows()
+	for _, window := range device {
+		windows = append(windows, window.In(loc))
+	}
+	return windows
+}
+
+// Location gets the account's time zone, falling back to UTC if it isn't set or known
+func (a *Account) Location() *time.Location {
+	if a.TimeZone == "" {
+		return time.UTC
+	}
+	loc, err := time.LoadLocation(a.TimeZone)
+	if err != nil {
+		log.Printf("Account '%s' has an unknown time zone '%s': %v", a.AccountId, a.TimeZone, err)
+		return time.UTC
+	}
+	return loc
+}
+
+// DisconnectionGracePeriod gets how long to wait before notifying that a device has disconnected
+func (a *Account) DisconnectionGracePeriod() time.Duration {
+	return time.Duration(a.GracePeriod) * time.Second
+}
+
 func unmarshalAccount(item map[string]*dynamodb.AttributeValue) (*Account, error) {
 	// Unmarshal the account
 	account := Account{}
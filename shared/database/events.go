@@ -0,0 +1,57 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+const (
+	EVENTS_TABLE = "processed-events"
+)
+
+// ClaimEvent records that an event is being processed, so it is only processed once
+// Returns false if the event has already been claimed
+// The record is deleted by DynamoDB some time after it expires
+func (d *client) ClaimEvent(id string, expires time.Time) (bool, error) {
+	_, err := d.db.PutItem(&dynamodb.PutItemInput{
+		TableName: aws.String(EVENTS_TABLE),
+		Item: map[string]*dynamodb.AttributeValue{
+			"event-id": {S: aws.String(id)},
+			"expires":  {N: aws.String(strconv.FormatInt(expires.Unix(), 10))},
+		},
+		// Only succeed if nobody else has claimed the event
+		ConditionExpression: aws.String("attribute_not_exists(#id)"),
+		ExpressionAttributeNames: map[string]*string{
+			"#id": aws.String("event-id"),
+		},
+	})
+	// Check if the event was already claimed
+	var aerr awserr.Error
+	if errors.As(err, &aerr) && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("Failed to claim event '%s': %w", id, err)
+	}
+	return true, nil
+}
+
+// ReleaseEvent removes a claim, so that the event can be processed again
+func (d *client) ReleaseEvent(id string) error {
+	_, err := d.db.DeleteItem(&dynamodb.DeleteItemInput{
+		TableName: aws.String(EVENTS_TABLE),
+		Key: map[string]*dynamodb.AttributeValue{
+			"event-id": {S: aws.String(id)},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("Failed to release event '%s': %w", id, err)
+	}
+	return nil
+}
@@ -0,0 +1,195 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/expression"
+)
+
+const (
+	LOGIN_ATTEMPTS_TABLE = "login-attempts"
+	LOGINS_TABLE         = "logins"
+	// How long login attempts are kept in an account's audit trail
+	loginRetention = 90 * 24 * time.Hour
+)
+
+var ErrLoginAttemptsChanged = errors.New("Login attempts changed since they were read")
+
+// LoginAttempts counts recent failed logins for a username or IP address
+// Attempts are counted before they're checked, so that concurrent attempts can't
+// get around the count, and forgotten once one succeeds
+type LoginAttempts struct {
+	Key      string `dynamodbav:"key"`
+	Failures int    `dynamodbav:"failures"`
+	// Unix time
+	LastFailure int64 `dynamodbav:"last-failure"`
+	// Unix time (DynamoDB deletes the record some time after)
+	Expires int64 `dynamodbav:"expires"`
+}
+
+// Login is an attempt to log in to an account, kept so the owner can see it
+type Login struct {
+	AccountId string `dynamodbav:"account-id"`
+	// Nanoseconds since the epoch
+	Timestamp int64  `dynamodbav:"timestamp"`
+	IP        string `dynamodbav:"ip"`
+	UserAgent string `dynamodbav:"user-agent"`
+	Success   bool   `dynamodbav:"success"`
+	// Why the attempt failed
+	Reason string `dynamodbav:"reason"`
+}
+
+// Time gets when the attempt was made
+func (l *Login) Time() time.Time {
+	return time.Unix(0, l.Timestamp).UTC()
+}
+
+// GetLoginAttempts gets the recent failed logins for a key
+// Keys that haven't failed (or whose failures have expired) have no failures
+func (d *client) GetLoginAttempts(key string, now time.Time) (*LoginAttempts, error) {
+	result, err := d.db.GetItem(&dynamodb.GetItemInput{
+		TableName: aws.String(LOGIN_ATTEMPTS_TABLE),
+		Key: map[string]*dynamodb.AttributeValue{
+			"key": {S: aws.String(key)},
+		},
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get login attempts for '%s': %w", key, err)
+	}
+	// DynamoDB can take a while to delete expired records
+	attempts := LoginAttempts{Key: key}
+	if result.Item == nil {
+		return &attempts, nil
+	}
+	if err := dynamodbattribute.UnmarshalMap(result.Item, &attempts); err != nil {
+		return nil, fmt.Errorf("Failed to unmarshal login attempts: %w", err)
+	}
+	if attempts.Expires <= now.Unix() {
+		return &LoginAttempts{Key: key}, nil
+	}
+	return &attempts, nil
+}
+
+// RecordLoginAttempt counts a login attempt for a key, before the attempt is checked
+// The attempts must be as they were last read, so only one of several concurrent attempts
+// can be counted against them: ErrLoginAttemptsChanged is returned for the others
+func (d *client) RecordLoginAttempt(seen LoginAttempts, at time.Time, expires time.Time) (*LoginAttempts, error) {
+	attempts := LoginAttempts{Key: seen.Key, Failures: seen.Failures + 1, LastFailure: at.Unix(), Expires: expires.Unix()}
+	var err error
+	if seen.Failures == 0 {
+		// There are no failures still counting, so start again (unless someone else just has)
+		var item map[string]*dynamodb.AttributeValue
+		item, err = dynamodbattribute.MarshalMap(attempts)
+		if err != nil {
+			return nil, err
+		}
+		_, err = d.db.PutItem(&dynamodb.PutItemInput{
+			TableName:           aws.String(LOGIN_ATTEMPTS_TABLE),
+			Item:                item,
+			ConditionExpression: aws.String("attribute_not_exists(#key) OR #expires <= :at"),
+			ExpressionAttributeNames: map[string]*string{
+				"#key":     aws.String("key"),
+				"#expires": aws.String("expires"),
+			},
+			ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+				":at": {N: aws.String(strconv.FormatInt(at.Unix(), 10))},
+			},
+		})
+	} else {
+		// Add to the failures, as long as nobody else has since they were read
+		_, err = d.db.UpdateItem(&dynamodb.UpdateItemInput{
+			TableName: aws.String(LOGIN_ATTEMPTS_TABLE),
+			Key: map[string]*dynamodb.AttributeValue{
+				"key": {S: aws.String(seen.Key)},
+			},
+			UpdateExpression:    aws.String("ADD #failures :one SET #last = :at, #expires = :expires"),
+			ConditionExpression: aws.String("#failures = :seen AND #expires > :at"),
+			ExpressionAttributeNames: map[string]*string{
+				"#failures": aws.String("failures"),
+				"#last":     aws.String("last-failure"),
+				"#expires":  aws.String("expires"),
+			},
+			ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+				":one":     {N: aws.String("1")},
+				":seen":    {N: aws.String(strconv.Itoa(seen.Failures))},
+				":at":      {N: aws.String(strconv.FormatInt(at.Unix(), 10))},
+				":expires": {N: aws.String(strconv.FormatInt(expires.Unix(), 10))},
+			},
+		})
+	}
+	var aerr awserr.Error
+	if errors.As(err, &aerr) && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+		return nil, ErrLoginAttemptsChanged
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Failed to record login attempt for '%s': %w", seen.Key, err)
+	}
+	return &attempts, nil
+}
+
+// ClearLoginAttempts forgets the failed logins for a key
+func (d *client) ClearLoginAttempts(key string) error {
+	_, err := d.db.DeleteItem(&dynamodb.DeleteItemInput{
+		TableName: aws.String(LOGIN_ATTEMPTS_TABLE),
+		Key: map[string]*dynamodb.AttributeValue{
+			"key": {S: aws.String(key)},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("Failed to clear login attempts for '%s': %w", key, err)
+	}
+	return nil
+}
+
+// RecordLogin adds an attempt to an account's audit trail
+func (d *client) RecordLogin(login Login) error {
+	item, err := dynamodbattribute.MarshalMap(login)
+	if err != nil {
+		return err
+	}
+	// Let DynamoDB tidy up old attempts
+	item["expires"] = &dynamodb.AttributeValue{N: aws.String(strconv.FormatInt(login.Time().Add(loginRetention).Unix(), 10))}
+	_, err = d.db.PutItem(&dynamodb.PutItemInput{
+		TableName: aws.String(LOGINS_TABLE),
+		Item:      item,
+	})
+	if err != nil {
+		return fmt.Errorf("Failed to record login for '%s': %w", login.AccountId, err)
+	}
+	return nil
+}
+
+// GetLogins gets an account's most recent login attempts, newest first
+func (d *client) GetLogins(accountID string, limit int) ([]Login, error) {
+	// Build an expression
+	kc := expression.Key("account-id").Equal(expression.Value(accountID))
+	expr, err := expression.NewBuilder().WithKeyCondition(kc).Build()
+	if err != nil {
+		return nil, fmt.Errorf("Failed build dynamodb query for account '%s': %w", accountID, err)
+	}
+	// Request the attempts
+	result, err := d.db.Query(&dynamodb.QueryInput{
+		TableName:                 aws.String(LOGINS_TABLE),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+		KeyConditionExpression:    expr.KeyCondition(),
+		ScanIndexForward:          aws.Bool(false),
+		Limit:                     aws.Int64(int64(limit)),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get logins for '%s': %w", accountID, err)
+	}
+	logins := []Login{}
+	if err := dynamodbattribute.UnmarshalListOfMaps(result.Items, &logins); err != nil {
+		return nil, fmt.Errorf("Failed to unmarshal logins: %w", err)
+	}
+	return logins, nil
+}
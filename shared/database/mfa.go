@@ -0,0 +1,217 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/expression"
+)
+
+const (
+	MFA_CHALLENGES_TABLE    = "mfa-challenges"
+	MFA_CHALLENGES_GSI_NAME = "account-id-index"
+)
+
+var ErrMFAChallengeNotFound = errors.New("MFA challenge not found")
+
+// MFA is an account's two-factor authentication (TOTP) settings
+type MFA struct {
+	// Base32-encoded TOTP secret
+	Secret string `dynamodbav:"secret"`
+	// Whether a code is needed to log in (rather than just having started enrolment)
+	Enabled bool `dynamodbav:"enabled"`
+	// Hashes of the codes that can be used (once) instead of a TOTP code
+	RecoveryCodes []string `dynamodbav:"recovery-codes,stringset,omitempty"`
+	// The last TOTP time step a code was used for, so codes can't be reused
+	LastStep int64 `dynamodbav:"last-step"`
+}
+
+// MFAChallenge represents a 'mfa-challenges' table entry
+// It shows the password was correct, while a code is awaited
+type MFAChallenge struct {
+	Hash      string `dynamodbav:"challenge-hash"`
+	AccountId string `dynamodbav:"account-id"`
+	// Unix time (DynamoDB deletes the record some time after)
+	Expires int64 `dynamodbav:"expires"`
+}
+
+// UpdateAccountMFA replaces an account's two-factor authentication settings
+func (d *client) UpdateAccountMFA(accountId string, mfa MFA) (*Account, error) {
+	// Build an update expression
+	update := expression.Set(
+		expression.Name("mfa"),
+		expression.Value(mfa),
+	)
+	// Create the DynamoDB expression from the Update.
+	expr, err := expression.NewBuilder().WithUpdate(update).Build()
+	if err != nil {
+		return nil, err
+	}
+	// Update the settings (request updated response)
+	result, err := d.db.UpdateItem(&dynamodb.UpdateItemInput{
+		TableName:                 aws.String(ACCOUNTS_TABLE),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+		Key:                       map[string]*dynamodb.AttributeValue{"account-id": {S: aws.String(accountId)}},
+		UpdateExpression:          expr.Update(),
+		ReturnValues:              aws.String(dynamodb.ReturnValueAllNew),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to update MFA for '%s': %w", accountId, err)
+	}
+	return unmarshalAccount(result.Attributes)
+}
+
+// UseMFAStep records that a TOTP code has been used
+// Returns false if a code for the same (or a later) time step has already been used
+func (d *client) UseMFAStep(accountId string, step int64) (bool, error) {
+	_, err := d.db.UpdateItem(&dynamodb.UpdateItemInput{
+		TableName:           aws.String(ACCOUNTS_TABLE),
+		Key:                 map[string]*dynamodb.AttributeValue{"account-id": {S: aws.String(accountId)}},
+		UpdateExpression:    aws.String("SET #mfa.#step = :step"),
+		ConditionExpression: aws.String("#mfa.#step < :step"),
+		ExpressionAttributeNames: map[string]*string{
+			"#mfa":  aws.String("mfa"),
+			"#step": aws.String("last-step"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":step": {N: aws.String(strconv.FormatInt(step, 10))},
+		},
+	})
+	var aerr awserr.Error
+	if errors.As(err, &aerr) && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("Failed to use MFA code for '%s': %w", accountId, err)
+	}
+	return true, nil
+}
+
+// UpdateRecoveryCodes replaces an account's recovery codes, leaving the rest of its settings alone
+func (d *client) UpdateRecoveryCodes(accountId string, hashes []string) error {
+	_, err := d.db.UpdateItem(&dynamodb.UpdateItemInput{
+		TableName:        aws.String(ACCOUNTS_TABLE),
+		Key:              map[string]*dynamodb.AttributeValue{"account-id": {S: aws.String(accountId)}},
+		UpdateExpression: aws.String("SET #mfa.#codes = :codes"),
+		ExpressionAttributeNames: map[string]*string{
+			"#mfa":   aws.String("mfa"),
+			"#codes": aws.String("recovery-codes"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":codes": {SS: aws.StringSlice(hashes)},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("Failed to update recovery codes for '%s': %w", accountId, err)
+	}
+	return nil
+}
+
+// UseRecoveryCode removes a recovery code, so it can only be used once
+// Returns false if the account doesn't have the code
+func (d *client) UseRecoveryCode(accountId string, hash string) (bool, error) {
+	_, err := d.db.UpdateItem(&dynamodb.UpdateItemInput{
+		TableName:           aws.String(ACCOUNTS_TABLE),
+		Key:                 map[string]*dynamodb.AttributeValue{"account-id": {S: aws.String(accountId)}},
+		UpdateExpression:    aws.String("DELETE #mfa.#codes :codes"),
+		ConditionExpression: aws.String("contains(#mfa.#codes, :code)"),
+		ExpressionAttributeNames: map[string]*string{
+			"#mfa":   aws.String("mfa"),
+			"#codes": aws.String("recovery-codes"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":codes": {SS: []*string{aws.String(hash)}},
+			":code":  {S: aws.String(hash)},
+		},
+	})
+	var aerr awserr.Error
+	if errors.As(err, &aerr) && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("Failed to use recovery code for '%s': %w", accountId, err)
+	}
+	return true, nil
+}
+
+// CreateMFAChallenge stores a challenge, so it can later be completed with a code
+func (d *client) CreateMFAChallenge(challenge MFAChallenge) error {
+	item, err := dynamodbattribute.MarshalMap(challenge)
+	if err != nil {
+		return err
+	}
+	_, err = d.db.PutItem(&dynamodb.PutItemInput{
+		TableName: aws.String(MFA_CHALLENGES_TABLE),
+		Item:      item,
+	})
+	if err != nil {
+		return fmt.Errorf("Failed to create MFA challenge: %w", err)
+	}
+	return nil
+}
+
+// CountMFAChallenges counts the challenges an account has open (ignoring any that have expired)
+func (d *client) CountMFAChallenges(accountId string, now time.Time) (int, error) {
+	// Build an expression
+	kc := expression.Key("account-id").Equal(expression.Value(accountId))
+	filter := expression.Name("expires").GreaterThan(expression.Value(now.Unix()))
+	expr, err := expression.NewBuilder().WithKeyCondition(kc).WithFilter(filter).Build()
+	if err != nil {
+		return 0, fmt.Errorf("Failed build dynamodb query for account '%s': %w", accountId, err)
+	}
+	// Count the challenges
+	count := 0
+	err = d.db.QueryPages(&dynamodb.QueryInput{
+		TableName:                 aws.String(MFA_CHALLENGES_TABLE),
+		IndexName:                 aws.String(MFA_CHALLENGES_GSI_NAME),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+		KeyConditionExpression:    expr.KeyCondition(),
+		FilterExpression:          expr.Filter(),
+		Select:                    aws.String(dynamodb.SelectCount),
+	}, func(page *dynamodb.QueryOutput, last bool) bool {
+		count += int(aws.Int64Value(page.Count))
+		return true
+	})
+	if err != nil {
+		return 0, fmt.Errorf("Failed to count MFA challenges for '%s': %w", accountId, err)
+	}
+	return count, nil
+}
+
+// UseMFAChallenge removes a challenge, so it can only be attempted once
+// Returns ErrMFAChallengeNotFound if the challenge doesn't exist (or has already been attempted)
+func (d *client) UseMFAChallenge(hash string) (*MFAChallenge, error) {
+	result, err := d.db.DeleteItem(&dynamodb.DeleteItemInput{
+		TableName: aws.String(MFA_CHALLENGES_TABLE),
+		Key: map[string]*dynamodb.AttributeValue{
+			"challenge-hash": {S: aws.String(hash)},
+		},
+		// Only succeed if the challenge hasn't been attempted by someone else
+		ConditionExpression: aws.String("attribute_exists(#hash)"),
+		ExpressionAttributeNames: map[string]*string{
+			"#hash": aws.String("challenge-hash"),
+		},
+		ReturnValues: aws.String(dynamodb.ReturnValueAllOld),
+	})
+	var aerr awserr.Error
+	if errors.As(err, &aerr) && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+		return nil, ErrMFAChallengeNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Failed to use MFA challenge: %w", err)
+	}
+	// Unmarshal the challenge
+	var challenge MFAChallenge
+	if err := dynamodbattribute.UnmarshalMap(result.Attributes, &challenge); err != nil {
+		return nil, fmt.Errorf("Failed to unmarshal MFA challenge: %w", err)
+	}
+	return &challenge, nil
+}
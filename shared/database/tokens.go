@@ -0,0 +1,225 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/expression"
+)
+
+const (
+	REFRESH_TOKENS_TABLE            = "refresh-tokens"
+	REVOKED_TOKENS_TABLE            = "revoked-tokens"
+	REFRESH_TOKENS_FAMILY_GSI_NAME  = "family-index"
+	REFRESH_TOKENS_ACCOUNT_GSI_NAME = "account-id-index"
+)
+
+var (
+	ErrRefreshTokenNotFound = errors.New("Refresh token not found")
+	ErrRefreshTokenReused   = errors.New("Refresh token already used")
+)
+
+// RefreshToken represents a 'refresh-tokens' table entry
+// Only a hash of the token is stored, so the table can't be used to log in
+type RefreshToken struct {
+	Hash      string `dynamodbav:"token-hash"`
+	AccountId string `dynamodbav:"account-id"`
+	// Shared by every token refreshed from the same login, so they can be revoked together
+	Family string `dynamodbav:"family"`
+	// Used tokens are kept until they expire, so that reuse can be spotted
+	Used bool `dynamodbav:"used"`
+	// Unix time (DynamoDB deletes the record some time after)
+	Expires int64 `dynamodbav:"expires"`
+}
+
+// CreateRefreshToken stores a refresh token, so it can later be exchanged for a new access token
+func (d *client) CreateRefreshToken(token RefreshToken) error {
+	item, err := dynamodbattribute.MarshalMap(token)
+	if err != nil {
+		return err
+	}
+	_, err = d.db.PutItem(&dynamodb.PutItemInput{
+		TableName: aws.String(REFRESH_TOKENS_TABLE),
+		Item:      item,
+	})
+	if err != nil {
+		return fmt.Errorf("Failed to create refresh token: %w", err)
+	}
+	return nil
+}
+
+// UseRefreshToken marks a refresh token as used, so it can only be used once
+// Returns ErrRefreshTokenNotFound if the token doesn't exist, or the token and
+// ErrRefreshTokenReused if it has already been used
+func (d *client) UseRefreshToken(hash string) (*RefreshToken, error) {
+	result, err := d.db.UpdateItem(&dynamodb.UpdateItemInput{
+		TableName: aws.String(REFRESH_TOKENS_TABLE),
+		Key: map[string]*dynamodb.AttributeValue{
+			"token-hash": {S: aws.String(hash)},
+		},
+		UpdateExpression: aws.String("SET #used = :true"),
+		// Only succeed if the token hasn't been used by someone else
+		ConditionExpression: aws.String("attribute_exists(#hash) AND NOT #used = :true"),
+		ExpressionAttributeNames: map[string]*string{
+			"#hash": aws.String("token-hash"),
+			"#used": aws.String("used"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":true": {BOOL: aws.Bool(true)},
+		},
+		ReturnValues: aws.String(dynamodb.ReturnValueAllNew),
+	})
+	var aerr awserr.Error
+	if errors.As(err, &aerr) && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+		// Find out whether the token is unknown, or has been used before
+		token, err := d.GetRefreshToken(hash)
+		if err != nil {
+			return nil, err
+		}
+		return token, ErrRefreshTokenReused
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Failed to use refresh token: %w", err)
+	}
+	// Unmarshal the token
+	var token RefreshToken
+	if err := dynamodbattribute.UnmarshalMap(result.Attributes, &token); err != nil {
+		return nil, fmt.Errorf("Failed to unmarshal refresh token: %w", err)
+	}
+	return &token, nil
+}
+
+// GetRefreshToken gets a refresh token, whether or not it has been used
+// Returns ErrRefreshTokenNotFound if the token doesn't exist
+func (d *client) GetRefreshToken(hash string) (*RefreshToken, error) {
+	result, err := d.db.GetItem(&dynamodb.GetItemInput{
+		TableName: aws.String(REFRESH_TOKENS_TABLE),
+		Key: map[string]*dynamodb.AttributeValue{
+			"token-hash": {S: aws.String(hash)},
+		},
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get refresh token: %w", err)
+	}
+	if result.Item == nil {
+		return nil, ErrRefreshTokenNotFound
+	}
+	// Unmarshal the token
+	var token RefreshToken
+	if err := dynamodbattribute.UnmarshalMap(result.Item, &token); err != nil {
+		return nil, fmt.Errorf("Failed to unmarshal refresh token: %w", err)
+	}
+	return &token, nil
+}
+
+// RevokeRefreshTokens deletes a refresh token, along with every other token in its family
+func (d *client) RevokeRefreshTokens(token RefreshToken) error {
+	// Tokens created before families were introduced stand alone
+	if token.Family == "" {
+		return d.deleteRefreshTokens([]string{token.Hash})
+	}
+	hashes, err := d.queryRefreshTokens(REFRESH_TOKENS_FAMILY_GSI_NAME, "family", token.Family)
+	if err != nil {
+		return err
+	}
+	// Include the token itself, in case the index hasn't caught up with it
+	return d.deleteRefreshTokens(append(hashes, token.Hash))
+}
+
+// RevokeAccountRefreshTokens deletes all of an account's refresh tokens, logging it out everywhere
+func (d *client) RevokeAccountRefreshTokens(accountId string) error {
+	hashes, err := d.queryRefreshTokens(REFRESH_TOKENS_ACCOUNT_GSI_NAME, "account-id", accountId)
+	if err != nil {
+		return err
+	}
+	return d.deleteRefreshTokens(hashes)
+}
+
+// queryRefreshTokens gets the hashes of the refresh tokens with a value in one of the table's indexes
+func (d *client) queryRefreshTokens(index, key, value string) ([]string, error) {
+	// Build an expression
+	kc := expression.Key(key).Equal(expression.Value(value))
+	expr, err := expression.NewBuilder().WithKeyCondition(kc).Build()
+	if err != nil {
+		return nil, fmt.Errorf("Failed build dynamodb query for refresh tokens with %s '%s': %w", key, value, err)
+	}
+	// Request the tokens
+	hashes := []string{}
+	var unmarshalErr error
+	err = d.db.QueryPages(&dynamodb.QueryInput{
+		TableName:                 aws.String(REFRESH_TOKENS_TABLE),
+		IndexName:                 aws.String(index),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+		KeyConditionExpression:    expr.KeyCondition(),
+	}, func(page *dynamodb.QueryOutput, last bool) bool {
+		var tokens []RefreshToken
+		if unmarshalErr = dynamodbattribute.UnmarshalListOfMaps(page.Items, &tokens); unmarshalErr != nil {
+			return false
+		}
+		for _, token := range tokens {
+			hashes = append(hashes, token.Hash)
+		}
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get refresh tokens with %s '%s': %w", key, value, err)
+	}
+	if unmarshalErr != nil {
+		return nil, unmarshalErr
+	}
+	return hashes, nil
+}
+
+// deleteRefreshTokens deletes each of the refresh tokens with the given hashes
+func (d *client) deleteRefreshTokens(hashes []string) error {
+	for _, hash := range hashes {
+		_, err := d.db.DeleteItem(&dynamodb.DeleteItemInput{
+			TableName: aws.String(REFRESH_TOKENS_TABLE),
+			Key: map[string]*dynamodb.AttributeValue{
+				"token-hash": {S: aws.String(hash)},
+			},
+		})
+		if err != nil {
+			return fmt.Errorf("Failed to revoke refresh token: %w", err)
+		}
+	}
+	return nil
+}
+
+// RevokeToken records that an access token is no longer accepted
+// The record is deleted by DynamoDB some time after the token expires
+func (d *client) RevokeToken(id string, expires time.Time) error {
+	_, err := d.db.PutItem(&dynamodb.PutItemInput{
+		TableName: aws.String(REVOKED_TOKENS_TABLE),
+		Item: map[string]*dynamodb.AttributeValue{
+			"token-id": {S: aws.String(id)},
+			"expires":  {N: aws.String(strconv.FormatInt(expires.Unix(), 10))},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("Failed to revoke token '%s': %w", id, err)
+	}
+	return nil
+}
+
+// IsTokenRevoked indicates whether an access token has been revoked
+func (d *client) IsTokenRevoked(id string) (bool, error) {
+	result, err := d.db.GetItem(&dynamodb.GetItemInput{
+		TableName: aws.String(REVOKED_TOKENS_TABLE),
+		Key: map[string]*dynamodb.AttributeValue{
+			"token-id": {S: aws.String(id)},
+		},
+	})
+	if err != nil {
+		return false, fmt.Errorf("Failed to check token '%s': %w", id, err)
+	}
+	return result.Item != nil, nil
+}
@@ -0,0 +1,75 @@
+// Package discharge estimates how long a device's battery will last during a power cut
+package discharge
+
+import (
+	"time"
+
+	"github.com/briggysmalls/detectordag/shared/shadow"
+)
+
+// MaxSamples is how many battery readings are kept to estimate the discharge rate
+const MaxSamples = 20
+
+// Record adds a reading to the history of the battery discharging
+// The previous charge is what the battery reported before the reading. If it doesn't
+// follow on from the latest sample then the battery has charged since, so a new discharge has begun
+// (and the account can be warned about it again).
+func Record(history shadow.DischargeShadow, previous int, sample shadow.BatterySample) shadow.DischargeShadow {
+	// Start again if this is a new discharge
+	samples := history.Samples
+	alerted := history.Alerted
+	if len(samples) == 0 || samples[len(samples)-1].Percent != previous {
+		samples = nil
+		alerted = false
+	}
+	// Add the sample, forgetting the oldest if necessary
+	samples = append(samples, sample)
+	if len(samples) > MaxSamples {
+		samples = samples[len(samples)-MaxSamples:]
+	}
+	// Keep the previous rate if we can't calculate a new one (it's the best guess we have)
+	rate, ok := Rate(samples)
+	if !ok {
+		rate = history.Rate
+	}
+	return shadow.DischargeShadow{Samples: samples, Rate: rate, Alerted: alerted}
+}
+
+// Rate calculates the discharge rate (percent per hour) over a series of samples
+func Rate(samples []shadow.BatterySample) (float64, bool) {
+	if len(samples) < 2 {
+		return 0, false
+	}
+	first, last := samples[0], samples[len(samples)-1]
+	elapsed := last.Time.Sub(first.Time).Hours()
+	discharged := float64(first.Percent - last.Percent)
+	if elapsed <= 0 || discharged <= 0 {
+		return 0, false
+	}
+	return discharged / elapsed, true
+}
+
+// Remaining estimates how much longer a device can keep reporting during a power cut
+// The estimate is reduced by the time that has passed since the battery was last sampled
+func Remaining(shdw *shadow.Shadow, now time.Time) (time.Duration, bool) {
+	// We can only estimate if the device is running on a battery we know about
+	if shdw.Power.Value != shadow.POWER_STATUS_OFF || shdw.Battery == nil || shdw.Battery.Charging {
+		return 0, false
+	}
+	if shdw.Discharge.Rate <= 0 {
+		return 0, false
+	}
+	hours := float64(shdw.Battery.Percent) / shdw.Discharge.Rate
+	remaining := time.Duration(hours * float64(time.Hour))
+	// Take off the time the battery has been discharging since it was last sampled
+	if samples := shdw.Discharge.Samples; len(samples) > 0 {
+		if elapsed := now.Sub(samples[len(samples)-1].Time); elapsed > 0 {
+			remaining -= elapsed
+		}
+	}
+	// The battery may already have run out
+	if remaining < 0 {
+		remaining = 0
+	}
+	return remaining.Round(time.Minute), true
+}
@@ -0,0 +1,119 @@
+package discharge
+
+import (
+	"testing"
+	"time"
+
+	"github.com/briggysmalls/detectordag/shared/shadow"
+	"github.com/stretchr/testify/assert"
+)
+
+var start = time.Unix(1584800000, 0)
+
+func sample(percent int, after time.Duration) shadow.BatterySample {
+	return shadow.BatterySample{Percent: percent, Time: start.Add(after)}
+}
+
+func TestRecord(t *testing.T) {
+	testParams := []struct {
+		history  shadow.DischargeShadow
+		previous int
+		sample   shadow.BatterySample
+		expected shadow.DischargeShadow
+	}{
+		{ // The first sample
+			previous: 100,
+			sample:   sample(99, 0),
+			expected: shadow.DischargeShadow{Samples: []shadow.BatterySample{sample(99, 0)}},
+		},
+		{ // Following on from the latest sample
+			history:  shadow.DischargeShadow{Samples: []shadow.BatterySample{sample(99, 0)}, Alerted: true},
+			previous: 99,
+			sample:   sample(89, 2*time.Hour),
+			expected: shadow.DischargeShadow{Samples: []shadow.BatterySample{sample(99, 0), sample(89, 2*time.Hour)}, Rate: 5, Alerted: true},
+		},
+		{ // The battery has charged since, but the last rate is remembered
+			history:  shadow.DischargeShadow{Samples: []shadow.BatterySample{sample(99, 0), sample(89, 2*time.Hour)}, Rate: 5, Alerted: true},
+			previous: 100,
+			sample:   sample(99, 48*time.Hour),
+			expected: shadow.DischargeShadow{Samples: []shadow.BatterySample{sample(99, 48*time.Hour)}, Rate: 5},
+		},
+	}
+	for _, params := range testParams {
+		assert.Equal(t, params.expected, Record(params.history, params.previous, params.sample))
+	}
+}
+
+func TestRecordLimitsSamples(t *testing.T) {
+	var history shadow.DischargeShadow
+	previous := 100
+	for i := 0; i < MaxSamples+5; i++ {
+		history = Record(history, previous, sample(previous-1, time.Duration(i)*time.Hour))
+		previous--
+	}
+	assert.Len(t, history.Samples, MaxSamples)
+	assert.Equal(t, 75, history.Samples[len(history.Samples)-1].Percent)
+	assert.Equal(t, 1.0, history.Rate)
+}
+
+func TestRate(t *testing.T) {
+	testParams := []struct {
+		samples []shadow.BatterySample
+		rate    float64
+		ok      bool
+	}{
+		{samples: nil},
+		{samples: []shadow.BatterySample{sample(80, 0)}},
+		{samples: []shadow.BatterySample{sample(80, 0), sample(70, 30*time.Minute), sample(60, time.Hour)}, rate: 20, ok: true},
+		// No time has passed
+		{samples: []shadow.BatterySample{sample(80, 0), sample(70, 0)}},
+		// The battery hasn't discharged
+		{samples: []shadow.BatterySample{sample(80, 0), sample(80, time.Hour)}},
+	}
+	for _, params := range testParams {
+		rate, ok := Rate(params.samples)
+		assert.Equal(t, params.ok, ok)
+		assert.Equal(t, params.rate, rate)
+	}
+}
+
+func TestRemaining(t *testing.T) {
+	now := time.Date(2020, 3, 22, 12, 0, 0, 0, time.UTC)
+	testParams := []struct {
+		power     string
+		battery   *shadow.BatteryShadow
+		rate      float64
+		sampled   time.Time
+		remaining time.Duration
+		ok        bool
+	}{
+		{power: shadow.POWER_STATUS_OFF, battery: &shadow.BatteryShadow{Percent: 65}, rate: 30, remaining: 2*time.Hour + 10*time.Minute, ok: true},
+		// The battery was sampled a while ago
+		{power: shadow.POWER_STATUS_OFF, battery: &shadow.BatteryShadow{Percent: 65}, rate: 30, sampled: now.Add(-time.Hour), remaining: time.Hour + 10*time.Minute, ok: true},
+		// The battery was sampled just now
+		{power: shadow.POWER_STATUS_OFF, battery: &shadow.BatteryShadow{Percent: 65}, rate: 30, sampled: now, remaining: 2*time.Hour + 10*time.Minute, ok: true},
+		// The battery should have run out since it was sampled
+		{power: shadow.POWER_STATUS_OFF, battery: &shadow.BatteryShadow{Percent: 65}, rate: 30, sampled: now.Add(-3 * time.Hour), remaining: 0, ok: true},
+		// The power is on
+		{power: shadow.POWER_STATUS_ON, battery: &shadow.BatteryShadow{Percent: 65}, rate: 30},
+		// The battery is charging
+		{power: shadow.POWER_STATUS_OFF, battery: &shadow.BatteryShadow{Percent: 65, Charging: true}, rate: 30},
+		// The battery isn't reported
+		{power: shadow.POWER_STATUS_OFF, rate: 30},
+		// The discharge rate isn't known
+		{power: shadow.POWER_STATUS_OFF, battery: &shadow.BatteryShadow{Percent: 65}},
+	}
+	for _, params := range testParams {
+		history := shadow.DischargeShadow{Rate: params.rate}
+		if !params.sampled.IsZero() {
+			history.Samples = []shadow.BatterySample{{Percent: params.battery.Percent, Time: params.sampled}}
+		}
+		remaining, ok := Remaining(&shadow.Shadow{
+			Power:     shadow.PowerShadow{Value: params.power},
+			Battery:   params.battery,
+			Discharge: history,
+		}, now)
+		assert.Equal(t, params.ok, ok)
+		assert.Equal(t, params.remaining, remaining)
+	}
+}
@@ -3,13 +3,13 @@ package email
 import (
 	"bytes"
 	"fmt"
-	"html/template"
+	"net/mail"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/ses"
 	"github.com/aws/aws-sdk-go/service/ses/sesiface"
-	"github.com/briggysmalls/detectordag/shared/shadow"
+	"github.com/briggysmalls/detectordag/shared/state"
 )
 
 const (
@@ -17,53 +17,114 @@ const (
 	CharSet = "UTF-8"
 )
 
-// StateType is an 'enum' indicating different states
-type StateType int
+// The branding used where an account hasn't overridden it
+var defaultBranding = Branding{
+	LogoURL:      "https://detectordag.tk/android-chrome-512x512.png",
+	HeaderColour: "#17a2b8",
+	ButtonColour: "#0066FF",
+	SenderName:   "detector dag",
+}
 
-const (
-	StateTypeOn     StateType = iota
-	StateTypeOff    StateType = iota
-	StateTypeWasOn  StateType = iota
-	StateTypeWasOff StateType = iota
-)
+type emailer struct {
+	ses       sesiface.SESAPI
+	templates *templateCache
+	sender    string
+	verifier  Verifier
+}
+
+type Emailer interface {
+	SendUpdate(toAddresses []string, event state.Event, context ContextData) error
+	SendAlert(toAddresses []string, alert Alert, current state.State, context ContextData) error
+}
 
-// TransitionType is an 'enum' on the different state transitions
-type TransitionType int
+// Alert is an 'enum' of the warnings about a device that don't change its state
+type Alert int
 
 const (
-	TransitionTypeOn           TransitionType = iota
-	TransitionTypeOff          TransitionType = iota
-	TransitionTypeConnected    TransitionType = iota
-	TransitionTypeDisconnected TransitionType = iota
+	// The device's battery is running out during a power cut
+	LowBattery Alert = iota
+	// The device is on course to use more than its data allowance
+	DataOverage
+	// One of the device's temperature probes is out of range
+	TemperatureAlert
+	// One of the device's metrics has broken a rule
+	MetricAlert
+	// The power has been off for longer than food stays safe
+	FoodSafety
+	// A maintenance window has ended with the power still off
+	MaintenanceEnded
 )
 
-// Helper map for looking up state
-var stateLookup = map[string]map[string]StateType{
-	shadow.CONNECTION_STATUS_CONNECTED: {
-		shadow.POWER_STATUS_ON:  StateTypeOn,
-		shadow.POWER_STATUS_OFF: StateTypeOff,
-	},
-	shadow.CONNECTION_STATUS_DISCONNECTED: {
-		shadow.POWER_STATUS_ON:  StateTypeWasOn,
-		shadow.POWER_STATUS_OFF: StateTypeWasOff,
-	},
+var alertNames = map[Alert]string{
+	LowBattery:       "low battery",
+	DataOverage:      "data overage",
+	TemperatureAlert: "temperature alert",
+	MetricAlert:      "metric alert",
+	FoodSafety:       "food safety",
+	MaintenanceEnded: "maintenance ended",
 }
 
-type emailer struct {
-	ses          sesiface.SESAPI
-	htmlTemplate *template.Template
-	textTemplate *template.Template
-	sender       string
-	verifier     Verifier
+func (a Alert) String() string {
+	return alertNames[a]
 }
 
-type Emailer interface {
-	SendUpdate(toAddresses []string, state StateType, transition TransitionType, context ContextData) error
+// Branding customises the look of an email
+// Empty fields use the default branding
+type Branding struct {
+	LogoURL      string
+	HeaderColour string
+	ButtonColour string
+	SenderName   string
 }
 
 type ContextData struct {
 	DeviceName string
 	Time       time.Time
+	Branding   Branding
+	// Runtime is how much longer the device can report on battery (zero if unknown)
+	Runtime time.Duration
+	// Temperature is the reading that caused a temperature alert
+	Temperature *TemperatureData
+	// Metric is the reading that caused a metric alert
+	Metric *MetricData
+	// Food is set when the power has been off for longer than food stays safe
+	Food *FoodData
+}
+
+// FoodData describes a power cut that has lasted longer than food stays safe
+type FoodData struct {
+	// Appliance is where the food is kept (e.g. 'freezer')
+	Appliance string
+	// Limit is how long food in the appliance stays safe without power
+	Limit time.Duration
+	// Outage is how long the power has been off
+	Outage time.Duration
+}
+
+// MetricData describes a reading that has broken a rule
+type MetricData struct {
+	// Name is what the device reports the metric as (e.g. 'cellar')
+	Name string
+	// DisplayName is the kind of metric (e.g. 'Humidity')
+	DisplayName string
+	// Symbol is the unit of the metric (e.g. '%')
+	Symbol    string
+	Value     float64
+	Condition string
+	// Limit is the value in the rule
+	Limit float64
+	// For is how long the condition has held
+	For time.Duration
+}
+
+// TemperatureData describes a probe reading that is out of range
+type TemperatureData struct {
+	Probe   string
+	Celsius float64
+	// Limit is the threshold the reading is past
+	Limit float64
+	// Above indicates the reading is too warm (rather than too cold)
+	Above bool
 }
 
 type stateData struct {
@@ -80,75 +141,82 @@ type updateData struct {
 	stateData
 	transitionData
 	ContextData
+	// FoodAdvice tells the account to check their food, if it may have spoiled
+	FoodAdvice string
 }
 
-var stateDataLookup = map[StateType]stateData{
-	StateTypeOn: {
+var stateDataLookup = map[state.State]stateData{
+	state.On: {
 		Title:       "On",
 		Description: "The power is on!",
 		ImageSrc:    "https://detectordag.tk/on.png",
 	},
-	StateTypeOff: {
+	state.Off: {
 		Title:       "Off",
 		Description: "Your dag says that the power is off",
 		ImageSrc:    "https://detectordag.tk/off.png",
 	},
-	StateTypeWasOn: {
+	state.WasOn: {
 		Title:       "Was On",
 		Description: "We've lost contact with your dag. The power was on the last we heard...",
 		ImageSrc:    "https://detectordag.tk/on-disconnected.png",
 	},
-	StateTypeWasOff: {
+	state.WasOff: {
 		Title:       "Was Off",
 		Description: "Your dag noticed the power go, and then we lost contact. It may have run out of battery.",
 		ImageSrc:    "https://detectordag.tk/off-disconnected.png",
 	},
 }
 
-var transitionDataLookup = map[TransitionType]transitionData{
-	TransitionTypeOn:           {TransitionText: "Your power's back!"},
-	TransitionTypeOff:          {TransitionText: "You've lost power!"},
-	TransitionTypeConnected:    {TransitionText: "Your dag is back!"},
-	TransitionTypeDisconnected: {TransitionText: "We've lost contact with your dag!"},
+var transitionDataLookup = map[state.Transition]transitionData{
+	state.PowerOn:      {TransitionText: "Your power's back!"},
+	state.PowerOff:     {TransitionText: "You've lost power!"},
+	state.Connected:    {TransitionText: "Your dag is back!"},
+	state.Disconnected: {TransitionText: "We've lost contact with your dag!"},
+	state.Unstable:     {TransitionText: "Your dag keeps losing its connection. Check its SIM and antenna."},
 }
 
-// ToStateType allows external packages to lookup email state
-func ToStateType(connection string, power string) (StateType, error) {
-	// First use connection
-	subMap, ok := stateLookup[connection]
-	if !ok {
-		return 0, fmt.Errorf("Bad connection value: '%s'", connection)
-	}
-	// Then the power
-	state, ok := subMap[power]
-	if !ok {
-		return 0, fmt.Errorf("Bad power value: '%s'", power)
-	}
-	return state, nil
+var alertDataLookup = map[Alert]transitionData{
+	LowBattery:       {TransitionText: "Your dag's battery is running low! It won't be able to report for much longer."},
+	DataOverage:      {TransitionText: "Your dag is on course to use more than its data allowance this month."},
+	TemperatureAlert: {TransitionText: "A temperature probe on your dag is out of range!"},
+	MetricAlert:      {TransitionText: "A reading from your dag has broken one of your rules!"},
+	FoodSafety:       {TransitionText: "Your power has been off for a long time. Your food may no longer be safe to eat."},
+	MaintenanceEnded: {TransitionText: "Your maintenance window has ended, but the power is still off!"},
 }
 
 // NewEmailer gets a new Emailer
-func NewEmailer(ses sesiface.SESAPI, sender string) (Emailer, error) {
+// Templates are loaded from the source if provided, otherwise the embedded templates are used
+func NewEmailer(ses sesiface.SESAPI, sender string, source TemplateSource) (Emailer, error) {
 	// Create templates
-	htmlTemplate, err := template.New("htmlTemplate").Parse(updateHTMLTemplateSource)
-	if err != nil {
-		return nil, err
-	}
-	textTemplate, err := template.New("textTemplate").Parse(textTemplateSource)
+	templates, err := newTemplateCache(source, defaultTemplateTTL)
 	if err != nil {
 		return nil, err
 	}
 	// Create our client wrapper
 	return &emailer{
-		ses:          ses,
-		htmlTemplate: htmlTemplate,
-		textTemplate: textTemplate,
-		sender:       sender,
-		verifier:     &verifier{ses: ses},
+		ses:       ses,
+		templates: templates,
+		sender:    sender,
+		verifier:  &verifier{ses: ses},
 	}, nil
 }
 
-func (e *emailer) SendUpdate(toAddresses []string, state StateType, transition TransitionType, context ContextData) error {
+func (e *emailer) SendUpdate(toAddresses []string, event state.Event, context ContextData) error {
+	// Fill in any branding the account hasn't overridden
+	context.Branding = withDefaultBranding(context.Branding)
+	return e.send(toAddresses, newUpdateData(event, context))
+}
+
+// SendAlert sends a warning about a device, which is in the current state
+func (e *emailer) SendAlert(toAddresses []string, alert Alert, current state.State, context ContextData) error {
+	// Fill in any branding the account hasn't overridden
+	context.Branding = withDefaultBranding(context.Branding)
+	return e.send(toAddresses, newAlertData(alert, current, context))
+}
+
+// send sends an email about a device to the verified addresses
+func (e *emailer) send(toAddresses []string, c updateData) error {
 	// Filter the emails to those that are verified
 	// (otherwise the operation will be rejected)
 	statuses, err := e.verifier.GetVerificationStatuses(toAddresses)
@@ -161,26 +229,23 @@ func (e *emailer) SendUpdate(toAddresses []string, state StateType, transition T
 			recipients = append(recipients, address)
 		}
 	}
-	// Get context
-	c := updateData{
-		ContextData:    context,
-		transitionData: transitionDataLookup[transition],
-		stateData:      stateDataLookup[state],
-	}
+	// Send from the account's sender name
+	sender := (&mail.Address{Name: c.Branding.SenderName, Address: e.sender}).String()
 	// Send mail
-	return e.SendEmail(recipients, e.sender, c.TransitionText, c)
+	return e.SendEmail(recipients, sender, c.TransitionText, c)
 }
 
 func (e *emailer) SendEmail(recipients []string, sender, subject string, context interface{}) error {
 	// Execute the templates
 	var err error
+	templates := e.templates.Get()
 	var htmlBody bytes.Buffer
-	err = e.htmlTemplate.Execute(&htmlBody, context)
+	err = templates.html.Execute(&htmlBody, context)
 	if err != nil {
 		return err
 	}
 	var textBody bytes.Buffer
-	err = e.textTemplate.Execute(&textBody, context)
+	err = templates.text.Execute(&textBody, context)
 	if err != nil {
 		return err
 	}
@@ -220,3 +285,106 @@ func (e *emailer) SendEmail(recipients []string, sender, subject string, context
 	}
 	return nil
 }
+
+// newUpdateData gets the data to render an update email with
+func newUpdateData(event state.Event, context ContextData) updateData {
+	return newData(transitionDataLookup[event.Transition], event.To, context)
+}
+
+// newAlertData gets the data to render an alert email with
+func newAlertData(alert Alert, current state.State, context ContextData) updateData {
+	c := newData(alertDataLookup[alert], current, context)
+	switch {
+	case alert == TemperatureAlert && context.Temperature != nil:
+		// Let them know which probe is out of range
+		c.TransitionText = FormatTemperatureAlert(*context.Temperature)
+	case alert == MetricAlert && context.Metric != nil:
+		// Let them know which rule was broken
+		c.TransitionText = FormatMetricAlert(*context.Metric)
+	case alert == FoodSafety && context.Food != nil:
+		c.TransitionText = fmt.Sprintf("Your %s has been without power for over %s!", context.Food.Appliance, FormatRuntime(context.Food.Limit))
+	}
+	return c
+}
+
+func newData(transition transitionData, current state.State, context ContextData) updateData {
+	c := updateData{
+		ContextData:    context,
+		transitionData: transition,
+		stateData:      stateDataLookup[current],
+	}
+	// Let them know how long the dag will last during a power cut
+	if current == state.Off && context.Runtime > 0 {
+		c.Description = fmt.Sprintf("%s. It can report for about %s more.", c.Description, FormatRuntime(context.Runtime))
+	}
+	// Let them know to check their food
+	if context.Food != nil {
+		c.FoodAdvice = FormatFoodAdvice(*context.Food, current != state.On)
+	}
+	return c
+}
+
+// FormatRuntime formats a runtime to the nearest ten minutes (e.g. '2h10m')
+func FormatRuntime(runtime time.Duration) string {
+	runtime = runtime.Round(10 * time.Minute)
+	if runtime < 10*time.Minute {
+		runtime = 10 * time.Minute
+	}
+	hours := int(runtime.Hours())
+	minutes := int(runtime.Minutes()) % 60
+	switch {
+	case hours == 0:
+		return fmt.Sprintf("%dm", minutes)
+	case minutes == 0:
+		return fmt.Sprintf("%dh", hours)
+	default:
+		return fmt.Sprintf("%dh%dm", hours, minutes)
+	}
+}
+
+// FormatTemperatureAlert describes a probe reading that is out of range (e.g. 'freezer is too warm: -12.0°C (limit -15.0°C)')
+func FormatTemperatureAlert(temperature TemperatureData) string {
+	condition := "too cold"
+	if temperature.Above {
+		condition = "too warm"
+	}
+	return fmt.Sprintf("%s is %s: %.1f°C (limit %.1f°C)", temperature.Probe, condition, temperature.Celsius, temperature.Limit)
+}
+
+// FormatMetricAlert describes a reading that has broken a rule (e.g. 'Humidity (cellar) has been above 80% for 30 minutes: 85%')
+func FormatMetricAlert(metric MetricData) string {
+	text := fmt.Sprintf("%s (%s) has been %s %g%s", metric.DisplayName, metric.Name, metric.Condition, metric.Limit, metric.Symbol)
+	if minutes := int(metric.For.Minutes()); minutes > 0 {
+		text = fmt.Sprintf("%s for %d minutes", text, minutes)
+	}
+	return fmt.Sprintf("%s: %g%s", text, metric.Value, metric.Symbol)
+}
+
+// FormatFoodAdvice tells the account to check their food after a long power cut
+// (e.g. 'The power was off for 5h10m, but food in a fridge only stays safe for about 4h without power. [...]')
+func FormatFoodAdvice(food FoodData, ongoing bool) string {
+	verb := "was"
+	if ongoing {
+		verb = "has been"
+	}
+	return fmt.Sprintf(
+		"The power %s off for %s, but food in a %s only stays safe for about %s without power. Check your food before eating it, and if in doubt, throw it out.",
+		verb, FormatRuntime(food.Outage), food.Appliance, FormatRuntime(food.Limit),
+	)
+}
+
+func withDefaultBranding(branding Branding) Branding {
+	if branding.LogoURL == "" {
+		branding.LogoURL = defaultBranding.LogoURL
+	}
+	if branding.HeaderColour == "" {
+		branding.HeaderColour = defaultBranding.HeaderColour
+	}
+	if branding.ButtonColour == "" {
+		branding.ButtonColour = defaultBranding.ButtonColour
+	}
+	if branding.SenderName == "" {
+		branding.SenderName = defaultBranding.SenderName
+	}
+	return branding
+}
@@ -107,8 +107,8 @@ const (
         <tr>
           <td style="line-height:0px;font-size:0px;mso-line-height-rule:exactly;">
       <![endif]-->
-    <div style="background:#17a2b8;background-color:#17a2b8;margin:0px auto;max-width:600px;">
-      <table align="center" border="0" cellpadding="0" cellspacing="0" role="presentation" style="background:#17a2b8;background-color:#17a2b8;width:100%;">
+    <div style="background:{{ .Branding.HeaderColour }};background-color:{{ .Branding.HeaderColour }};margin:0px auto;max-width:600px;">
+      <table align="center" border="0" cellpadding="0" cellspacing="0" role="presentation" style="background:{{ .Branding.HeaderColour }};background-color:{{ .Branding.HeaderColour }};width:100%;">
         <tbody>
           <tr>
             <td style="direction:ltr;font-size:0px;padding:0;text-align:center;">
@@ -140,7 +140,7 @@ const (
                           <tbody>
                             <tr>
                               <td style="width:100px;">
-                                <img height="auto" src="https://detectordag.tk/android-chrome-512x512.png" style="border:0;display:block;outline:none;text-decoration:none;height:auto;width:100%;font-size:13px;" width="100" />
+                                <img height="auto" src="{{ .Branding.LogoURL }}" style="border:0;display:block;outline:none;text-decoration:none;height:auto;width:100%;font-size:13px;" width="100" />
                               </td>
                             </tr>
                           </tbody>
@@ -337,6 +337,13 @@ const (
                       <div style="font-family:Ubuntu, Helvetica, Arial, sans-serif;font-size:13px;line-height:1;text-align:center;color:#626262;">{{ .Description }}</div>
                     </td>
                   </tr>
+                  {{ if .FoodAdvice }}
+                  <tr>
+                    <td align="center" style="font-size:0px;padding:10px 25px;word-break:break-word;">
+                      <div style="font-family:Ubuntu, Helvetica, Arial, sans-serif;font-size:13px;line-height:1;text-align:center;color:#626262;"><b>Check your food</b><br />{{ .FoodAdvice }}</div>
+                    </td>
+                  </tr>
+                  {{ end }}
                 </table>
               </div>
               <!--[if mso | IE]>
@@ -389,8 +396,8 @@ const (
                     <td align="center" vertical-align="middle" style="font-size:0px;padding:10px 25px;word-break:break-word;">
                       <table border="0" cellpadding="0" cellspacing="0" role="presentation" style="border-collapse:separate;line-height:100%;">
                         <tr>
-                          <td align="center" bgcolor="#0066FF" role="presentation" style="border:none;border-radius:3px;cursor:auto;mso-padding-alt:10px 25px;background:#0066FF;" valign="middle">
-                            <a href="https://detectordag.tk" style="display:inline-block;background:#0066FF;color:#ffffff;font-family:Ubuntu, Helvetica, Arial, sans-serif;font-size:13px;font-weight:normal;line-height:120%;margin:0;text-decoration:none;text-transform:none;padding:10px 25px;mso-padding-alt:0px;border-radius:3px;" target="_blank"> See live status </a>
+                          <td align="center" bgcolor="{{ .Branding.ButtonColour }}" role="presentation" style="border:none;border-radius:3px;cursor:auto;mso-padding-alt:10px 25px;background:{{ .Branding.ButtonColour }};" valign="middle">
+                            <a href="https://detectordag.tk" style="display:inline-block;background:{{ .Branding.ButtonColour }};color:#ffffff;font-family:Ubuntu, Helvetica, Arial, sans-serif;font-size:13px;font-weight:normal;line-height:120%;margin:0;text-decoration:none;text-transform:none;padding:10px 25px;mso-padding-alt:0px;border-radius:3px;" target="_blank"> See live status </a>
                           </td>
                         </tr>
                       </table>
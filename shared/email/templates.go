@@ -0,0 +1,170 @@
+package email
+
+import (
+	"fmt"
+	"html/template"
+	"io/ioutil"
+	"log"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+)
+
+const (
+	// Names of the templates within a template location
+	HTMLTemplateName = "update.html"
+	TextTemplateName = "update.txt"
+	// Prefix indicating a template location is an S3 bucket
+	s3LocationPrefix = "s3://"
+	// How long loaded templates are used before they are reloaded
+	defaultTemplateTTL = 5 * time.Minute
+)
+
+// TemplateSource provides the raw source of the email templates
+type TemplateSource interface {
+	Load(name string) (string, error)
+}
+
+type directorySource struct {
+	dir string
+}
+
+type s3Source struct {
+	s3     s3iface.S3API
+	bucket string
+	prefix string
+}
+
+// templates holds the parsed templates used to render an email
+type templates struct {
+	html *template.Template
+	text *template.Template
+}
+
+// templateCache serves templates from a source, falling back to the defaults
+type templateCache struct {
+	source   TemplateSource
+	ttl      time.Duration
+	defaults *templates
+	mutex    sync.Mutex
+	loaded   *templates
+	expiry   time.Time
+}
+
+// NewTemplateSource creates a source for the templates at the given location
+// The location is either a directory, or an S3 bucket/prefix (e.g. 's3://bucket/templates')
+// An empty location indicates the embedded templates should be used, and so returns nil
+func NewTemplateSource(sesh *session.Session, location string) (TemplateSource, error) {
+	if location == "" {
+		return nil, nil
+	}
+	// Check if the templates are in an S3 bucket
+	if strings.HasPrefix(location, s3LocationPrefix) {
+		parts := strings.SplitN(strings.TrimPrefix(location, s3LocationPrefix), "/", 2)
+		if parts[0] == "" {
+			return nil, fmt.Errorf("Bad template location: '%s'", location)
+		}
+		source := s3Source{s3: s3.New(sesh), bucket: parts[0]}
+		if len(parts) == 2 {
+			source.prefix = parts[1]
+		}
+		return &source, nil
+	}
+	// Otherwise assume we've been given a directory
+	return &directorySource{dir: location}, nil
+}
+
+func (d *directorySource) Load(name string) (string, error) {
+	content, err := ioutil.ReadFile(filepath.Join(d.dir, name))
+	if err != nil {
+		return "", err
+	}
+	return string(content), nil
+}
+
+func (s *s3Source) Load(name string) (string, error) {
+	// Request the template object
+	key := path.Join(s.prefix, name)
+	result, err := s.s3.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return "", fmt.Errorf("Failed to get template '%s': %w", key, err)
+	}
+	defer result.Body.Close()
+	// Read the contents
+	content, err := ioutil.ReadAll(result.Body)
+	if err != nil {
+		return "", err
+	}
+	return string(content), nil
+}
+
+func newTemplateCache(source TemplateSource, ttl time.Duration) (*templateCache, error) {
+	// Parse the embedded templates, which we must always have available
+	defaults, err := parseTemplates(updateHTMLTemplateSource, textTemplateSource)
+	if err != nil {
+		return nil, err
+	}
+	return &templateCache{
+		source:   source,
+		ttl:      ttl,
+		defaults: defaults,
+	}, nil
+}
+
+// Get returns the templates to use, reloading them from the source if they have expired
+func (c *templateCache) Get() *templates {
+	// Short-circuit if there is nothing to load
+	if c.source == nil {
+		return c.defaults
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	// Use the loaded templates until they expire
+	if c.loaded != nil && time.Now().Before(c.expiry) {
+		return c.loaded
+	}
+	// Reload the templates
+	loaded, err := c.load()
+	if err != nil {
+		// Don't fail to send an email just because we can't customise it
+		log.Printf("Failed to load templates, using defaults: %v", err)
+		loaded = c.defaults
+	}
+	c.loaded = loaded
+	c.expiry = time.Now().Add(c.ttl)
+	return c.loaded
+}
+
+func (c *templateCache) load() (*templates, error) {
+	html, err := c.source.Load(HTMLTemplateName)
+	if err != nil {
+		return nil, err
+	}
+	text, err := c.source.Load(TextTemplateName)
+	if err != nil {
+		return nil, err
+	}
+	return parseTemplates(html, text)
+}
+
+func parseTemplates(html, text string) (*templates, error) {
+	htmlTemplate, err := template.New("htmlTemplate").Parse(html)
+	if err != nil {
+		return nil, err
+	}
+	textTemplate, err := template.New("textTemplate").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	return &templates{html: htmlTemplate, text: textTemplate}, nil
+}
@@ -0,0 +1,196 @@
+package email
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/briggysmalls/detectordag/shared/state"
+	"github.com/stretchr/testify/assert"
+)
+
+type stubSource struct {
+	templates map[string]string
+	loads     int
+}
+
+func (s *stubSource) Load(name string) (string, error) {
+	s.loads++
+	template, ok := s.templates[name]
+	if !ok {
+		return "", errors.New("Template not found")
+	}
+	return template, nil
+}
+
+func TestTemplatesLoaded(t *testing.T) {
+	// Create a source with custom templates
+	source := stubSource{templates: map[string]string{
+		HTMLTemplateName: `<p>{{ .DeviceName }}</p>`,
+		TextTemplateName: `{{ .DeviceName }}`,
+	}}
+	cache, err := newTemplateCache(&source, time.Hour)
+	assert.NoError(t, err)
+	// Render the custom template
+	templates := cache.Get()
+	var body bytes.Buffer
+	assert.NoError(t, templates.html.Execute(&body, ContextData{DeviceName: "My Dag"}))
+	assert.Equal(t, "<p>My Dag</p>", body.String())
+	// Subsequent requests should be cached
+	cache.Get()
+	assert.Equal(t, 2, source.loads)
+}
+
+func TestTemplatesFallback(t *testing.T) {
+	testParams := []map[string]string{
+		// Missing templates
+		{},
+		// Text template missing
+		{HTMLTemplateName: `<p>{{ .DeviceName }}</p>`},
+		// Template fails to parse
+		{HTMLTemplateName: `<p>{{ .DeviceName </p>`, TextTemplateName: `{{ .DeviceName }}`},
+	}
+	for _, params := range testParams {
+		// Create a source that cannot provide valid templates
+		cache, err := newTemplateCache(&stubSource{templates: params}, time.Hour)
+		assert.NoError(t, err)
+		// Assert we fall back to the defaults
+		assert.Equal(t, cache.defaults, cache.Get())
+	}
+}
+
+func TestDefaultBranding(t *testing.T) {
+	// Assert empty branding is replaced by defaults
+	assert.Equal(t, defaultBranding, withDefaultBranding(Branding{}))
+	// Assert overrides are preserved
+	branding := withDefaultBranding(Branding{HeaderColour: "#000000", SenderName: "Acme Monitoring"})
+	assert.Equal(t, "#000000", branding.HeaderColour)
+	assert.Equal(t, "Acme Monitoring", branding.SenderName)
+	assert.Equal(t, defaultBranding.LogoURL, branding.LogoURL)
+	assert.Equal(t, defaultBranding.ButtonColour, branding.ButtonColour)
+}
+
+func TestDefaultTemplatesRender(t *testing.T) {
+	cache, err := newTemplateCache(nil, time.Hour)
+	assert.NoError(t, err)
+	// Render the embedded templates with some branding
+	context := updateData{
+		ContextData: ContextData{
+			DeviceName: "My Dag",
+			Branding:   withDefaultBranding(Branding{HeaderColour: "#123456"}),
+		},
+		stateData: stateDataLookup[state.On],
+	}
+	var html, text bytes.Buffer
+	assert.NoError(t, cache.Get().html.Execute(&html, context))
+	assert.NoError(t, cache.Get().text.Execute(&text, context))
+	// Assert the branding is applied
+	assert.Contains(t, html.String(), "background-color:#123456")
+	assert.Contains(t, html.String(), defaultBranding.LogoURL)
+}
+
+func TestRuntimeDescribed(t *testing.T) {
+	testParams := []struct {
+		event       state.Event
+		runtime     time.Duration
+		description string
+	}{
+		{
+			event:       state.Event{From: state.On, To: state.Off, Transition: state.PowerOff},
+			runtime:     2*time.Hour + 7*time.Minute,
+			description: "Your dag says that the power is off. It can report for about 2h10m more.",
+		},
+		{ // The runtime isn't known
+			event:       state.Event{From: state.On, To: state.Off, Transition: state.PowerOff},
+			description: stateDataLookup[state.Off].Description,
+		},
+		{ // The power is back
+			event:       state.Event{From: state.Off, To: state.On, Transition: state.PowerOn},
+			runtime:     time.Hour,
+			description: stateDataLookup[state.On].Description,
+		},
+	}
+	for _, params := range testParams {
+		data := newUpdateData(params.event, ContextData{Runtime: params.runtime})
+		assert.Equal(t, params.description, data.Description)
+	}
+}
+
+func TestTemperatureAlertDescribed(t *testing.T) {
+	// Check the probe is described
+	data := newAlertData(TemperatureAlert, state.Off, ContextData{Temperature: &TemperatureData{Probe: "freezer", Celsius: -12, Limit: -15, Above: true}})
+	assert.Equal(t, "freezer is too warm: -12.0°C (limit -15.0°C)", data.TransitionText)
+	// Check we fall back to the general text
+	data = newAlertData(TemperatureAlert, state.Off, ContextData{})
+	assert.Equal(t, alertDataLookup[TemperatureAlert].TransitionText, data.TransitionText)
+}
+
+func TestMetricAlertDescribed(t *testing.T) {
+	testParams := []struct {
+		metric *MetricData
+		text   string
+	}{
+		{
+			metric: &MetricData{Name: "cellar", DisplayName: "Humidity", Symbol: "%", Value: 85, Condition: "above", Limit: 80, For: 30 * time.Minute},
+			text:   "Humidity (cellar) has been above 80% for 30 minutes: 85%",
+		},
+		{ // The rule didn't have to wait
+			metric: &MetricData{Name: "mains", DisplayName: "Mains voltage", Symbol: "V", Value: 198.5, Condition: "below", Limit: 207},
+			text:   "Mains voltage (mains) has been below 207V: 198.5V",
+		},
+		// We fall back to the general text
+		{text: alertDataLookup[MetricAlert].TransitionText},
+	}
+	for _, params := range testParams {
+		data := newAlertData(MetricAlert, state.On, ContextData{Metric: params.metric})
+		assert.Equal(t, params.text, data.TransitionText)
+	}
+}
+
+func TestFoodAdviceDescribed(t *testing.T) {
+	food := &FoodData{Appliance: "fridge", Limit: 4 * time.Hour, Outage: 5*time.Hour + 8*time.Minute}
+	// The power has been off too long
+	data := newAlertData(FoodSafety, state.Off, ContextData{Food: food})
+	assert.Equal(t, "Your fridge has been without power for over 4h!", data.TransitionText)
+	assert.Equal(t, "The power has been off for 5h10m, but food in a fridge only stays safe for about 4h without power. Check your food before eating it, and if in doubt, throw it out.", data.FoodAdvice)
+	// The power came back too late
+	event := state.Event{From: state.Off, To: state.On, Transition: state.PowerOn}
+	data = newUpdateData(event, ContextData{Food: food})
+	assert.Equal(t, transitionDataLookup[state.PowerOn].TransitionText, data.TransitionText)
+	assert.Equal(t, "The power was off for 5h10m, but food in a fridge only stays safe for about 4h without power. Check your food before eating it, and if in doubt, throw it out.", data.FoodAdvice)
+	// The power came back in time
+	data = newUpdateData(event, ContextData{})
+	assert.Equal(t, transitionDataLookup[state.PowerOn].TransitionText, data.TransitionText)
+	assert.Empty(t, data.FoodAdvice)
+}
+
+func TestFoodAdviceRendered(t *testing.T) {
+	cache, err := newTemplateCache(nil, time.Hour)
+	assert.NoError(t, err)
+	event := state.Event{From: state.Off, To: state.On, Transition: state.PowerOn}
+	for _, food := range []*FoodData{{Appliance: "freezer", Limit: 24 * time.Hour, Outage: 30 * time.Hour}, nil} {
+		// Render the embedded templates
+		context := newUpdateData(event, ContextData{DeviceName: "My Dag", Branding: withDefaultBranding(Branding{}), Food: food})
+		var html, text bytes.Buffer
+		assert.NoError(t, cache.Get().html.Execute(&html, context))
+		assert.NoError(t, cache.Get().text.Execute(&text, context))
+		// Assert the section only appears when the food may have spoiled
+		assert.Equal(t, food != nil, strings.Contains(html.String(), "Check your food"))
+		assert.Equal(t, food != nil, strings.Contains(text.String(), "Check your food"))
+	}
+}
+
+func TestFormatRuntime(t *testing.T) {
+	testParams := map[time.Duration]string{
+		2*time.Hour + 10*time.Minute: "2h10m",
+		2*time.Hour + 4*time.Minute:  "2h",
+		40 * time.Minute:             "40m",
+		time.Minute:                  "10m",
+		26 * time.Hour:               "26h",
+	}
+	for runtime, expected := range testParams {
+		assert.Equal(t, expected, FormatRuntime(runtime))
+	}
+}
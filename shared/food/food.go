@@ -0,0 +1,44 @@
+// Package food advises accounts on whether their food is still safe after a power cut
+package food
+
+import (
+	"time"
+
+	"github.com/briggysmalls/detectordag/shared/shadow"
+)
+
+// JobTypeAdvisory is the job scheduled to advise an account once food may have spoiled
+const JobTypeAdvisory = "food-safety-advisory"
+
+// How long food stays safe without power, for each appliance
+var limits = map[string]time.Duration{
+	shadow.APPLIANCE_FRIDGE: 4 * time.Hour,
+	// A full freezer keeps for about 48 hours, but a half-full one only about 24
+	shadow.APPLIANCE_FREEZER: 24 * time.Hour,
+}
+
+// AdvisoryPayload is the payload of an advisory job
+type AdvisoryPayload struct {
+	DeviceID string `json:"deviceId" validate:"required"`
+	// OutageStarted identifies the power cut the advisory is for
+	OutageStarted time.Time `json:"outageStarted" validate:"required"`
+}
+
+// Limit gets how long food in the appliance stays safe without power
+// Returns false if the account hasn't set an appliance
+func Limit(appliance shadow.ApplianceShadow) (time.Duration, bool) {
+	limit, ok := limits[appliance.Type]
+	if !ok {
+		return 0, false
+	}
+	if appliance.SafeFor > 0 {
+		limit = appliance.SafeFor
+	}
+	return limit, true
+}
+
+// Exceeded indicates whether a power cut lasted longer than food in the appliance stays safe
+func Exceeded(appliance shadow.ApplianceShadow, outage time.Duration) bool {
+	limit, ok := Limit(appliance)
+	return ok && outage > limit
+}
@@ -0,0 +1,36 @@
+package food
+
+import (
+	"testing"
+	"time"
+
+	"github.com/briggysmalls/detectordag/shared/shadow"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLimit(t *testing.T) {
+	testParams := []struct {
+		appliance shadow.ApplianceShadow
+		limit     time.Duration
+		ok        bool
+	}{
+		{appliance: shadow.ApplianceShadow{Type: shadow.APPLIANCE_FRIDGE}, limit: 4 * time.Hour, ok: true},
+		{appliance: shadow.ApplianceShadow{Type: shadow.APPLIANCE_FREEZER}, limit: 24 * time.Hour, ok: true},
+		// The account knows their freezer is full
+		{appliance: shadow.ApplianceShadow{Type: shadow.APPLIANCE_FREEZER, SafeFor: 48 * time.Hour}, limit: 48 * time.Hour, ok: true},
+		// No appliance has been set
+		{appliance: shadow.ApplianceShadow{SafeFor: time.Hour}},
+	}
+	for _, params := range testParams {
+		limit, ok := Limit(params.appliance)
+		assert.Equal(t, params.ok, ok)
+		assert.Equal(t, params.limit, limit)
+	}
+}
+
+func TestExceeded(t *testing.T) {
+	fridge := shadow.ApplianceShadow{Type: shadow.APPLIANCE_FRIDGE}
+	assert.True(t, Exceeded(fridge, 5*time.Hour))
+	assert.False(t, Exceeded(fridge, 3*time.Hour))
+	assert.False(t, Exceeded(shadow.ApplianceShadow{}, 72*time.Hour))
+}
@@ -24,6 +24,7 @@ type client struct {
 type Client interface {
 	GetThing(id string) (*Device, error)
 	GetThingsByAccount(id string) ([]*Device, error)
+	GetThingsInGroup() ([]string, error)
 	RegisterThing(accountID, deviceID string) (*Device, *Certificates, error)
 }
 
@@ -73,6 +74,26 @@ func (c *client) GetThingsByAccount(id string) ([]*Device, error) {
 	})
 }
 
+// GetThingsInGroup returns the names of all things in the detectordag thing group
+func (c *client) GetThingsInGroup() ([]string, error) {
+	// Search for things
+	names := []string{}
+	input := &iot.ListThingsInThingGroupInput{ThingGroupName: aws.String(thingGroup)}
+	for {
+		output, err := c.iot.ListThingsInThingGroup(input)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to list things in group: %w", err)
+		}
+		// Add the things
+		names = append(names, aws.StringValueSlice(output.Things)...)
+		// Stop if there are no more requests to make
+		if output.NextToken == nil {
+			return names, nil
+		}
+		input.SetNextToken(*output.NextToken)
+	}
+}
+
 // RegisterThing creates a new thing and provides certificates for it to communicate
 func (c *client) RegisterThing(accountID, deviceID string) (*Device, *Certificates, error) {
 	// Create a new certificate
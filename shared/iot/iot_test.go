@@ -97,6 +97,36 @@ func TestGetThingsByAccount(t *testing.T) {
 	}
 }
 
+func TestGetThingsInGroup(t *testing.T) {
+	const (
+		deviceOne = "f80103e1-ba55-4b55-b80e-b24f5dd518bb"
+		deviceTwo = "5c5ad1e7-4e28-4b4c-9c5e-b81d8c4f0a4e"
+		nextToken = "next"
+	)
+	// Create unit under test and mocks
+	mock, c := createUnitAndMocks(t)
+	// Configure mock to return two pages of things
+	gomock.InOrder(
+		mock.EXPECT().ListThingsInThingGroup(gomock.Not(gomock.Nil())).Do(func(input *iot.ListThingsInThingGroupInput) {
+			assert.Equal(t, thingGroup, *input.ThingGroupName)
+			assert.Nil(t, input.NextToken)
+		}).Return(&iot.ListThingsInThingGroupOutput{
+			Things:    aws.StringSlice([]string{deviceOne}),
+			NextToken: aws.String(nextToken), // Indicate there are more things to come
+		}, nil),
+		mock.EXPECT().ListThingsInThingGroup(gomock.Not(gomock.Nil())).Do(func(input *iot.ListThingsInThingGroupInput) {
+			assert.Equal(t, thingGroup, *input.ThingGroupName)
+			assert.Equal(t, nextToken, *input.NextToken)
+		}).Return(&iot.ListThingsInThingGroupOutput{
+			Things: aws.StringSlice([]string{deviceTwo}),
+		}, nil),
+	)
+	// Query for all the devices
+	devices, err := c.GetThingsInGroup()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{deviceOne, deviceTwo}, devices)
+}
+
 func TestRegisterDevice(t *testing.T) {
 	const (
 		accountID             = "aac45d02-c97d-442c-8431-336d578fdcf7"
@@ -0,0 +1,188 @@
+package iotsql
+
+import (
+	"encoding/json"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// undefined is the result of referencing fields that don't exist
+// As in AWS, undefined values are left out of the output, and conditions on them don't hold
+type undefinedValue struct{}
+
+var undefined = undefinedValue{}
+
+// message is what expressions are evaluated against
+type message struct {
+	topic    string
+	document interface{}
+	now      time.Time
+}
+
+type expr interface {
+	eval(m *message) interface{}
+}
+
+type literal struct {
+	value interface{}
+}
+
+func (l literal) eval(m *message) interface{} {
+	return l.value
+}
+
+// path references a field of the message document
+type path []string
+
+func (p path) eval(m *message) interface{} {
+	value := m.document
+	for _, field := range p {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return undefined
+		}
+		if value, ok = object[field]; !ok {
+			return undefined
+		}
+	}
+	return value
+}
+
+type call struct {
+	name string
+	args []expr
+}
+
+func (c call) eval(m *message) interface{} {
+	switch c.name {
+	case "topic":
+		// Without an argument we get the whole topic
+		if len(c.args) == 0 {
+			return m.topic
+		}
+		// Otherwise get the (one-based) segment
+		n, ok := toNumber(c.args[0].eval(m))
+		segments := strings.Split(m.topic, "/")
+		if !ok || n < 1 || int(n) > len(segments) {
+			return undefined
+		}
+		return segments[int(n)-1]
+	case "timestamp":
+		return m.now.UnixNano() / int64(time.Millisecond)
+	}
+	return undefined
+}
+
+type comparison struct {
+	op          string
+	left, right expr
+}
+
+func (c comparison) eval(m *message) interface{} {
+	left, right := c.left.eval(m), c.right.eval(m)
+	if left == undefined || right == undefined {
+		return undefined
+	}
+	// Compare numbers by value
+	if l, ok := toNumber(left); ok {
+		r, ok := toNumber(right)
+		if !ok {
+			return undefined
+		}
+		return compare(c.op, l < r, l == r)
+	}
+	// Strings are ordered too
+	if l, ok := left.(string); ok {
+		r, ok := right.(string)
+		if !ok {
+			return undefined
+		}
+		return compare(c.op, l < r, l == r)
+	}
+	// Anything else can only be checked for equality
+	if reflect.TypeOf(left) != reflect.TypeOf(right) {
+		return undefined
+	}
+	switch c.op {
+	case "=":
+		return reflect.DeepEqual(left, right)
+	case "<>", "!=":
+		return !reflect.DeepEqual(left, right)
+	}
+	return undefined
+}
+
+func compare(op string, less, equal bool) interface{} {
+	switch op {
+	case "=":
+		return equal
+	case "<>", "!=":
+		return !equal
+	case "<":
+		return less
+	case "<=":
+		return less || equal
+	case ">":
+		return !less && !equal
+	case ">=":
+		return !less
+	}
+	return undefined
+}
+
+type logical struct {
+	op          string
+	left, right expr
+}
+
+func (l logical) eval(m *message) interface{} {
+	left, lok := l.left.eval(m).(bool)
+	right, rok := l.right.eval(m).(bool)
+	// A definite operand can decide the result by itself
+	switch l.op {
+	case "AND":
+		if (lok && !left) || (rok && !right) {
+			return false
+		}
+		if lok && rok {
+			return true
+		}
+	case "OR":
+		if (lok && left) || (rok && right) {
+			return true
+		}
+		if lok && rok {
+			return false
+		}
+	}
+	return undefined
+}
+
+type negation struct {
+	operand expr
+}
+
+func (n negation) eval(m *message) interface{} {
+	value, ok := n.operand.eval(m).(bool)
+	if !ok {
+		return undefined
+	}
+	return !value
+}
+
+func toNumber(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case json.Number:
+		f, err := strconv.ParseFloat(string(v), 64)
+		return f, err == nil
+	case float64:
+		return v, true
+	case int64:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	}
+	return 0, false
+}
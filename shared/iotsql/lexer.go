@@ -0,0 +1,79 @@
+package iotsql
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenIdent
+	tokenString
+	tokenNumber
+	tokenOperator
+	tokenPunct
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+// lex splits a statement into tokens
+func lex(sql string) ([]token, error) {
+	var tokens []token
+	runes := []rune(sql)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		start := i
+		switch {
+		case unicode.IsSpace(r):
+			i++
+			continue
+		case r == '\'' || r == '"':
+			// Read up to the closing quote
+			i++
+			for i < len(runes) && runes[i] != r {
+				i++
+			}
+			if i == len(runes) {
+				return nil, fmt.Errorf("Unterminated string at %d", start)
+			}
+			i++
+			tokens = append(tokens, token{kind: tokenString, text: string(runes[start+1 : i-1]), pos: start})
+		case unicode.IsDigit(r):
+			for i < len(runes) && (unicode.IsDigit(runes[i]) || runes[i] == '.') {
+				i++
+			}
+			tokens = append(tokens, token{kind: tokenNumber, text: string(runes[start:i]), pos: start})
+		case unicode.IsLetter(r) || r == '_' || r == '$':
+			for i < len(runes) && (unicode.IsLetter(runes[i]) || unicode.IsDigit(runes[i]) || runes[i] == '_' || runes[i] == '$') {
+				i++
+			}
+			tokens = append(tokens, token{kind: tokenIdent, text: string(runes[start:i]), pos: start})
+		case strings.ContainsRune("=<>!", r):
+			// Operators are at most two characters long
+			i++
+			if i < len(runes) && strings.ContainsRune("=>", runes[i]) {
+				i++
+			}
+			op := string(runes[start:i])
+			switch op {
+			case "=", "<>", "!=", "<", ">", "<=", ">=":
+			default:
+				return nil, fmt.Errorf("Unknown operator '%s' at %d", op, start)
+			}
+			tokens = append(tokens, token{kind: tokenOperator, text: op, pos: start})
+		case strings.ContainsRune("(),.*", r):
+			i++
+			tokens = append(tokens, token{kind: tokenPunct, text: string(r), pos: start})
+		default:
+			return nil, fmt.Errorf("Unexpected character '%c' at %d", r, start)
+		}
+	}
+	return append(tokens, token{kind: tokenEOF, pos: len(runes)}), nil
+}
@@ -0,0 +1,289 @@
+package iotsql
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// column is a single item of a SELECT clause
+type column struct {
+	expr expr
+	name string
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+// Parse parses a statement written in the subset of IoT SQL used by our rules:
+// SELECT of field paths, literals and topic()/timestamp() calls (optionally AS an alias),
+// FROM a topic filter, and an optional WHERE clause of comparisons joined with AND/OR/NOT.
+func Parse(sql string) (*Rule, error) {
+	// Split into tokens
+	tokens, err := lex(sql)
+	if err != nil {
+		return nil, err
+	}
+	p := parser{tokens: tokens}
+	rule := Rule{SQL: sql}
+	// Parse the columns
+	if err := p.expectKeyword("SELECT"); err != nil {
+		return nil, err
+	}
+	if p.accept(tokenPunct, "*") {
+		rule.selectAll = true
+	} else {
+		for {
+			col, err := p.column()
+			if err != nil {
+				return nil, err
+			}
+			rule.columns = append(rule.columns, col)
+			if !p.accept(tokenPunct, ",") {
+				break
+			}
+		}
+	}
+	// Parse the topic filter
+	if err := p.expectKeyword("FROM"); err != nil {
+		return nil, err
+	}
+	from := p.next()
+	if from.kind != tokenString {
+		return nil, p.errorf(from, "Expected topic filter")
+	}
+	rule.Topic = from.text
+	// Parse the condition
+	if p.acceptKeyword("WHERE") {
+		if rule.where, err = p.expression(); err != nil {
+			return nil, err
+		}
+	}
+	// Make sure we consumed everything
+	if tok := p.next(); tok.kind != tokenEOF {
+		return nil, p.errorf(tok, "Unexpected '%s'", tok.text)
+	}
+	return &rule, nil
+}
+
+func (p *parser) column() (column, error) {
+	// Parse the expression
+	start := p.peek()
+	e, err := p.expression()
+	if err != nil {
+		return column{}, err
+	}
+	// Use the alias if there is one
+	if p.acceptKeyword("AS") {
+		alias := p.next()
+		if alias.kind != tokenIdent {
+			return column{}, p.errorf(alias, "Expected alias")
+		}
+		return column{expr: e, name: alias.text}, nil
+	}
+	// Otherwise name the column the way AWS does
+	switch e := e.(type) {
+	case path:
+		return column{expr: e, name: e[len(e)-1]}, nil
+	case call:
+		return column{expr: e, name: e.name}, nil
+	}
+	return column{}, p.errorf(start, "Expression requires an alias")
+}
+
+// expression parses a chain of ORs, which bind loosest
+func (p *parser) expression() (expr, error) {
+	left, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+	for p.acceptKeyword("OR") {
+		right, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		left = logical{op: "OR", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) and() (expr, error) {
+	left, err := p.not()
+	if err != nil {
+		return nil, err
+	}
+	for p.acceptKeyword("AND") {
+		right, err := p.not()
+		if err != nil {
+			return nil, err
+		}
+		left = logical{op: "AND", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) not() (expr, error) {
+	if p.acceptKeyword("NOT") {
+		operand, err := p.not()
+		if err != nil {
+			return nil, err
+		}
+		return negation{operand: operand}, nil
+	}
+	return p.comparison()
+}
+
+func (p *parser) comparison() (expr, error) {
+	left, err := p.operand()
+	if err != nil {
+		return nil, err
+	}
+	if op := p.peek(); op.kind == tokenOperator {
+		p.next()
+		right, err := p.operand()
+		if err != nil {
+			return nil, err
+		}
+		return comparison{op: op.text, left: left, right: right}, nil
+	}
+	return left, nil
+}
+
+func (p *parser) operand() (expr, error) {
+	tok := p.next()
+	switch tok.kind {
+	case tokenString:
+		return literal{value: tok.text}, nil
+	case tokenNumber:
+		if _, err := strconv.ParseFloat(tok.text, 64); err != nil {
+			return nil, p.errorf(tok, "Invalid number '%s'", tok.text)
+		}
+		return literal{value: json.Number(tok.text)}, nil
+	case tokenPunct:
+		if tok.text != "(" {
+			break
+		}
+		e, err := p.expression()
+		if err != nil {
+			return nil, err
+		}
+		if !p.accept(tokenPunct, ")") {
+			return nil, p.errorf(p.peek(), "Expected ')'")
+		}
+		return e, nil
+	case tokenIdent:
+		if isKeyword(tok.text) {
+			break
+		}
+		switch strings.ToUpper(tok.text) {
+		case "TRUE":
+			return literal{value: true}, nil
+		case "FALSE":
+			return literal{value: false}, nil
+		}
+		// Function calls are followed by brackets
+		if p.accept(tokenPunct, "(") {
+			return p.call(tok)
+		}
+		// Otherwise we have a field path
+		fields := path{tok.text}
+		for p.accept(tokenPunct, ".") {
+			field := p.next()
+			if field.kind != tokenIdent {
+				return nil, p.errorf(field, "Expected field name")
+			}
+			fields = append(fields, field.text)
+		}
+		return fields, nil
+	}
+	return nil, p.errorf(tok, "Unexpected '%s'", tok.text)
+}
+
+func (p *parser) call(name token) (expr, error) {
+	c := call{name: strings.ToLower(name.text)}
+	// Parse the arguments
+	if !p.accept(tokenPunct, ")") {
+		for {
+			arg, err := p.expression()
+			if err != nil {
+				return nil, err
+			}
+			c.args = append(c.args, arg)
+			if p.accept(tokenPunct, ")") {
+				break
+			}
+			if !p.accept(tokenPunct, ",") {
+				return nil, p.errorf(p.peek(), "Expected ',' or ')'")
+			}
+		}
+	}
+	// Check we support the function
+	switch c.name {
+	case "topic":
+		if len(c.args) > 1 {
+			return nil, p.errorf(name, "topic() takes at most one argument")
+		}
+		if len(c.args) == 1 {
+			if _, ok := c.args[0].(literal); !ok {
+				return nil, p.errorf(name, "topic() takes a literal segment number")
+			}
+		}
+	case "timestamp":
+		if len(c.args) != 0 {
+			return nil, p.errorf(name, "timestamp() takes no arguments")
+		}
+	default:
+		return nil, p.errorf(name, "Unsupported function '%s'", name.text)
+	}
+	return c, nil
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	tok := p.tokens[p.pos]
+	if tok.kind != tokenEOF {
+		p.pos++
+	}
+	return tok
+}
+
+func (p *parser) accept(kind tokenKind, text string) bool {
+	if tok := p.peek(); tok.kind == kind && tok.text == text {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) acceptKeyword(keyword string) bool {
+	if tok := p.peek(); tok.kind == tokenIdent && strings.EqualFold(tok.text, keyword) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) expectKeyword(keyword string) error {
+	if !p.acceptKeyword(keyword) {
+		return p.errorf(p.peek(), "Expected %s", keyword)
+	}
+	return nil
+}
+
+func (p *parser) errorf(tok token, format string, args ...interface{}) error {
+	return fmt.Errorf("Failed to parse SQL at %d: %s", tok.pos, fmt.Sprintf(format, args...))
+}
+
+func isKeyword(text string) bool {
+	switch strings.ToUpper(text) {
+	case "SELECT", "FROM", "WHERE", "AS", "AND", "OR", "NOT":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,105 @@
+package iotsql
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Rule is an IoT topic rule, which selects an event from messages published to matching topics
+type Rule struct {
+	Name  string
+	SQL   string
+	Topic string
+	// selectAll is set for 'SELECT *'
+	selectAll bool
+	columns   []column
+	where     expr
+}
+
+// ruleFile is the format of the rules in config/rules, as given by 'aws iot get-topic-rule'
+type ruleFile struct {
+	Rule struct {
+		RuleName string `yaml:"ruleName"`
+		SQL      string `yaml:"sql"`
+	} `yaml:"rule"`
+}
+
+// Load reads a rule from a YAML file
+func Load(filename string) (*Rule, error) {
+	// Read the file
+	contents, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var file ruleFile
+	if err := yaml.Unmarshal(contents, &file); err != nil {
+		return nil, fmt.Errorf("Failed to read rule from '%s': %w", filename, err)
+	}
+	// Parse the SQL
+	rule, err := Parse(file.Rule.SQL)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to load rule '%s': %w", file.Rule.RuleName, err)
+	}
+	rule.Name = file.Rule.RuleName
+	return rule, nil
+}
+
+// Evaluate runs the rule over a message published to a topic at the given time
+// It returns the event the rule would send to its actions, and whether the rule fires at all
+func (r *Rule) Evaluate(topic string, payload []byte, now time.Time) ([]byte, bool, error) {
+	// Check the message is for us
+	if !MatchTopic(r.Topic, topic) {
+		return nil, false, nil
+	}
+	// Decode the payload, keeping numbers as they were written
+	var document interface{}
+	decoder := json.NewDecoder(bytes.NewReader(payload))
+	decoder.UseNumber()
+	if err := decoder.Decode(&document); err != nil {
+		return nil, false, fmt.Errorf("Failed to decode payload: %w", err)
+	}
+	m := message{topic: topic, document: document, now: now}
+	// Check the condition
+	if r.where != nil {
+		if matched, ok := r.where.eval(&m).(bool); !ok || !matched {
+			return nil, false, nil
+		}
+	}
+	// Select the output
+	output := map[string]interface{}{}
+	if object, ok := document.(map[string]interface{}); ok && r.selectAll {
+		output = object
+	}
+	for _, col := range r.columns {
+		if value := col.expr.eval(&m); value != undefined {
+			output[col.name] = value
+		}
+	}
+	event, err := json.Marshal(output)
+	return event, true, err
+}
+
+// MatchTopic checks whether a topic matches a filter, which may contain '+' and '#' wildcards
+func MatchTopic(filter, topic string) bool {
+	filters := strings.Split(filter, "/")
+	segments := strings.Split(topic, "/")
+	for i, f := range filters {
+		// A multi-level wildcard matches everything that's left
+		if f == "#" {
+			return true
+		}
+		if i >= len(segments) {
+			return false
+		}
+		if f != "+" && f != segments[i] {
+			return false
+		}
+	}
+	return len(filters) == len(segments)
+}
@@ -0,0 +1,149 @@
+package iotsql
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMatchTopic(t *testing.T) {
+	testParams := []struct {
+		filter  string
+		topic   string
+		matches bool
+	}{
+		{filter: "$aws/things/+/shadow/update/documents", topic: "$aws/things/dev/shadow/update/documents", matches: true},
+		{filter: "$aws/things/+/shadow/update/documents", topic: "$aws/things/dev/shadow/update", matches: false},
+		{filter: "$aws/things/+/shadow/update", topic: "$aws/things/dev/shadow/update/documents", matches: false},
+		{filter: "$aws/events/presence/#", topic: "$aws/events/presence/connected/dev", matches: true},
+		{filter: "$aws/events/presence/#", topic: "$aws/events/other/connected/dev", matches: false},
+	}
+	for _, params := range testParams {
+		assert.Equal(t, params.matches, MatchTopic(params.filter, params.topic), params.topic)
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	const topic = "$aws/things/dev/shadow/update/documents"
+	now := time.Unix(1584803414, 123000000)
+	testParams := []struct {
+		sql     string
+		payload string
+		event   string
+		fired   bool
+	}{
+		{ // Fields and topic segments can be aliased
+			sql:     "SELECT topic(3) AS deviceId, a.b AS value FROM '$aws/things/+/shadow/update/documents'",
+			payload: `{"a":{"b":{"c":1}}}`,
+			event:   `{"deviceId":"dev","value":{"c":1}}`,
+			fired:   true,
+		},
+		{ // Unaliased fields are named after their last part
+			sql:     "SELECT timestamp, a.b FROM '$aws/things/+/shadow/update/documents'",
+			payload: `{"timestamp":1584803414,"a":{"b":"c"}}`,
+			event:   `{"b":"c","timestamp":1584803414}`,
+			fired:   true,
+		},
+		{ // Missing fields are left out
+			sql:     "SELECT a.b AS value, c FROM '$aws/things/+/shadow/update/documents'",
+			payload: `{"c":"d"}`,
+			event:   `{"c":"d"}`,
+			fired:   true,
+		},
+		{ // Literals and the time can be selected
+			sql:     `select "connected" as eventType, timestamp() as timestamp from '$aws/things/+/shadow/update/documents'`,
+			payload: `{}`,
+			event:   `{"eventType":"connected","timestamp":1584803414123}`,
+			fired:   true,
+		},
+		{ // Everything can be selected
+			sql:     "SELECT * FROM '$aws/things/+/shadow/update/documents'",
+			payload: `{"a":1}`,
+			event:   `{"a":1}`,
+			fired:   true,
+		},
+		{ // Other topics are ignored
+			sql:     "SELECT * FROM '$aws/things/+/shadow/update'",
+			payload: `{"a":1}`,
+		},
+		{ // Conditions must hold
+			sql:     "SELECT a FROM '$aws/things/+/shadow/update/documents' WHERE a.b <> c.b",
+			payload: `{"a":{"b":"on"},"c":{"b":"on"}}`,
+		},
+		{
+			sql:     "SELECT a.b AS b FROM '$aws/things/+/shadow/update/documents' WHERE a.b <> c.b",
+			payload: `{"a":{"b":"on"},"c":{"b":"off"}}`,
+			event:   `{"b":"on"}`,
+			fired:   true,
+		},
+		{ // Conditions on missing fields don't hold
+			sql:     "SELECT a FROM '$aws/things/+/shadow/update/documents' WHERE a.b <> c.b",
+			payload: `{"a":{"b":"on"}}`,
+		},
+		{ // Numbers are compared by value, and conditions can be combined
+			sql:     "SELECT a FROM '$aws/things/+/shadow/update/documents' WHERE a >= 2.0 AND (a < 3 OR NOT b = 'x')",
+			payload: `{"a":2,"b":"y"}`,
+			event:   `{"a":2}`,
+			fired:   true,
+		},
+		{
+			sql:     "SELECT a FROM '$aws/things/+/shadow/update/documents' WHERE a > 2 OR topic(3) = 'other'",
+			payload: `{"a":2}`,
+		},
+	}
+	for _, params := range testParams {
+		// Parse the rule
+		rule, err := Parse(params.sql)
+		assert.NoError(t, err, params.sql)
+		// Run it
+		event, fired, err := rule.Evaluate(topic, []byte(params.payload), now)
+		assert.NoError(t, err)
+		assert.Equal(t, params.fired, fired, params.sql)
+		if params.fired {
+			assert.JSONEq(t, params.event, string(event), params.sql)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	testParams := []string{
+		"",
+		"SELECT FROM 'a'",
+		"SELECT a 'a'",
+		"SELECT a FROM b",
+		"SELECT a FROM 'a' WHERE",
+		"SELECT a FROM 'a' WHERE a <> ",
+		"SELECT 'x' FROM 'a'",
+		"SELECT unknown() AS x FROM 'a'",
+		"SELECT topic(a) AS x FROM 'a'",
+		"SELECT a FROM 'a' WHERE a ~ b",
+		"SELECT a FROM 'a",
+		"SELECT a FROM 'a' extra",
+		"SELECT *, a FROM 'a'",
+	}
+	for _, sql := range testParams {
+		_, err := Parse(sql)
+		assert.Error(t, err, sql)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	testParams := []struct {
+		file  string
+		name  string
+		topic string
+	}{
+		{file: "../../config/rules/power_status_changed.yaml", name: "PowerStatusChanged", topic: "$aws/things/+/shadow/update/documents"},
+		{file: "../../config/rules/device_seen.yaml", name: "DeviceSeen", topic: "$aws/things/+/shadow/update/documents"},
+	}
+	for _, params := range testParams {
+		rule, err := Load(params.file)
+		assert.NoError(t, err)
+		assert.Equal(t, params.name, rule.Name)
+		assert.Equal(t, params.topic, rule.Topic)
+	}
+	// Missing files are reported
+	_, err := Load("missing.yaml")
+	assert.Error(t, err)
+}
@@ -0,0 +1,106 @@
+// Package maintenance works out when notifications are held back, while work is done on a supply
+package maintenance
+
+import (
+	"time"
+)
+
+// JobTypeEnded is the job scheduled to check on a device once a maintenance window ends
+const JobTypeEnded = "maintenance-ended"
+
+// MaxDuration is the longest a maintenance window can last
+const MaxDuration = 7 * 24 * time.Hour
+
+// Repeat is how often a maintenance window recurs
+type Repeat string
+
+const (
+	Once   Repeat = "once"
+	Daily  Repeat = "daily"
+	Weekly Repeat = "weekly"
+)
+
+// How many days between the start of each occurrence of a recurring window
+var periods = map[Repeat]int{
+	Daily:  1,
+	Weekly: 7,
+}
+
+// Window is a period during which notifications are held back
+// A recurring window repeats on the calendar of its start's location, so it keeps the same local time
+// when the clocks change
+type Window struct {
+	ID       string
+	Start    time.Time
+	Duration time.Duration
+	Repeat   Repeat
+}
+
+// EndedPayload is the payload of the job scheduled when a maintenance window ends
+type EndedPayload struct {
+	DeviceID string `json:"deviceId" validate:"required"`
+	// Ended is when the window the device was in ended
+	Ended time.Time `json:"ended" validate:"required"`
+}
+
+// occurrence gets the start of the latest occurrence of the window, at or before the given time
+func (w Window) occurrence(at time.Time) (time.Time, bool) {
+	if at.Before(w.Start) {
+		return time.Time{}, false
+	}
+	days, ok := periods[w.Repeat]
+	if !ok {
+		return w.Start, true
+	}
+	// Estimate how many times the window has repeated, then correct for days that aren't 24 hours long
+	n := int(at.Sub(w.Start) / (time.Duration(days) * 24 * time.Hour))
+	start := w.Start.AddDate(0, 0, n*days)
+	for start.After(at) {
+		n--
+		start = w.Start.AddDate(0, 0, n*days)
+	}
+	for next := w.Start.AddDate(0, 0, (n+1)*days); !next.After(at); next = w.Start.AddDate(0, 0, (n+1)*days) {
+		n++
+		start = next
+	}
+	return start, true
+}
+
+// In gets the window, repeating on the calendar of the given location
+func (w Window) In(loc *time.Location) Window {
+	w.Start = w.Start.In(loc)
+	return w
+}
+
+// Contains indicates whether the time falls inside an occurrence of the window
+func (w Window) Contains(at time.Time) bool {
+	start, ok := w.occurrence(at)
+	return ok && at.Before(start.Add(w.Duration))
+}
+
+// End gets when the occurrence of the window containing the time ends
+func (w Window) End(at time.Time) time.Time {
+	start, _ := w.occurrence(at)
+	return start.Add(w.Duration)
+}
+
+// Expired indicates the window won't occur again after the given time
+func (w Window) Expired(at time.Time) bool {
+	_, recurring := periods[w.Repeat]
+	return !recurring && !at.Before(w.Start.Add(w.Duration))
+}
+
+// Find gets the window the time falls inside, ending the latest if there are several
+func Find(windows []Window, at time.Time) (Window, bool) {
+	var found Window
+	ok := false
+	for _, window := range windows {
+		if !window.Contains(at) {
+			continue
+		}
+		if !ok || window.End(at).After(found.End(at)) {
+			found, ok = window, true
+		}
+	}
+	return found, ok
+}
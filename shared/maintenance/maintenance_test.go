@@ -0,0 +1,93 @@
+package maintenance
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func createTime(t *testing.T, timeString string) time.Time {
+	tme, err := time.Parse("2006/01/02 15:04:05", timeString)
+	assert.NoError(t, err)
+	return tme
+}
+
+func TestContains(t *testing.T) {
+	testParams := []struct {
+		repeat   Repeat
+		at       string
+		contains bool
+		end      string
+	}{
+		{repeat: Once, at: "2020/03/22 09:30:00", contains: true, end: "2020/03/22 11:00:00"},
+		{repeat: Once, at: "2020/03/22 08:59:59"},
+		{repeat: Once, at: "2020/03/22 11:00:00"},
+		{repeat: Once, at: "2020/03/23 09:30:00"},
+		{repeat: Daily, at: "2020/03/22 09:00:00", contains: true, end: "2020/03/22 11:00:00"},
+		{repeat: Daily, at: "2020/03/25 10:59:59", contains: true, end: "2020/03/25 11:00:00"},
+		{repeat: Daily, at: "2020/03/25 12:00:00"},
+		{repeat: Daily, at: "2020/03/21 09:30:00"},
+		{repeat: Weekly, at: "2020/03/29 10:00:00", contains: true, end: "2020/03/29 11:00:00"},
+		{repeat: Weekly, at: "2020/03/28 10:00:00"},
+	}
+	for _, params := range testParams {
+		window := Window{Start: createTime(t, "2020/03/22 09:00:00"), Duration: 2 * time.Hour, Repeat: params.repeat}
+		at := createTime(t, params.at)
+		assert.Equal(t, params.contains, window.Contains(at), params.at)
+		if params.contains {
+			assert.Equal(t, createTime(t, params.end), window.End(at))
+		}
+	}
+}
+
+func TestContainsAcrossClockChange(t *testing.T) {
+	london, err := time.LoadLocation("Europe/London")
+	assert.NoError(t, err)
+	// The clocks go forward on 29th March 2020
+	start := time.Date(2020, 3, 27, 9, 0, 0, 0, london)
+	testParams := []struct {
+		repeat   Repeat
+		at       time.Time
+		contains bool
+		end      time.Time
+	}{
+		// The window stays at 09:00 local time after the clocks change
+		{repeat: Daily, at: time.Date(2020, 3, 30, 9, 30, 0, 0, london), contains: true, end: time.Date(2020, 3, 30, 11, 0, 0, 0, london)},
+		{repeat: Daily, at: time.Date(2020, 3, 30, 8, 30, 0, 0, london)},
+		{repeat: Daily, at: time.Date(2020, 3, 30, 10, 59, 59, 0, london), contains: true, end: time.Date(2020, 3, 30, 11, 0, 0, 0, london)},
+		{repeat: Weekly, at: time.Date(2020, 4, 3, 9, 0, 0, 0, london), contains: true, end: time.Date(2020, 4, 3, 11, 0, 0, 0, london)},
+		{repeat: Weekly, at: time.Date(2020, 4, 3, 8, 30, 0, 0, london)},
+	}
+	for _, params := range testParams {
+		// Start with the window stored as UTC, as it is in the database
+		window := Window{Start: start.UTC(), Duration: 2 * time.Hour, Repeat: params.repeat}.In(london)
+		assert.Equal(t, params.contains, window.Contains(params.at), params.at)
+		if params.contains {
+			assert.True(t, params.end.Equal(window.End(params.at)), "%s != %s", params.end, window.End(params.at))
+		}
+	}
+}
+
+func TestExpired(t *testing.T) {
+	start := createTime(t, "2020/03/22 09:00:00")
+	assert.False(t, Window{Start: start, Duration: time.Hour, Repeat: Once}.Expired(start))
+	assert.True(t, Window{Start: start, Duration: time.Hour, Repeat: Once}.Expired(start.Add(time.Hour)))
+	assert.False(t, Window{Start: start, Duration: time.Hour, Repeat: Daily}.Expired(start.Add(72*time.Hour)))
+}
+
+func TestFind(t *testing.T) {
+	windows := []Window{
+		{ID: "short", Start: createTime(t, "2020/03/22 09:00:00"), Duration: time.Hour, Repeat: Once},
+		{ID: "long", Start: createTime(t, "2020/03/22 08:00:00"), Duration: 4 * time.Hour, Repeat: Daily},
+	}
+	// The window ending latest is found
+	window, ok := Find(windows, createTime(t, "2020/03/22 09:30:00"))
+	assert.True(t, ok)
+	assert.Equal(t, "long", window.ID)
+	// Nothing is found outside the windows
+	_, ok = Find(windows, createTime(t, "2020/03/22 13:00:00"))
+	assert.False(t, ok)
+	_, ok = Find(nil, createTime(t, "2020/03/22 09:30:00"))
+	assert.False(t, ok)
+}
@@ -0,0 +1,87 @@
+// Package metrics describes the readings devices report generically, and the rules about them
+// New kinds of reading only need registering here, rather than adding to the shadow schema
+// Battery, cellular and temperature probe readings have their own pipelines, so aren't registered as metrics
+package metrics
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+var (
+	ErrUnknownKind = errors.New("Unknown kind of metric")
+	ErrWrongUnit   = errors.New("Metric reported in the wrong unit")
+	ErrOutOfRange  = errors.New("Metric out of range")
+)
+
+// Kind is a kind of reading a device can report
+type Kind struct {
+	// Name is how devices report the kind
+	Name string
+	// DisplayName is how we tell people about the kind
+	DisplayName string
+	// Unit is the unit devices must report the kind in
+	Unit string
+	// Symbol is how we show the unit to people
+	Symbol string
+	// Min and Max are the range of valid readings
+	Min float64
+	Max float64
+}
+
+// Reading is a value a device reported for a metric
+type Reading struct {
+	Kind  string
+	Value float64
+	Unit  string
+}
+
+// The kinds of metric we know about, by name
+var registry = map[string]Kind{}
+
+func init() {
+	for _, kind := range []Kind{
+		{Name: "humidity", DisplayName: "Humidity", Unit: "percent", Symbol: "%", Min: 0, Max: 100},
+		{Name: "voltage", DisplayName: "Mains voltage", Unit: "volts", Symbol: "V", Min: 0, Max: 500},
+		{Name: "frequency", DisplayName: "Mains frequency", Unit: "hertz", Symbol: "Hz", Min: 0, Max: 100},
+	} {
+		Register(kind)
+	}
+}
+
+// Register adds a kind of metric, replacing any of the same name
+func Register(kind Kind) {
+	registry[kind.Name] = kind
+}
+
+// Lookup gets a kind of metric by name
+func Lookup(name string) (Kind, bool) {
+	kind, ok := registry[name]
+	return kind, ok
+}
+
+// Kinds gets all the kinds of metric we know about, in name order
+func Kinds() []Kind {
+	kinds := make([]Kind, 0, len(registry))
+	for _, kind := range registry {
+		kinds = append(kinds, kind)
+	}
+	sort.Slice(kinds, func(i, j int) bool { return kinds[i].Name < kinds[j].Name })
+	return kinds
+}
+
+// Validate checks a reading is of a known kind, in the right unit and in range
+func Validate(reading Reading) error {
+	kind, ok := Lookup(reading.Kind)
+	if !ok {
+		return fmt.Errorf("%w: '%s'", ErrUnknownKind, reading.Kind)
+	}
+	if reading.Unit != kind.Unit {
+		return fmt.Errorf("%w: '%s' is reported in %s, not %s", ErrWrongUnit, kind.Name, kind.Unit, reading.Unit)
+	}
+	if reading.Value < kind.Min || reading.Value > kind.Max {
+		return fmt.Errorf("%w: %s of %g", ErrOutOfRange, kind.Name, reading.Value)
+	}
+	return nil
+}
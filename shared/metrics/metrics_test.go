@@ -0,0 +1,52 @@
+package metrics
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidate(t *testing.T) {
+	testParams := []struct {
+		reading Reading
+		err     error
+	}{
+		{reading: Reading{Kind: "voltage", Value: 230, Unit: "volts"}},
+		{reading: Reading{Kind: "humidity", Value: 100, Unit: "percent"}},
+		{reading: Reading{Kind: "pressure", Value: 1013, Unit: "hectopascals"}, err: ErrUnknownKind},
+		// Temperatures are reported by probes instead
+		{reading: Reading{Kind: "temperature", Value: -18.5, Unit: "celsius"}, err: ErrUnknownKind},
+		{reading: Reading{Kind: "humidity", Value: 0.85, Unit: "fraction"}, err: ErrWrongUnit},
+		{reading: Reading{Kind: "humidity", Value: 101, Unit: "percent"}, err: ErrOutOfRange},
+	}
+	for _, params := range testParams {
+		err := Validate(params.reading)
+		if params.err == nil {
+			assert.NoError(t, err)
+		} else {
+			assert.True(t, errors.Is(err, params.err), "%v", err)
+		}
+	}
+}
+
+func TestRegister(t *testing.T) {
+	// Register a new kind
+	kind := Kind{Name: "pressure", DisplayName: "Air pressure", Unit: "hectopascals", Symbol: "hPa", Min: 800, Max: 1200}
+	Register(kind)
+	defer delete(registry, kind.Name)
+	// Check it can be used
+	found, ok := Lookup("pressure")
+	assert.True(t, ok)
+	assert.Equal(t, kind, found)
+	assert.NoError(t, Validate(Reading{Kind: "pressure", Value: 1013, Unit: "hectopascals"}))
+	assert.Contains(t, Kinds(), kind)
+}
+
+func TestKinds(t *testing.T) {
+	var names []string
+	for _, kind := range Kinds() {
+		names = append(names, kind.Name)
+	}
+	assert.Equal(t, []string{"frequency", "humidity", "voltage"}, names)
+}
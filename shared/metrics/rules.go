@@ -0,0 +1,62 @@
+package metrics
+
+import (
+	"time"
+)
+
+// Condition is how a rule compares a reading with its value
+type Condition string
+
+const (
+	Above Condition = "above"
+	Below Condition = "below"
+)
+
+// Rule warns when a metric has been above or below a value for a while
+type Rule struct {
+	ID string
+	// Metric is the name the device reports the reading under
+	Metric    string
+	Condition Condition
+	Value     float64
+	// For is how long the condition must hold before warning
+	For time.Duration
+}
+
+// RuleState is how a rule stands with the readings so far
+type RuleState struct {
+	// Since is when the condition started holding (zero if it doesn't)
+	Since time.Time
+	// Alerting indicates the account has been warned
+	Alerting bool
+}
+
+// Holds indicates whether a reading meets the rule's condition
+func (r Rule) Holds(reading Reading) bool {
+	switch r.Condition {
+	case Above:
+		return reading.Value > r.Value
+	case Below:
+		return reading.Value < r.Value
+	}
+	return false
+}
+
+// Evaluate updates the state of a rule with a new reading, and indicates whether to warn the account
+// The account is warned once, when the condition has held for long enough
+func (r Rule) Evaluate(state RuleState, reading Reading, at time.Time) (RuleState, bool) {
+	// Start again if the condition doesn't hold
+	if !r.Holds(reading) {
+		return RuleState{}, false
+	}
+	// Note when the condition started holding
+	if state.Since.IsZero() {
+		state.Since = at
+	}
+	// Warn if it has held long enough
+	if state.Alerting || at.Sub(state.Since) < r.For {
+		return state, false
+	}
+	state.Alerting = true
+	return state, true
+}
@@ -0,0 +1,60 @@
+package metrics
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var start = time.Unix(1584800000, 0)
+
+func humidity(value float64) Reading {
+	return Reading{Kind: "humidity", Value: value, Unit: "percent"}
+}
+
+func TestHolds(t *testing.T) {
+	above := Rule{Metric: "cellar", Condition: Above, Value: 80}
+	below := Rule{Metric: "cellar", Condition: Below, Value: 20}
+	assert.True(t, above.Holds(humidity(81)))
+	assert.False(t, above.Holds(humidity(80)))
+	assert.True(t, below.Holds(humidity(19)))
+	assert.False(t, below.Holds(humidity(20)))
+	// Unknown conditions never hold
+	assert.False(t, Rule{Condition: "near", Value: 20}.Holds(humidity(20)))
+}
+
+func TestEvaluate(t *testing.T) {
+	rule := Rule{Metric: "cellar", Condition: Above, Value: 80, For: 30 * time.Minute}
+	testParams := []struct {
+		state    RuleState
+		value    float64
+		after    time.Duration
+		expected RuleState
+		alert    bool
+	}{
+		// The condition starts holding
+		{value: 85, expected: RuleState{Since: start}},
+		// ...but not for long enough
+		{state: RuleState{Since: start}, value: 85, after: 20 * time.Minute, expected: RuleState{Since: start}},
+		// ...until now
+		{state: RuleState{Since: start}, value: 85, after: 30 * time.Minute, expected: RuleState{Since: start, Alerting: true}, alert: true},
+		// The account has already been warned
+		{state: RuleState{Since: start, Alerting: true}, value: 90, after: time.Hour, expected: RuleState{Since: start, Alerting: true}},
+		// The condition no longer holds
+		{state: RuleState{Since: start, Alerting: true}, value: 70, after: 2 * time.Hour},
+	}
+	for _, params := range testParams {
+		state, alert := rule.Evaluate(params.state, humidity(params.value), start.Add(params.after))
+		assert.Equal(t, params.expected, state)
+		assert.Equal(t, params.alert, alert)
+	}
+}
+
+func TestEvaluateImmediately(t *testing.T) {
+	// Rules needn't wait
+	rule := Rule{Metric: "cellar", Condition: Below, Value: 20}
+	state, alert := rule.Evaluate(RuleState{}, humidity(10), start)
+	assert.True(t, alert)
+	assert.Equal(t, RuleState{Since: start, Alerting: true}, state)
+}
@@ -0,0 +1,112 @@
+package scheduler
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Clock provides the current time, and timers against it
+type Clock interface {
+	Now() time.Time
+	AfterFunc(d time.Duration, f func()) Timer
+}
+
+// Timer is a function waiting to be called
+type Timer interface {
+	Stop() bool
+}
+
+type realClock struct{}
+
+// NewClock gets a Clock that uses the system time
+func NewClock() Clock {
+	return realClock{}
+}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) AfterFunc(d time.Duration, f func()) Timer {
+	return time.AfterFunc(d, f)
+}
+
+// FakeClock is a Clock that only moves when told to, for deterministic tests
+type FakeClock struct {
+	mutex  sync.Mutex
+	now    time.Time
+	timers []*fakeTimer
+}
+
+type fakeTimer struct {
+	clock *FakeClock
+	due   time.Time
+	f     func()
+}
+
+// NewFakeClock gets a FakeClock set to the provided time
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{now: now}
+}
+
+func (c *FakeClock) Now() time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.now
+}
+
+func (c *FakeClock) AfterFunc(d time.Duration, f func()) Timer {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	timer := &fakeTimer{clock: c, due: c.now.Add(d), f: f}
+	c.timers = append(c.timers, timer)
+	return timer
+}
+
+// Advance moves the clock forward, calling any timers that become due in order
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mutex.Lock()
+	end := c.now.Add(d)
+	c.mutex.Unlock()
+	// Fire due timers one at a time, as they may add more timers
+	for {
+		timer := c.popDue(end)
+		if timer == nil {
+			break
+		}
+		timer.f()
+	}
+	c.mutex.Lock()
+	c.now = end
+	c.mutex.Unlock()
+}
+
+func (c *FakeClock) popDue(end time.Time) *fakeTimer {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	// Find the earliest timer
+	sort.SliceStable(c.timers, func(i, j int) bool {
+		return c.timers[i].due.Before(c.timers[j].due)
+	})
+	if len(c.timers) == 0 || c.timers[0].due.After(end) {
+		return nil
+	}
+	// Move time on to when the timer is due
+	timer := c.timers[0]
+	c.timers = c.timers[1:]
+	c.now = timer.due
+	return timer
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.clock.mutex.Lock()
+	defer t.clock.mutex.Unlock()
+	for i, timer := range t.clock.timers {
+		if timer == t {
+			t.clock.timers = append(t.clock.timers[:i], t.clock.timers[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
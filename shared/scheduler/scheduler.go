@@ -0,0 +1,94 @@
+package scheduler
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/briggysmalls/detectordag/shared"
+)
+
+// Scheduler runs jobs at some point in the future
+type Scheduler interface {
+	// Schedule runs a job of the given type with the payload at the given time
+	Schedule(jobType string, payload interface{}, at time.Time) error
+	// ScheduleAfter runs a job of the given type with the payload after the given delay
+	ScheduleAfter(jobType string, payload interface{}, delay time.Duration) error
+}
+
+// Queue is a scheduler that delivers jobs as messages, which must be passed back to Handle
+type Queue interface {
+	Scheduler
+	Handle(body string) error
+}
+
+// HandlerFunc runs a job, given its payload
+type HandlerFunc func(payload json.RawMessage) error
+
+// Job is a scheduled unit of work
+type Job struct {
+	Type    string          `json:"type" validate:"required"`
+	RunAt   time.Time       `json:"runAt" validate:"required"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+// Dispatcher routes jobs to the handler registered for their type
+type Dispatcher struct {
+	handlers map[string]HandlerFunc
+	legacy   string
+}
+
+// NewDispatcher creates a Dispatcher without any handlers
+func NewDispatcher() *Dispatcher {
+	return &Dispatcher{handlers: map[string]HandlerFunc{}}
+}
+
+// Register sets the handler to run for jobs of the given type
+func (d *Dispatcher) Register(jobType string, handler HandlerFunc) {
+	d.handlers[jobType] = handler
+}
+
+// SetLegacyType runs messages that aren't jobs as jobs of the given type
+// They are payloads that were queued directly, before jobs were introduced
+// TODO: Remove once no messages in the old format can still be queued
+func (d *Dispatcher) SetLegacyType(jobType string) {
+	d.legacy = jobType
+}
+
+// legacyJob wraps a message in the old format as a job that is due now
+func (d *Dispatcher) legacyJob(body string, now time.Time) (Job, error) {
+	if d.legacy == "" {
+		return Job{}, fmt.Errorf("Message isn't a job")
+	}
+	return Job{Type: d.legacy, RunAt: now, Payload: json.RawMessage(body)}, nil
+}
+
+// Dispatch runs the job with its registered handler
+func (d *Dispatcher) Dispatch(job Job) error {
+	handler, ok := d.handlers[job.Type]
+	if !ok {
+		return fmt.Errorf("No handler for job type: '%s'", job.Type)
+	}
+	return handler(job.Payload)
+}
+
+func newJob(jobType string, payload interface{}, at time.Time) (Job, error) {
+	// Ensure the payload is valid now, rather than only finding out when the job runs
+	if reflect.Indirect(reflect.ValueOf(payload)).Kind() == reflect.Struct {
+		if err := shared.Validate.Struct(payload); err != nil {
+			return Job{}, err
+		}
+	}
+	// Serialise the payload
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return Job{}, err
+	}
+	job := Job{Type: jobType, RunAt: at.UTC(), Payload: body}
+	// Ensure the job is valid
+	if err := shared.Validate.Struct(job); err != nil {
+		return Job{}, err
+	}
+	return job, nil
+}
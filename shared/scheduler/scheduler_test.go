@@ -0,0 +1,163 @@
+package scheduler
+
+//go:generate go run github.com/golang/mock/mockgen -destination mock_sqs.go -package scheduler -mock_names Client=MockSQSClient github.com/briggysmalls/detectordag/shared/sqs Client
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/briggysmalls/detectordag/shared/sqs"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	testJobType = "test"
+)
+
+type testPayload struct {
+	Message string `json:"message" validate:"required"`
+}
+
+func TestSQSSchedule(t *testing.T) {
+	testParams := []struct {
+		delay    time.Duration
+		expected time.Duration
+	}{
+		{delay: 30 * time.Second, expected: 30 * time.Second},
+		{delay: -time.Minute, expected: 0},
+		// Delays longer than SQS allows are re-enqueued when received
+		{delay: time.Hour, expected: sqs.MaxDelay},
+	}
+	for _, params := range testParams {
+		// Create the unit under test
+		clock := NewFakeClock(time.Unix(1584803414, 0))
+		queue, mock, _ := createSQSQueue(t, clock)
+		// Expect the job to be sent
+		mock.EXPECT().Send(gomock.Any(), params.expected).Do(func(body []byte, delay time.Duration) {
+			var job Job
+			assert.NoError(t, json.Unmarshal(body, &job))
+			assert.Equal(t, testJobType, job.Type)
+			assert.Equal(t, clock.Now().Add(params.delay).UTC(), job.RunAt)
+			assert.JSONEq(t, `{"message":"hello"}`, string(job.Payload))
+		})
+		// Schedule the job
+		assert.NoError(t, queue.ScheduleAfter(testJobType, testPayload{Message: "hello"}, params.delay))
+	}
+}
+
+func TestSQSScheduleInvalid(t *testing.T) {
+	// Create the unit under test
+	queue, _, _ := createSQSQueue(t, NewFakeClock(time.Unix(1584803414, 0)))
+	// Assert an invalid payload is never sent
+	assert.Error(t, queue.ScheduleAfter(testJobType, testPayload{}, time.Minute))
+}
+
+func TestSQSHandle(t *testing.T) {
+	// Create the unit under test
+	clock := NewFakeClock(time.Unix(1584803414, 0))
+	queue, mock, dispatcher := createSQSQueue(t, clock)
+	// Register a handler
+	var received []testPayload
+	dispatcher.Register(testJobType, func(body json.RawMessage) error {
+		var payload testPayload
+		assert.NoError(t, json.Unmarshal(body, &payload))
+		received = append(received, payload)
+		return nil
+	})
+	// Schedule a job an hour away, capturing the message
+	var message []byte
+	mock.EXPECT().Send(gomock.Any(), sqs.MaxDelay).Do(func(body []byte, delay time.Duration) {
+		message = body
+	}).Times(4)
+	assert.NoError(t, queue.ScheduleAfter(testJobType, testPayload{Message: "hello"}, time.Hour))
+	// Receive the message each time SQS delivers it
+	for i := 0; i < 3; i++ {
+		clock.Advance(sqs.MaxDelay)
+		assert.NoError(t, queue.Handle(string(message)))
+		assert.Empty(t, received)
+	}
+	// The job runs once it is due
+	clock.Advance(sqs.MaxDelay)
+	assert.NoError(t, queue.Handle(string(message)))
+	assert.Equal(t, []testPayload{{Message: "hello"}}, received)
+}
+
+func TestSQSHandleLegacy(t *testing.T) {
+	const legacy = `{"message":"hello"}`
+	// Create the unit under test
+	queue, _, dispatcher := createSQSQueue(t, NewFakeClock(time.Unix(1584803414, 0)))
+	// Register a handler
+	var received []string
+	dispatcher.Register(testJobType, func(body json.RawMessage) error {
+		received = append(received, string(body))
+		return nil
+	})
+	// Assert messages in the old format are rejected, unless they're expected
+	assert.Error(t, queue.Handle(legacy))
+	dispatcher.SetLegacyType(testJobType)
+	assert.NoError(t, queue.Handle(legacy))
+	assert.Equal(t, []string{legacy}, received)
+}
+
+func TestSQSHandleInvalid(t *testing.T) {
+	testParams := []string{
+		`not json`,
+		`{"payload":{}}`,
+		`{"type":"unknown","runAt":"2020-03-21T15:10:14Z","payload":{}}`,
+	}
+	for _, params := range testParams {
+		// Create the unit under test
+		queue, _, _ := createSQSQueue(t, NewFakeClock(time.Unix(1584803414, 0)))
+		assert.Error(t, queue.Handle(params))
+	}
+}
+
+func TestTimerScheduler(t *testing.T) {
+	// Create the unit under test
+	clock := NewFakeClock(time.Unix(1584803414, 0))
+	dispatcher := NewDispatcher()
+	scheduler := NewTimer(clock, dispatcher)
+	// Register a handler
+	var received []string
+	dispatcher.Register(testJobType, func(body json.RawMessage) error {
+		var payload testPayload
+		assert.NoError(t, json.Unmarshal(body, &payload))
+		received = append(received, payload.Message)
+		return nil
+	})
+	// Schedule some jobs out of order
+	assert.NoError(t, scheduler.ScheduleAfter(testJobType, testPayload{Message: "second"}, 10*time.Minute))
+	assert.NoError(t, scheduler.Schedule(testJobType, testPayload{Message: "first"}, clock.Now().Add(5*time.Minute)))
+	// Assert nothing runs early
+	clock.Advance(4 * time.Minute)
+	assert.Empty(t, received)
+	// Assert the jobs run in order
+	clock.Advance(time.Minute)
+	assert.Equal(t, []string{"first"}, received)
+	clock.Advance(time.Hour)
+	assert.Equal(t, []string{"first", "second"}, received)
+}
+
+func TestFakeClockTimerStopped(t *testing.T) {
+	clock := NewFakeClock(time.Unix(1584803414, 0))
+	// Create a timer, and stop it
+	fired := false
+	timer := clock.AfterFunc(time.Second, func() { fired = true })
+	assert.True(t, timer.Stop())
+	// Assert it never fires
+	clock.Advance(time.Minute)
+	assert.False(t, fired)
+	assert.False(t, timer.Stop())
+}
+
+func createSQSQueue(t *testing.T, clock Clock) (Queue, *MockSQSClient, *Dispatcher) {
+	// Create mock controller
+	ctrl := gomock.NewController(t)
+	// Create mock SQS client
+	mock := NewMockSQSClient(ctrl)
+	// Create the unit under test
+	dispatcher := NewDispatcher()
+	return NewSQS(mock, clock, dispatcher), mock, dispatcher
+}
@@ -0,0 +1,82 @@
+package scheduler
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/briggysmalls/detectordag/shared"
+	"github.com/briggysmalls/detectordag/shared/sqs"
+)
+
+type sqsQueue struct {
+	sqs        sqs.Client
+	clock      Clock
+	dispatcher *Dispatcher
+}
+
+// NewSQS gets a Queue that delivers jobs through SQS
+// Jobs further away than SQS allows are delivered early, and then re-enqueued until they are due
+func NewSQS(sqs sqs.Client, clock Clock, dispatcher *Dispatcher) Queue {
+	return &sqsQueue{
+		sqs:        sqs,
+		clock:      clock,
+		dispatcher: dispatcher,
+	}
+}
+
+func (q *sqsQueue) Schedule(jobType string, payload interface{}, at time.Time) error {
+	job, err := newJob(jobType, payload, at)
+	if err != nil {
+		return err
+	}
+	return q.send(job)
+}
+
+func (q *sqsQueue) ScheduleAfter(jobType string, payload interface{}, delay time.Duration) error {
+	return q.Schedule(jobType, payload, q.clock.Now().Add(delay))
+}
+
+// Handle runs the job in a message received from the queue, if it is due
+func (q *sqsQueue) Handle(body string) error {
+	// Deserialise the job
+	var job Job
+	if err := json.Unmarshal([]byte(body), &job); err != nil {
+		return err
+	}
+	if job.RunAt.IsZero() && job.Payload == nil {
+		// This was queued in the old format, so isn't wrapped in a job
+		legacy, err := q.dispatcher.legacyJob(body, q.clock.Now())
+		if err != nil {
+			return err
+		}
+		job = legacy
+	}
+	if err := shared.Validate.Struct(job); err != nil {
+		return err
+	}
+	// Put the job back if it isn't due yet
+	if job.RunAt.After(q.clock.Now()) {
+		log.Printf("Job '%s' not due until %s, re-enqueuing", job.Type, job.RunAt)
+		return q.send(job)
+	}
+	// Run the job
+	return q.dispatcher.Dispatch(job)
+}
+
+func (q *sqsQueue) send(job Job) error {
+	// Serialise the job
+	body, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	// Delay for as long as we can, up to when the job is due
+	delay := job.RunAt.Sub(q.clock.Now())
+	if delay < 0 {
+		delay = 0
+	}
+	if delay > sqs.MaxDelay {
+		delay = sqs.MaxDelay
+	}
+	return q.sqs.Send(body, delay)
+}
@@ -0,0 +1,39 @@
+package scheduler
+
+import (
+	"log"
+	"time"
+)
+
+type timerScheduler struct {
+	clock      Clock
+	dispatcher *Dispatcher
+}
+
+// NewTimer gets a Scheduler that runs jobs in-process, for when we aren't hosted on AWS
+// Note: Scheduled jobs are lost if the process exits
+func NewTimer(clock Clock, dispatcher *Dispatcher) Scheduler {
+	return &timerScheduler{
+		clock:      clock,
+		dispatcher: dispatcher,
+	}
+}
+
+func (s *timerScheduler) Schedule(jobType string, payload interface{}, at time.Time) error {
+	// Serialise the job now, so it runs the same as if it had been queued
+	job, err := newJob(jobType, payload, at)
+	if err != nil {
+		return err
+	}
+	// Run the job when it is due
+	s.clock.AfterFunc(at.Sub(s.clock.Now()), func() {
+		if err := s.dispatcher.Dispatch(job); err != nil {
+			log.Printf("Job '%s' failed: %v", job.Type, err)
+		}
+	})
+	return nil
+}
+
+func (s *timerScheduler) ScheduleAfter(jobType string, payload interface{}, delay time.Duration) error {
+	return s.Schedule(jobType, payload, s.clock.Now().Add(delay))
+}
@@ -2,6 +2,7 @@ package shadow
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"time"
@@ -11,19 +12,41 @@ import (
 	"github.com/aws/aws-sdk-go/service/iot"
 	"github.com/aws/aws-sdk-go/service/iotdataplane"
 	"github.com/aws/aws-sdk-go/service/iotdataplane/iotdataplaneiface"
+	"github.com/briggysmalls/detectordag/shared/maintenance"
+	"github.com/briggysmalls/detectordag/shared/metrics"
 )
 
 const (
 	TopicPatternRequestStatusUpdate = "dags/%s/status/request"
+	// maxConditionalAttempts is how many times a conditional update is tried when racing other updates
+	maxConditionalAttempts = 3
 )
 
+// ErrStaleUpdate indicates the shadow already holds a more recent connection status
+var ErrStaleUpdate = errors.New("Shadow holds a newer connection status")
+
+// ErrVersionConflict indicates the shadow has changed since the version an update was conditional on
+var ErrVersionConflict = errors.New("Shadow changed since it was read")
+
 // Client represents a client to the device shadow service
 type Client interface {
 	Get(deviceId string) (*Shadow, error)
-	UpdateConnectionStatus(deviceID string, status string, updated time.Time) (*Shadow, error)
-	UpdateConnectionTransientID(deviceID string, ID string) error
+	GetWithContext(ctx aws.Context, deviceId string) (*Shadow, error)
+	UpdateConnectionStatus(deviceID string, status string, updated time.Time) (*Shadow, *Shadow, error)
+	UpdateConnectionTransientID(deviceID string, ID string, at time.Time) error
 	UpdateName(deviceId, name string) (*Shadow, error)
 	RequestStatusUpdate(deviceID string) error
+	UpdateStability(deviceID string, stability StabilityShadow, version int) error
+	UpdateDesiredConfig(deviceID string, update DeviceConfigUpdate) (*Shadow, error)
+	UpdateDischarge(deviceID string, discharge DischargeShadow) error
+	UpdateCellularHistory(deviceID string, cellular CellularShadow) error
+	UpdateTemperatureHistory(deviceID string, probes map[string]ProbeShadow) error
+	UpdateProbeThresholds(deviceID, probe string, thresholds ProbeThresholds) (*Shadow, error)
+	UpdateMetricRules(deviceID string, rules []metrics.Rule) (*Shadow, error)
+	UpdateRuleStates(deviceID string, states map[string]metrics.RuleState) error
+	UpdateAppliance(deviceID string, appliance ApplianceShadow) (*Shadow, error)
+	UpdateOutage(deviceID string, outage OutageShadow) error
+	UpdateMaintenance(deviceID string, windows []maintenance.Window) (*Shadow, error)
 }
 
 type client struct {
@@ -31,7 +54,8 @@ type client struct {
 }
 
 type ConnectionUpdatePayload struct {
-	State struct {
+	Version int `json:"version,omitempty"`
+	State   struct {
 		Reported struct {
 			Connection struct {
 				Status  string    `json:"current"`
@@ -46,15 +70,113 @@ func (p *ConnectionUpdatePayload) Dump() ([]byte, error) {
 }
 
 type TransientConnectionUpdatePayload struct {
-	State struct {
+	Version int `json:"version,omitempty"`
+	State   struct {
 		Reported struct {
 			Connection struct {
-				TransientID string `json:"transientId"`
+				TransientID string    `json:"transientId"`
+				LastEvent   Timestamp `json:"lastEvent"`
 			} `json:"connection"`
 		} `json:"reported"`
 	} `json:"state"`
 }
 
+type StabilityUpdatePayload struct {
+	Version int `json:"version,omitempty"`
+	State   struct {
+		Reported struct {
+			Stability struct {
+				Unstable    bool `json:"unstable"`
+				Disconnects int  `json:"disconnects"`
+				MeanSession int  `json:"meanSession"`
+			} `json:"stability"`
+		} `json:"reported"`
+	} `json:"state"`
+}
+
+type DischargeUpdatePayload struct {
+	State struct {
+		Reported struct {
+			Discharge DischargeSchema `json:"discharge"`
+		} `json:"reported"`
+	} `json:"state"`
+}
+
+type CellularHistoryUpdatePayload struct {
+	State struct {
+		Reported struct {
+			Cellular CellularSchema `json:"cellular"`
+		} `json:"reported"`
+	} `json:"state"`
+}
+
+type TemperatureHistoryUpdatePayload struct {
+	State struct {
+		Reported struct {
+			Probes map[string]ProbeSchema `json:"probes"`
+		} `json:"reported"`
+	} `json:"state"`
+}
+
+type ProbeThresholdsUpdatePayload struct {
+	State struct {
+		Desired struct {
+			Thresholds map[string]ThresholdsSchema `json:"thresholds"`
+		} `json:"desired"`
+		Reported struct {
+			Thresholds map[string]*ThresholdsSchema `json:"thresholds"`
+		} `json:"reported"`
+	} `json:"state"`
+}
+
+type MetricRulesUpdatePayload struct {
+	State struct {
+		Reported struct {
+			MetricRules []MetricRuleSchema `json:"metricRules"`
+		} `json:"reported"`
+	} `json:"state"`
+}
+
+type RuleStatesUpdatePayload struct {
+	State struct {
+		Reported struct {
+			RuleStates map[string]RuleStateSchema `json:"ruleStates"`
+		} `json:"reported"`
+	} `json:"state"`
+}
+
+type ApplianceUpdatePayload struct {
+	State struct {
+		Reported struct {
+			Appliance ApplianceSchema `json:"appliance"`
+		} `json:"reported"`
+	} `json:"state"`
+}
+
+type OutageUpdatePayload struct {
+	State struct {
+		Reported struct {
+			Outage OutageSchema `json:"outage"`
+		} `json:"reported"`
+	} `json:"state"`
+}
+
+type MaintenanceUpdatePayload struct {
+	State struct {
+		Reported struct {
+			Maintenance []MaintenanceSchema `json:"maintenance"`
+		} `json:"reported"`
+	} `json:"state"`
+}
+
+type DesiredConfigUpdatePayload struct {
+	State struct {
+		Desired struct {
+			Config ConfigUpdateSchema `json:"config"`
+		} `json:"desired"`
+	} `json:"state"`
+}
+
 type NameUpdatePayload struct {
 	State struct {
 		Reported struct {
@@ -86,8 +208,13 @@ func New(sess *session.Session) (Client, error) {
 }
 
 func (c *client) Get(deviceId string) (*Shadow, error) {
+	return c.GetWithContext(aws.BackgroundContext(), deviceId)
+}
+
+// GetWithContext gets the shadow, giving up when the context is done
+func (c *client) GetWithContext(ctx aws.Context, deviceId string) (*Shadow, error) {
 	// Request the shadow
-	payload, err := c.getShadow(deviceId)
+	payload, err := c.getShadow(ctx, deviceId)
 	if err != nil {
 		return nil, err
 	}
@@ -96,26 +223,65 @@ func (c *client) Get(deviceId string) (*Shadow, error) {
 	return shadowSchema.Extract(payload)
 }
 
-func (c *client) UpdateConnectionStatus(deviceID, status string, updated time.Time) (*Shadow, error) {
+// UpdateConnectionStatus updates the connection status, unless the shadow holds a newer one
+// The update is conditional on the shadow version, so racing updates cannot regress the status,
+// and the shadow that was replaced is returned alongside the updated one
+func (c *client) UpdateConnectionStatus(deviceID, status string, updated time.Time) (*Shadow, *Shadow, error) {
+	for attempt := 1; ; attempt++ {
+		// Get the current status
+		current, err := c.Get(deviceID)
+		if err != nil {
+			return nil, nil, err
+		}
+		// Refuse to overwrite a newer status
+		if updated.Before(current.Connection.Updated) {
+			return nil, nil, ErrStaleUpdate
+		}
+		// Create new reported state
+		updatePayload := ConnectionUpdatePayload{Version: current.Version}
+		updatePayload.State.Reported.Connection.Status = status
+		updatePayload.State.Reported.Connection.Updated.Time = updated
+		// Bundle up the request
+		payload, err := updatePayload.Dump()
+		if err != nil {
+			return nil, nil, err
+		}
+		// Update
+		shdw, err := c.updateShadow(deviceID, payload)
+		// Try again if the shadow changed since we read it
+		var conflict *iotdataplane.ConflictException
+		if errors.As(err, &conflict) && attempt < maxConditionalAttempts {
+			log.Printf("Shadow for '%s' changed during update, retrying", deviceID)
+			continue
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		return current, shdw, nil
+	}
+}
+
+func (c *client) UpdateName(deviceID, name string) (*Shadow, error) {
 	// Create new reported state
-	updatePayload := ConnectionUpdatePayload{}
-	updatePayload.State.Reported.Connection.Status = status
-	updatePayload.State.Reported.Connection.Updated.Time = updated
+	updatePayload := NameUpdatePayload{}
+	updatePayload.State.Reported.Name = name
 	// Bundle up the request
 	payload, err := updatePayload.Dump()
 	if err != nil {
 		return nil, err
 	}
-	// Update
+	// Make the request
 	return c.updateShadow(deviceID, payload)
 }
 
-func (c *client) UpdateName(deviceID, name string) (*Shadow, error) {
-	// Create new reported state
-	updatePayload := NameUpdatePayload{}
-	updatePayload.State.Reported.Name = name
+// UpdateDesiredConfig asks the device to apply new configuration
+// Nil fields of the update are left as they are, and zero values are cleared
+func (c *client) UpdateDesiredConfig(deviceID string, update DeviceConfigUpdate) (*Shadow, error) {
+	// Create new desired state
+	updatePayload := DesiredConfigUpdatePayload{}
+	updatePayload.State.Desired.Config = NewConfigUpdateSchema(update)
 	// Bundle up the request
-	payload, err := updatePayload.Dump()
+	payload, err := json.Marshal(updatePayload)
 	if err != nil {
 		return nil, err
 	}
@@ -134,7 +300,7 @@ func (c *client) updateShadow(deviceID string, payload []byte) (*Shadow, error)
 		return nil, err
 	}
 	// Request the shadow
-	shdw, err := c.getShadow(deviceID)
+	shdw, err := c.getShadow(aws.BackgroundContext(), deviceID)
 	if err != nil {
 		return nil, err
 	}
@@ -143,17 +309,99 @@ func (c *client) updateShadow(deviceID string, payload []byte) (*Shadow, error)
 	return shadowSchema.Extract([]byte(shdw))
 }
 
-func (c *client) UpdateConnectionTransientID(deviceID, ID string) error {
+// UpdateConnectionTransientID records the latest lifecycle event, even if it doesn't change the status
+// Events older than one already recorded are refused with ErrStaleUpdate
+func (c *client) UpdateConnectionTransientID(deviceID, ID string, at time.Time) error {
+	for attempt := 1; ; attempt++ {
+		// Get the latest event
+		current, err := c.Get(deviceID)
+		if err != nil {
+			return err
+		}
+		// Refuse to overwrite a newer event
+		if at.Before(current.Connection.Updated) || at.Before(current.Connection.LastEvent) {
+			return ErrStaleUpdate
+		}
+		// Create new reported state
+		updatePayload := TransientConnectionUpdatePayload{Version: current.Version}
+		updatePayload.State.Reported.Connection.TransientID = ID
+		updatePayload.State.Reported.Connection.LastEvent.Time = at
+		// Bundle up the request (by reference, so the timestamp is marshalled as such)
+		payload, err := json.Marshal(&updatePayload)
+		if err != nil {
+			return err
+		}
+		// Make the request
+		log.Print(string(payload))
+		_, err = c.dp.UpdateThingShadow(&iotdataplane.UpdateThingShadowInput{
+			ThingName: aws.String(deviceID),
+			Payload:   payload,
+		})
+		// Try again if the shadow changed since we read it
+		var conflict *iotdataplane.ConflictException
+		if errors.As(err, &conflict) && attempt < maxConditionalAttempts {
+			log.Printf("Shadow for '%s' changed during update, retrying", deviceID)
+			continue
+		}
+		return err
+	}
+}
+
+// UpdateStability records the device's recent connection stability
+// The update is conditional on the shadow still being at the given version
+func (c *client) UpdateStability(deviceID string, stability StabilityShadow, version int) error {
 	// Create new reported state
-	updatePayload := TransientConnectionUpdatePayload{}
-	updatePayload.State.Reported.Connection.TransientID = ID
+	updatePayload := StabilityUpdatePayload{Version: version}
+	updatePayload.State.Reported.Stability.Unstable = stability.Unstable
+	updatePayload.State.Reported.Stability.Disconnects = stability.Disconnects
+	updatePayload.State.Reported.Stability.MeanSession = int(stability.MeanSession.Seconds())
+	// Bundle up the request
+	payload, err := json.Marshal(updatePayload)
+	if err != nil {
+		return err
+	}
+	// Make the request
+	_, err = c.dp.UpdateThingShadow(&iotdataplane.UpdateThingShadowInput{
+		ThingName: aws.String(deviceID),
+		Payload:   payload,
+	})
+	var conflict *iotdataplane.ConflictException
+	if errors.As(err, &conflict) {
+		return ErrVersionConflict
+	}
+	return err
+}
+
+// UpdateDischarge records the history of the device's battery discharging
+func (c *client) UpdateDischarge(deviceID string, discharge DischargeShadow) error {
+	// Create new reported state
+	updatePayload := DischargeUpdatePayload{}
+	updatePayload.State.Reported.Discharge = NewDischargeSchema(discharge)
+	// Bundle up the request
+	payload, err := json.Marshal(updatePayload)
+	if err != nil {
+		return err
+	}
+	// Make the request
+	_, err = c.dp.UpdateThingShadow(&iotdataplane.UpdateThingShadowInput{
+		ThingName: aws.String(deviceID),
+		Payload:   payload,
+	})
+	return err
+}
+
+// UpdateCellularHistory records the history of the device's cellular connection
+// Only the samples and warnings are written, the device reports the rest
+func (c *client) UpdateCellularHistory(deviceID string, cellular CellularShadow) error {
+	// Create new reported state
+	updatePayload := CellularHistoryUpdatePayload{}
+	updatePayload.State.Reported.Cellular = NewCellularSchema(cellular)
 	// Bundle up the request
 	payload, err := json.Marshal(updatePayload)
 	if err != nil {
 		return err
 	}
 	// Make the request
-	log.Print(string(payload))
 	_, err = c.dp.UpdateThingShadow(&iotdataplane.UpdateThingShadowInput{
 		ThingName: aws.String(deviceID),
 		Payload:   payload,
@@ -161,6 +409,134 @@ func (c *client) UpdateConnectionTransientID(deviceID, ID string) error {
 	return err
 }
 
+// UpdateTemperatureHistory records the history of the given temperature probes
+// Probes that aren't given are left as they are
+func (c *client) UpdateTemperatureHistory(deviceID string, probes map[string]ProbeShadow) error {
+	// Create new reported state
+	updatePayload := TemperatureHistoryUpdatePayload{}
+	updatePayload.State.Reported.Probes = make(map[string]ProbeSchema, len(probes))
+	for name, probe := range probes {
+		updatePayload.State.Reported.Probes[name] = NewProbeSchema(probe)
+	}
+	// Bundle up the request
+	payload, err := json.Marshal(updatePayload)
+	if err != nil {
+		return err
+	}
+	// Make the request
+	_, err = c.dp.UpdateThingShadow(&iotdataplane.UpdateThingShadowInput{
+		ThingName: aws.String(deviceID),
+		Payload:   payload,
+	})
+	return err
+}
+
+// UpdateProbeThresholds sets the temperatures a probe should stay between
+func (c *client) UpdateProbeThresholds(deviceID, probe string, thresholds ProbeThresholds) (*Shadow, error) {
+	// Create new desired state
+	updatePayload := ProbeThresholdsUpdatePayload{}
+	updatePayload.State.Desired.Thresholds = map[string]ThresholdsSchema{
+		probe: {Min: thresholds.Min, Max: thresholds.Max},
+	}
+	// Forget any thresholds that were stored in the reported state, so they don't come back
+	updatePayload.State.Reported.Thresholds = map[string]*ThresholdsSchema{probe: nil}
+	// Bundle up the request
+	payload, err := json.Marshal(updatePayload)
+	if err != nil {
+		return nil, err
+	}
+	// Make the request
+	return c.updateShadow(deviceID, payload)
+}
+
+// UpdateMetricRules replaces the rules the device's metrics are checked against
+func (c *client) UpdateMetricRules(deviceID string, rules []metrics.Rule) (*Shadow, error) {
+	// Create new reported state
+	updatePayload := MetricRulesUpdatePayload{}
+	updatePayload.State.Reported.MetricRules = make([]MetricRuleSchema, len(rules))
+	for i, rule := range rules {
+		updatePayload.State.Reported.MetricRules[i] = NewMetricRuleSchema(rule)
+	}
+	// Bundle up the request
+	payload, err := json.Marshal(updatePayload)
+	if err != nil {
+		return nil, err
+	}
+	// Make the request
+	return c.updateShadow(deviceID, payload)
+}
+
+// UpdateRuleStates records how the given rules stand
+// Rules that aren't given are left as they are
+func (c *client) UpdateRuleStates(deviceID string, states map[string]metrics.RuleState) error {
+	// Create new reported state
+	updatePayload := RuleStatesUpdatePayload{}
+	updatePayload.State.Reported.RuleStates = make(map[string]RuleStateSchema, len(states))
+	for id, state := range states {
+		updatePayload.State.Reported.RuleStates[id] = NewRuleStateSchema(state)
+	}
+	// Bundle up the request
+	payload, err := json.Marshal(updatePayload)
+	if err != nil {
+		return err
+	}
+	// Make the request
+	_, err = c.dp.UpdateThingShadow(&iotdataplane.UpdateThingShadowInput{
+		ThingName: aws.String(deviceID),
+		Payload:   payload,
+	})
+	return err
+}
+
+// UpdateAppliance sets the appliance the device is watching over
+func (c *client) UpdateAppliance(deviceID string, appliance ApplianceShadow) (*Shadow, error) {
+	// Create new reported state
+	updatePayload := ApplianceUpdatePayload{}
+	updatePayload.State.Reported.Appliance = NewApplianceSchema(appliance)
+	// Bundle up the request
+	payload, err := json.Marshal(updatePayload)
+	if err != nil {
+		return nil, err
+	}
+	// Make the request
+	return c.updateShadow(deviceID, payload)
+}
+
+// UpdateOutage records the power cut the device is in the middle of
+func (c *client) UpdateOutage(deviceID string, outage OutageShadow) error {
+	// Create new reported state
+	updatePayload := OutageUpdatePayload{}
+	updatePayload.State.Reported.Outage = NewOutageSchema(outage)
+	// Bundle up the request
+	payload, err := json.Marshal(updatePayload)
+	if err != nil {
+		return err
+	}
+	// Make the request
+	_, err = c.dp.UpdateThingShadow(&iotdataplane.UpdateThingShadowInput{
+		ThingName: aws.String(deviceID),
+		Payload:   payload,
+	})
+	return err
+}
+
+// UpdateMaintenance replaces the windows during which the device's notifications are held back
+func (c *client) UpdateMaintenance(deviceID string, windows []maintenance.Window) (*Shadow, error) {
+	// Create new reported state
+	updatePayload := MaintenanceUpdatePayload{}
+	updatePayload.State.Reported.Maintenance = make([]MaintenanceSchema, len(windows))
+	for i, window := range windows {
+		updatePayload.State.Reported.Maintenance[i] = NewMaintenanceSchema(window)
+	}
+	// Bundle up the request
+	payload, err := json.Marshal(updatePayload)
+	if err != nil {
+		return nil, err
+	}
+	// Make the request
+	return c.updateShadow(deviceID, payload)
+}
+
 func (c *client) RequestStatusUpdate(deviceID string) error {
 	_, err := c.dp.Publish(&iotdataplane.PublishInput{
 		Qos:     aws.Int64(1),
@@ -170,9 +546,9 @@ func (c *client) RequestStatusUpdate(deviceID string) error {
 	return err
 }
 
-func (c *client) getShadow(deviceID string) ([]byte, error) {
+func (c *client) getShadow(ctx aws.Context, deviceID string) ([]byte, error) {
 	// Request the shadow
-	resp, err := c.dp.GetThingShadow(&iotdataplane.GetThingShadowInput{
+	resp, err := c.dp.GetThingShadowWithContext(ctx, &iotdataplane.GetThingShadowInput{
 		ThingName: aws.String(deviceID),
 	})
 	// Bail on error
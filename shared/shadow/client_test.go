@@ -4,11 +4,14 @@ package shadow
 
 import (
 	"log"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/iotdataplane"
+	"github.com/briggysmalls/detectordag/shared/maintenance"
+	"github.com/briggysmalls/detectordag/shared/metrics"
 	"github.com/golang/mock/gomock"
 	"github.com/stretchr/testify/assert"
 )
@@ -34,7 +37,26 @@ func TestGetShadow(t *testing.T) {
 						"transientId":"efb3ed5f-5357-4ebd-843c-6f8e79b74eae",
 						"updated":1584803417
 					},
-					"status":"off"
+					"status":"off",
+					"stability":{"unstable":true,"disconnects":12,"meanSession":5400},
+					"config":{"reportInterval":60,"lowBatteryThreshold":20},
+					"battery":{"percent":85,"voltage":4.05,"charging":true},
+					"discharge":{"rate":12.5,"samples":[{"percent":90,"timestamp":1584800000},{"percent":85,"timestamp":1584801440}]},
+					"signal":{"rssi":-71,"rsrp":-98},
+					"dataUsedBytes":52428800,
+					"cellular":{"capWarned":"2020-02","samples":[{"timestamp":1584800000,"rssi":-75,"rsrp":-101,"dataUsedBytes":41943040}]},
+					"temperatures":[{"probe":"freezer","celsius":-18.5},{"probe":"fridge","celsius":4}],
+					"probes":{"freezer":{"alerting":true,"samples":[{"timestamp":1584800000,"celsius":-17}]}},
+					"thresholds":{"fridge":{"min":0,"max":5}},
+					"metrics":{"cellar":{"kind":"humidity","value":85,"unit":"percent"},"mains":{"kind":"voltage","value":-1,"unit":"volts"}},
+					"metricRules":[{"id":"damp","metric":"cellar","condition":"above","value":80,"for":1800}],
+					"ruleStates":{"damp":{"since":1584800000,"alerting":false}},
+					"appliance":{"type":"freezer","safeFor":172800},
+					"outage":{"started":1584803414,"advised":false},
+					"maintenance":[{"id":"rewiring","start":1584867600,"duration":7200,"repeat":"once"}]
+				},"desired":{
+					"config":{"reportInterval":300,"lowBatteryThreshold":20},
+					"thresholds":{"freezer":{"max":-15}}
 				}},
 				"timestamp":1584810789,"version":50
 			}`,
@@ -43,6 +65,7 @@ func TestGetShadow(t *testing.T) {
 				Name:    "hello world",
 				Time:    time.Unix(1584810789, 0),
 				Version: 50,
+				Seen:    time.Unix(1584803414, 0),
 				Connection: ConnectionShadow{
 					Status:      CONNECTION_STATUS_CONNECTED,
 					TransientID: "efb3ed5f-5357-4ebd-843c-6f8e79b74eae",
@@ -52,6 +75,54 @@ func TestGetShadow(t *testing.T) {
 					Value:   POWER_STATUS_OFF,
 					Updated: time.Unix(1584803414, 0),
 				},
+				Stability: StabilityShadow{
+					Unstable:    true,
+					Disconnects: 12,
+					MeanSession: 90 * time.Minute,
+				},
+				Config: ConfigShadow{
+					Desired:  DeviceConfig{ReportInterval: 5 * time.Minute, LowBatteryThreshold: 20},
+					Reported: DeviceConfig{ReportInterval: time.Minute, LowBatteryThreshold: 20},
+				},
+				Battery: &BatteryShadow{Percent: 85, Voltage: 4.05, Charging: true},
+				Discharge: DischargeShadow{
+					Rate: 12.5,
+					Samples: []BatterySample{
+						{Percent: 90, Time: time.Unix(1584800000, 0)},
+						{Percent: 85, Time: time.Unix(1584801440, 0)},
+					},
+				},
+				Cellular: CellularShadow{
+					Signal:    &SignalShadow{RSSI: -71, RSRP: -98},
+					DataUsed:  52428800,
+					CapWarned: "2020-02",
+					Samples: []CellularSample{{
+						Time:     time.Unix(1584800000, 0),
+						Signal:   SignalShadow{RSSI: -75, RSRP: -101},
+						DataUsed: 41943040,
+					}},
+				},
+				Temperature: TemperatureShadow{
+					Readings: []ProbeReading{{Probe: "freezer", Celsius: -18.5}, {Probe: "fridge", Celsius: 4}},
+					Probes: map[string]ProbeShadow{
+						"freezer": {Alerting: true, Samples: []TemperatureSample{{Time: time.Unix(1584800000, 0), Celsius: -17}}},
+					},
+					Thresholds: map[string]ProbeThresholds{
+						"freezer": {Max: celsius(-15)},
+						"fridge":  {Min: celsius(0), Max: celsius(5)},
+					},
+				},
+				Metrics: MetricsShadow{
+					// Invalid readings are left out
+					Readings: map[string]metrics.Reading{"cellar": {Kind: "humidity", Value: 85, Unit: "percent"}},
+					Rules:    []metrics.Rule{{ID: "damp", Metric: "cellar", Condition: metrics.Above, Value: 80, For: 30 * time.Minute}},
+					States:   map[string]metrics.RuleState{"damp": {Since: time.Unix(1584800000, 0)}},
+				},
+				Appliance: ApplianceShadow{Type: APPLIANCE_FREEZER, SafeFor: 48 * time.Hour},
+				Outage:    OutageShadow{Started: time.Unix(1584803414, 0)},
+				Maintenance: []maintenance.Window{
+					{ID: "rewiring", Start: time.Unix(1584867600, 0), Duration: 2 * time.Hour, Repeat: maintenance.Once},
+				},
 			},
 		},
 		{ // Missing a name
@@ -72,6 +143,7 @@ func TestGetShadow(t *testing.T) {
 				Name:    "",
 				Time:    time.Unix(1584810789, 0),
 				Version: 50,
+				Seen:    time.Unix(1584803414, 0),
 				Connection: ConnectionShadow{
 					Status:      CONNECTION_STATUS_CONNECTED,
 					Updated:     time.Unix(1584803417, 0),
@@ -89,7 +161,7 @@ func TestGetShadow(t *testing.T) {
 		// Create mocks
 		client, mock := createStubbedClient(t)
 		// Configure expectations
-		mock.EXPECT().GetThingShadow(&iotdataplane.GetThingShadowInput{
+		mock.EXPECT().GetThingShadowWithContext(gomock.Any(), &iotdataplane.GetThingShadowInput{
 			ThingName: aws.String(params.deviceID),
 		}).Return(&iotdataplane.GetThingShadowOutput{Payload: []byte(params.payload)}, params.error)
 		// Run the test
@@ -100,10 +172,25 @@ func TestGetShadow(t *testing.T) {
 	}
 }
 
+// A shadow for a device before its connection status is updated
+const currentShadowPayload = `{"metadata":{"reported":{
+		"status":{"timestamp":1584803414}
+	}},
+	"state":{"reported":{
+		"name":"my dag",
+		"connection":{
+			"current":"connected",
+			"transientId":"619eb763-d0ab-4513-aeeb-8ff6ad8a500e",
+			"updated":1584803000
+		},
+		"status":"off"
+	}},"timestamp":1584803400,"version":49}`
+
 // A helper for executing UpdateConnectionStatus without arguments
 func updateConnectionStatusFactory(id, status string, time time.Time) func(Client) (*Shadow, error) {
 	return func(client Client) (*Shadow, error) {
-		return client.UpdateConnectionStatus(id, status, time)
+		_, shdw, err := client.UpdateConnectionStatus(id, status, time)
+		return shdw, err
 	}
 }
 
@@ -117,12 +204,13 @@ func updateNameFactory(id, name string) func(Client) (*Shadow, error) {
 func TestUpdateShadow(t *testing.T) {
 	// Create some test iterations
 	testParams := []struct {
-		deviceID      string
-		status        string
-		payload       string
-		returnPayload string
-		shadow        *Shadow
-		testFunc      func(Client) (*Shadow, error)
+		deviceID       string
+		status         string
+		currentPayload string
+		payload        string
+		returnPayload  string
+		shadow         *Shadow
+		testFunc       func(Client) (*Shadow, error)
 	}{
 		{ // Update connection to 'connected'
 			testFunc: updateConnectionStatusFactory(
@@ -130,8 +218,9 @@ func TestUpdateShadow(t *testing.T) {
 				CONNECTION_STATUS_CONNECTED,
 				time.Unix(1584803417, 0),
 			),
-			deviceID: "eb49b2e7-fd3a-4c03-b47f-b819281475e5",
-			payload:  `{"state":{"reported":{"connection":{"current":"connected","updated":1584803417}}}}`,
+			deviceID:       "eb49b2e7-fd3a-4c03-b47f-b819281475e5",
+			currentPayload: currentShadowPayload,
+			payload:        `{"version":49,"state":{"reported":{"connection":{"current":"connected","updated":1584803417}}}}`,
 			returnPayload: `{"metadata":{"reported":{
 					"status":{"timestamp":1584803414}
 				}},
@@ -148,6 +237,7 @@ func TestUpdateShadow(t *testing.T) {
 				Name:    "my dag",
 				Time:    time.Unix(1584810789, 0),
 				Version: 50,
+				Seen:    time.Unix(1584803414, 0),
 				Connection: ConnectionShadow{
 					Status:      CONNECTION_STATUS_CONNECTED,
 					TransientID: "619eb763-d0ab-4513-aeeb-8ff6ad8a500e",
@@ -165,8 +255,9 @@ func TestUpdateShadow(t *testing.T) {
 				CONNECTION_STATUS_DISCONNECTED,
 				time.Unix(1584803417, 0),
 			),
-			deviceID: "eb49b2e7-fd3a-4c03-b47f-b819281475e5",
-			payload:  `{"state":{"reported":{"connection":{"current":"disconnected","updated":1584803417}}}}`,
+			deviceID:       "eb49b2e7-fd3a-4c03-b47f-b819281475e5",
+			currentPayload: currentShadowPayload,
+			payload:        `{"version":49,"state":{"reported":{"connection":{"current":"disconnected","updated":1584803417}}}}`,
 			returnPayload: `{"metadata":{"reported":{
 				"status":{"timestamp":1584803414}
 			}},
@@ -183,6 +274,7 @@ func TestUpdateShadow(t *testing.T) {
 				Name:    "Annex",
 				Time:    time.Unix(1584810789, 0),
 				Version: 50,
+				Seen:    time.Unix(1584803414, 0),
 				Connection: ConnectionShadow{
 					Status:      CONNECTION_STATUS_DISCONNECTED,
 					Updated:     time.Unix(1584803417, 0),
@@ -217,6 +309,7 @@ func TestUpdateShadow(t *testing.T) {
 				Name:    "Hello",
 				Time:    time.Unix(1584810789, 0),
 				Version: 50,
+				Seen:    time.Unix(1584803414, 0),
 				Connection: ConnectionShadow{
 					Status:      CONNECTION_STATUS_CONNECTED,
 					Updated:     time.Unix(1584803417, 0),
@@ -249,6 +342,7 @@ func TestUpdateShadow(t *testing.T) {
 				Name:    "My Dag",
 				Time:    time.Unix(1584810789, 0),
 				Version: 50,
+				Seen:    time.Unix(1584803414, 0),
 				Connection: ConnectionShadow{
 					Status:      CONNECTION_STATUS_DISCONNECTED,
 					Updated:     time.Unix(1584803417, 0),
@@ -267,16 +361,26 @@ func TestUpdateShadow(t *testing.T) {
 		// Create mocks
 		client, mock := createStubbedClient(t)
 		// Configure expectations
-		mock.EXPECT().UpdateThingShadow(&iotdataplane.UpdateThingShadowInput{
-			ThingName: aws.String(params.deviceID),
-			Payload:   []byte(params.payload),
-		})
-		mock.EXPECT().GetThingShadow(&iotdataplane.GetThingShadowInput{
-			ThingName: aws.String(params.deviceID),
-		}).Return(
-			&iotdataplane.GetThingShadowOutput{
-				Payload: []byte(params.returnPayload),
-			}, nil)
+		calls := []*gomock.Call{}
+		if params.currentPayload != "" {
+			// Conditional updates read the shadow first
+			calls = append(calls, mock.EXPECT().GetThingShadowWithContext(gomock.Any(), &iotdataplane.GetThingShadowInput{
+				ThingName: aws.String(params.deviceID),
+			}).Return(&iotdataplane.GetThingShadowOutput{Payload: []byte(params.currentPayload)}, nil))
+		}
+		calls = append(calls,
+			mock.EXPECT().UpdateThingShadow(&iotdataplane.UpdateThingShadowInput{
+				ThingName: aws.String(params.deviceID),
+				Payload:   []byte(params.payload),
+			}),
+			mock.EXPECT().GetThingShadowWithContext(gomock.Any(), &iotdataplane.GetThingShadowInput{
+				ThingName: aws.String(params.deviceID),
+			}).Return(
+				&iotdataplane.GetThingShadowOutput{
+					Payload: []byte(params.returnPayload),
+				}, nil),
+		)
+		gomock.InOrder(calls...)
 		// Run the test
 		shadow, err := params.testFunc(client)
 		assert.Nil(t, err)
@@ -284,41 +388,305 @@ func TestUpdateShadow(t *testing.T) {
 	}
 }
 
+func TestUpdateConnectionStatusStale(t *testing.T) {
+	const deviceID = "eb49b2e7-fd3a-4c03-b47f-b819281475e5"
+	// Create mocks
+	client, mock := createStubbedClient(t)
+	// Return a shadow updated after the event
+	mock.EXPECT().GetThingShadowWithContext(gomock.Any(), gomock.Any()).Return(&iotdataplane.GetThingShadowOutput{Payload: []byte(currentShadowPayload)}, nil)
+	// Run the test, asserting no update is made
+	_, _, err := client.UpdateConnectionStatus(deviceID, CONNECTION_STATUS_DISCONNECTED, time.Unix(1584802000, 0))
+	assert.Equal(t, ErrStaleUpdate, err)
+}
+
+func TestUpdateConnectionStatusConflict(t *testing.T) {
+	const deviceID = "eb49b2e7-fd3a-4c03-b47f-b819281475e5"
+	// Create mocks
+	client, mock := createStubbedClient(t)
+	// Always return the same shadow
+	mock.EXPECT().GetThingShadowWithContext(gomock.Any(), gomock.Any()).Return(&iotdataplane.GetThingShadowOutput{Payload: []byte(currentShadowPayload)}, nil).AnyTimes()
+	// Reject the first update, as if another update beat us to it
+	gomock.InOrder(
+		mock.EXPECT().UpdateThingShadow(gomock.Any()).Return(nil, &iotdataplane.ConflictException{}),
+		mock.EXPECT().UpdateThingShadow(gomock.Any()).Return(&iotdataplane.UpdateThingShadowOutput{}, nil),
+	)
+	// Run the test
+	previous, _, err := client.UpdateConnectionStatus(deviceID, CONNECTION_STATUS_DISCONNECTED, time.Unix(1584803417, 0))
+	assert.NoError(t, err)
+	// Assert we get the shadow the update replaced
+	assert.Equal(t, 49, previous.Version)
+	assert.Equal(t, CONNECTION_STATUS_CONNECTED, previous.Connection.Status)
+}
+
 func TestUpdateTransientID(t *testing.T) {
-	// Create some test iterations
+	const deviceID = "eb49b2e7-fd3a-4c03-b47f-b819281475e5"
+	// Create mocks
+	client, mock := createStubbedClient(t)
+	gomock.InOrder(
+		// Conditional updates read the shadow first
+		mock.EXPECT().GetThingShadowWithContext(gomock.Any(), &iotdataplane.GetThingShadowInput{
+			ThingName: aws.String(deviceID),
+		}).Return(&iotdataplane.GetThingShadowOutput{Payload: []byte(currentShadowPayload)}, nil),
+		mock.EXPECT().UpdateThingShadow(&iotdataplane.UpdateThingShadowInput{
+			ThingName: aws.String(deviceID),
+			Payload:   []byte(`{"version":49,"state":{"reported":{"connection":{"transientId":"9e9b59ac-b6b6-491b-8c55-f2d502f653b9","lastEvent":1584803417}}}}`),
+		}),
+	)
+	// Run the test
+	assert.NoError(t, client.UpdateConnectionTransientID(deviceID, "9e9b59ac-b6b6-491b-8c55-f2d502f653b9", time.Unix(1584803417, 0)))
+}
+
+func TestUpdateTransientIDStale(t *testing.T) {
+	const deviceID = "eb49b2e7-fd3a-4c03-b47f-b819281475e5"
+	testParams := []string{
+		// The status was updated after the event
+		currentShadowPayload,
+		// An event that didn't change the status was recorded after the event
+		strings.Replace(currentShadowPayload, `"updated":1584803000`, `"updated":1584800000,"lastEvent":1584803000`, 1),
+	}
+	for _, current := range testParams {
+		// Create mocks
+		client, mock := createStubbedClient(t)
+		mock.EXPECT().GetThingShadowWithContext(gomock.Any(), gomock.Any()).Return(&iotdataplane.GetThingShadowOutput{Payload: []byte(current)}, nil)
+		// Run the test, asserting no update is made
+		err := client.UpdateConnectionTransientID(deviceID, "9e9b59ac-b6b6-491b-8c55-f2d502f653b9", time.Unix(1584802000, 0))
+		assert.Equal(t, ErrStaleUpdate, err)
+	}
+}
+
+func TestUpdateTransientIDConflict(t *testing.T) {
+	const deviceID = "eb49b2e7-fd3a-4c03-b47f-b819281475e5"
+	// Create mocks
+	client, mock := createStubbedClient(t)
+	// Always return the same shadow
+	mock.EXPECT().GetThingShadowWithContext(gomock.Any(), gomock.Any()).Return(&iotdataplane.GetThingShadowOutput{Payload: []byte(currentShadowPayload)}, nil).AnyTimes()
+	// Reject the first update, as if another event beat us to it
+	gomock.InOrder(
+		mock.EXPECT().UpdateThingShadow(gomock.Any()).Return(nil, &iotdataplane.ConflictException{}),
+		mock.EXPECT().UpdateThingShadow(gomock.Any()).Return(&iotdataplane.UpdateThingShadowOutput{}, nil),
+	)
+	// Run the test
+	assert.NoError(t, client.UpdateConnectionTransientID(deviceID, "9e9b59ac-b6b6-491b-8c55-f2d502f653b9", time.Unix(1584803417, 0)))
+}
+
+func TestUpdateStability(t *testing.T) {
+	const deviceID = "eb49b2e7-fd3a-4c03-b47f-b819281475e5"
+	// Create mocks
+	client, mock := createStubbedClient(t)
+	// Expect the stability to be reported
+	mock.EXPECT().UpdateThingShadow(&iotdataplane.UpdateThingShadowInput{
+		ThingName: aws.String(deviceID),
+		Payload:   []byte(`{"version":49,"state":{"reported":{"stability":{"unstable":true,"disconnects":12,"meanSession":5400}}}}`),
+	})
+	// Run the test
+	assert.NoError(t, client.UpdateStability(deviceID, StabilityShadow{
+		Unstable:    true,
+		Disconnects: 12,
+		MeanSession: 90 * time.Minute,
+	}, 49))
+}
+
+func TestUpdateStabilityConflict(t *testing.T) {
+	const deviceID = "eb49b2e7-fd3a-4c03-b47f-b819281475e5"
+	// Create mocks
+	client, mock := createStubbedClient(t)
+	// Reject the update, as if another update beat us to it
+	mock.EXPECT().UpdateThingShadow(gomock.Any()).Return(nil, &iotdataplane.ConflictException{})
+	// Run the test
+	assert.Equal(t, ErrVersionConflict, client.UpdateStability(deviceID, StabilityShadow{Unstable: true}, 49))
+}
+
+func TestUpdateDesiredConfig(t *testing.T) {
+	const deviceID = "eb49b2e7-fd3a-4c03-b47f-b819281475e5"
+	// Create mocks
+	client, mock := createStubbedClient(t)
+	gomock.InOrder(
+		// Expect only the given fields to be desired, with cleared fields deleted
+		mock.EXPECT().UpdateThingShadow(&iotdataplane.UpdateThingShadowInput{
+			ThingName: aws.String(deviceID),
+			Payload:   []byte(`{"state":{"desired":{"config":{"reportInterval":300,"lowBatteryThreshold":null}}}}`),
+		}),
+		// Expect the updated shadow to be fetched
+		mock.EXPECT().GetThingShadowWithContext(gomock.Any(), gomock.Any()).Return(&iotdataplane.GetThingShadowOutput{Payload: []byte(currentShadowPayload)}, nil),
+	)
+	// Run the test
+	interval := 5 * time.Minute
+	cleared := 0
+	_, err := client.UpdateDesiredConfig(deviceID, DeviceConfigUpdate{
+		ReportInterval:      &interval,
+		LowBatteryThreshold: &cleared,
+	})
+	assert.NoError(t, err)
+}
+
+func TestUpdateDischarge(t *testing.T) {
+	const deviceID = "eb49b2e7-fd3a-4c03-b47f-b819281475e5"
+	// Create mocks
+	client, mock := createStubbedClient(t)
+	// Expect the discharge history to be reported
+	mock.EXPECT().UpdateThingShadow(&iotdataplane.UpdateThingShadowInput{
+		ThingName: aws.String(deviceID),
+		Payload:   []byte(`{"state":{"reported":{"discharge":{"samples":[{"percent":90,"timestamp":1584800000}],"rate":12.5,"alerted":true}}}}`),
+	})
+	// Run the test
+	assert.NoError(t, client.UpdateDischarge(deviceID, DischargeShadow{
+		Rate:    12.5,
+		Samples: []BatterySample{{Percent: 90, Time: time.Unix(1584800000, 0)}},
+		Alerted: true,
+	}))
+}
+
+func TestUpdateCellularHistory(t *testing.T) {
+	const deviceID = "eb49b2e7-fd3a-4c03-b47f-b819281475e5"
+	// Create mocks
+	client, mock := createStubbedClient(t)
+	// Expect only the history to be reported
+	mock.EXPECT().UpdateThingShadow(&iotdataplane.UpdateThingShadowInput{
+		ThingName: aws.String(deviceID),
+		Payload:   []byte(`{"state":{"reported":{"cellular":{"samples":[{"timestamp":1584800000,"rssi":-75,"rsrp":-101,"dataUsedBytes":1024}],"capWarned":"2020-03"}}}}`),
+	})
+	// Run the test
+	assert.NoError(t, client.UpdateCellularHistory(deviceID, CellularShadow{
+		Signal:    &SignalShadow{RSSI: -70, RSRP: -90},
+		DataUsed:  2048,
+		CapWarned: "2020-03",
+		Samples: []CellularSample{{
+			Time:     time.Unix(1584800000, 0),
+			Signal:   SignalShadow{RSSI: -75, RSRP: -101},
+			DataUsed: 1024,
+		}},
+	}))
+}
+
+func TestUpdateTemperatureHistory(t *testing.T) {
+	const deviceID = "eb49b2e7-fd3a-4c03-b47f-b819281475e5"
+	// Create mocks
+	client, mock := createStubbedClient(t)
+	// Expect only the given probes to be reported
+	mock.EXPECT().UpdateThingShadow(&iotdataplane.UpdateThingShadowInput{
+		ThingName: aws.String(deviceID),
+		Payload:   []byte(`{"state":{"reported":{"probes":{"freezer":{"samples":[{"timestamp":1584800000,"celsius":-17.5}],"alerting":true}}}}}`),
+	})
+	// Run the test
+	assert.NoError(t, client.UpdateTemperatureHistory(deviceID, map[string]ProbeShadow{
+		"freezer": {Alerting: true, Samples: []TemperatureSample{{Time: time.Unix(1584800000, 0), Celsius: -17.5}}},
+	}))
+}
+
+func TestUpdateProbeThresholds(t *testing.T) {
+	const deviceID = "eb49b2e7-fd3a-4c03-b47f-b819281475e5"
+	// Create mocks
+	client, mock := createStubbedClient(t)
+	gomock.InOrder(
+		// Expect the unbounded threshold to be cleared, and the old reported thresholds forgotten
+		mock.EXPECT().UpdateThingShadow(&iotdataplane.UpdateThingShadowInput{
+			ThingName: aws.String(deviceID),
+			Payload:   []byte(`{"state":{"desired":{"thresholds":{"freezer":{"min":null,"max":-15}}},"reported":{"thresholds":{"freezer":null}}}}`),
+		}),
+		// Expect the updated shadow to be fetched
+		mock.EXPECT().GetThingShadowWithContext(gomock.Any(), gomock.Any()).Return(&iotdataplane.GetThingShadowOutput{Payload: []byte(currentShadowPayload)}, nil),
+	)
+	// Run the test
+	_, err := client.UpdateProbeThresholds(deviceID, "freezer", ProbeThresholds{Max: celsius(-15)})
+	assert.NoError(t, err)
+}
+
+func TestUpdateMetricRules(t *testing.T) {
+	const deviceID = "eb49b2e7-fd3a-4c03-b47f-b819281475e5"
+	// Create mocks
+	client, mock := createStubbedClient(t)
+	gomock.InOrder(
+		// Expect the rules to be replaced
+		mock.EXPECT().UpdateThingShadow(&iotdataplane.UpdateThingShadowInput{
+			ThingName: aws.String(deviceID),
+			Payload:   []byte(`{"state":{"reported":{"metricRules":[{"id":"damp","metric":"cellar","condition":"above","value":80,"for":1800}]}}}`),
+		}),
+		// Expect the updated shadow to be fetched
+		mock.EXPECT().GetThingShadowWithContext(gomock.Any(), gomock.Any()).Return(&iotdataplane.GetThingShadowOutput{Payload: []byte(currentShadowPayload)}, nil),
+	)
+	// Run the test
+	_, err := client.UpdateMetricRules(deviceID, []metrics.Rule{
+		{ID: "damp", Metric: "cellar", Condition: metrics.Above, Value: 80, For: 30 * time.Minute},
+	})
+	assert.NoError(t, err)
+}
+
+func TestUpdateRuleStates(t *testing.T) {
+	const deviceID = "eb49b2e7-fd3a-4c03-b47f-b819281475e5"
+	// Create mocks
+	client, mock := createStubbedClient(t)
+	// Expect only the given states to be reported
+	mock.EXPECT().UpdateThingShadow(&iotdataplane.UpdateThingShadowInput{
+		ThingName: aws.String(deviceID),
+		Payload:   []byte(`{"state":{"reported":{"ruleStates":{"damp":{"since":1584800000,"alerting":true},"dry":{"since":0,"alerting":false}}}}}`),
+	})
+	// Run the test
+	assert.NoError(t, client.UpdateRuleStates(deviceID, map[string]metrics.RuleState{
+		"damp": {Since: time.Unix(1584800000, 0), Alerting: true},
+		"dry":  {},
+	}))
+}
+
+func TestUpdateAppliance(t *testing.T) {
+	const deviceID = "eb49b2e7-fd3a-4c03-b47f-b819281475e5"
+	// Create mocks
+	client, mock := createStubbedClient(t)
+	gomock.InOrder(
+		// Expect the appliance to be set, with its usual limit
+		mock.EXPECT().UpdateThingShadow(&iotdataplane.UpdateThingShadowInput{
+			ThingName: aws.String(deviceID),
+			Payload:   []byte(`{"state":{"reported":{"appliance":{"type":"fridge","safeFor":0}}}}`),
+		}),
+		// Expect the updated shadow to be fetched
+		mock.EXPECT().GetThingShadowWithContext(gomock.Any(), gomock.Any()).Return(&iotdataplane.GetThingShadowOutput{Payload: []byte(currentShadowPayload)}, nil),
+	)
+	// Run the test
+	_, err := client.UpdateAppliance(deviceID, ApplianceShadow{Type: APPLIANCE_FRIDGE})
+	assert.NoError(t, err)
+}
+
+func TestUpdateOutage(t *testing.T) {
+	const deviceID = "eb49b2e7-fd3a-4c03-b47f-b819281475e5"
 	testParams := []struct {
-		deviceID    string
-		payload     string
-		transientID string
+		outage  OutageShadow
+		payload string
 	}{
-		{
-			deviceID:    "eb49b2e7-fd3a-4c03-b47f-b819281475e5",
-			payload:     `{"state":{"reported":{"connection":{"transientId":"9e9b59ac-b6b6-491b-8c55-f2d502f653b9"}}}}`,
-			transientID: "9e9b59ac-b6b6-491b-8c55-f2d502f653b9",
-		},
+		{outage: OutageShadow{Started: time.Unix(1584803414, 0), Advised: true}, payload: `{"state":{"reported":{"outage":{"started":1584803414,"advised":true}}}}`},
+		// The power came back
+		{outage: OutageShadow{}, payload: `{"state":{"reported":{"outage":{"started":0,"advised":false}}}}`},
 	}
-	// Iterate the tests
-	for i, params := range testParams {
-		log.Printf("Test iteration: %d", i)
-		// Create mock controller
-		ctrl := gomock.NewController(t)
-		// Create mock database client
-		mock := NewMockIoTDataPlaneAPI(ctrl)
-		// Create the unit under test
-		client := client{
-			dp: mock,
-		}
-		// Configure expectations
+	for _, params := range testParams {
+		// Create mocks
+		client, mock := createStubbedClient(t)
 		mock.EXPECT().UpdateThingShadow(&iotdataplane.UpdateThingShadowInput{
-			ThingName: aws.String(params.deviceID),
+			ThingName: aws.String(deviceID),
 			Payload:   []byte(params.payload),
 		})
 		// Run the test
-		err := client.UpdateConnectionTransientID(params.deviceID, params.transientID)
-		assert.Nil(t, err)
+		assert.NoError(t, client.UpdateOutage(deviceID, params.outage))
 	}
 }
 
+func TestUpdateMaintenance(t *testing.T) {
+	const deviceID = "eb49b2e7-fd3a-4c03-b47f-b819281475e5"
+	// Create mocks
+	client, mock := createStubbedClient(t)
+	gomock.InOrder(
+		// Expect the windows to be replaced
+		mock.EXPECT().UpdateThingShadow(&iotdataplane.UpdateThingShadowInput{
+			ThingName: aws.String(deviceID),
+			Payload:   []byte(`{"state":{"reported":{"maintenance":[{"id":"rewiring","start":1584867600,"duration":7200,"repeat":"weekly"}]}}}`),
+		}),
+		// Expect the updated shadow to be fetched
+		mock.EXPECT().GetThingShadowWithContext(gomock.Any(), gomock.Any()).Return(&iotdataplane.GetThingShadowOutput{Payload: []byte(currentShadowPayload)}, nil),
+	)
+	// Run the test
+	_, err := client.UpdateMaintenance(deviceID, []maintenance.Window{
+		{ID: "rewiring", Start: time.Unix(1584867600, 0), Duration: 2 * time.Hour, Repeat: maintenance.Weekly},
+	})
+	assert.NoError(t, err)
+}
+
 func TestRequestStatusUpdate(t *testing.T) {
 	// Create mocks
 	client, mock := createStubbedClient(t)
@@ -347,3 +715,7 @@ func createStubbedClient(t *testing.T) (Client, *MockIoTDataPlaneAPI) {
 	}
 	return &client, mock
 }
+
+func celsius(value float64) *float64 {
+	return &value
+}
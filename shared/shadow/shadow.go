@@ -3,10 +3,13 @@ package shadow
 import (
 	"encoding/json"
 	"fmt"
+	"log"
 	"strconv"
 	"time"
 
 	"github.com/briggysmalls/detectordag/shared"
+	"github.com/briggysmalls/detectordag/shared/maintenance"
+	"github.com/briggysmalls/detectordag/shared/metrics"
 )
 
 const (
@@ -14,6 +17,8 @@ const (
 	CONNECTION_STATUS_DISCONNECTED = "disconnected"
 	POWER_STATUS_ON                = "on"
 	POWER_STATUS_OFF               = "off"
+	APPLIANCE_FRIDGE               = "fridge"
+	APPLIANCE_FREEZER              = "freezer"
 )
 
 type Timestamp struct {
@@ -50,35 +55,594 @@ type ConnectionShadow struct {
 	Status      string
 	Updated     time.Time
 	TransientID string
+	// LastEvent is when the latest lifecycle event happened, even if it didn't change the status
+	LastEvent time.Time
+}
+
+// StabilityShadow summarises how reliable a device's connection has been recently
+type StabilityShadow struct {
+	Unstable    bool
+	Disconnects int
+	MeanSession time.Duration
+}
+
+// BatteryShadow is the state of the device's UPS battery
+type BatteryShadow struct {
+	// Percent is the remaining charge
+	Percent  int
+	Voltage  float64
+	Charging bool
+}
+
+// BatterySample is a reading of the battery's charge
+type BatterySample struct {
+	Percent int
+	Time    time.Time
+}
+
+// DischargeShadow is the history of the battery discharging during power cuts
+type DischargeShadow struct {
+	// Samples are readings from the latest discharge, oldest first
+	Samples []BatterySample
+	// Rate is the latest known discharge rate (percent per hour), or zero if it is unknown
+	Rate float64
+	// Alerted is set once the account has been warned the battery is low during the latest discharge
+	Alerted bool
+}
+
+// SignalShadow is the strength of the device's cellular signal (dBm)
+type SignalShadow struct {
+	RSSI int
+	RSRP int
+}
+
+// CellularSample is a reading of the device's cellular connection
+type CellularSample struct {
+	Time     time.Time
+	Signal   SignalShadow
+	DataUsed int64
+}
+
+// CellularShadow is the state of the device's cellular connection
+type CellularShadow struct {
+	// Signal is nil for devices that don't report it
+	Signal *SignalShadow
+	// DataUsed is how many bytes the device has used this month
+	DataUsed int64
+	// Samples are the latest readings, oldest first
+	Samples []CellularSample
+	// CapWarned is the month (e.g. '2020-03') the account was last warned about the data cap
+	CapWarned string
+}
+
+// ProbeReading is a reading from one of the device's temperature probes
+type ProbeReading struct {
+	Probe   string
+	Celsius float64
+}
+
+// TemperatureSample is a reading from a temperature probe
+type TemperatureSample struct {
+	Time    time.Time
+	Celsius float64
+}
+
+// ProbeThresholds are the temperatures a probe should stay between
+// A nil threshold is unbounded
+type ProbeThresholds struct {
+	Min *float64
+	Max *float64
+}
+
+// ProbeShadow is the history of a temperature probe
+type ProbeShadow struct {
+	// Samples are the latest readings, oldest first
+	Samples []TemperatureSample
+	// Alerting indicates the account has been told the probe is out of range
+	Alerting bool
+}
+
+// TemperatureShadow is the state of the device's temperature probes
+type TemperatureShadow struct {
+	// Readings are the latest readings the device reported
+	Readings []ProbeReading
+	// Probes are the history of each probe, by name
+	Probes map[string]ProbeShadow
+	// Thresholds are what the account has set for each probe, by name
+	Thresholds map[string]ProbeThresholds
+}
+
+// MetricsShadow holds the generic readings a device reports, and the rules about them
+type MetricsShadow struct {
+	// Readings are the latest valid readings, by metric name
+	Readings map[string]metrics.Reading
+	// Rules are what the account has set up for the device
+	Rules []metrics.Rule
+	// States are how each rule stands, by rule ID
+	States map[string]metrics.RuleState
+}
+
+// ApplianceShadow is the appliance the device is plugged in alongside, so we can advise on food safety
+type ApplianceShadow struct {
+	// Type is empty if the account hasn't set one
+	Type string
+	// SafeFor is how long food stays safe without power, or zero for the appliance's usual limit
+	SafeFor time.Duration
+}
+
+// OutageShadow is the power cut the device is in the middle of
+type OutageShadow struct {
+	// Started is zero if the power is on
+	Started time.Time
+	// Advised indicates the account has been told food may no longer be safe
+	Advised bool
+}
+
+// DeviceConfig is the configuration a device runs with
+// Zero values are unset, so the device uses its default
+type DeviceConfig struct {
+	// ReportInterval is how often the device reports its status
+	ReportInterval time.Duration
+	// StatusRequestTimeout is how long the device has to respond to a status request
+	StatusRequestTimeout time.Duration
+	// LowBatteryThreshold is the UPS charge (percent) below which the battery is considered low
+	LowBatteryThreshold int
+}
+
+// DeviceConfigUpdate is a change to the configuration we want a device to use
+// Nil fields are left as they are, and zero values are cleared, so the device goes back to its default
+type DeviceConfigUpdate struct {
+	ReportInterval       *time.Duration
+	StatusRequestTimeout *time.Duration
+	LowBatteryThreshold  *int
+}
+
+// ConfigShadow holds the configuration we want a device to use, and the one it reports using
+type ConfigShadow struct {
+	Desired  DeviceConfig
+	Reported DeviceConfig
+}
+
+// Delta gets the desired configuration the device is yet to apply
+func (c ConfigShadow) Delta() DeviceConfig {
+	var delta DeviceConfig
+	if c.Desired.ReportInterval != 0 && c.Desired.ReportInterval != c.Reported.ReportInterval {
+		delta.ReportInterval = c.Desired.ReportInterval
+	}
+	if c.Desired.StatusRequestTimeout != 0 && c.Desired.StatusRequestTimeout != c.Reported.StatusRequestTimeout {
+		delta.StatusRequestTimeout = c.Desired.StatusRequestTimeout
+	}
+	if c.Desired.LowBatteryThreshold != 0 && c.Desired.LowBatteryThreshold != c.Reported.LowBatteryThreshold {
+		delta.LowBatteryThreshold = c.Desired.LowBatteryThreshold
+	}
+	return delta
+}
+
+// Applied indicates whether the device has applied all the desired configuration
+func (c ConfigShadow) Applied() bool {
+	return c.Delta() == DeviceConfig{}
 }
 
 type Shadow struct {
-	Time       time.Time
-	Version    int
+	Time    time.Time
+	Version int
+	// Seen is when the device itself last reported anything (rather than us updating its shadow)
+	Seen       time.Time
 	Name       string
 	Connection ConnectionShadow
 	Power      PowerShadow
+	Stability  StabilityShadow
+	Config     ConfigShadow
+	// Battery is nil for devices that don't report their battery
+	Battery     *BatteryShadow
+	Discharge   DischargeShadow
+	Cellular    CellularShadow
+	Temperature TemperatureShadow
+	Metrics     MetricsShadow
+	Appliance   ApplianceShadow
+	Outage      OutageShadow
+	// Maintenance are the windows during which the device's notifications are held back
+	Maintenance []maintenance.Window
+}
+
+// ConfigSchema is the shadow representation of a DeviceConfig
+type ConfigSchema struct {
+	// Seconds
+	ReportInterval int `json:"reportInterval,omitempty"`
+	// Seconds
+	StatusRequestTimeout int `json:"statusRequestTimeout,omitempty"`
+	// Percent
+	LowBatteryThreshold int `json:"lowBatteryThreshold,omitempty"`
+}
+
+// ConfigUpdateSchema is the shadow representation of a DeviceConfigUpdate
+type ConfigUpdateSchema struct {
+	ReportInterval       *clearable `json:"reportInterval,omitempty"`
+	StatusRequestTimeout *clearable `json:"statusRequestTimeout,omitempty"`
+	LowBatteryThreshold  *clearable `json:"lowBatteryThreshold,omitempty"`
+}
+
+// clearable is a shadow value that is deleted from the shadow when zero
+type clearable int
+
+func (c clearable) MarshalJSON() ([]byte, error) {
+	if c == 0 {
+		// The shadow service deletes fields that are set to null
+		return []byte("null"), nil
+	}
+	return json.Marshal(int(c))
+}
+
+// NewConfigUpdateSchema converts a DeviceConfigUpdate into its shadow representation
+func NewConfigUpdateSchema(update DeviceConfigUpdate) ConfigUpdateSchema {
+	var schema ConfigUpdateSchema
+	if update.ReportInterval != nil {
+		seconds := clearable(update.ReportInterval.Seconds())
+		schema.ReportInterval = &seconds
+	}
+	if update.StatusRequestTimeout != nil {
+		seconds := clearable(update.StatusRequestTimeout.Seconds())
+		schema.StatusRequestTimeout = &seconds
+	}
+	if update.LowBatteryThreshold != nil {
+		percent := clearable(*update.LowBatteryThreshold)
+		schema.LowBatteryThreshold = &percent
+	}
+	return schema
+}
+
+// Extract converts the shadow representation into a DeviceConfig
+func (c ConfigSchema) Extract() DeviceConfig {
+	return DeviceConfig{
+		ReportInterval:       time.Duration(c.ReportInterval) * time.Second,
+		StatusRequestTimeout: time.Duration(c.StatusRequestTimeout) * time.Second,
+		LowBatteryThreshold:  c.LowBatteryThreshold,
+	}
 }
 
 type DeviceShadowSchema struct {
 	Timestamp Timestamp
 	Version   int
 	State     struct {
+		Desired struct {
+			Config ConfigSchema
+			// Thresholds are set by the account, so live alongside the config it asks for
+			Thresholds map[string]ThresholdsSchema
+		}
 		Reported struct {
 			Name       string
 			Connection struct {
 				Current     string    `validate:"required,eq=connected|eq=disconnected"`
 				Updated     Timestamp `validate:"required"`
 				TransientID string    `validate:"required,uuid"`
+				LastEvent   Timestamp
+			}
+			Status    string `validate:"required,eq=on|eq=off"`
+			Stability struct {
+				Unstable    bool
+				Disconnects int
+				// Seconds
+				MeanSession int
 			}
-			Status string `validate:"required,eq=on|eq=off"`
+			Config  ConfigSchema
+			Battery *struct {
+				Percent  int     `validate:"min=0,max=100"`
+				Voltage  float64 `validate:"min=0"`
+				Charging bool
+			}
+			Discharge DischargeSchema
+			Signal    *struct {
+				RSSI int `validate:"min=-120,max=0"`
+				RSRP int `validate:"min=-140,max=-44"`
+			}
+			// Bytes used since the start of the month
+			DataUsedBytes int64 `validate:"min=0"`
+			Cellular      CellularSchema
+			Temperatures  []struct {
+				Probe   string `validate:"required,max=32"`
+				Celsius float64
+			} `validate:"dive"`
+			Probes map[string]ProbeSchema
+			// Thresholds used to be stored here, and are still read until they are next set
+			Thresholds map[string]ThresholdsSchema
+			// Readings are checked against the metrics registry, rather than here
+			Metrics     map[string]MetricSchema
+			MetricRules []MetricRuleSchema `validate:"dive"`
+			RuleStates  map[string]RuleStateSchema
+			Appliance   ApplianceSchema
+			Outage      OutageSchema
+			Maintenance []MaintenanceSchema `validate:"dive"`
 		}
 	}
 	Metadata struct {
 		Reported struct {
 			Status MetadataEntry `validate:"required"`
+			// Entries for the other fields only the device reports, so we know when it was last seen
+			Battery struct {
+				Percent MetadataEntry
+			}
+			Signal struct {
+				RSSI MetadataEntry
+			}
+			DataUsedBytes MetadataEntry
+		}
+	}
+}
+
+// seen gets when the device last reported any of the fields only it reports
+func (c *DeviceShadowSchema) seen() time.Time {
+	reported := c.Metadata.Reported
+	seen := reported.Status.Timestamp.Time
+	for _, entry := range []MetadataEntry{reported.Battery.Percent, reported.Signal.RSSI, reported.DataUsedBytes} {
+		if entry.Timestamp.After(seen) {
+			seen = entry.Timestamp.Time
 		}
 	}
+	return seen
+}
+
+// ApplianceSchema is the shadow representation of an ApplianceShadow
+type ApplianceSchema struct {
+	Type string `json:"type" validate:"omitempty,eq=fridge|eq=freezer"`
+	// Seconds
+	SafeFor int `json:"safeFor" validate:"min=0"`
+}
+
+// NewApplianceSchema converts an ApplianceShadow into its shadow representation
+func NewApplianceSchema(appliance ApplianceShadow) ApplianceSchema {
+	return ApplianceSchema{Type: appliance.Type, SafeFor: int(appliance.SafeFor.Seconds())}
+}
+
+// Extract converts the shadow representation into an ApplianceShadow
+func (a ApplianceSchema) Extract() ApplianceShadow {
+	return ApplianceShadow{Type: a.Type, SafeFor: time.Duration(a.SafeFor) * time.Second}
+}
+
+// OutageSchema is the shadow representation of an OutageShadow
+type OutageSchema struct {
+	// Unix time, or zero if the power is on
+	Started int64 `json:"started"`
+	Advised bool  `json:"advised"`
+}
+
+// NewOutageSchema converts an OutageShadow into its shadow representation
+func NewOutageSchema(outage OutageShadow) OutageSchema {
+	schema := OutageSchema{Advised: outage.Advised}
+	if !outage.Started.IsZero() {
+		schema.Started = outage.Started.Unix()
+	}
+	return schema
+}
+
+// Extract converts the shadow representation into an OutageShadow
+func (o OutageSchema) Extract() OutageShadow {
+	outage := OutageShadow{Advised: o.Advised}
+	if o.Started != 0 {
+		outage.Started = time.Unix(o.Started, 0)
+	}
+	return outage
+}
+
+// MaintenanceSchema is the shadow representation of a maintenance.Window
+type MaintenanceSchema struct {
+	ID string `json:"id" validate:"required"`
+	// Unix time
+	Start int64 `json:"start"`
+	// Seconds
+	Duration int    `json:"duration" validate:"min=0"`
+	Repeat   string `json:"repeat" validate:"eq=once|eq=daily|eq=weekly"`
+}
+
+// NewMaintenanceSchema converts a maintenance.Window into its shadow representation
+func NewMaintenanceSchema(window maintenance.Window) MaintenanceSchema {
+	return MaintenanceSchema{
+		ID:       window.ID,
+		Start:    window.Start.Unix(),
+		Duration: int(window.Duration.Seconds()),
+		Repeat:   string(window.Repeat),
+	}
+}
+
+// Extract converts the shadow representation into a maintenance.Window
+func (m MaintenanceSchema) Extract() maintenance.Window {
+	return maintenance.Window{
+		ID:       m.ID,
+		Start:    time.Unix(m.Start, 0),
+		Duration: time.Duration(m.Duration) * time.Second,
+		Repeat:   maintenance.Repeat(m.Repeat),
+	}
+}
+
+// DischargeSchema is the shadow representation of a DischargeShadow
+type DischargeSchema struct {
+	Samples []SampleSchema `json:"samples"`
+	// Percent per hour
+	Rate    float64 `json:"rate"`
+	Alerted bool    `json:"alerted"`
+}
+
+// SampleSchema is the shadow representation of a BatterySample
+type SampleSchema struct {
+	Percent   int       `json:"percent"`
+	Timestamp Timestamp `json:"timestamp"`
+}
+
+// NewDischargeSchema converts a DischargeShadow into its shadow representation
+func NewDischargeSchema(discharge DischargeShadow) DischargeSchema {
+	schema := DischargeSchema{Samples: []SampleSchema{}, Rate: discharge.Rate, Alerted: discharge.Alerted}
+	for _, sample := range discharge.Samples {
+		schema.Samples = append(schema.Samples, SampleSchema{Percent: sample.Percent, Timestamp: Timestamp{sample.Time}})
+	}
+	return schema
+}
+
+// Extract converts the shadow representation into a DischargeShadow
+func (d DischargeSchema) Extract() DischargeShadow {
+	discharge := DischargeShadow{Rate: d.Rate, Alerted: d.Alerted}
+	for _, sample := range d.Samples {
+		discharge.Samples = append(discharge.Samples, BatterySample{Percent: sample.Percent, Time: sample.Timestamp.Time})
+	}
+	return discharge
+}
+
+// CellularSchema is the shadow representation of the history of a CellularShadow
+type CellularSchema struct {
+	Samples   []CellularSampleSchema `json:"samples"`
+	CapWarned string                 `json:"capWarned"`
+}
+
+// CellularSampleSchema is the shadow representation of a CellularSample
+type CellularSampleSchema struct {
+	Timestamp     Timestamp `json:"timestamp"`
+	RSSI          int       `json:"rssi"`
+	RSRP          int       `json:"rsrp"`
+	DataUsedBytes int64     `json:"dataUsedBytes"`
+}
+
+// NewCellularSchema converts the history of a CellularShadow into its shadow representation
+func NewCellularSchema(cellular CellularShadow) CellularSchema {
+	schema := CellularSchema{Samples: []CellularSampleSchema{}, CapWarned: cellular.CapWarned}
+	for _, sample := range cellular.Samples {
+		schema.Samples = append(schema.Samples, CellularSampleSchema{
+			Timestamp:     Timestamp{sample.Time},
+			RSSI:          sample.Signal.RSSI,
+			RSRP:          sample.Signal.RSRP,
+			DataUsedBytes: sample.DataUsed,
+		})
+	}
+	return schema
+}
+
+// Extract converts the shadow representation into the history of a CellularShadow
+func (c CellularSchema) Extract() CellularShadow {
+	cellular := CellularShadow{CapWarned: c.CapWarned}
+	for _, sample := range c.Samples {
+		cellular.Samples = append(cellular.Samples, CellularSample{
+			Time:     sample.Timestamp.Time,
+			Signal:   SignalShadow{RSSI: sample.RSSI, RSRP: sample.RSRP},
+			DataUsed: sample.DataUsedBytes,
+		})
+	}
+	return cellular
+}
+
+// ProbeSchema is the shadow representation of a ProbeShadow
+type ProbeSchema struct {
+	Samples  []TemperatureSampleSchema `json:"samples"`
+	Alerting bool                      `json:"alerting"`
+}
+
+// TemperatureSampleSchema is the shadow representation of a TemperatureSample
+type TemperatureSampleSchema struct {
+	Timestamp Timestamp `json:"timestamp"`
+	Celsius   float64   `json:"celsius"`
+}
+
+// ThresholdsSchema is the shadow representation of ProbeThresholds
+// Unbounded thresholds are written as null, so the shadow forgets them
+type ThresholdsSchema struct {
+	Min *float64 `json:"min"`
+	Max *float64 `json:"max"`
+}
+
+// NewProbeSchema converts a ProbeShadow into its shadow representation
+func NewProbeSchema(probe ProbeShadow) ProbeSchema {
+	schema := ProbeSchema{Samples: []TemperatureSampleSchema{}, Alerting: probe.Alerting}
+	for _, sample := range probe.Samples {
+		schema.Samples = append(schema.Samples, TemperatureSampleSchema{Timestamp: Timestamp{sample.Time}, Celsius: sample.Celsius})
+	}
+	return schema
+}
+
+// Extract converts the shadow representation into a ProbeShadow
+func (p ProbeSchema) Extract() ProbeShadow {
+	probe := ProbeShadow{Alerting: p.Alerting}
+	for _, sample := range p.Samples {
+		probe.Samples = append(probe.Samples, TemperatureSample{Time: sample.Timestamp.Time, Celsius: sample.Celsius})
+	}
+	return probe
+}
+
+// MetricSchema is the shadow representation of a metrics.Reading
+type MetricSchema struct {
+	Kind  string  `json:"kind"`
+	Value float64 `json:"value"`
+	Unit  string  `json:"unit"`
+}
+
+// MetricRuleSchema is the shadow representation of a metrics.Rule
+type MetricRuleSchema struct {
+	ID        string  `json:"id" validate:"required"`
+	Metric    string  `json:"metric" validate:"required"`
+	Condition string  `json:"condition" validate:"eq=above|eq=below"`
+	Value     float64 `json:"value"`
+	// Seconds
+	For int `json:"for" validate:"min=0"`
+}
+
+// RuleStateSchema is the shadow representation of a metrics.RuleState
+type RuleStateSchema struct {
+	// Seconds since the epoch, or zero if the condition doesn't hold
+	Since    int64 `json:"since"`
+	Alerting bool  `json:"alerting"`
+}
+
+// NewMetricRuleSchema converts a metrics.Rule into its shadow representation
+func NewMetricRuleSchema(rule metrics.Rule) MetricRuleSchema {
+	return MetricRuleSchema{
+		ID:        rule.ID,
+		Metric:    rule.Metric,
+		Condition: string(rule.Condition),
+		Value:     rule.Value,
+		For:       int(rule.For.Seconds()),
+	}
+}
+
+// Extract converts the shadow representation into a metrics.Rule
+func (r MetricRuleSchema) Extract() metrics.Rule {
+	return metrics.Rule{
+		ID:        r.ID,
+		Metric:    r.Metric,
+		Condition: metrics.Condition(r.Condition),
+		Value:     r.Value,
+		For:       time.Duration(r.For) * time.Second,
+	}
+}
+
+// NewRuleStateSchema converts a metrics.RuleState into its shadow representation
+func NewRuleStateSchema(state metrics.RuleState) RuleStateSchema {
+	schema := RuleStateSchema{Alerting: state.Alerting}
+	if !state.Since.IsZero() {
+		schema.Since = state.Since.Unix()
+	}
+	return schema
+}
+
+// Extract converts the shadow representation into a metrics.RuleState
+func (r RuleStateSchema) Extract() metrics.RuleState {
+	state := metrics.RuleState{Alerting: r.Alerting}
+	if r.Since != 0 {
+		state.Since = time.Unix(r.Since, 0)
+	}
+	return state
+}
+
+// ExtractReadings converts reported metrics into readings, leaving out any that are invalid
+// (a faulty sensor, or one we don't know about yet, shouldn't stop us monitoring the power)
+func ExtractReadings(reported map[string]MetricSchema) map[string]metrics.Reading {
+	var readings map[string]metrics.Reading
+	for name, metric := range reported {
+		reading := metrics.Reading{Kind: metric.Kind, Value: metric.Value, Unit: metric.Unit}
+		if err := metrics.Validate(reading); err != nil {
+			log.Printf("Ignoring metric '%s': %v", name, err)
+			continue
+		}
+		if readings == nil {
+			readings = make(map[string]metrics.Reading, len(reported))
+		}
+		readings[name] = reading
+	}
+	return readings
 }
 
 // Extract converts the information into a more user-friendly form
@@ -95,16 +659,73 @@ func (c *DeviceShadowSchema) Extract(payload []byte) (*Shadow, error) {
 	s := Shadow{
 		Time:    c.Timestamp.Time,
 		Version: c.Version,
+		Seen:    c.seen(),
 		Name:    c.State.Reported.Name,
 		Connection: ConnectionShadow{
 			Status:      c.State.Reported.Connection.Current,
 			Updated:     c.State.Reported.Connection.Updated.Time,
 			TransientID: c.State.Reported.Connection.TransientID,
+			LastEvent:   c.State.Reported.Connection.LastEvent.Time,
 		},
 		Power: PowerShadow{
 			Value:   c.State.Reported.Status,
 			Updated: c.Metadata.Reported.Status.Timestamp.Time,
 		},
+		Stability: StabilityShadow{
+			Unstable:    c.State.Reported.Stability.Unstable,
+			Disconnects: c.State.Reported.Stability.Disconnects,
+			MeanSession: time.Duration(c.State.Reported.Stability.MeanSession) * time.Second,
+		},
+		Config: ConfigShadow{
+			Desired:  c.State.Desired.Config.Extract(),
+			Reported: c.State.Reported.Config.Extract(),
+		},
+	}
+	s.Discharge = c.State.Reported.Discharge.Extract()
+	s.Cellular = c.State.Reported.Cellular.Extract()
+	s.Cellular.DataUsed = c.State.Reported.DataUsedBytes
+	if signal := c.State.Reported.Signal; signal != nil {
+		s.Cellular.Signal = &SignalShadow{RSSI: signal.RSSI, RSRP: signal.RSRP}
+	}
+	for _, reading := range c.State.Reported.Temperatures {
+		s.Temperature.Readings = append(s.Temperature.Readings, ProbeReading{Probe: reading.Probe, Celsius: reading.Celsius})
+	}
+	if len(c.State.Reported.Probes) > 0 {
+		s.Temperature.Probes = make(map[string]ProbeShadow, len(c.State.Reported.Probes))
+		for name, probe := range c.State.Reported.Probes {
+			s.Temperature.Probes[name] = probe.Extract()
+		}
+	}
+	if len(c.State.Desired.Thresholds) > 0 || len(c.State.Reported.Thresholds) > 0 {
+		s.Temperature.Thresholds = make(map[string]ProbeThresholds, len(c.State.Desired.Thresholds))
+		for name, thresholds := range c.State.Reported.Thresholds {
+			s.Temperature.Thresholds[name] = ProbeThresholds{Min: thresholds.Min, Max: thresholds.Max}
+		}
+		for name, thresholds := range c.State.Desired.Thresholds {
+			s.Temperature.Thresholds[name] = ProbeThresholds{Min: thresholds.Min, Max: thresholds.Max}
+		}
+	}
+	s.Metrics.Readings = ExtractReadings(c.State.Reported.Metrics)
+	for _, rule := range c.State.Reported.MetricRules {
+		s.Metrics.Rules = append(s.Metrics.Rules, rule.Extract())
+	}
+	if len(c.State.Reported.RuleStates) > 0 {
+		s.Metrics.States = make(map[string]metrics.RuleState, len(c.State.Reported.RuleStates))
+		for id, state := range c.State.Reported.RuleStates {
+			s.Metrics.States[id] = state.Extract()
+		}
+	}
+	s.Appliance = c.State.Reported.Appliance.Extract()
+	s.Outage = c.State.Reported.Outage.Extract()
+	for _, window := range c.State.Reported.Maintenance {
+		s.Maintenance = append(s.Maintenance, window.Extract())
+	}
+	if battery := c.State.Reported.Battery; battery != nil {
+		s.Battery = &BatteryShadow{
+			Percent:  battery.Percent,
+			Voltage:  battery.Voltage,
+			Charging: battery.Charging,
+		}
 	}
 	// Extract the fields we care about
 	return &s, nil
@@ -11,6 +11,14 @@ func TestInvalid(t *testing.T) {
 	testStrings := []string{
 		`{"metadata":{"reported":{"connection":{"timestamp":1584803417},"status":{"timestamp":1584803414}}},"state":{"reported":{"connection":"dummy","status":"off"}},"timestamp":1584810789,"version":50}`,
 		`{"metadata":{"reported":{"connection":{"timestamp":1584803417},"status":{"timestamp":1584803414}}},"state":{"reported":{"connection":"connected","status":"dummy"}},"timestamp":1584810789,"version":50}`,
+		`{"metadata":{"reported":{"status":{"timestamp":1584803414}}},"state":{"reported":{"connection":{"current":"connected","transientId":"f5dc1874-5ba1-4727-8366-35d8278ea3e4","updated":1584803417},"status":"off","battery":{"percent":120,"voltage":4.1}}},"timestamp":1584810789,"version":50}`,
+		`{"metadata":{"reported":{"status":{"timestamp":1584803414}}},"state":{"reported":{"connection":{"current":"connected","transientId":"f5dc1874-5ba1-4727-8366-35d8278ea3e4","updated":1584803417},"status":"off","battery":{"percent":50,"voltage":-1}}},"timestamp":1584810789,"version":50}`,
+		`{"metadata":{"reported":{"status":{"timestamp":1584803414}}},"state":{"reported":{"connection":{"current":"connected","transientId":"f5dc1874-5ba1-4727-8366-35d8278ea3e4","updated":1584803417},"status":"off","signal":{"rssi":10,"rsrp":-90}}},"timestamp":1584810789,"version":50}`,
+		`{"metadata":{"reported":{"status":{"timestamp":1584803414}}},"state":{"reported":{"connection":{"current":"connected","transientId":"f5dc1874-5ba1-4727-8366-35d8278ea3e4","updated":1584803417},"status":"off","dataUsedBytes":-1}},"timestamp":1584810789,"version":50}`,
+		`{"metadata":{"reported":{"status":{"timestamp":1584803414}}},"state":{"reported":{"connection":{"current":"connected","transientId":"f5dc1874-5ba1-4727-8366-35d8278ea3e4","updated":1584803417},"status":"off","temperatures":[{"celsius":-18}]}},"timestamp":1584810789,"version":50}`,
+		`{"metadata":{"reported":{"status":{"timestamp":1584803414}}},"state":{"reported":{"connection":{"current":"connected","transientId":"f5dc1874-5ba1-4727-8366-35d8278ea3e4","updated":1584803417},"status":"off","metricRules":[{"id":"damp","metric":"cellar","condition":"near","value":80}]}},"timestamp":1584810789,"version":50}`,
+		`{"metadata":{"reported":{"status":{"timestamp":1584803414}}},"state":{"reported":{"connection":{"current":"connected","transientId":"f5dc1874-5ba1-4727-8366-35d8278ea3e4","updated":1584803417},"status":"off","appliance":{"type":"oven"}}},"timestamp":1584810789,"version":50}`,
+		`{"metadata":{"reported":{"status":{"timestamp":1584803414}}},"state":{"reported":{"connection":{"current":"connected","transientId":"f5dc1874-5ba1-4727-8366-35d8278ea3e4","updated":1584803417},"status":"off","maintenance":[{"id":"rewiring","start":1584867600,"duration":7200,"repeat":"monthly"}]}},"timestamp":1584810789,"version":50}`,
 	}
 	for _, str := range testStrings {
 		// Unpack the payload
@@ -58,4 +66,63 @@ func TestSuccess(t *testing.T) {
 	// Assert the power values
 	assert.Equal(t, POWER_STATUS_OFF, shadow.Power.Value)
 	assert.Equal(t, time.Unix(1584803414, 0), shadow.Power.Updated)
+	assert.Equal(t, time.Unix(1584803414, 0), shadow.Seen)
+	// Devices needn't report their battery
+	assert.Nil(t, shadow.Battery)
+	// ...or their temperature
+	assert.Equal(t, TemperatureShadow{}, shadow.Temperature)
+}
+
+func TestSeen(t *testing.T) {
+	// The device reported its battery after the power last changed, and then we updated the connection
+	payload := `{
+	  "metadata": {"reported": {
+	    "status": {"timestamp": 1584803414},
+	    "battery": {"percent": {"timestamp": 1584806000}, "voltage": {"timestamp": 1584806000}},
+	    "connection": {"current": {"timestamp": 1584809000}}
+	  }},
+	  "state": {"reported": {
+	    "connection": {"current": "connected", "transientId": "f5dc1874-5ba1-4727-8366-35d8278ea3e4", "updated": 1584809000},
+	    "status": "on",
+	    "battery": {"percent": 80, "voltage": 4.1}
+	  }},
+	  "timestamp": 1584810789,
+	  "version": 50
+	}`
+	var shadowSchema DeviceShadowSchema
+	shadow, err := shadowSchema.Extract([]byte(payload))
+	assert.NoError(t, err)
+	// Assert only what the device reported counts
+	assert.Equal(t, time.Unix(1584806000, 0), shadow.Seen)
+}
+
+func TestConfigDelta(t *testing.T) {
+	testParams := []struct {
+		config  ConfigShadow
+		delta   DeviceConfig
+		applied bool
+	}{
+		{ // Nothing desired
+			config:  ConfigShadow{Reported: DeviceConfig{ReportInterval: time.Minute}},
+			applied: true,
+		},
+		{ // Desired config has been applied
+			config: ConfigShadow{
+				Desired:  DeviceConfig{ReportInterval: time.Minute},
+				Reported: DeviceConfig{ReportInterval: time.Minute, LowBatteryThreshold: 20},
+			},
+			applied: true,
+		},
+		{ // Only some desired config has been applied
+			config: ConfigShadow{
+				Desired:  DeviceConfig{ReportInterval: time.Minute, StatusRequestTimeout: 10 * time.Second, LowBatteryThreshold: 15},
+				Reported: DeviceConfig{ReportInterval: time.Minute, LowBatteryThreshold: 20},
+			},
+			delta: DeviceConfig{StatusRequestTimeout: 10 * time.Second, LowBatteryThreshold: 15},
+		},
+	}
+	for _, params := range testParams {
+		assert.Equal(t, params.delta, params.config.Delta())
+		assert.Equal(t, params.applied, params.config.Applied())
+	}
 }
@@ -1,8 +1,9 @@
 package sqs
 
 import (
-	"encoding/json"
 	"errors"
+	"fmt"
+	"math"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
@@ -12,25 +13,62 @@ import (
 	"github.com/briggysmalls/detectordag/shared"
 )
 
+const (
+	// The longest SQS will allow a message to be delayed
+	MaxDelay = 15 * time.Minute
+)
+
+const (
+	ConfirmationStageRequesting = "requesting"
+	ConfirmationStageGrace      = "grace"
+)
+
 type client struct {
 	sqs      sqsiface.SQSAPI
 	queueUrl string
 }
 
 type ConnectionEventPayload struct {
-	DeviceID string    `json:"deviceId" validate:"uuid"`
-	Status   string    `json:"type" validate:"eq=connected|eq=disconnected"`
-	Time     time.Time `json:"time" validate:"required"`
-	ID       string    `json:"id" validate:"uuid"`
+	DeviceID     string            `json:"deviceId" validate:"uuid"`
+	Status       string            `json:"type" validate:"eq=connected|eq=disconnected"`
+	Time         time.Time         `json:"time" validate:"required"`
+	ID           string            `json:"id" validate:"uuid"`
+	Confirmation ConfirmationState `json:"confirmation"`
+}
+
+// ConfirmationState records how far through confirming a disconnection we are
+type ConfirmationState struct {
+	Stage   string `json:"stage" validate:"omitempty,eq=requesting|eq=grace"`
+	Attempt int    `json:"attempt" validate:"min=0"`
 }
 
 func (d *ConnectionEventPayload) Validate() error {
 	return shared.Validate.Struct(d)
 }
 
-// Client is a client for sending status updates to the queue
+// BatchResponse is what a lambda handling a batch of messages returns, to report the ones that failed
+// Only the failed messages are retried (the event source must have ReportBatchItemFailures enabled)
+type BatchResponse struct {
+	BatchItemFailures []BatchItemFailure `json:"batchItemFailures"`
+}
+
+// BatchItemFailure identifies a message that failed to be handled
+type BatchItemFailure struct {
+	ItemIdentifier string `json:"itemIdentifier"`
+}
+
+// Client is a client for sending messages to the queue
 type Client interface {
-	QueueConnectionEvent(payload ConnectionEventPayload) error
+	Send(body []byte, delay time.Duration) error
+	Receive(max int, visibility time.Duration, wait time.Duration) ([]Message, error)
+	Delete(receiptHandle string) error
+}
+
+// Message is a message received from the queue
+type Message struct {
+	ID            string
+	Body          string
+	ReceiptHandle string
 }
 
 // NewSender gets a new Client
@@ -48,20 +86,53 @@ func New(sesh *session.Session, queueUrl string) (Client, error) {
 	return &client, nil
 }
 
-func (c *client) QueueConnectionEvent(payload ConnectionEventPayload) error {
-	// Ensure the struct is valid
-	if err := shared.Validate.Struct(payload); err != nil {
-		return err
+// Send sends a message, which will be received after the delay
+func (c *client) Send(body []byte, delay time.Duration) error {
+	// Ensure SQS will accept the delay
+	if delay < 0 || delay > MaxDelay {
+		return fmt.Errorf("Delay out of range: %s", delay)
 	}
-	// Marshal the payload to a string
-	body, err := json.Marshal(payload)
+	// Send the message (rounding up, so it is never received early)
+	_, err := c.sqs.SendMessage(&sqs.SendMessageInput{
+		MessageBody:  aws.String(string(body)),
+		QueueUrl:     aws.String(c.queueUrl),
+		DelaySeconds: aws.Int64(int64(math.Ceil(delay.Seconds()))),
+	})
+	return err
+}
+
+// Receive receives up to max messages, hiding them from other receivers for the visibility timeout
+// A non-zero wait long polls, so that no messages are missed and an empty result means the queue is empty
+func (c *client) Receive(max int, visibility time.Duration, wait time.Duration) ([]Message, error) {
+	output, err := c.sqs.ReceiveMessage(&sqs.ReceiveMessageInput{
+		QueueUrl:            aws.String(c.queueUrl),
+		MaxNumberOfMessages: aws.Int64(int64(max)),
+		VisibilityTimeout:   aws.Int64(int64(visibility.Seconds())),
+		WaitTimeSeconds:     aws.Int64(int64(wait.Seconds())),
+	})
 	if err != nil {
-		return err
+		return nil, fmt.Errorf("Failed to receive messages: %w", err)
+	}
+	// Convert the messages
+	messages := make([]Message, len(output.Messages))
+	for i, message := range output.Messages {
+		messages[i] = Message{
+			ID:            aws.StringValue(message.MessageId),
+			Body:          aws.StringValue(message.Body),
+			ReceiptHandle: aws.StringValue(message.ReceiptHandle),
+		}
 	}
-	// Send the message
-	_, err = c.sqs.SendMessage(&sqs.SendMessageInput{
-		MessageBody: aws.String(string(body)),
-		QueueUrl:    aws.String(c.queueUrl),
+	return messages, nil
+}
+
+// Delete removes a received message from the queue
+func (c *client) Delete(receiptHandle string) error {
+	_, err := c.sqs.DeleteMessage(&sqs.DeleteMessageInput{
+		QueueUrl:      aws.String(c.queueUrl),
+		ReceiptHandle: aws.String(receiptHandle),
 	})
-	return err
+	if err != nil {
+		return fmt.Errorf("Failed to delete message: %w", err)
+	}
+	return nil
 }
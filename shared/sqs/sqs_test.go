@@ -3,13 +3,13 @@ package sqs
 //go:generate go run github.com/golang/mock/mockgen -destination mock_sqs.go -package sqs github.com/aws/aws-sdk-go/service/sqs/sqsiface SQSAPI
 
 import (
-	"fmt"
 	"testing"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/sqs"
 	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
 )
 
 const (
@@ -17,28 +17,67 @@ const (
 )
 
 func TestSend(t *testing.T) {
+	testParams := []struct {
+		delay        time.Duration
+		delaySeconds int64
+	}{
+		{delay: 30 * time.Second, delaySeconds: 30},
+		{delay: 1500 * time.Millisecond, delaySeconds: 2},
+		{delay: MaxDelay, delaySeconds: 900},
+	}
+	for _, params := range testParams {
+		// Create the unit under test
+		client, isqs := createUnitAndMocks(t)
+		body := `{"hello":"world"}`
+		// Configure mock to expect a call
+		isqs.EXPECT().SendMessage(&sqs.SendMessageInput{
+			MessageBody:  aws.String(body),
+			QueueUrl:     aws.String(QueueUrl),
+			DelaySeconds: aws.Int64(params.delaySeconds),
+		}).Return(nil, nil)
+		// Make the call
+		assert.NoError(t, client.Send([]byte(body), params.delay))
+	}
+}
+
+func TestSendDelayOutOfRange(t *testing.T) {
+	// Create the unit under test
+	client, _ := createUnitAndMocks(t)
+	// Make the call with a delay SQS won't accept
+	assert.Error(t, client.Send([]byte("{}"), 16*time.Minute))
+	assert.Error(t, client.Send([]byte("{}"), -time.Second))
+}
+
+func TestReceive(t *testing.T) {
+	// Create the unit under test
+	client, isqs := createUnitAndMocks(t)
+	// Configure mock to return a message
+	isqs.EXPECT().ReceiveMessage(&sqs.ReceiveMessageInput{
+		QueueUrl:            aws.String(QueueUrl),
+		MaxNumberOfMessages: aws.Int64(10),
+		VisibilityTimeout:   aws.Int64(300),
+		WaitTimeSeconds:     aws.Int64(2),
+	}).Return(&sqs.ReceiveMessageOutput{
+		Messages: []*sqs.Message{
+			{MessageId: aws.String("id"), Body: aws.String("{}"), ReceiptHandle: aws.String("handle")},
+		},
+	}, nil)
+	// Make the call
+	messages, err := client.Receive(10, 5*time.Minute, 2*time.Second)
+	assert.NoError(t, err)
+	assert.Equal(t, []Message{{ID: "id", Body: "{}", ReceiptHandle: "handle"}}, messages)
+}
+
+func TestDelete(t *testing.T) {
 	// Create the unit under test
 	client, isqs := createUnitAndMocks(t)
-	// Set some test parameters
-	const (
-		deviceId = "573b0564-12f1-47fb-adf5-2d0906b39123"
-		eventID  = "186b8a97-bd3e-43fc-ade1-e1d4f66bcb18"
-		status   = "connected"
-	)
 	// Configure mock to expect a call
-	isqs.EXPECT().SendMessage(&sqs.SendMessageInput{
-		MessageBody: aws.String(fmt.Sprintf(
-			`{"deviceId":"%s","type":"%s","time":"1970-01-01T00:00:00Z","id":"%s"}`,
-			deviceId, status, eventID)),
-		QueueUrl: aws.String(QueueUrl),
+	isqs.EXPECT().DeleteMessage(&sqs.DeleteMessageInput{
+		QueueUrl:      aws.String(QueueUrl),
+		ReceiptHandle: aws.String("handle"),
 	}).Return(nil, nil)
 	// Make the call
-	client.QueueConnectionEvent(ConnectionEventPayload{
-		DeviceID: deviceId,
-		Time:     time.Unix(0, 0).UTC(),
-		Status:   status,
-		ID:       eventID,
-	})
+	assert.NoError(t, client.Delete("handle"))
 }
 
 func createUnitAndMocks(t *testing.T) (Client, *MockSQSAPI) {
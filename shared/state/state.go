@@ -0,0 +1,163 @@
+// Package state models the state of a device, combining its connection and power status
+package state
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/briggysmalls/detectordag/shared/shadow"
+)
+
+// State is an 'enum' of the states a device can be in
+type State int
+
+const (
+	// The device is connected and the power is on
+	On State = iota
+	// The device is connected and the power is off
+	Off
+	// The device is disconnected, and the power was on when last heard from
+	WasOn
+	// The device is disconnected, and the power was off when last heard from
+	WasOff
+)
+
+// Transition is an 'enum' of the ways a device's state can change
+type Transition int
+
+const (
+	PowerOn Transition = iota
+	PowerOff
+	Connected
+	Disconnected
+	// The device keeps disconnecting (the state itself is unchanged)
+	Unstable
+)
+
+// Event is emitted when a device makes a transition
+type Event struct {
+	From       State
+	To         State
+	Transition Transition
+}
+
+// ErrIllegalTransition indicates a transition that cannot happen
+var ErrIllegalTransition = errors.New("Illegal state transition")
+
+// The legal transitions from each state
+// Note: A disconnected device cannot report a change in power
+var transitions = map[State]map[Transition]State{
+	On:     {PowerOff: Off, Disconnected: WasOn, Unstable: On},
+	Off:    {PowerOn: On, Disconnected: WasOff, Unstable: Off},
+	WasOn:  {Connected: On, Unstable: WasOn},
+	WasOff: {Connected: Off, Unstable: WasOff},
+}
+
+// Lookup of states from the statuses stored in the shadow
+var stateLookup = map[string]map[string]State{
+	shadow.CONNECTION_STATUS_CONNECTED: {
+		shadow.POWER_STATUS_ON:  On,
+		shadow.POWER_STATUS_OFF: Off,
+	},
+	shadow.CONNECTION_STATUS_DISCONNECTED: {
+		shadow.POWER_STATUS_ON:  WasOn,
+		shadow.POWER_STATUS_OFF: WasOff,
+	},
+}
+
+var stateNames = map[State]string{
+	On:     "on",
+	Off:    "off",
+	WasOn:  "was-on",
+	WasOff: "was-off",
+}
+
+var transitionNames = map[Transition]string{
+	PowerOn:      "power on",
+	PowerOff:     "power off",
+	Connected:    "connected",
+	Disconnected: "disconnected",
+	Unstable:     "unstable",
+}
+
+// New gets the state from a connection and power status
+func New(connection, power string) (State, error) {
+	// First use connection
+	subMap, ok := stateLookup[connection]
+	if !ok {
+		return 0, fmt.Errorf("Bad connection value: '%s'", connection)
+	}
+	// Then the power
+	state, ok := subMap[power]
+	if !ok {
+		return 0, fmt.Errorf("Bad power value: '%s'", power)
+	}
+	return state, nil
+}
+
+// FromShadow gets the state recorded in a device shadow
+func FromShadow(shdw *shadow.Shadow) (State, error) {
+	return New(shdw.Connection.Status, shdw.Power.Value)
+}
+
+// PowerTransition gets the transition to a power status
+func PowerTransition(power string) (Transition, error) {
+	switch power {
+	case shadow.POWER_STATUS_ON:
+		return PowerOn, nil
+	case shadow.POWER_STATUS_OFF:
+		return PowerOff, nil
+	default:
+		return 0, fmt.Errorf("Bad power value: '%s'", power)
+	}
+}
+
+// ConnectionTransition gets the transition to a connection status
+func ConnectionTransition(connection string) (Transition, error) {
+	switch connection {
+	case shadow.CONNECTION_STATUS_CONNECTED:
+		return Connected, nil
+	case shadow.CONNECTION_STATUS_DISCONNECTED:
+		return Disconnected, nil
+	default:
+		return 0, fmt.Errorf("Bad connection value: '%s'", connection)
+	}
+}
+
+// Apply makes a transition from the state
+func (s State) Apply(t Transition) (Event, error) {
+	to, ok := transitions[s][t]
+	if !ok {
+		return Event{}, fmt.Errorf("%w: %s from %s", ErrIllegalTransition, t, s)
+	}
+	return Event{From: s, To: to, Transition: t}, nil
+}
+
+// Connected reports whether the device is connected in this state
+func (s State) Connected() bool {
+	return s == On || s == Off
+}
+
+// Connection gets the connection status of the state
+func (s State) Connection() string {
+	if s.Connected() {
+		return shadow.CONNECTION_STATUS_CONNECTED
+	}
+	return shadow.CONNECTION_STATUS_DISCONNECTED
+}
+
+// Power gets the (last known) power status of the state
+func (s State) Power() string {
+	if s == On || s == WasOn {
+		return shadow.POWER_STATUS_ON
+	}
+	return shadow.POWER_STATUS_OFF
+}
+
+func (s State) String() string {
+	return stateNames[s]
+}
+
+func (t Transition) String() string {
+	return transitionNames[t]
+}
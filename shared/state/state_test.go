@@ -0,0 +1,69 @@
+package state
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/briggysmalls/detectordag/shared/shadow"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNew(t *testing.T) {
+	testParams := []struct {
+		connection string
+		power      string
+		state      State
+		valid      bool
+	}{
+		{connection: shadow.CONNECTION_STATUS_CONNECTED, power: shadow.POWER_STATUS_ON, state: On, valid: true},
+		{connection: shadow.CONNECTION_STATUS_CONNECTED, power: shadow.POWER_STATUS_OFF, state: Off, valid: true},
+		{connection: shadow.CONNECTION_STATUS_DISCONNECTED, power: shadow.POWER_STATUS_ON, state: WasOn, valid: true},
+		{connection: shadow.CONNECTION_STATUS_DISCONNECTED, power: shadow.POWER_STATUS_OFF, state: WasOff, valid: true},
+		{connection: "confused", power: shadow.POWER_STATUS_OFF},
+		{connection: shadow.CONNECTION_STATUS_CONNECTED, power: "sort of"},
+	}
+	for _, params := range testParams {
+		state, err := New(params.connection, params.power)
+		if !params.valid {
+			assert.Error(t, err)
+			continue
+		}
+		assert.NoError(t, err)
+		assert.Equal(t, params.state, state)
+		// Assert the state converts back
+		assert.Equal(t, params.connection, state.Connection())
+		assert.Equal(t, params.power, state.Power())
+	}
+}
+
+func TestApply(t *testing.T) {
+	testParams := []struct {
+		from       State
+		transition Transition
+		to         State
+		legal      bool
+	}{
+		{from: On, transition: PowerOff, to: Off, legal: true},
+		{from: Off, transition: PowerOn, to: On, legal: true},
+		{from: On, transition: Disconnected, to: WasOn, legal: true},
+		{from: Off, transition: Disconnected, to: WasOff, legal: true},
+		{from: WasOn, transition: Connected, to: On, legal: true},
+		{from: WasOff, transition: Connected, to: Off, legal: true},
+		{from: WasOff, transition: Unstable, to: WasOff, legal: true},
+		// Nothing changes
+		{from: On, transition: PowerOn},
+		{from: On, transition: Connected},
+		{from: WasOn, transition: Disconnected},
+		// Disconnected devices can't tell us about power
+		{from: WasOn, transition: PowerOff},
+	}
+	for _, params := range testParams {
+		event, err := params.from.Apply(params.transition)
+		if !params.legal {
+			assert.True(t, errors.Is(err, ErrIllegalTransition))
+			continue
+		}
+		assert.NoError(t, err)
+		assert.Equal(t, Event{From: params.from, To: params.to, Transition: params.transition}, event)
+	}
+}
@@ -0,0 +1,94 @@
+// Package telemetry holds what the lambdas that handle device telemetry have in common
+package telemetry
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ses"
+	"github.com/briggysmalls/detectordag/shared"
+	"github.com/briggysmalls/detectordag/shared/database"
+	"github.com/briggysmalls/detectordag/shared/email"
+	"github.com/briggysmalls/detectordag/shared/iot"
+	"github.com/briggysmalls/detectordag/shared/shadow"
+)
+
+const (
+	senderEnvVar           = "SENDER_EMAIL"
+	templateLocationEnvVar = "TEMPLATE_LOCATION"
+)
+
+// Clients are the services used to handle device telemetry
+type Clients struct {
+	DB      database.Client
+	IoT     iot.Client
+	Shadow  shadow.Client
+	Emailer email.Emailer
+}
+
+// NewClients creates the clients, with the emailer configured from the lambda's environment
+func NewClients(sesh *session.Session) (*Clients, error) {
+	// Create a database client
+	db, err := database.New(sesh)
+	if err != nil {
+		return nil, err
+	}
+	// Create an IOT client
+	iotClient, err := iot.New(sesh)
+	if err != nil {
+		return nil, err
+	}
+	// Create a new shadow client
+	shadowClient, err := shadow.New(sesh)
+	if err != nil {
+		return nil, err
+	}
+	// Get the email sender
+	sender := os.Getenv(senderEnvVar)
+	if sender == "" {
+		return nil, fmt.Errorf("Env var '%s' unset", senderEnvVar)
+	}
+	// Create a source for the email templates
+	templates, err := email.NewTemplateSource(sesh, os.Getenv(templateLocationEnvVar))
+	if err != nil {
+		return nil, err
+	}
+	// Create a new session just for emailing (there is no emailing service in eu-west-2)
+	emailSesh := shared.CreateSession(aws.Config{Region: aws.String("eu-west-1")})
+	// Create a new email client
+	emailClient, err := email.NewEmailer(ses.New(emailSesh), sender, templates)
+	if err != nil {
+		return nil, err
+	}
+	return &Clients{
+		DB:      db,
+		IoT:     iotClient,
+		Shadow:  shadowClient,
+		Emailer: emailClient,
+	}, nil
+}
+
+// Account gets the account that owns a device
+func (c *Clients) Account(deviceID string) (*database.Account, error) {
+	device, err := c.IoT.GetThing(deviceID)
+	if err != nil {
+		return nil, shared.LogErrorAndReturn(err)
+	}
+	account, err := c.DB.GetAccountById(device.AccountId)
+	if err != nil {
+		return nil, shared.LogErrorAndReturn(err)
+	}
+	return account, nil
+}
+
+// NewContext gets the details common to every notification about a device
+func NewContext(shdw *shadow.Shadow, account *database.Account, at time.Time) email.ContextData {
+	return email.ContextData{
+		DeviceName: shdw.Name,
+		Time:       at,
+		Branding:   email.Branding(account.Branding),
+	}
+}
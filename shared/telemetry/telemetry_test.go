@@ -0,0 +1,66 @@
+package telemetry
+
+//go:generate go run github.com/golang/mock/mockgen -destination mock_db.go -package telemetry -mock_names Client=MockDBClient github.com/briggysmalls/detectordag/shared/database Client
+//go:generate go run github.com/golang/mock/mockgen -destination mock_iot.go -package telemetry -mock_names Client=MockIoTClient github.com/briggysmalls/detectordag/shared/iot Client
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/briggysmalls/detectordag/shared/database"
+	"github.com/briggysmalls/detectordag/shared/email"
+	"github.com/briggysmalls/detectordag/shared/iot"
+	"github.com/briggysmalls/detectordag/shared/shadow"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	deviceID  = "792ac520-0733-4ffe-8137-8aba3ca446d7"
+	accountID = "c6d62b30-00ac-49c4-9268-88559a46889f"
+)
+
+func TestAccount(t *testing.T) {
+	errUnknownDevice := errors.New("Unknown device")
+	testParams := []struct {
+		deviceErr error
+		account   *database.Account
+	}{
+		{account: &database.Account{AccountId: accountID, Emails: []string{"owner@example.com"}}},
+		// The device isn't known
+		{deviceErr: errUnknownDevice},
+	}
+	for _, params := range testParams {
+		// Create the clients
+		ctrl := gomock.NewController(t)
+		db := NewMockDBClient(ctrl)
+		iotClient := NewMockIoTClient(ctrl)
+		clients := &Clients{DB: db, IoT: iotClient}
+		// Expect the device to be looked up, then its account
+		if params.deviceErr != nil {
+			iotClient.EXPECT().GetThing(deviceID).Return(nil, params.deviceErr)
+		} else {
+			iotClient.EXPECT().GetThing(deviceID).Return(&iot.Device{DeviceId: deviceID, AccountId: accountID}, nil)
+			db.EXPECT().GetAccountById(accountID).Return(params.account, nil)
+		}
+		// Run the test
+		account, err := clients.Account(deviceID)
+		if params.deviceErr != nil {
+			assert.True(t, errors.Is(err, params.deviceErr), "%v", err)
+			continue
+		}
+		assert.NoError(t, err)
+		assert.Equal(t, params.account, account)
+	}
+}
+
+func TestNewContext(t *testing.T) {
+	at := time.Unix(1586952000, 0)
+	context := NewContext(
+		&shadow.Shadow{Name: "My Dag"},
+		&database.Account{Branding: database.Branding{HeaderColour: "#123456"}},
+		at,
+	)
+	assert.Equal(t, email.ContextData{DeviceName: "My Dag", Time: at, Branding: email.Branding{HeaderColour: "#123456"}}, context)
+}
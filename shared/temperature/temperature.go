@@ -0,0 +1,41 @@
+// Package temperature keeps track of the readings of devices' temperature probes
+package temperature
+
+import (
+	"github.com/briggysmalls/detectordag/shared/shadow"
+)
+
+// MaxSamples is how many readings are kept in each probe's history
+const MaxSamples = 96
+
+// Breach is how a reading is out of range
+type Breach int
+
+const (
+	// The reading is within range
+	None Breach = iota
+	// The reading is below the probe's minimum
+	Below
+	// The reading is above the probe's maximum
+	Above
+)
+
+// Record adds a reading to the probe's history, forgetting the oldest if necessary
+func Record(probe shadow.ProbeShadow, sample shadow.TemperatureSample) shadow.ProbeShadow {
+	probe.Samples = append(probe.Samples, sample)
+	if len(probe.Samples) > MaxSamples {
+		probe.Samples = probe.Samples[len(probe.Samples)-MaxSamples:]
+	}
+	return probe
+}
+
+// Check gets whether a reading is out of range, and the threshold it is past
+func Check(thresholds shadow.ProbeThresholds, celsius float64) (Breach, float64) {
+	if thresholds.Min != nil && celsius < *thresholds.Min {
+		return Below, *thresholds.Min
+	}
+	if thresholds.Max != nil && celsius > *thresholds.Max {
+		return Above, *thresholds.Max
+	}
+	return None, 0
+}
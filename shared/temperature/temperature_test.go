@@ -0,0 +1,54 @@
+package temperature
+
+import (
+	"testing"
+	"time"
+
+	"github.com/briggysmalls/detectordag/shared/shadow"
+	"github.com/stretchr/testify/assert"
+)
+
+var start = time.Unix(1584800000, 0)
+
+func celsius(value float64) *float64 {
+	return &value
+}
+
+func TestRecord(t *testing.T) {
+	// Record a few readings
+	var probe shadow.ProbeShadow
+	for i := 0; i < MaxSamples+5; i++ {
+		probe = Record(probe, shadow.TemperatureSample{Time: start.Add(time.Duration(i) * time.Minute), Celsius: float64(i)})
+	}
+	// Check the oldest were forgotten
+	assert.Len(t, probe.Samples, MaxSamples)
+	assert.Equal(t, 5.0, probe.Samples[0].Celsius)
+	assert.Equal(t, float64(MaxSamples+4), probe.Samples[len(probe.Samples)-1].Celsius)
+}
+
+func TestRecordKeepsAlerting(t *testing.T) {
+	probe := Record(shadow.ProbeShadow{Alerting: true}, shadow.TemperatureSample{Time: start, Celsius: -5})
+	assert.True(t, probe.Alerting)
+}
+
+func TestCheck(t *testing.T) {
+	testParams := []struct {
+		thresholds shadow.ProbeThresholds
+		celsius    float64
+		breach     Breach
+		limit      float64
+	}{
+		{thresholds: shadow.ProbeThresholds{Max: celsius(-15)}, celsius: -18},
+		{thresholds: shadow.ProbeThresholds{Max: celsius(-15)}, celsius: -12, breach: Above, limit: -15},
+		{thresholds: shadow.ProbeThresholds{Min: celsius(0), Max: celsius(5)}, celsius: -1, breach: Below, limit: 0},
+		// The limit itself is fine
+		{thresholds: shadow.ProbeThresholds{Min: celsius(0), Max: celsius(5)}, celsius: 5},
+		// Unbounded
+		{celsius: 100},
+	}
+	for _, params := range testParams {
+		breach, limit := Check(params.thresholds, params.celsius)
+		assert.Equal(t, params.breach, breach)
+		assert.Equal(t, params.limit, limit)
+	}
+}
@@ -0,0 +1,116 @@
+package app
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/briggysmalls/detectordag/shared"
+	"github.com/briggysmalls/detectordag/shared/email"
+	"github.com/briggysmalls/detectordag/shared/shadow"
+	"github.com/briggysmalls/detectordag/shared/state"
+	"github.com/briggysmalls/detectordag/shared/telemetry"
+	"github.com/briggysmalls/detectordag/shared/temperature"
+)
+
+// TemperatureUpdatedEvent is sent by the 'TemperatureReported' IoT rule
+type TemperatureUpdatedEvent struct {
+	DeviceId     string `validate:"required"`
+	Timestamp    int64  `validate:"required"`
+	Temperatures []struct {
+		Probe   string `validate:"required"`
+		Celsius float64
+	} `validate:"required,dive"`
+}
+
+type app struct {
+	*telemetry.Clients
+}
+
+type App interface {
+	HandleRequest(ctx context.Context, event TemperatureUpdatedEvent) error
+}
+
+// New gets an App that records devices' temperature probes, and warns accounts when they are out of range
+func New(clients *telemetry.Clients) App {
+	return &app{Clients: clients}
+}
+
+// HandleRequest handles a lambda call
+func (a *app) HandleRequest(ctx context.Context, event TemperatureUpdatedEvent) error {
+	// Print the event
+	log.Printf("%v\n", event)
+	// Validate the event
+	if err := shared.Validate.Struct(event); err != nil {
+		return err
+	}
+	// Get the device shadow
+	shdw, err := a.Shadow.Get(event.DeviceId)
+	if err != nil {
+		return err
+	}
+	// Add each reading to its probe's history, and check it is in range
+	at := time.Unix(event.Timestamp, 0)
+	probes := make(map[string]shadow.ProbeShadow, len(event.Temperatures))
+	var alerts []email.TemperatureData
+	for _, reading := range event.Temperatures {
+		probe := temperature.Record(shdw.Temperature.Probes[reading.Probe], shadow.TemperatureSample{Time: at, Celsius: reading.Celsius})
+		breach, limit := temperature.Check(shdw.Temperature.Thresholds[reading.Probe], reading.Celsius)
+		switch {
+		case breach == temperature.None:
+			probe.Alerting = false
+		case !probe.Alerting:
+			// Only alert as the probe goes out of range, rather than on every reading after
+			log.Printf("Probe '%s' of device '%s' is out of range: %.1f", reading.Probe, event.DeviceId, reading.Celsius)
+			alerts = append(alerts, email.TemperatureData{
+				Probe:   reading.Probe,
+				Celsius: reading.Celsius,
+				Limit:   limit,
+				Above:   breach == temperature.Above,
+			})
+			probe.Alerting = true
+		}
+		probes[reading.Probe] = probe
+	}
+	// Warn the account
+	var alertErr error
+	if len(alerts) > 0 {
+		if alertErr = a.alert(event.DeviceId, shdw, alerts, at); alertErr != nil {
+			// Try again with the next reading
+			for _, alert := range alerts {
+				probe := probes[alert.Probe]
+				probe.Alerting = false
+				probes[alert.Probe] = probe
+			}
+		}
+	}
+	// Record the history, even if we failed to alert
+	if err := a.Shadow.UpdateTemperatureHistory(event.DeviceId, probes); err != nil {
+		return err
+	}
+	return alertErr
+}
+
+// alert emails the account about probes that have gone out of range
+func (a *app) alert(deviceID string, shdw *shadow.Shadow, alerts []email.TemperatureData, at time.Time) error {
+	// Get the account
+	account, err := a.Account(deviceID)
+	if err != nil {
+		return err
+	}
+	// We are connected if we've been given a temperature update
+	current, err := state.New(shadow.CONNECTION_STATUS_CONNECTED, shdw.Power.Value)
+	if err != nil {
+		return err
+	}
+	// Send a 'temperature alert' email for each probe
+	log.Printf("Send emails to: %s", account.Emails)
+	for i := range alerts {
+		update := telemetry.NewContext(shdw, account, at)
+		update.Temperature = &alerts[i]
+		if err := a.Emailer.SendAlert(account.Emails, email.TemperatureAlert, current, update); err != nil {
+			return shared.LogErrorAndReturn(err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,161 @@
+package app
+
+//go:generate go run github.com/golang/mock/mockgen -destination mock_db.go -package app -mock_names Client=MockDBClient github.com/briggysmalls/detectordag/shared/database Client
+//go:generate go run github.com/golang/mock/mockgen -destination mock_iot.go -package app -mock_names Client=MockIoTClient github.com/briggysmalls/detectordag/shared/iot Client
+//go:generate go run github.com/golang/mock/mockgen -destination mock_shadow.go -package app -mock_names Client=MockShadowClient github.com/briggysmalls/detectordag/shared/shadow Client
+//go:generate go run github.com/golang/mock/mockgen -destination mock_email.go -package app github.com/briggysmalls/detectordag/shared/email Emailer
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/briggysmalls/detectordag/shared/database"
+	"github.com/briggysmalls/detectordag/shared/email"
+	"github.com/briggysmalls/detectordag/shared/iot"
+	"github.com/briggysmalls/detectordag/shared/shadow"
+	"github.com/briggysmalls/detectordag/shared/state"
+	"github.com/briggysmalls/detectordag/shared/telemetry"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	deviceID  = "792ac520-0733-4ffe-8137-8aba3ca446d7"
+	accountID = "c6d62b30-00ac-49c4-9268-88559a46889f"
+	timestamp = 1586952000
+)
+
+type mocks struct {
+	db      *MockDBClient
+	iot     *MockIoTClient
+	shadow  *MockShadowClient
+	emailer *MockEmailer
+}
+
+func TestHistoryRecorded(t *testing.T) {
+	// Create app under test
+	app, m := getStubbedApp(t)
+	// Return a shadow with some history
+	previous := shadow.TemperatureSample{Time: time.Unix(timestamp-600, 0), Celsius: -19}
+	m.shadow.EXPECT().Get(deviceID).Return(&shadow.Shadow{Temperature: shadow.TemperatureShadow{
+		Probes: map[string]shadow.ProbeShadow{"freezer": {Samples: []shadow.TemperatureSample{previous}}},
+	}}, nil)
+	// Expect the readings to be added
+	m.shadow.EXPECT().UpdateTemperatureHistory(deviceID, map[string]shadow.ProbeShadow{
+		"freezer": {Samples: []shadow.TemperatureSample{previous, {Time: time.Unix(timestamp, 0), Celsius: -18}}},
+		"fridge":  {Samples: []shadow.TemperatureSample{{Time: time.Unix(timestamp, 0), Celsius: 4}}},
+	})
+	// Run the test
+	assert.NoError(t, app.HandleRequest(nil, createEvent(-18, 4)))
+}
+
+func TestTemperatureAlert(t *testing.T) {
+	testParams := []struct {
+		freezer  float64
+		alerting bool
+		alert    *email.TemperatureData
+		expected bool
+	}{
+		// Going out of range
+		{freezer: -12, alert: &email.TemperatureData{Probe: "freezer", Celsius: -12, Limit: -15, Above: true}, expected: true},
+		// Already alerted
+		{freezer: -10, alerting: true, expected: true},
+		// Back in range
+		{freezer: -18, alerting: true, expected: false},
+	}
+	for _, params := range testParams {
+		// Create app under test
+		app, m := getStubbedApp(t)
+		max := -15.0
+		shdw := &shadow.Shadow{
+			Name:  "My Dag",
+			Power: shadow.PowerShadow{Value: shadow.POWER_STATUS_OFF},
+			Temperature: shadow.TemperatureShadow{
+				Probes:     map[string]shadow.ProbeShadow{"freezer": {Alerting: params.alerting}},
+				Thresholds: map[string]shadow.ProbeThresholds{"freezer": {Max: &max}},
+			},
+		}
+		m.shadow.EXPECT().Get(deviceID).Return(shdw, nil)
+		if params.alert != nil {
+			// Expect the account to be warned
+			m.iot.EXPECT().GetThing(deviceID).Return(&iot.Device{DeviceId: deviceID, AccountId: accountID}, nil)
+			m.db.EXPECT().GetAccountById(accountID).Return(&database.Account{AccountId: accountID, Emails: []string{"owner@example.com"}}, nil)
+			m.emailer.EXPECT().SendAlert(
+				[]string{"owner@example.com"},
+				email.TemperatureAlert,
+				state.Off,
+				email.ContextData{DeviceName: "My Dag", Time: time.Unix(timestamp, 0), Temperature: params.alert},
+			)
+		}
+		// Expect the alert to be remembered
+		m.shadow.EXPECT().UpdateTemperatureHistory(deviceID, gomock.Any()).Do(func(id string, probes map[string]shadow.ProbeShadow) {
+			assert.Equal(t, params.expected, probes["freezer"].Alerting)
+			assert.False(t, probes["fridge"].Alerting)
+		})
+		// Run the test
+		assert.NoError(t, app.HandleRequest(nil, createEvent(params.freezer, 4)))
+	}
+}
+
+func TestAlertFailed(t *testing.T) {
+	// Create app under test
+	app, m := getStubbedApp(t)
+	min := 0.0
+	m.shadow.EXPECT().Get(deviceID).Return(&shadow.Shadow{Temperature: shadow.TemperatureShadow{
+		Thresholds: map[string]shadow.ProbeThresholds{"fridge": {Min: &min}},
+	}}, nil)
+	// Fail to get the account
+	m.iot.EXPECT().GetThing(deviceID).Return(nil, errors.New("Oops"))
+	// Assert the history is still recorded, ready to alert again
+	m.shadow.EXPECT().UpdateTemperatureHistory(deviceID, gomock.Any()).Do(func(id string, probes map[string]shadow.ProbeShadow) {
+		assert.False(t, probes["fridge"].Alerting)
+	})
+	// Run the test
+	assert.Error(t, app.HandleRequest(nil, createEvent(-18, -2)))
+}
+
+func TestInvalidEvent(t *testing.T) {
+	testParams := []TemperatureUpdatedEvent{
+		{Timestamp: timestamp, Temperatures: createEvent(-18, 4).Temperatures},
+		{DeviceId: deviceID, Temperatures: createEvent(-18, 4).Temperatures},
+		{DeviceId: deviceID, Timestamp: timestamp},
+	}
+	// A probe without a name
+	event := createEvent(-18, 4)
+	event.Temperatures[0].Probe = ""
+	testParams = append(testParams, event)
+	for _, event := range testParams {
+		app, _ := getStubbedApp(t)
+		assert.Error(t, app.HandleRequest(nil, event))
+	}
+}
+
+func createEvent(freezer, fridge float64) TemperatureUpdatedEvent {
+	event := TemperatureUpdatedEvent{
+		DeviceId:  deviceID,
+		Timestamp: timestamp,
+	}
+	event.Temperatures = []struct {
+		Probe   string `validate:"required"`
+		Celsius float64
+	}{
+		{Probe: "freezer", Celsius: freezer},
+		{Probe: "fridge", Celsius: fridge},
+	}
+	return event
+}
+
+func getStubbedApp(t *testing.T) (App, mocks) {
+	// Create mock controller
+	ctrl := gomock.NewController(t)
+	// Create the mocks
+	m := mocks{
+		db:      NewMockDBClient(ctrl),
+		iot:     NewMockIoTClient(ctrl),
+		shadow:  NewMockShadowClient(ctrl),
+		emailer: NewMockEmailer(ctrl),
+	}
+	// Create the app
+	return New(&telemetry.Clients{DB: m.db, IoT: m.iot, Shadow: m.shadow, Emailer: m.emailer}), m
+}
@@ -0,0 +1,47 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/briggysmalls/detectordag/shared/iotsql"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestTemperatureReportedRule checks the events the IoT rule sends us can be handled
+func TestTemperatureReportedRule(t *testing.T) {
+	const topic = "$aws/things/" + deviceID + "/shadow/update/documents"
+	// Load the rule
+	rule, err := iotsql.Load("../../config/rules/temperature_reported.yaml")
+	assert.NoError(t, err)
+	testParams := []struct {
+		previous float64
+		current  float64
+		fired    bool
+	}{
+		{previous: -19, current: -18, fired: true},
+		// Other updates are ignored
+		{previous: -18, current: -18, fired: false},
+	}
+	for _, params := range testParams {
+		// Prepare shadow documents, as published by the shadow service
+		documents := fmt.Sprintf(`{
+			"timestamp": %d,
+			"previous": {"state": {"reported": {"status": "on", "temperatures": [{"probe": "freezer", "celsius": %f}, {"probe": "fridge", "celsius": 4}]}}},
+			"current": {"state": {"reported": {"status": "on", "temperatures": [{"probe": "freezer", "celsius": %f}, {"probe": "fridge", "celsius": 4}]}}}
+		}`, timestamp, params.previous, params.current)
+		// Run the rule
+		payload, fired, err := rule.Evaluate(topic, []byte(documents), time.Unix(timestamp, 0))
+		assert.NoError(t, err)
+		assert.Equal(t, params.fired, fired)
+		if !fired {
+			continue
+		}
+		// Check we get the event we expect
+		var event TemperatureUpdatedEvent
+		assert.NoError(t, json.Unmarshal(payload, &event))
+		assert.Equal(t, createEvent(params.current, 4), event)
+	}
+}
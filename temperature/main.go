@@ -0,0 +1,31 @@
+package main
+
+import (
+	"log"
+
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/briggysmalls/detectordag/shared"
+	"github.com/briggysmalls/detectordag/shared/telemetry"
+	"github.com/briggysmalls/detectordag/temperature/app"
+)
+
+// Prepare an application to reuse across lambda runs
+var temperature app.App
+
+func init() {
+	// Add file/line number to the default logger
+	log.SetFlags(log.LstdFlags | log.Lshortfile)
+	// Create the clients, sharing an AWS session between them
+	clients, err := telemetry.NewClients(shared.CreateSession(aws.Config{}))
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	// Create the application
+	temperature = app.New(clients)
+}
+
+// main is the entrypoint to the lambda function
+func main() {
+	lambda.Start(temperature.HandleRequest)
+}